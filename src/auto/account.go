@@ -1,15 +1,14 @@
 package auto
 
 import (
+	"context"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
 	"github.com/maddiesch/serverless/amazon"
-)
-
-const (
-	accountRecordSortKey = "_USER_ACCOUNT"
+	"github.com/segmentio/ksuid"
 )
 
 // Account represents a user account
@@ -20,39 +19,194 @@ type Account struct {
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 	LastAuthenticatedAt time.Time
+	TimeZone            string
 	AutomaticID         string `json:"-"`
+	// SessionsValidAfter is when the account's sessions were last revoked. API tokens issued at or before it are rejected.
+	SessionsValidAfter time.Time `json:"-"`
+	// LastDigestAt is when the account was last sent a notification digest
+	LastDigestAt time.Time `json:"-"`
 }
 
 // FindAccount returns the account with the passed ID.
-func FindAccount(accountID string) (*Account, error) {
-	item, err := DynamoDB().GetItem(&dynamodb.GetItemInput{
-		TableName: TableName(),
-		Key: map[string]*dynamodb.AttributeValue{
-			"PK": {S: aws.String(accountID)},
-			"SK": {S: aws.String(accountRecordSortKey)},
-		},
-	})
+func (s *DynamoStore) FindAccount(ctx context.Context, accountID string) (*Account, error) {
+	item, err := s.getItem(ctx, keys.Account(accountID))
 	if err != nil && amazon.IsErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
 		return nil, ErrRecordNotFound
 	} else if err != nil {
 		return nil, err
 	}
 
-	return &Account{
-		ID:                  aws.StringValue(item.Item["PK"].S),
-		FirstName:           aws.StringValue(item.Item["FirstName"].S),
-		LastName:            aws.StringValue(item.Item["LastName"].S),
-		CreatedAt:           TimeFromDynamo(item.Item["CreatedAt"]),
-		UpdatedAt:           TimeFromDynamo(item.Item["UpdatedAt"]),
-		LastAuthenticatedAt: TimeFromDynamo(item.Item["LastAuthenticatedAt"]),
-		AutomaticID:         aws.StringValue(item.Item["AutomaticID"].S),
-	}, nil
+	return accountFromDynamo(item), nil
+}
+
+// FindAccountByAutomaticID returns the account linked to the Automatic user
+func (s *DynamoStore) FindAccountByAutomaticID(ctx context.Context, automaticID string) (*Account, error) {
+	index, err := keys.AutomaticAccount(automaticID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              s.table,
+		IndexName:              aws.String(keys.GSI2.Name),
+		KeyConditionExpression: aws.String("#pk = :pk AND #sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(index.HashKey)},
+			":sk": {S: aws.String(index.RangeKey)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(keys.GSI2.HashAttribute),
+			"#sk": aws.String(keys.GSI2.RangeAttribute),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	// GSI2 only projects keys, so the account itself is read from the table.
+	return s.FindAccount(ctx, StringFromDynamo(result.Items[0][keys.HashKeyAttribute]))
+}
+
+// SaveAutomaticAuthentication writes the account, a newly issued Automatic token and any contacts in a single transaction
+func (s *DynamoStore) SaveAutomaticAuthentication(ctx context.Context, account *Account, token AutomaticAccessToken, contacts []*Contact) error {
+	now := time.Now()
+	if account.CreatedAt.IsZero() {
+		account.CreatedAt = now
+	}
+	account.UpdatedAt = now
+	account.LastAuthenticatedAt = now
+	account.AutomaticID = token.UserID
+
+	accountItem, err := account.dynamo()
+	if err != nil {
+		return err
+	}
+	tokenItem, err := token.dynamo(ctx, s.encryption(), account, ksuid.New().String())
+	if err != nil {
+		return err
+	}
+
+	items := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{TableName: s.table, Item: accountItem}},
+		{Put: &dynamodb.Put{TableName: s.table, Item: tokenItem}},
+	}
+
+	var contactSecret string
+	if len(contacts) > 0 {
+		if contactSecret, err = s.contactKeying(); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range contacts {
+		c.AccountID = account.ID
+		contactItem, err := c.dynamo(ctx, s.encryption(), contactSecret)
+		if err != nil {
+			return err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{TableName: s.table, Item: contactItem},
+		})
+	}
+
+	_, err = s.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	return err
+}
+
+// RevokeSessions invalidates every API token issued to the account before now
+func (s *DynamoStore) RevokeSessions(ctx context.Context, accountID string) (time.Time, error) {
+	now := time.Now()
+
+	_, err := s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           s.table,
+		Key:                 keys.Account(accountID).Dynamo(),
+		ConditionExpression: aws.String("attribute_exists(#pk)"),
+		UpdateExpression:    aws.String("SET #valid = :now, #updated = :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":      aws.String(keys.HashKeyAttribute),
+			"#valid":   aws.String("SessionsValidAfter"),
+			"#updated": aws.String("UpdatedAt"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": DynamoTime(now),
+		},
+	})
+	if amazon.IsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return time.Time{}, ErrRecordNotFound
+	}
+
+	return now, err
 }
 
 // PrimaryKey returns the primary key for DynamoDB
-func (a *Account) PrimaryKey() PrimaryKey {
-	return PrimaryKey{
-		HashKey: a.ID,
-		SortKey: accountRecordSortKey,
+func (a *Account) PrimaryKey() (PrimaryKey, error) {
+	return keys.Account(a.ID), nil
+}
+
+// Location returns the time zone the account has chosen. Accounts without a
+// valid time zone are treated as UTC.
+func (a *Account) Location() *time.Location {
+	if a.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(a.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (a *Account) dynamo() (map[string]*dynamodb.AttributeValue, error) {
+	index, err := keys.AutomaticAccount(a.AutomaticID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := keys.Item{
+		Primary: keys.Account(a.ID),
+		Indexes: []keys.IndexKey{index},
+	}.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	item["FirstName"] = &dynamodb.AttributeValue{S: aws.String(a.FirstName)}
+	item["LastName"] = &dynamodb.AttributeValue{S: aws.String(a.LastName)}
+	item["AutomaticID"] = &dynamodb.AttributeValue{S: aws.String(a.AutomaticID)}
+	item["CreatedAt"] = DynamoTime(a.CreatedAt)
+	item["UpdatedAt"] = DynamoTime(a.UpdatedAt)
+	item["LastAuthenticatedAt"] = DynamoTime(a.LastAuthenticatedAt)
+
+	if a.TimeZone != "" {
+		item["TimeZone"] = &dynamodb.AttributeValue{S: aws.String(a.TimeZone)}
+	}
+	if !a.SessionsValidAfter.IsZero() {
+		item["SessionsValidAfter"] = DynamoTime(a.SessionsValidAfter)
+	}
+	if !a.LastDigestAt.IsZero() {
+		item["LastDigestAt"] = DynamoTime(a.LastDigestAt)
+	}
+
+	return item, nil
+}
+
+func accountFromDynamo(item map[string]*dynamodb.AttributeValue) *Account {
+	return &Account{
+		ID:                  StringFromDynamo(item[keys.HashKeyAttribute]),
+		FirstName:           StringFromDynamo(item["FirstName"]),
+		LastName:            StringFromDynamo(item["LastName"]),
+		CreatedAt:           TimeFromDynamo(item["CreatedAt"]),
+		UpdatedAt:           TimeFromDynamo(item["UpdatedAt"]),
+		LastAuthenticatedAt: TimeFromDynamo(item["LastAuthenticatedAt"]),
+		TimeZone:            StringFromDynamo(item["TimeZone"]),
+		AutomaticID:         StringFromDynamo(item["AutomaticID"]),
+		SessionsValidAfter:  TimeFromDynamo(item["SessionsValidAfter"]),
+		LastDigestAt:        TimeFromDynamo(item["LastDigestAt"]),
 	}
 }
@@ -0,0 +1,35 @@
+package auto
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// API tokens are JWTs signed with the app's signing secret
+const (
+	APITokenIssuer   = "autorem://api/v1"
+	APITokenAudience = "autorem://api/v1"
+
+	apiTokenLifetime = 90 * 24 * time.Hour
+)
+
+// NewAPIToken returns a signed API token for the account
+func NewAPIToken(accountID string) (string, error) {
+	secrets, err := Secrets()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		ExpiresAt: now.Add(apiTokenLifetime).Unix(),
+		Issuer:    APITokenIssuer,
+		Audience:  APITokenAudience,
+		Subject:   accountID,
+		IssuedAt:  now.Unix(),
+	})
+
+	return token.SignedString([]byte(secrets.Signing))
+}
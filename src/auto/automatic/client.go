@@ -0,0 +1,229 @@
+// Package automatic is a client for the Automatic API.
+//
+// Client calls api.automatic.com with an account's access token, and OAuth
+// exchanges codes and refresh tokens with accounts.automatic.com. Both take
+// their base URL as a field, so they can be pointed at a stub or simulator.
+package automatic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Base URLs of the production Automatic services
+const (
+	DefaultAPIURL      = "https://api.automatic.com"
+	DefaultAccountsURL = "https://accounts.automatic.com"
+)
+
+// Doer sends HTTP requests. *http.Client is a Doer.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// TokenSource returns the access token requests are signed with
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// RefreshableTokenSource is a TokenSource that can replace a token the API has rejected
+type RefreshableTokenSource interface {
+	TokenSource
+	// Refresh returns a new token to replace the rejected one
+	Refresh(ctx context.Context, rejected string) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token
+type StaticToken string
+
+// Token returns the token
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Kinds of API error. Check for them with errors.Is.
+var (
+	// ErrUnauthorized means the access token was rejected. Refreshing it may help.
+	ErrUnauthorized = errors.New("automatic: unauthorized")
+	// ErrNotFound means the requested resource doesn't exist or isn't shared with the app
+	ErrNotFound = errors.New("automatic: not found")
+	// ErrRateLimited means the app has made too many requests
+	ErrRateLimited = errors.New("automatic: rate limited")
+	// ErrUnavailable means the API failed with a 5xx. It may work later.
+	ErrUnavailable = errors.New("automatic: unavailable")
+)
+
+// APIError is returned for any response outside the 2xx range
+type APIError struct {
+	StatusCode int
+	// Code and Detail are read from Automatic's error body, when it has one
+	Code   string
+	Detail string
+	Body   []byte
+}
+
+// Is matches the error with the kind of its status code
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+func (e *APIError) Error() string {
+	message := e.Detail
+	if message == "" {
+		message = e.Code
+	}
+	if message == "" {
+		message = strings.TrimSpace(string(e.Body))
+	}
+	if message == "" {
+		return fmt.Sprintf("automatic: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("automatic: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), message)
+}
+
+// Client calls the Automatic REST API. A request rejected with a 401 is sent
+// again once with a refreshed token when Tokens is a RefreshableTokenSource.
+type Client struct {
+	// BaseURL defaults to DefaultAPIURL
+	BaseURL string
+	// HTTP defaults to http.DefaultClient
+	HTTP Doer
+	// Tokens signs every request
+	Tokens TokenSource
+}
+
+// User returns an Automatic user
+func (c *Client) User(ctx context.Context, userID string) (*User, error) {
+	user := &User{}
+	if err := c.get(ctx, "/user/"+url.PathEscape(userID)+"/", nil, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, into interface{}) error {
+	token, err := c.Tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.getWithToken(ctx, token, path, query, into)
+	if refresher, ok := c.Tokens.(RefreshableTokenSource); ok && errors.Is(err, ErrUnauthorized) {
+		if token, err = refresher.Refresh(ctx, token); err != nil {
+			return err
+		}
+		err = c.getWithToken(ctx, token, path, query, into)
+	}
+
+	return err
+}
+
+func (c *Client) getWithToken(ctx context.Context, token, path string, query url.Values, into interface{}) error {
+	uri, err := resolve(c.BaseURL, DefaultAPIURL, path, query)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	return send(c.HTTP, request, into)
+}
+
+func postJSON(ctx context.Context, doer Doer, uri string, body interface{}, into interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	return send(doer, request, into)
+}
+
+// send sends the request and decodes a 2xx response into the passed value
+func send(doer Doer, request *http.Request, into interface{}) error {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+
+	response, err := doer.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: response.StatusCode, Body: body}
+		detail := struct {
+			Error  string `json:"error"`
+			Detail string `json:"detail"`
+		}{}
+		if json.Unmarshal(body, &detail) == nil {
+			apiErr.Code = detail.Error
+			apiErr.Detail = detail.Detail
+		}
+		return apiErr
+	}
+
+	if into == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, into); err != nil {
+		return fmt.Errorf("automatic: decoding %s %s: %w", request.Method, request.URL.Path, err)
+	}
+	return nil
+}
+
+// maxResponseSize caps how much of a response is read. A page of 250 trips is well under it.
+const maxResponseSize = 10 << 20
+
+// resolve joins the path and query to the base URL
+func resolve(base, fallback, path string, query url.Values) (string, error) {
+	if base == "" {
+		base = fallback
+	}
+
+	uri, err := url.Parse(strings.TrimSuffix(base, "/") + path)
+	if err != nil {
+		return "", err
+	}
+	if len(query) > 0 {
+		uri.RawQuery = query.Encode()
+	}
+
+	return uri.String(), nil
+}
@@ -0,0 +1,160 @@
+package automatic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"err_unauthorized","detail":"Invalid token"}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/user/U_1/":
+			w.Write([]byte(`{"id":"U_1","first_name":"Testy","email":"test@email.test","email_verified":true}`))
+		case "/vehicle/":
+			// Next points at the production API, as Automatic's does.
+			if r.URL.Query().Get("page") == "" {
+				w.Write([]byte(`{"_metadata":{"count":2,"next":"https://api.automatic.com/vehicle/?limit=250&page=2"},"results":[{"id":"C_1","make":"Honda"}]}`))
+			} else {
+				w.Write([]byte(`{"_metadata":{"count":2,"next":null},"results":[{"id":"C_2","make":"Subaru"}]}`))
+			}
+		case "/trip/":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"_metadata": map[string]interface{}{"count": 1},
+				"results": []map[string]interface{}{{
+					"id":         "T_1",
+					"distance_m": 1500.5,
+					"ended_at":   "2019-09-01T10:00:00Z",
+					"vehicle":    r.URL.Query().Get("vehicle") + "|" + r.URL.Query().Get("started_at__gte"),
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`not found`))
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{BaseURL: server.URL, Tokens: StaticToken("access")}
+	ctx := context.Background()
+
+	t.Run("returns a user", func(t *testing.T) {
+		user, err := client.User(ctx, "U_1")
+		require.NoError(t, err)
+		assert.Equal(t, "Testy", user.FirstName)
+		assert.True(t, user.EmailVerified)
+	})
+
+	t.Run("follows the next page on the same server", func(t *testing.T) {
+		vehicles, err := client.AllVehicles(ctx)
+		require.NoError(t, err)
+		require.Len(t, vehicles, 2)
+		assert.Equal(t, "C_1", vehicles[0].ID)
+		assert.Equal(t, "Subaru", vehicles[1].Make)
+	})
+
+	t.Run("filters trips", func(t *testing.T) {
+		trips, err := client.AllTrips(ctx, TripQuery{Vehicle: "C_1", StartedAfter: time.Unix(1567000000, 0)})
+		require.NoError(t, err)
+		require.Len(t, trips, 1)
+		assert.Equal(t, "C_1|1567000000", trips[0].Vehicle)
+		assert.Equal(t, 1500.5, trips[0].Distance)
+	})
+
+	t.Run("returns API errors with the status and body", func(t *testing.T) {
+		_, err := client.User(ctx, "U_missing")
+
+		apiErr, ok := err.(*APIError)
+		require.True(t, ok, "got %T", err)
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.Equal(t, "not found", string(apiErr.Body))
+
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.False(t, errors.Is(err, ErrUnavailable))
+
+		_, err = (&Client{BaseURL: server.URL, Tokens: StaticToken("expired")}).User(ctx, "U_1")
+		require.IsType(t, &APIError{}, err)
+		assert.True(t, errors.Is(err, ErrUnauthorized))
+		assert.Equal(t, "err_unauthorized", err.(*APIError).Code)
+		assert.EqualError(t, err, "automatic: 401 Unauthorized: Invalid token")
+	})
+
+	t.Run("retries a rejected token once with a refreshed one", func(t *testing.T) {
+		tokens := &refreshingTokens{token: "expired", refreshed: "access"}
+
+		user, err := (&Client{BaseURL: server.URL, Tokens: tokens}).User(ctx, "U_1")
+		require.NoError(t, err)
+		assert.Equal(t, "U_1", user.ID)
+		assert.Equal(t, []string{"expired"}, tokens.rejected)
+
+		tokens = &refreshingTokens{token: "expired", refreshed: "also-expired"}
+		_, err = (&Client{BaseURL: server.URL, Tokens: tokens}).User(ctx, "U_1")
+		assert.True(t, errors.Is(err, ErrUnauthorized))
+		assert.Len(t, tokens.rejected, 1)
+	})
+}
+
+type refreshingTokens struct {
+	token     string
+	refreshed string
+	rejected  []string
+}
+
+func (r *refreshingTokens) Token(context.Context) (string, error) {
+	return r.token, nil
+}
+
+func (r *refreshingTokens) Refresh(ctx context.Context, rejected string) (string, error) {
+	r.rejected = append(r.rejected, rejected)
+	r.token = r.refreshed
+	return r.token, nil
+}
+
+func TestOAuth(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/oauth/access_token/", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Write([]byte(`{"access_token":"new","refresh_token":"refresh-2","expires_in":3600,"user_id":"U_1","token_type":"bearer"}`))
+	}))
+	defer server.Close()
+
+	oauth := &OAuth{BaseURL: server.URL, ClientID: "id", ClientSecret: "secret"}
+
+	t.Run("builds the authorize URL", func(t *testing.T) {
+		uri, err := oauth.AuthorizeURL("state-1", []string{"scope:public", "scope:trip"})
+		require.NoError(t, err)
+		assert.Equal(t, server.URL+"/oauth/authorize/?client_id=id&response_type=code&scope=scope:public%20scope:trip&state=state-1", uri)
+	})
+
+	t.Run("exchanges a code", func(t *testing.T) {
+		token, err := oauth.Exchange(context.Background(), "code-1")
+		require.NoError(t, err)
+		assert.Equal(t, "new", token.AccessToken)
+		assert.Equal(t, time.Hour, token.Lifetime())
+		assert.Equal(t, map[string]string{"client_id": "id", "client_secret": "secret", "code": "code-1", "grant_type": "authorization_code"}, body)
+	})
+
+	t.Run("refreshes a token", func(t *testing.T) {
+		token, err := oauth.Refresh(context.Background(), "refresh-1")
+		require.NoError(t, err)
+		assert.Equal(t, "refresh-2", token.RefreshToken)
+		assert.Equal(t, "refresh_token", body["grant_type"])
+		assert.Equal(t, "refresh-1", body["refresh_token"])
+	})
+}
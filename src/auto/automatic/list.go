@@ -0,0 +1,122 @@
+package automatic
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultPageSize is the largest page the API returns
+const DefaultPageSize = 250
+
+// TripQuery filters the trips returned by Trips
+type TripQuery struct {
+	// Vehicle is the ID of the vehicle the trips were taken in. Empty returns every vehicle's trips.
+	Vehicle string
+	// StartedAfter returns trips started at or after the time
+	StartedAfter time.Time
+	// PageSize defaults to DefaultPageSize
+	PageSize int
+}
+
+// Vehicles calls fn with each page of the user's vehicles
+func (c *Client) Vehicles(ctx context.Context, fn func([]Vehicle) error) error {
+	return c.paginate(ctx, "/vehicle/", pageQuery(0), func(results json.RawMessage) error {
+		page := make([]Vehicle, 0)
+		if err := json.Unmarshal(results, &page); err != nil {
+			return err
+		}
+		return fn(page)
+	})
+}
+
+// AllVehicles returns every vehicle on the user's account
+func (c *Client) AllVehicles(ctx context.Context) ([]Vehicle, error) {
+	vehicles := make([]Vehicle, 0)
+	err := c.Vehicles(ctx, func(page []Vehicle) error {
+		vehicles = append(vehicles, page...)
+		return nil
+	})
+	return vehicles, err
+}
+
+// Trips calls fn with each page of the trips matching the query
+func (c *Client) Trips(ctx context.Context, q TripQuery, fn func([]Trip) error) error {
+	query := pageQuery(q.PageSize)
+	if q.Vehicle != "" {
+		query.Set("vehicle", q.Vehicle)
+	}
+	if !q.StartedAfter.IsZero() {
+		query.Set("started_at__gte", strconv.FormatInt(q.StartedAfter.Unix(), 10))
+	}
+
+	return c.paginate(ctx, "/trip/", query, func(results json.RawMessage) error {
+		page := make([]Trip, 0)
+		if err := json.Unmarshal(results, &page); err != nil {
+			return err
+		}
+		return fn(page)
+	})
+}
+
+// AllTrips returns every trip matching the query
+func (c *Client) AllTrips(ctx context.Context, q TripQuery) ([]Trip, error) {
+	trips := make([]Trip, 0)
+	err := c.Trips(ctx, q, func(page []Trip) error {
+		trips = append(trips, page...)
+		return nil
+	})
+	return trips, err
+}
+
+// Devices calls fn with each page of the user's devices
+func (c *Client) Devices(ctx context.Context, fn func([]Device) error) error {
+	return c.paginate(ctx, "/device/", pageQuery(0), func(results json.RawMessage) error {
+		page := make([]Device, 0)
+		if err := json.Unmarshal(results, &page); err != nil {
+			return err
+		}
+		return fn(page)
+	})
+}
+
+// paginate fetches the first page and then follows _metadata.next until it's empty.
+//
+// Next is an absolute URL on the production API. Only its path and query are
+// used, so a client with another BaseURL keeps talking to the same server.
+func (c *Client) paginate(ctx context.Context, path string, query url.Values, fn func(json.RawMessage) error) error {
+	for {
+		list := struct {
+			Metadata Metadata        `json:"_metadata"`
+			Results  json.RawMessage `json:"results"`
+		}{}
+		if err := c.get(ctx, path, query, &list); err != nil {
+			return err
+		}
+
+		if len(list.Results) > 0 {
+			if err := fn(list.Results); err != nil {
+				return err
+			}
+		}
+
+		if list.Metadata.Next == "" {
+			return nil
+		}
+
+		next, err := url.Parse(list.Metadata.Next)
+		if err != nil {
+			return err
+		}
+		path, query = next.Path, next.Query()
+	}
+}
+
+func pageQuery(size int) url.Values {
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	return url.Values{"limit": []string{strconv.Itoa(size)}}
+}
@@ -0,0 +1,65 @@
+package automatic
+
+import "time"
+
+// User is an Automatic user
+type User struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Username      string `json:"username"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// Vehicle is a vehicle on an Automatic user's account
+type Vehicle struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	VIN         string    `json:"vin"`
+	Make        string    `json:"make"`
+	Model       string    `json:"model"`
+	Submodel    string    `json:"submodel"`
+	Year        int       `json:"year"`
+	DisplayName string    `json:"display_name"`
+	Color       string    `json:"color"`
+	FuelGrade   string    `json:"fuel_grade"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Trip is a single drive recorded by an Automatic device
+type Trip struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Vehicle is the URL of the vehicle the trip was taken in
+	Vehicle   string    `json:"vehicle"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	// Distance is in meters
+	Distance float64 `json:"distance_m"`
+	// Duration is in seconds
+	Duration     float64  `json:"duration_s"`
+	StartAddress *Address `json:"start_address"`
+	EndAddress   *Address `json:"end_address"`
+}
+
+// Address is where a trip started or ended
+type Address struct {
+	Name string `json:"name"`
+}
+
+// Device is an Automatic adapter
+type Device struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Version int    `json:"version"`
+}
+
+// Metadata is the pagination information returned with every list
+type Metadata struct {
+	Count    int    `json:"count"`
+	Next     string `json:"next"`
+	Previous string `json:"previous"`
+}
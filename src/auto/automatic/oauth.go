@@ -0,0 +1,86 @@
+package automatic
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Token is an access token issued by Automatic's OAuth server
+type Token struct {
+	UserID       string `json:"user_id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the token's lifetime in seconds
+	ExpiresIn int `json:"expires_in"`
+	// Scope is a space separated list of scopes
+	Scope     string `json:"scope"`
+	TokenType string `json:"token_type"`
+}
+
+// Lifetime returns how long the token is valid for after it's issued
+func (t *Token) Lifetime() time.Duration {
+	return time.Duration(t.ExpiresIn) * time.Second
+}
+
+// OAuth signs users in with Automatic and refreshes their tokens
+type OAuth struct {
+	// BaseURL defaults to DefaultAccountsURL
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	// HTTP defaults to http.DefaultClient
+	HTTP Doer
+}
+
+// AuthorizeURL returns the page a user is sent to to grant the app access. The state is passed back to the callback.
+func (o *OAuth) AuthorizeURL(state string, scopes []string) (string, error) {
+	uri, err := resolve(o.BaseURL, DefaultAccountsURL, "/oauth/authorize/", nil)
+	if err != nil {
+		return "", err
+	}
+
+	// Automatic expects the scopes separated by %20, which url.Values would encode as +.
+	values := []string{
+		"client_id=" + url.QueryEscape(o.ClientID),
+		"response_type=code",
+		"scope=" + strings.Join(scopes, "%20"),
+		"state=" + url.QueryEscape(state),
+	}
+
+	return uri + "?" + strings.Join(values, "&"), nil
+}
+
+// Exchange trades the code passed to the callback for a token
+func (o *OAuth) Exchange(ctx context.Context, code string) (*Token, error) {
+	return o.token(ctx, map[string]string{
+		"code":       code,
+		"grant_type": "authorization_code",
+	})
+}
+
+// Refresh trades a refresh token for a new token
+func (o *OAuth) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	return o.token(ctx, map[string]string{
+		"refresh_token": refreshToken,
+		"grant_type":    "refresh_token",
+	})
+}
+
+func (o *OAuth) token(ctx context.Context, body map[string]string) (*Token, error) {
+	uri, err := resolve(o.BaseURL, DefaultAccountsURL, "/oauth/access_token/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body["client_id"] = o.ClientID
+	body["client_secret"] = o.ClientSecret
+
+	token := &Token{}
+	if err := postJSON(ctx, o.HTTP, uri, body, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+)
+
+// Page sizes, as the Automatic API has them
+const (
+	defaultPageSize = 100
+	maxPageSize     = automatic.DefaultPageSize
+)
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error in the Automatic format
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeJSON(w, status, map[string]string{"error": code, "detail": detail})
+}
+
+// writePage writes the page of results the request's limit and page ask for,
+// with a _metadata.next link to the following page
+func writePage(w http.ResponseWriter, r *http.Request, results []interface{}) {
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+
+	start := (page - 1) * limit
+	if start > len(results) {
+		start = len(results)
+	}
+	end := start + limit
+	if end > len(results) {
+		end = len(results)
+	}
+
+	metadata := map[string]interface{}{"count": len(results), "next": nil, "previous": nil}
+	if end < len(results) {
+		query.Set("page", strconv.Itoa(page+1))
+		metadata["next"] = baseURL(r) + r.URL.Path + "?" + query.Encode()
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page-1))
+		metadata["previous"] = baseURL(r) + r.URL.Path + "?" + query.Encode()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"_metadata": metadata, "results": results[start:end]})
+}
+
+// baseURL is the scheme and host the request was sent to, which links point at
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// readParams reads a JSON or form encoded body
+func readParams(r *http.Request) (map[string]string, error) {
+	params := map[string]string{}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, errors.New("the body must be a JSON object of strings")
+		}
+		return params, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	for key := range r.PostForm {
+		params[key] = r.PostForm.Get(key)
+	}
+	return params, nil
+}
+
+// unixParam reads a time passed as unix seconds. A missing param is the zero time.
+func unixParam(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a unix time", name)
+	}
+	return time.Unix(seconds, 0), nil
+}
@@ -0,0 +1,486 @@
+// Package simulator emulates the Automatic services, so sync, webhooks and
+// reminders can be developed end to end without a real Automatic account.
+//
+// A Server answers both accounts.automatic.com's OAuth endpoints and
+// api.automatic.com's user, vehicle, trip and device endpoints, so the app's
+// AUTOMATIC_API_URL and AUTOMATIC_ACCOUNTS_URL can both point at it. Its users
+// and vehicles come from a Config, and each vehicle's trips are generated from
+// a TripStream as time passes.
+//
+// The authorize page lists the users to sign in as. Passing user=<id> with the
+// authorize request skips the page and redirects straight back to the app,
+// which is what tests do.
+//
+// Tests can run a Server in-process with httptest.NewServer.
+package simulator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+)
+
+// Config describes the simulated app registration and users
+type Config struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL is the app's OAuth callback. An authorize request's redirect_uri overrides it.
+	RedirectURL string `json:"redirect_url"`
+	// TokenLifetime is how long access tokens last, in seconds
+	TokenLifetime int `json:"token_lifetime_s"`
+
+	Users []User `json:"users"`
+}
+
+// User is a simulated Automatic user and their vehicles
+type User struct {
+	automatic.User
+	Vehicles []Vehicle `json:"vehicles"`
+}
+
+// Vehicle is a simulated vehicle and the trips it takes
+type Vehicle struct {
+	automatic.Vehicle
+	Trips TripStream `json:"trips"`
+}
+
+// DefaultConfig is a single user with two vehicles that drive every few hours.
+// Its client matches the app's fake secrets.
+func DefaultConfig() Config {
+	return Config{
+		ClientID:      "fake-client-id",
+		ClientSecret:  "fake-client-secret",
+		RedirectURL:   "http://127.0.0.1:3000/v1/integration/automatic/authenticate/callback",
+		TokenLifetime: 86400,
+		Users: []User{
+			{
+				User: automatic.User{
+					ID:            "U_simulated",
+					Username:      "simulated",
+					FirstName:     "Sim",
+					LastName:      "Ulated",
+					Email:         "simulated@example.com",
+					EmailVerified: true,
+				},
+				Vehicles: []Vehicle{
+					{
+						Vehicle: automatic.Vehicle{ID: "C_accord", VIN: "1HGCM82633A004352", Make: "Honda", Model: "Accord", Year: 2003, DisplayName: "Accord"},
+						Trips:   TripStream{IntervalHours: 8, DistanceMeters: 18000, HistoryDays: 30, Seed: 1},
+					},
+					{
+						Vehicle: automatic.Vehicle{ID: "C_outback", VIN: "4S4BSANC5K3200000", Make: "Subaru", Model: "Outback", Year: 2019, DisplayName: "Outback"},
+						Trips:   TripStream{IntervalHours: 26, DistanceMeters: 64000, HistoryDays: 30, Seed: 2},
+					},
+				},
+			},
+		},
+	}
+}
+
+// LoadConfig reads a JSON config
+func LoadConfig(data []byte) (Config, error) {
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("simulator config: %w", err)
+	}
+	return config, nil
+}
+
+// Server is a simulated Automatic. It's an http.Handler and safe for concurrent use.
+type Server struct {
+	// Now is the simulated time. It defaults to time.Now.
+	Now func() time.Time
+
+	config  Config
+	handler http.Handler
+
+	mu      sync.Mutex
+	codes   map[string]*grant
+	access  map[string]*grant
+	refresh map[string]*grant
+}
+
+// grant is what a code or token was issued for
+type grant struct {
+	userID    string
+	scope     string
+	expiresAt time.Time
+	// accessToken is the access token issued with a refresh token. It's revoked when the refresh token is used.
+	accessToken string
+}
+
+// New returns a server for the config
+func New(config Config) *Server {
+	if config.TokenLifetime <= 0 {
+		config.TokenLifetime = DefaultConfig().TokenLifetime
+	}
+
+	s := &Server{
+		config:  config,
+		codes:   map[string]*grant{},
+		access:  map[string]*grant{},
+		refresh: map[string]*grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/authorize/", s.authorize)
+	mux.HandleFunc("/oauth/access_token/", s.accessToken)
+	mux.HandleFunc("/user/", s.authenticated(s.user))
+	mux.HandleFunc("/vehicle/", s.authenticated(s.vehicles))
+	mux.HandleFunc("/trip/", s.authenticated(s.trips))
+	mux.HandleFunc("/device/", s.authenticated(s.devices))
+	s.handler = mux
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// ExpireTokens expires every access token issued to the user, so the app has to refresh them
+func (s *Server) ExpireTokens(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range s.access {
+		if g.userID == userID {
+			g.expiresAt = time.Time{}
+		}
+	}
+}
+
+// Trips returns the trips the vehicle has finished, newest first
+func (s *Server) Trips(vehicleID string) []automatic.Trip {
+	for _, user := range s.config.Users {
+		for _, vehicle := range user.Vehicles {
+			if vehicle.ID == vehicleID {
+				return vehicle.Trips.generate(vehicle.ID, s.now())
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Server) findUser(id string) (*User, bool) {
+	for i := range s.config.Users {
+		if s.config.Users[i].ID == id {
+			return &s.config.Users[i], true
+		}
+	}
+	return nil, false
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Automatic Simulator</title></head>
+<body>
+<h1>Sign in to the Automatic Simulator</h1>
+<p>Pick the user to grant {{.Scope}} as.</p>
+<ul>
+{{range .Users}}<li><a href="{{.Link}}">{{.Name}}</a> ({{.ID}})</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != s.config.ClientID {
+		writeError(w, http.StatusBadRequest, "invalid_client", "Unknown client_id")
+		return
+	}
+	if query.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "unsupported_response_type", "response_type must be code")
+		return
+	}
+
+	redirect := query.Get("redirect_uri")
+	if redirect == "" {
+		redirect = s.config.RedirectURL
+	}
+	callback, err := url.Parse(redirect)
+	if err != nil || redirect == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "A redirect URL is required")
+		return
+	}
+
+	userID := query.Get("user")
+	if userID == "" {
+		s.renderAuthorizePage(w, r, query)
+		return
+	}
+	if _, ok := s.findUser(userID); !ok {
+		writeError(w, http.StatusNotFound, "err_not_found", "Unknown user")
+		return
+	}
+
+	code := s.issue(s.codes, &grant{userID: userID, scope: query.Get("scope"), expiresAt: s.now().Add(10 * time.Minute)})
+
+	values := callback.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	callback.RawQuery = values.Encode()
+
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (s *Server) renderAuthorizePage(w http.ResponseWriter, r *http.Request, query url.Values) {
+	type option struct {
+		ID   string
+		Name string
+		Link string
+	}
+
+	options := make([]option, len(s.config.Users))
+	for i, user := range s.config.Users {
+		values := url.Values{}
+		for k, v := range query {
+			values[k] = v
+		}
+		values.Set("user", user.ID)
+
+		options[i] = option{ID: user.ID, Name: user.FirstName + " " + user.LastName, Link: r.URL.Path + "?" + values.Encode()}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	authorizePage.Execute(w, map[string]interface{}{"Scope": query.Get("scope"), "Users": options})
+}
+
+func (s *Server) accessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "Use POST")
+		return
+	}
+
+	params, err := readParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if params["client_id"] != s.config.ClientID || params["client_secret"] != s.config.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+
+	var issued *grant
+	switch params["grant_type"] {
+	case "authorization_code":
+		issued = s.redeem(s.codes, params["code"])
+	case "refresh_token":
+		issued = s.redeem(s.refresh, params["refresh_token"])
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+	if issued == nil {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "The code or refresh token is invalid or expired")
+		return
+	}
+
+	lifetime := time.Duration(s.config.TokenLifetime) * time.Second
+	access := s.issue(s.access, &grant{userID: issued.userID, scope: issued.scope, expiresAt: s.now().Add(lifetime)})
+	refresh := s.issue(s.refresh, &grant{userID: issued.userID, scope: issued.scope, accessToken: access})
+
+	writeJSON(w, http.StatusOK, automatic.Token{
+		UserID:       issued.userID,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    s.config.TokenLifetime,
+		Scope:        issued.scope,
+		TokenType:    "bearer",
+	})
+}
+
+// issue stores a grant under a new random token and returns the token
+func (s *Server) issue(grants map[string]*grant, g *grant) string {
+	data := make([]byte, 20)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants[token] = g
+	return token
+}
+
+// redeem removes a code or refresh token and returns what it was issued for.
+// The access token issued with a refresh token is revoked with it.
+func (s *Server) redeem(grants map[string]*grant, token string) *grant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := grants[token]
+	if !ok {
+		return nil
+	}
+	delete(grants, token)
+	delete(s.access, g.accessToken)
+
+	if !g.expiresAt.IsZero() && s.now().After(g.expiresAt) {
+		return nil
+	}
+	return g
+}
+
+// authenticated passes the user the request's access token was issued to
+func (s *Server) authenticated(fn func(http.ResponseWriter, *http.Request, *User, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "err_method_not_allowed", "Use GET")
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("access_token")
+		}
+
+		s.mu.Lock()
+		g, ok := s.access[token]
+		valid := ok && s.now().Before(g.expiresAt)
+		s.mu.Unlock()
+
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "err_unauthorized", "Invalid or expired access token")
+			return
+		}
+
+		user, ok := s.findUser(g.userID)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "err_unauthorized", "The user no longer exists")
+			return
+		}
+
+		// The part of the path after the collection, e.g. the ID in /vehicle/<id>/
+		parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+		id := ""
+		if len(parts) == 2 {
+			id = strings.TrimSuffix(parts[1], "/")
+		}
+
+		fn(w, r, user, id)
+	}
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request, user *User, id string) {
+	if id != "me" && id != user.ID {
+		writeError(w, http.StatusNotFound, "err_not_found", "Not found")
+		return
+	}
+
+	result := user.User
+	result.URL = baseURL(r) + "/user/" + user.ID + "/"
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) vehicles(w http.ResponseWriter, r *http.Request, user *User, id string) {
+	results := make([]interface{}, 0, len(user.Vehicles))
+	for _, vehicle := range user.Vehicles {
+		result := vehicle.Vehicle
+		result.URL = baseURL(r) + "/vehicle/" + vehicle.ID + "/"
+		if id == vehicle.ID {
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+		results = append(results, result)
+	}
+
+	if id != "" {
+		writeError(w, http.StatusNotFound, "err_not_found", "Not found")
+		return
+	}
+	writePage(w, r, results)
+}
+
+func (s *Server) trips(w http.ResponseWriter, r *http.Request, user *User, id string) {
+	query := r.URL.Query()
+
+	after, err := unixParam(query, "started_at__gte")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "err_invalid_parameter", err.Error())
+		return
+	}
+	before, err := unixParam(query, "started_at__lte")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "err_invalid_parameter", err.Error())
+		return
+	}
+
+	trips := make([]automatic.Trip, 0)
+	for _, vehicle := range user.Vehicles {
+		if filter := query.Get("vehicle"); filter != "" && filter != vehicle.ID {
+			continue
+		}
+		for _, trip := range vehicle.Trips.generate(vehicle.ID, s.now()) {
+			if id != "" && trip.ID != id {
+				continue
+			}
+			if (!after.IsZero() && trip.StartedAt.Before(after)) || (!before.IsZero() && trip.StartedAt.After(before)) {
+				continue
+			}
+			trip.URL = baseURL(r) + "/trip/" + trip.ID + "/"
+			trip.Vehicle = baseURL(r) + "/vehicle/" + vehicle.ID + "/"
+			trips = append(trips, trip)
+		}
+	}
+
+	if id != "" {
+		if len(trips) == 0 {
+			writeError(w, http.StatusNotFound, "err_not_found", "Not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, trips[0])
+		return
+	}
+
+	sort.SliceStable(trips, func(i, j int) bool { return trips[i].StartedAt.After(trips[j].StartedAt) })
+
+	results := make([]interface{}, len(trips))
+	for i, trip := range trips {
+		results[i] = trip
+	}
+	writePage(w, r, results)
+}
+
+// devices returns an adapter plugged into each vehicle
+func (s *Server) devices(w http.ResponseWriter, r *http.Request, user *User, id string) {
+	results := make([]interface{}, 0, len(user.Vehicles))
+	for _, vehicle := range user.Vehicles {
+		deviceID := "D_" + strings.TrimPrefix(vehicle.ID, "C_")
+		device := automatic.Device{ID: deviceID, URL: baseURL(r) + "/device/" + deviceID + "/", Version: 2}
+		if id == deviceID {
+			writeJSON(w, http.StatusOK, device)
+			return
+		}
+		results = append(results, device)
+	}
+
+	if id != "" {
+		writeError(w, http.StatusNotFound, "err_not_found", "Not found")
+		return
+	}
+	writePage(w, r, results)
+}
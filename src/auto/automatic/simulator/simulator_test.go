@@ -0,0 +1,163 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signIn follows the authorize flow as the user and returns the code passed to the callback
+func signIn(t *testing.T, oauth *automatic.OAuth, userID string) string {
+	authorize, err := oauth.AuthorizeURL("some-state", []string{"scope:public", "scope:trip"})
+	require.NoError(t, err)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(authorize + "&user=" + userID)
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusFound, response.StatusCode)
+
+	callback, err := url.Parse(response.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/callback", callback.Path)
+	assert.Equal(t, "some-state", callback.Query().Get("state"))
+
+	return callback.Query().Get("code")
+}
+
+func TestServer(t *testing.T) {
+	config := DefaultConfig()
+	config.RedirectURL = "http://app.test/callback"
+
+	sim := New(config)
+	now := time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)
+	sim.Now = func() time.Time { return now }
+
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	ctx := context.Background()
+	oauth := &automatic.OAuth{BaseURL: server.URL, ClientID: config.ClientID, ClientSecret: config.ClientSecret}
+
+	t.Run("lists users on the authorize page", func(t *testing.T) {
+		authorize, err := oauth.AuthorizeURL("some-state", []string{"scope:public"})
+		require.NoError(t, err)
+
+		response, err := http.Get(authorize)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Contains(t, response.Header.Get("Content-Type"), "text/html")
+	})
+
+	token, err := oauth.Exchange(ctx, signIn(t, oauth, "U_simulated"))
+	require.NoError(t, err)
+	assert.Equal(t, "U_simulated", token.UserID)
+	assert.Equal(t, "scope:public scope:trip", token.Scope)
+
+	client := &automatic.Client{BaseURL: server.URL, Tokens: automatic.StaticToken(token.AccessToken)}
+
+	t.Run("codes can only be used once", func(t *testing.T) {
+		code := signIn(t, oauth, "U_simulated")
+		_, err := oauth.Exchange(ctx, code)
+		require.NoError(t, err)
+
+		_, err = oauth.Exchange(ctx, code)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects unknown clients", func(t *testing.T) {
+		wrong := *oauth
+		wrong.ClientSecret = "wrong"
+		_, err := wrong.Exchange(ctx, signIn(t, oauth, "U_simulated"))
+		assert.Error(t, err)
+	})
+
+	t.Run("returns the signed in user", func(t *testing.T) {
+		user, err := client.User(ctx, "U_simulated")
+		require.NoError(t, err)
+		assert.Equal(t, "simulated@example.com", user.Email)
+
+		_, err = client.User(ctx, "U_someone_else")
+		assert.True(t, errors.Is(err, automatic.ErrNotFound))
+	})
+
+	t.Run("pages through vehicles", func(t *testing.T) {
+		var pages int
+		err := client.Vehicles(ctx, func([]automatic.Vehicle) error {
+			pages++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, pages)
+
+		vehicles, err := client.AllVehicles(ctx)
+		require.NoError(t, err)
+		require.Len(t, vehicles, 2)
+		assert.Equal(t, "C_accord", vehicles[0].ID)
+	})
+
+	t.Run("generates the same trips every time", func(t *testing.T) {
+		trips, err := client.AllTrips(ctx, automatic.TripQuery{Vehicle: "C_accord", PageSize: 7})
+		require.NoError(t, err)
+		expected := sim.Trips("C_accord")
+		require.Len(t, trips, len(expected))
+		assert.True(t, len(trips) >= 89, "30 days of a trip every 8 hours")
+
+		for i, trip := range trips {
+			assert.Equal(t, expected[i].ID, trip.ID)
+			assert.Equal(t, expected[i].Distance, trip.Distance)
+			assert.Equal(t, server.URL+"/vehicle/C_accord/", trip.Vehicle)
+			assert.False(t, trip.EndedAt.After(now), "only finished trips are returned")
+			assert.True(t, trip.Distance >= 9000 && trip.Distance <= 27000)
+			if i > 0 {
+				assert.True(t, trip.StartedAt.Before(trips[i-1].StartedAt), "newest first")
+			}
+		}
+	})
+
+	t.Run("filters trips by start time", func(t *testing.T) {
+		since := now.Add(-48 * time.Hour)
+		trips, err := client.AllTrips(ctx, automatic.TripQuery{Vehicle: "C_outback", StartedAfter: since})
+		require.NoError(t, err)
+		assert.NotEmpty(t, trips)
+		for _, trip := range trips {
+			assert.False(t, trip.StartedAt.Before(since))
+		}
+	})
+
+	t.Run("new trips finish as time passes", func(t *testing.T) {
+		newest := sim.Trips("C_accord")[0]
+		now = now.Add(24 * time.Hour)
+		defer func() { now = now.Add(-24 * time.Hour) }()
+
+		later := sim.Trips("C_accord")
+		assert.True(t, later[0].StartedAt.After(newest.StartedAt))
+		assert.Contains(t, later, newest)
+	})
+
+	t.Run("refreshes expired tokens", func(t *testing.T) {
+		sim.ExpireTokens("U_simulated")
+
+		_, err := client.User(ctx, "U_simulated")
+		require.True(t, errors.Is(err, automatic.ErrUnauthorized))
+
+		refreshed, err := oauth.Refresh(ctx, token.RefreshToken)
+		require.NoError(t, err)
+
+		client := &automatic.Client{BaseURL: server.URL, Tokens: automatic.StaticToken(refreshed.AccessToken)}
+		_, err = client.User(ctx, "U_simulated")
+		assert.NoError(t, err)
+
+		_, err = oauth.Refresh(ctx, token.RefreshToken)
+		assert.Error(t, err, "refresh tokens can only be used once")
+	})
+}
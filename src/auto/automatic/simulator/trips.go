@@ -0,0 +1,76 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+)
+
+// TripStream generates a vehicle's trips. A trip starts roughly every interval,
+// counted from the unix epoch, so the same trips are generated every time the
+// simulator runs and new ones finish as time passes.
+type TripStream struct {
+	// IntervalHours is the time between trips. Zero means the vehicle never drives.
+	IntervalHours float64 `json:"interval_h"`
+	// DistanceMeters is the average trip distance. Each trip is 50% to 150% of it.
+	DistanceMeters float64 `json:"distance_m"`
+	// HistoryDays is how far back trips go
+	HistoryDays int `json:"history_d"`
+	// Seed varies the start times and distances between vehicles
+	Seed int64 `json:"seed"`
+}
+
+// averageSpeed is the speed trip durations are worked out from, in meters per second
+const averageSpeed = 13.0
+
+// generate returns the trips finished by now, newest first
+func (t TripStream) generate(vehicleID string, now time.Time) []automatic.Trip {
+	trips := make([]automatic.Trip, 0)
+	if t.IntervalHours <= 0 || t.DistanceMeters <= 0 {
+		return trips
+	}
+
+	interval := time.Duration(t.IntervalHours * float64(time.Hour))
+	first := now.AddDate(0, 0, -t.HistoryDays).UnixNano() / int64(interval)
+	last := now.UnixNano() / int64(interval)
+
+	for n := last; n >= first; n-- {
+		random := rand.New(rand.NewSource(t.Seed*1000003 + n))
+
+		// Trips start in the first half of their interval and end before the next one starts
+		startedAt := time.Unix(0, n*int64(interval)+random.Int63n(int64(interval)/2)).UTC().Truncate(time.Second)
+		distance := float64(int64(t.DistanceMeters * (0.5 + random.Float64())))
+		duration := time.Duration(distance/averageSpeed) * time.Second
+		if duration > interval/2 {
+			duration = interval / 2
+		}
+		endedAt := startedAt.Add(duration)
+
+		if endedAt.After(now) {
+			continue
+		}
+
+		trips = append(trips, automatic.Trip{
+			ID:           fmt.Sprintf("T_%s_%d", vehicleID, n),
+			StartedAt:    startedAt,
+			EndedAt:      endedAt,
+			Distance:     distance,
+			Duration:     duration.Seconds(),
+			StartAddress: &automatic.Address{Name: addresses[random.Intn(len(addresses))]},
+			EndAddress:   &automatic.Address{Name: addresses[random.Intn(len(addresses))]},
+		})
+	}
+
+	return trips
+}
+
+var addresses = []string{
+	"1600 Pennsylvania Ave NW, Washington, DC",
+	"350 5th Ave, New York, NY",
+	"1 Infinite Loop, Cupertino, CA",
+	"600 Montgomery St, San Francisco, CA",
+	"233 S Wacker Dr, Chicago, IL",
+	"400 Broad St, Seattle, WA",
+}
@@ -1,5 +1,20 @@
 package auto
 
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/segmentio/ksuid"
+)
+
+// tokenRetention is how long a token is kept after it expires, so it can still be refreshed
+const tokenRetention = 90 * 24 * time.Hour
+
 type AutomaticAccessToken struct {
 	UserID       string `json:"user_id" validate:"required"`
 	AccessToken  string `json:"access_token" validate:"required"`
@@ -7,4 +22,159 @@ type AutomaticAccessToken struct {
 	Scope        string `json:"scope" validate:"required"`
 	RefreshToken string `json:"refresh_token" validate:"required"`
 	TokenType    string `json:"token_type" validate:"required"`
+
+	// IssuedAt is when Automatic issued the token. Together with ExpiresIn it says when the token expires.
+	IssuedAt time.Time `json:"-"`
+}
+
+// NewAutomaticAccessToken returns the stored form of a token issued now
+func NewAutomaticAccessToken(t *automatic.Token) AutomaticAccessToken {
+	return AutomaticAccessToken{
+		UserID:       t.UserID,
+		AccessToken:  t.AccessToken,
+		ExpiresIn:    t.ExpiresIn,
+		Scope:        t.Scope,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		IssuedAt:     time.Now(),
+	}
+}
+
+// ExpiresAt returns when the access token stops working
+func (t AutomaticAccessToken) ExpiresAt() time.Time {
+	return t.IssuedAt.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// SaveAutomaticAccessToken stores a token issued to an existing account, such as a refreshed token
+func (s *DynamoStore) SaveAutomaticAccessToken(ctx context.Context, account *Account, token AutomaticAccessToken) error {
+	if token.UserID == "" {
+		token.UserID = account.AutomaticID
+	}
+
+	// Token IDs only sort by time to the second. A token refreshed within a
+	// second of the one before it takes the next ID so it still sorts last.
+	id := ksuid.New()
+	latest, err := s.latestAutomaticAccessTokenItem(ctx, account)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+	if parts, err := keys.AccessTokenSortKey.Parse(StringFromDynamo(latest[keys.RangeKeyAttribute])); err == nil {
+		if previous, err := ksuid.Parse(parts[0]); err == nil && ksuid.Compare(id, previous) <= 0 {
+			id = previous.Next()
+		}
+	}
+
+	item, err := token.dynamo(ctx, s.encryption(), account, id.String())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: s.table,
+		Item:      item,
+	})
+
+	return err
+}
+
+// LatestAutomaticAccessToken returns the most recently issued Automatic token for the account
+func (s *DynamoStore) LatestAutomaticAccessToken(ctx context.Context, account *Account) (*AutomaticAccessToken, error) {
+	item, err := s.latestAutomaticAccessTokenItem(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+	if value := item["Scopes"]; value != nil {
+		scopes = aws.StringValueSlice(value.SS)
+	}
+
+	secrets, err := openAttributes(ctx, s.encryption(), item)
+	if err != nil {
+		return nil, err
+	}
+
+	issuedAt := TimeFromDynamo(item["IssuedAt"])
+	if issuedAt.IsZero() {
+		// Tokens stored before IssuedAt was written were issued when their KSUID was generated.
+		if parts, err := keys.AccessTokenSortKey.Parse(StringFromDynamo(item[keys.RangeKeyAttribute])); err == nil {
+			if id, err := ksuid.Parse(parts[0]); err == nil {
+				issuedAt = id.Time()
+			}
+		}
+	}
+
+	return &AutomaticAccessToken{
+		UserID:       account.AutomaticID,
+		AccessToken:  secrets["AccessToken"],
+		ExpiresIn:    int(IntFromDynamo(item["ExpiresIn"])),
+		Scope:        strings.Join(scopes, " "),
+		RefreshToken: secrets["RefreshToken"],
+		TokenType:    "bearer",
+		IssuedAt:     issuedAt,
+	}, nil
+}
+
+func (s *DynamoStore) latestAutomaticAccessTokenItem(ctx context.Context, account *Account) (map[string]*dynamodb.AttributeValue, error) {
+	result, err := s.db.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              s.table,
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(keys.HashKeyAttribute),
+			"#sk": aws.String(keys.RangeKeyAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(account.ID)},
+			":sk": {S: aws.String(keys.AccessTokenSortKey.Prefix())},
+		},
+		// Token sort keys end in a KSUID, so the newest token sorts last.
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return result.Items[0], nil
+}
+
+func (t AutomaticAccessToken) dynamo(ctx context.Context, p KeyProvider, account *Account, id string) (map[string]*dynamodb.AttributeValue, error) {
+	primary, err := keys.AccessToken(account.ID, id)
+	if err != nil {
+		return nil, err
+	}
+	index, err := keys.AccessTokenIndex(t.UserID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := keys.Item{Primary: primary, Indexes: []keys.IndexKey{index}}.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.IssuedAt.IsZero() {
+		t.IssuedAt = time.Now()
+	}
+
+	item[TimeToLiveAttribute] = DynamoTime(t.ExpiresAt().Add(tokenRetention))
+	item["IssuedAt"] = DynamoTime(t.IssuedAt)
+	item["ExpiresIn"] = DynamoInt(int64(t.ExpiresIn))
+
+	err = sealAttributes(ctx, p, item, map[string]string{
+		"AccessToken":  t.AccessToken,
+		"RefreshToken": t.RefreshToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Scope != "" {
+		item["Scopes"] = &dynamodb.AttributeValue{SS: aws.StringSlice(strings.Split(t.Scope, " "))}
+	}
+
+	return item, nil
 }
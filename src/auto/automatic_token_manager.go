@@ -0,0 +1,89 @@
+package auto
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+)
+
+// automaticTokenRefreshWindow is how long before it expires a token is refreshed, so it can't expire part way through a sync
+const automaticTokenRefreshWindow = 10 * time.Minute
+
+// AutomaticTokenManager signs an account's Automatic API requests. It reads the
+// account's latest token and refreshes it when it's about to expire, storing the
+// new token for later requests.
+type AutomaticTokenManager struct {
+	store   TokenStore
+	oauth   *automatic.OAuth
+	account *Account
+
+	mu    sync.Mutex
+	token *AutomaticAccessToken
+}
+
+// NewAutomaticTokenManager returns a token manager for the account
+func NewAutomaticTokenManager(store TokenStore, oauth *automatic.OAuth, account *Account) *AutomaticTokenManager {
+	return &AutomaticTokenManager{store: store, oauth: oauth, account: account}
+}
+
+// Token returns a current access token. It implements automatic.TokenSource.
+func (m *AutomaticTokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == nil {
+		token, err := m.store.LatestAutomaticAccessToken(ctx, m.account)
+		if err != nil {
+			return "", err
+		}
+		m.token = token
+	}
+
+	if time.Until(m.token.ExpiresAt()) > automaticTokenRefreshWindow {
+		return m.token.AccessToken, nil
+	}
+
+	return m.refresh(ctx)
+}
+
+// Refresh replaces a token the API has rejected. It implements automatic.RefreshableTokenSource.
+func (m *AutomaticTokenManager) Refresh(ctx context.Context, rejected string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == nil {
+		token, err := m.store.LatestAutomaticAccessToken(ctx, m.account)
+		if err != nil {
+			return "", err
+		}
+		m.token = token
+	}
+	if m.token.AccessToken != rejected {
+		// It has already been replaced
+		return m.token.AccessToken, nil
+	}
+
+	return m.refresh(ctx)
+}
+
+// refresh trades the current token's refresh token for a new token. The caller holds the lock.
+func (m *AutomaticTokenManager) refresh(ctx context.Context) (string, error) {
+	issued, err := m.oauth.Refresh(ctx, m.token.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("refreshing Automatic token for %s: %w", m.account.ID, err)
+	}
+
+	token := NewAutomaticAccessToken(issued)
+	if token.UserID == "" {
+		token.UserID = m.account.AutomaticID
+	}
+	if err := m.store.SaveAutomaticAccessToken(ctx, m.account, token); err != nil {
+		return "", err
+	}
+	m.token = &token
+
+	return m.token.AccessToken, nil
+}
@@ -0,0 +1,93 @@
+package auto
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutomaticTokenManager(t *testing.T) {
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		w.Write([]byte(`{"access_token":"refreshed","refresh_token":"refresh-2","expires_in":3600,"token_type":"bearer"}`))
+	}))
+	defer server.Close()
+
+	oauth := &automatic.OAuth{BaseURL: server.URL}
+	ctx := context.Background()
+
+	t.Run("returns a current token without refreshing", func(t *testing.T) {
+		store := NewMemoryStore()
+		account := &Account{ID: "auid:current"}
+		require.NoError(t, store.SaveAutomaticAuthentication(ctx, account, AutomaticAccessToken{
+			UserID:       "U_current",
+			AccessToken:  "current",
+			RefreshToken: "refresh-1",
+			ExpiresIn:    3600,
+		}, nil))
+
+		token, err := NewAutomaticTokenManager(store, oauth, account).Token(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "current", token)
+		assert.Equal(t, 0, refreshes)
+	})
+
+	t.Run("refreshes and stores an expiring token", func(t *testing.T) {
+		store := NewMemoryStore()
+		account := &Account{ID: "auid:expiring"}
+		require.NoError(t, store.SaveAutomaticAuthentication(ctx, account, AutomaticAccessToken{
+			UserID:       "U_expiring",
+			AccessToken:  "expiring",
+			RefreshToken: "refresh-1",
+			ExpiresIn:    3600,
+			IssuedAt:     time.Now().Add(-time.Hour + time.Minute),
+		}, nil))
+
+		manager := NewAutomaticTokenManager(store, oauth, account)
+
+		token, err := manager.Token(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "refreshed", token)
+		assert.Equal(t, 1, refreshes)
+
+		_, err = manager.Token(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, refreshes, "the refreshed token is reused")
+
+		latest, err := store.LatestAutomaticAccessToken(ctx, account)
+		require.NoError(t, err)
+		assert.Equal(t, "refreshed", latest.AccessToken)
+		assert.Equal(t, "refresh-2", latest.RefreshToken)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), latest.ExpiresAt(), 5*time.Second)
+	})
+
+	t.Run("refreshes a rejected token once", func(t *testing.T) {
+		store := NewMemoryStore()
+		account := &Account{ID: "auid:rejected"}
+		require.NoError(t, store.SaveAutomaticAuthentication(ctx, account, AutomaticAccessToken{
+			UserID:       "U_rejected",
+			AccessToken:  "revoked",
+			RefreshToken: "refresh-1",
+			ExpiresIn:    3600,
+		}, nil))
+
+		manager := NewAutomaticTokenManager(store, oauth, account)
+		before := refreshes
+
+		token, err := manager.Refresh(ctx, "revoked")
+		require.NoError(t, err)
+		assert.Equal(t, "refreshed", token)
+
+		token, err = manager.Refresh(ctx, "revoked")
+		require.NoError(t, err)
+		assert.Equal(t, "refreshed", token)
+		assert.Equal(t, before+1, refreshes, "a token that's already been replaced isn't refreshed again")
+	})
+}
@@ -0,0 +1,409 @@
+// Package cassette replays recorded HTTP interactions in tests, so code that
+// calls external APIs can be tested offline.
+//
+// A cassette is a list of interactions, each a request and the response to
+// send for it. In replay mode a request is matched strictly on method, path,
+// query and body against the interactions that haven't been used yet, and a
+// request that matches none fails. In record mode requests are sent upstream
+// and the interactions are saved to the cassette's file.
+//
+// Cassettes are JSON files, usually under testdata/cassettes. Request headers
+// and most response headers are never recorded. Secrets sent in bodies and
+// queries, like OAuth client secrets, codes and tokens, are replaced with
+// Scrubbed in both requests and responses, and requests are matched with them
+// scrubbed. Anything else in a response body is recorded as it is, so check
+// recordings for personal details before they're committed.
+package cassette
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnmatched is returned in replay mode for a request no interaction matches
+var ErrUnmatched = errors.New("cassette: unmatched request")
+
+// Mode is how a cassette handles requests
+type Mode int
+
+const (
+	// Replay answers requests from the cassette and fails requests it can't match
+	Replay Mode = iota
+	// Record sends requests upstream and records the interactions
+	Record
+)
+
+// ModeFromEnv returns Record when CASSETTE_MODE is "record", and Replay otherwise
+func ModeFromEnv() Mode {
+	if os.Getenv("CASSETTE_MODE") == "record" {
+		return Record
+	}
+	return Replay
+}
+
+// SendFunc sends a request upstream
+type SendFunc func(*http.Request) (*http.Response, error)
+
+// Cassette is a set of interactions. It's safe for concurrent use.
+type Cassette struct {
+	// Path is the file the cassette is loaded from and saved to
+	Path string
+	Mode Mode
+	// Upstream sends requests in Record mode
+	Upstream SendFunc
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+	unmatched    []string
+}
+
+type file struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// New returns a replaying cassette with the passed interactions
+func New(interactions ...Interaction) *Cassette {
+	c := &Cassette{}
+	c.Add(interactions...)
+	return c
+}
+
+// Load reads a cassette from a file. A missing file is an error in Replay mode
+// and an empty cassette in Record mode.
+func Load(path string, mode Mode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && mode == Record {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f := file{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	for _, i := range f.Interactions {
+		c.Add(*i)
+	}
+
+	return c, nil
+}
+
+// Add appends interactions to the cassette
+func (c *Cassette) Add(interactions ...Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range interactions {
+		interaction := interactions[i]
+		interaction.Request = interaction.Request.scrubbed()
+		c.interactions = append(c.interactions, &interaction)
+		c.used = append(c.used, false)
+	}
+}
+
+// Send answers the request. Its signature matches the API handler's httpStack sender.
+func (c *Cassette) Send(_ *http.Client, r *http.Request) (*http.Response, error) {
+	return c.Do(r)
+}
+
+// Do answers the request from the cassette, or in Record mode sends it upstream and records it
+func (c *Cassette) Do(r *http.Request) (*http.Response, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	request := newRequest(r, body).scrubbed()
+
+	if c.Mode == Record {
+		return c.record(r, request)
+	}
+
+	interaction, err := c.match(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return interaction.respond(r)
+}
+
+func (c *Cassette) match(request Request) (*Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, interaction := range c.interactions {
+		if c.used[i] && !interaction.Repeat {
+			continue
+		}
+		if interaction.Request.matches(request) {
+			c.used[i] = true
+			return interaction, nil
+		}
+	}
+
+	description := request.String()
+	c.unmatched = append(c.unmatched, description)
+	return nil, fmt.Errorf("%w: %s", ErrUnmatched, description)
+}
+
+func (c *Cassette) record(r *http.Request, request Request) (*http.Response, error) {
+	if c.Upstream == nil {
+		return nil, errors.New("cassette: recording needs an Upstream")
+	}
+
+	response, err := c.Upstream(r)
+	if err != nil {
+		c.Add(Interaction{Request: request, Error: err.Error()})
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	for _, name := range recordedHeaders {
+		if value := response.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+
+	c.Add(Interaction{
+		Request:  request,
+		Response: Response{Status: response.StatusCode, Headers: headers, Body: scrubBody(encodeBody(data))},
+	})
+
+	// The caller gets the response as it was sent, as it may need the secrets to carry on.
+	live := Interaction{Response: Response{Status: response.StatusCode, Headers: headers, Body: encodeBody(data)}}
+	return live.respond(r)
+}
+
+// recordedHeaders are the response headers worth keeping. Others, like cookies, are dropped.
+var recordedHeaders = []string{"Content-Type", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"}
+
+// Save writes the cassette's interactions to its file
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(file{Interactions: c.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.Path, append(data, '\n'), 0644)
+}
+
+// Unmatched describes the requests that didn't match any interaction
+func (c *Cassette) Unmatched() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.unmatched...)
+}
+
+// Unused describes the interactions no request has matched
+func (c *Cassette) Unused() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unused := make([]string, 0)
+	for i, interaction := range c.interactions {
+		if !c.used[i] {
+			unused = append(unused, interaction.Request.String())
+		}
+	}
+	return unused
+}
+
+// Interaction is a request and what the cassette answers it with
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+
+	// Error fails the request with a transport error instead of responding
+	Error string `json:"error,omitempty"`
+	// Latency delays the answer. A request whose context ends first fails with the context's error.
+	Latency Duration `json:"latency,omitempty"`
+	// Repeat lets the interaction answer any number of requests
+	Repeat bool `json:"repeat,omitempty"`
+}
+
+func (i *Interaction) respond(r *http.Request) (*http.Response, error) {
+	if i.Latency > 0 {
+		if err := wait(r.Context(), time.Duration(i.Latency)); err != nil {
+			return nil, err
+		}
+	}
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+
+	header := http.Header{}
+	for name, value := range i.Response.Headers {
+		header.Set(name, value)
+	}
+	status := i.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := decodeBody(i.Response.Body)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+// Request is the part of a request an interaction is matched on
+type Request struct {
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Query  url.Values `json:"query,omitempty"`
+	// Body is kept as JSON when the request body is JSON, and as a string otherwise
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+func newRequest(r *http.Request, body []byte) Request {
+	request := Request{Method: r.Method, Path: r.URL.Path, Body: encodeBody(body)}
+	if query := r.URL.Query(); len(query) > 0 {
+		request.Query = query
+	}
+	return request
+}
+
+func (r Request) matches(other Request) bool {
+	if r.Method != other.Method || r.Path != other.Path {
+		return false
+	}
+	if len(r.Query) != 0 || len(other.Query) != 0 {
+		if !reflect.DeepEqual(r.Query, other.Query) {
+			return false
+		}
+	}
+	return sameBody(r.Body, other.Body)
+}
+
+func (r Request) String() string {
+	description := r.Method + " " + r.Path
+	if len(r.Query) > 0 {
+		description += "?" + r.Query.Encode()
+	}
+	if len(r.Body) > 0 {
+		description += " " + string(r.Body)
+	}
+	return description
+}
+
+// Response is what an interaction answers with
+type Response struct {
+	// Status defaults to 200
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is JSON, or a JSON string holding a body that isn't JSON
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "250ms"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// encodeBody returns JSON bodies as they are and anything else as a JSON string
+func encodeBody(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	encoded, _ := json.Marshal(string(body))
+	return encoded
+}
+
+// decodeBody reverses encodeBody
+func decodeBody(body json.RawMessage) []byte {
+	var text string
+	if strings.HasPrefix(string(bytes.TrimSpace(body)), `"`) && json.Unmarshal(body, &text) == nil {
+		return []byte(text)
+	}
+	return body
+}
+
+// sameBody compares JSON bodies by value, so key order and whitespace don't matter
+func sameBody(a, b json.RawMessage) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	var left, right interface{}
+	if json.Unmarshal(a, &left) != nil || json.Unmarshal(b, &right) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
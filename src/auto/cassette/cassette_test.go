@@ -0,0 +1,207 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, c *Cassette, uri string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, uri, nil)
+	require.NoError(t, err)
+	return c.Do(request)
+}
+
+func readAll(t *testing.T, response *http.Response) string {
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestReplay(t *testing.T) {
+	t.Run("answers matching requests once each, in order", func(t *testing.T) {
+		c := New(
+			Respond(http.MethodGet, "/user/U_1/", http.StatusServiceUnavailable, "down"),
+			Respond(http.MethodGet, "/user/U_1/", http.StatusOK, map[string]string{"id": "U_1"}),
+		)
+
+		response, err := get(t, c, "https://api.automatic.com/user/U_1/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		assert.Equal(t, "down", readAll(t, response))
+
+		response, err = get(t, c, "https://api.automatic.com/user/U_1/")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id":"U_1"}`, readAll(t, response))
+
+		_, err = get(t, c, "https://api.automatic.com/user/U_1/")
+		assert.True(t, errors.Is(err, ErrUnmatched))
+		assert.Equal(t, []string{"GET /user/U_1/"}, c.Unmatched())
+		assert.Empty(t, c.Unused())
+	})
+
+	t.Run("matches strictly on the query and body", func(t *testing.T) {
+		c := New(
+			Respond(http.MethodGet, "/trip/", http.StatusOK, "[]").WithQuery(url.Values{"vehicle": {"C_1"}}),
+			Respond(http.MethodPost, "/oauth/access_token/", http.StatusOK, "{}").WithRequestBody(map[string]string{"code": "abc", "grant_type": "authorization_code"}),
+		)
+
+		_, err := get(t, c, "https://api.automatic.com/trip/?vehicle=C_2")
+		assert.Error(t, err)
+		_, err = get(t, c, "https://api.automatic.com/trip/")
+		assert.Error(t, err)
+		_, err = get(t, c, "https://api.automatic.com/trip/?vehicle=C_1")
+		assert.NoError(t, err)
+
+		post := func(body string) error {
+			_, err := c.Do(httptest.NewRequest(http.MethodPost, "https://accounts.automatic.com/oauth/access_token/", strings.NewReader(body)))
+			return err
+		}
+		assert.Error(t, post(`{"code":"abc","grant_type":"refresh_token"}`))
+		assert.NoError(t, post(`{"grant_type": "authorization_code", "code": "abc"}`), "JSON bodies match by value")
+
+		assert.Len(t, c.Unmatched(), 3)
+	})
+
+	t.Run("matches secrets by their scrubbed values", func(t *testing.T) {
+		c := New(
+			Respond(http.MethodPost, "/oauth/access_token/", http.StatusOK, "{}").WithRequestBody(map[string]string{"code": "recorded", "grant_type": "authorization_code"}),
+			Respond(http.MethodPost, "/oauth/access_token/", http.StatusOK, "{}").WithRequestBody("grant_type=refresh_token&refresh_token=recorded"),
+			Respond(http.MethodGet, "/user/", http.StatusOK, "{}").WithQuery(url.Values{"access_token": {"recorded"}}),
+		)
+
+		post := func(body string) error {
+			_, err := c.Do(httptest.NewRequest(http.MethodPost, "https://accounts.automatic.com/oauth/access_token/", strings.NewReader(body)))
+			return err
+		}
+		assert.NoError(t, post(`{"code":"sent","grant_type":"authorization_code"}`))
+		assert.NoError(t, post("grant_type=refresh_token&refresh_token=sent"), "form bodies are scrubbed too")
+
+		_, err := get(t, c, "https://api.automatic.com/user/?access_token=sent")
+		assert.NoError(t, err)
+	})
+
+	t.Run("repeats interactions marked as repeated", func(t *testing.T) {
+		c := New(Respond(http.MethodGet, "/device/", http.StatusOK, "{}").Repeated())
+		for i := 0; i < 3; i++ {
+			_, err := get(t, c, "https://api.automatic.com/device/")
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("simulates failures and latency", func(t *testing.T) {
+		c := New(
+			Fail(http.MethodGet, "/vehicle/", "connection reset by peer"),
+			Respond(http.MethodGet, "/vehicle/", http.StatusOK, "{}").WithLatency(time.Second),
+		)
+
+		_, err := get(t, c, "https://api.automatic.com/vehicle/")
+		assert.EqualError(t, err, "connection reset by peer")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		request, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.automatic.com/vehicle/", nil)
+		_, err = c.Do(request)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("builds paginated lists", func(t *testing.T) {
+		c := New(Pages("/vehicle/", url.Values{"limit": {"1"}},
+			[]map[string]string{{"id": "C_1"}},
+			[]map[string]string{{"id": "C_2"}},
+		)...)
+
+		ids := make([]string, 0)
+		next := "https://api.automatic.com/vehicle/?limit=1"
+		for next != "" {
+			response, err := get(t, c, next)
+			require.NoError(t, err)
+
+			page := struct {
+				Metadata struct {
+					Count int
+					Next  string
+				} `json:"_metadata"`
+				Results []struct{ ID string }
+			}{}
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&page))
+			assert.Equal(t, 2, page.Metadata.Count)
+
+			for _, result := range page.Results {
+				ids = append(ids, result.ID)
+			}
+			next = page.Metadata.Next
+		}
+
+		assert.Equal(t, []string{"C_1", "C_2"}, ids)
+		assert.Empty(t, c.Unused())
+	})
+}
+
+func TestRecord(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-RateLimit-Remaining", "99")
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"access_token":"issued-access","refresh_token":"issued-refresh","user":{"id":"U_1"}}`))
+			return
+		}
+		w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "cassette")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recorded.json")
+
+	c, err := Load(path, Record)
+	require.NoError(t, err)
+	c.Upstream = upstream.Client().Do
+
+	request, _ := http.NewRequest(http.MethodGet, upstream.URL+"/user/U_1/?fields=id", nil)
+	request.Header.Set("Authorization", "Bearer access")
+	response, err := c.Do(request)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"path":"/user/U_1/"}`, readAll(t, response))
+
+	token, _ := http.NewRequest(http.MethodPost, upstream.URL+"/oauth/access_token/", strings.NewReader(`{"client_secret":"client-secret","code":"oauth-code","grant_type":"authorization_code"}`))
+	response, err = c.Do(token)
+	require.NoError(t, err)
+	assert.Contains(t, readAll(t, response), "issued-access", "the caller gets the secrets")
+	require.NoError(t, c.Save())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Bearer", "request headers aren't recorded")
+	assert.NotContains(t, string(data), "session", "only known response headers are recorded")
+	for _, secret := range []string{"client-secret", "oauth-code", "issued-access", "issued-refresh"} {
+		assert.NotContains(t, string(data), secret)
+	}
+	assert.Contains(t, string(data), "U_1", "other fields are recorded")
+
+	replay, err := Load(path, Replay)
+	require.NoError(t, err)
+
+	response, err = get(t, replay, "https://api.automatic.com/user/U_1/?fields=id")
+	require.NoError(t, err)
+	assert.Equal(t, "99", response.Header.Get("X-RateLimit-Remaining"))
+	assert.JSONEq(t, `{"path":"/user/U_1/"}`, readAll(t, response))
+
+	response, err = replay.Do(httptest.NewRequest(http.MethodPost, "https://accounts.automatic.com/oauth/access_token/", strings.NewReader(`{"client_secret":"other","code":"other","grant_type":"authorization_code"}`)))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"access_token":"[scrubbed]","refresh_token":"[scrubbed]","user":{"id":"U_1"}}`, readAll(t, response))
+}
@@ -0,0 +1,126 @@
+package cassette
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Respond returns an interaction that answers a request for the method and
+// path with the status and body. A string or []byte body is sent as it is, and
+// anything else is encoded as JSON.
+func Respond(method, path string, status int, body interface{}) Interaction {
+	return Interaction{
+		Request:  Request{Method: method, Path: path},
+		Response: Response{Status: status, Headers: map[string]string{"Content-Type": "application/json"}, Body: marshalBody(body)},
+	}
+}
+
+// Fail returns an interaction that fails a request for the method and path with a transport error, such as a reset connection
+func Fail(method, path string, err string) Interaction {
+	return Interaction{Request: Request{Method: method, Path: path}, Error: err}
+}
+
+// WithQuery returns the interaction matching only requests with the query
+func (i Interaction) WithQuery(query url.Values) Interaction {
+	i.Request.Query = query
+	return i
+}
+
+// WithRequestBody returns the interaction matching only requests with the body
+func (i Interaction) WithRequestBody(body interface{}) Interaction {
+	i.Request.Body = marshalBody(body)
+	return i
+}
+
+// WithHeader returns the interaction answering with a response header
+func (i Interaction) WithHeader(name, value string) Interaction {
+	headers := map[string]string{}
+	for k, v := range i.Response.Headers {
+		headers[k] = v
+	}
+	headers[name] = value
+	i.Response.Headers = headers
+	return i
+}
+
+// WithLatency returns the interaction answering after a delay
+func (i Interaction) WithLatency(d time.Duration) Interaction {
+	i.Latency = Duration(d)
+	return i
+}
+
+// Repeated returns the interaction answering every matching request instead of only the first
+func (i Interaction) Repeated() Interaction {
+	i.Repeat = true
+	return i
+}
+
+// PageBaseURL is the host next page links point at, as the Automatic API's do
+const PageBaseURL = "https://api.automatic.com"
+
+// Pages returns interactions answering a GET of a paginated list the way the
+// Automatic API does: each page's results come with a _metadata.next link to the
+// following page, and the last page's link is null. Pages after the first are
+// requested with the query plus page=<number>.
+func Pages(path string, query url.Values, pages ...interface{}) []Interaction {
+	interactions := make([]Interaction, len(pages))
+
+	count := 0
+	for _, page := range pages {
+		var results []interface{}
+		json.Unmarshal(marshalBody(page), &results)
+		count += len(results)
+	}
+
+	for n, page := range pages {
+		var next *string
+		if n < len(pages)-1 {
+			link := PageBaseURL + path + "?" + pageQuery(query, n+2).Encode()
+			next = &link
+		}
+
+		body := map[string]interface{}{
+			"_metadata": map[string]interface{}{"count": count, "next": next},
+			"results":   json.RawMessage(marshalBody(page)),
+		}
+
+		requestQuery := query
+		if n > 0 {
+			requestQuery = pageQuery(query, n+1)
+		}
+		interactions[n] = Respond(http.MethodGet, path, http.StatusOK, body).WithQuery(requestQuery)
+	}
+
+	return interactions
+}
+
+func pageQuery(query url.Values, page int) url.Values {
+	paged := url.Values{}
+	for k, v := range query {
+		paged[k] = v
+	}
+	paged.Set("page", strconv.Itoa(page))
+	return paged
+}
+
+func marshalBody(body interface{}) json.RawMessage {
+	switch body := body.(type) {
+	case nil:
+		return nil
+	case string:
+		return encodeBody([]byte(body))
+	case []byte:
+		return encodeBody(body)
+	case json.RawMessage:
+		return body
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}
+}
@@ -0,0 +1,110 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+)
+
+// ScrubbedFields name the JSON fields, form fields and query parameters that
+// hold secrets. Their values are replaced with Scrubbed in everything a
+// cassette records, and requests are matched with them scrubbed, so a
+// recording matches whatever secret a test sends.
+var ScrubbedFields = []string{"access_token", "client_secret", "code", "refresh_token"}
+
+// Scrubbed replaces the value of every scrubbed field
+const Scrubbed = "[scrubbed]"
+
+func isScrubbed(name string) bool {
+	for _, field := range ScrubbedFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScrubbed(values url.Values) bool {
+	for name := range values {
+		if isScrubbed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubbed returns the request with the values of scrubbed fields replaced
+func (r Request) scrubbed() Request {
+	if len(r.Query) > 0 {
+		r.Query = scrubValues(r.Query)
+	}
+	r.Body = scrubBody(r.Body)
+	return r
+}
+
+func scrubValues(values url.Values) url.Values {
+	scrubbed := make(url.Values, len(values))
+	for name, value := range values {
+		if isScrubbed(name) {
+			value = []string{Scrubbed}
+		}
+		scrubbed[name] = value
+	}
+	return scrubbed
+}
+
+// scrubBody scrubs a JSON body, or a form body kept as a JSON string. Other
+// bodies are returned as they are.
+func scrubBody(body json.RawMessage) json.RawMessage {
+	if len(body) == 0 {
+		return body
+	}
+
+	var text string
+	if json.Unmarshal(body, &text) == nil {
+		form, err := url.ParseQuery(text)
+		if err != nil || !hasScrubbed(form) {
+			return body
+		}
+		encoded, _ := json.Marshal(scrubValues(form).Encode())
+		return encoded
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if decoder.Decode(&value) != nil || !scrubValue(value) {
+		return body
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return encoded
+}
+
+// scrubValue scrubs the decoded JSON value in place, and returns true if anything was scrubbed
+func scrubValue(value interface{}) bool {
+	changed := false
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if isScrubbed(name) {
+				v[name] = Scrubbed
+				changed = true
+			} else if scrubValue(field) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if scrubValue(item) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,380 @@
+package auto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+)
+
+// Profile is the kind of environment the app runs in. It picks the config's defaults and what it requires.
+type Profile string
+
+// Profiles
+const (
+	// ProfileProduction is the deployed app. Every setting must be passed and secrets come from SSM.
+	ProfileProduction Profile = "production"
+	// ProfileLocal is SAM Local or `make serve`, against DynamoDB Local with fake secrets
+	ProfileLocal Profile = "local"
+	// ProfileTest is the test suite, which uses fake secrets and usually an in-memory table
+	ProfileTest Profile = "test"
+)
+
+// Config is the app's configuration. It's read from the environment, and
+// optionally a JSON file of environment variables named by CONFIG_FILE that the
+// environment overrides.
+type Config struct {
+	// Profile is APP_PROFILE. Without it the test suite (AUTO_TEST=true) is
+	// ProfileTest, SAM Local (AWS_SAM_LOCAL=true) is ProfileLocal, and anything
+	// else is ProfileProduction.
+	Profile Profile
+
+	// TableName is DYNAMODB_TABLE_NAME
+	TableName string
+	// DynamoDBEndpoint is AWS_DYNAMODB_ENDPOINT. Empty uses the AWS endpoint. It's only read outside
+	// production, where it defaults to a DynamoDB Local on http://localhost:8000.
+	DynamoDBEndpoint string
+
+	// APIBaseURL is API_BASE_URL, the public URL of the API that links in emails point at
+	APIBaseURL string
+
+	// AutomaticAPIURL and AutomaticAccountsURL are AUTOMATIC_API_URL and
+	// AUTOMATIC_ACCOUNTS_URL. They default to the production services.
+	AutomaticAPIURL      string
+	AutomaticAccountsURL string
+
+	Secrets SecretsConfig
+
+	Encryption EncryptionConfig
+
+	// LogLevel is LOG_LEVEL, one of debug, info, warn or error. It defaults to info.
+	LogLevel LogLevel
+
+	// problems are settings that couldn't be parsed, reported by Validate
+	problems []string
+}
+
+// Sources of secrets
+const (
+	// SecretSourceSSM reads SSM parameters. It's the default in production.
+	SecretSourceSSM = "ssm"
+	// SecretSourceEnv reads environment variables
+	SecretSourceEnv = "env"
+	// SecretSourceFile reads a JSON file of names to values
+	SecretSourceFile = "file"
+	// SecretSourceFake uses fixed secrets. It's the default outside production, and isn't allowed in it.
+	SecretSourceFake = "fake"
+)
+
+// SecretsConfig is where the app's secrets come from
+type SecretsConfig struct {
+	// Source is SECRETS_SOURCE. RETURN_FAKE_SECRETS=true is the same as SecretSourceFake.
+	Source string
+
+	// The names the secrets are looked up by: SECRETS_CLIENT_ID_PARAMETER_NAME,
+	// SECRETS_CLIENT_SECRET_PARAMETER_NAME, SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME
+	// and SECRETS_CONTACT_KEYING_SECRET_PARAMETER_NAME. They're required for SSM,
+	// and default to AUTOMATIC_CLIENT_ID, AUTOMATIC_CLIENT_SECRET, SIGNING_SECRET
+	// and CONTACT_KEYING_SECRET for the other sources.
+	ClientIDName      string
+	ClientSecretName  string
+	SigningName       string
+	ContactKeyingName string
+
+	// File is SECRETS_FILE, the file SecretSourceFile reads
+	File string
+
+	// TTL is SECRETS_TTL, how long secrets are cached before they're fetched again to pick up rotated values
+	TTL time.Duration
+}
+
+// Sources of the keys sensitive attributes are encrypted with
+const (
+	// KeySourceKMS wraps data keys with a KMS key. It's the default in production.
+	KeySourceKMS = "kms"
+	// KeySourceLocal wraps data keys with AES keys from the config. It's the default outside production, and isn't allowed in it.
+	KeySourceLocal = "local"
+)
+
+// EncryptionConfig is where the keys that encrypt sensitive attributes come from
+type EncryptionConfig struct {
+	// Source is ENCRYPTION_KEY_SOURCE
+	Source string
+
+	// KeyID is ENCRYPTION_KEY_ID, the key new data keys are wrapped with: a KMS
+	// key ARN, or one of the local keys. Changing it rotates the key, and
+	// `migrate -reencrypt` re-encrypts the items stored under the old one.
+	KeyID string
+
+	// LocalKeys is ENCRYPTION_LOCAL_KEYS, comma separated <id>=<base64 key> pairs of
+	// 256 bit keys. Old keys stay listed until nothing is encrypted with them.
+	// Without it, KeySourceLocal uses a fixed development key.
+	LocalKeys map[string][]byte
+}
+
+// ConfigError lists everything wrong with a config
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// LoadConfig reads the config and validates it. The file is a JSON object of
+// environment variables, and an empty path reads CONFIG_FILE instead.
+func LoadConfig(path string) (*Config, error) {
+	env, err := configEnv(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := newConfig(env)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate returns a ConfigError when settings the profile requires are missing or invalid
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	require := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	switch c.Profile {
+	case ProfileProduction:
+		require(c.TableName != "", "DYNAMODB_TABLE_NAME is required")
+		require(c.APIBaseURL != "", "API_BASE_URL is required")
+		require(c.Secrets.Source != SecretSourceFake, "fake secrets aren't allowed in production")
+	case ProfileLocal:
+		require(c.TableName != "", "DYNAMODB_TABLE_NAME is required")
+	case ProfileTest:
+	default:
+		require(false, "APP_PROFILE %q isn't one of production, local or test", c.Profile)
+	}
+
+	switch c.Secrets.Source {
+	case SecretSourceSSM:
+		require(c.Secrets.ClientIDName != "", "SECRETS_CLIENT_ID_PARAMETER_NAME is required")
+		require(c.Secrets.ClientSecretName != "", "SECRETS_CLIENT_SECRET_PARAMETER_NAME is required")
+		require(c.Secrets.SigningName != "", "SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME is required")
+		require(c.Secrets.ContactKeyingName != "", "SECRETS_CONTACT_KEYING_SECRET_PARAMETER_NAME is required")
+	case SecretSourceFile:
+		require(c.Secrets.File != "", "SECRETS_FILE is required")
+	case SecretSourceEnv, SecretSourceFake:
+	default:
+		require(false, "SECRETS_SOURCE %q isn't one of ssm, env, file or fake", c.Secrets.Source)
+	}
+	require(c.Secrets.TTL > 0, "SECRETS_TTL must be a positive duration")
+
+	switch c.Encryption.Source {
+	case KeySourceKMS:
+		require(c.Encryption.KeyID != "", "ENCRYPTION_KEY_ID is required")
+	case KeySourceLocal:
+		require(c.Profile != ProfileProduction, "local encryption keys aren't allowed in production")
+		_, ok := c.Encryption.LocalKeys[c.Encryption.KeyID]
+		require(ok, "ENCRYPTION_KEY_ID %q isn't one of ENCRYPTION_LOCAL_KEYS", c.Encryption.KeyID)
+	default:
+		require(false, "ENCRYPTION_KEY_SOURCE %q isn't one of kms or local", c.Encryption.Source)
+	}
+
+	for _, problem := range c.problems {
+		require(false, problem)
+	}
+
+	for name, value := range map[string]string{
+		"API_BASE_URL":           c.APIBaseURL,
+		"AWS_DYNAMODB_ENDPOINT":  c.DynamoDBEndpoint,
+		"AUTOMATIC_API_URL":      c.AutomaticAPIURL,
+		"AUTOMATIC_ACCOUNTS_URL": c.AutomaticAccountsURL,
+	} {
+		if value == "" {
+			continue
+		}
+		uri, err := url.Parse(value)
+		require(err == nil && uri.IsAbs() && uri.Host != "", "%s %q isn't an absolute URL", name, value)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return &ConfigError{Problems: problems}
+}
+
+// newConfig builds a config from environment variables and fills in the profile's defaults
+func newConfig(env map[string]string) *Config {
+	config := &Config{
+		Profile:              Profile(env["APP_PROFILE"]),
+		TableName:            env["DYNAMODB_TABLE_NAME"],
+		APIBaseURL:           env["API_BASE_URL"],
+		AutomaticAPIURL:      env["AUTOMATIC_API_URL"],
+		AutomaticAccountsURL: env["AUTOMATIC_ACCOUNTS_URL"],
+		Secrets: SecretsConfig{
+			Source:            env["SECRETS_SOURCE"],
+			ClientIDName:      env["SECRETS_CLIENT_ID_PARAMETER_NAME"],
+			ClientSecretName:  env["SECRETS_CLIENT_SECRET_PARAMETER_NAME"],
+			SigningName:       env["SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME"],
+			ContactKeyingName: env["SECRETS_CONTACT_KEYING_SECRET_PARAMETER_NAME"],
+			File:              env["SECRETS_FILE"],
+			TTL:               5 * time.Minute,
+		},
+		Encryption: EncryptionConfig{
+			Source: env["ENCRYPTION_KEY_SOURCE"],
+			KeyID:  env["ENCRYPTION_KEY_ID"],
+		},
+		LogLevel: LogInfo,
+	}
+
+	if ttl, ok := env["SECRETS_TTL"]; ok {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			config.problems = append(config.problems, fmt.Sprintf("SECRETS_TTL %q isn't a duration", ttl))
+		}
+		config.Secrets.TTL = parsed
+	}
+
+	if name := env["LOG_LEVEL"]; name != "" {
+		level, err := ParseLogLevel(name)
+		if err != nil {
+			config.problems = append(config.problems, fmt.Sprintf("LOG_LEVEL %q isn't one of debug, info, warn or error", name))
+		}
+		config.LogLevel = level
+	}
+
+	if localKeys := env["ENCRYPTION_LOCAL_KEYS"]; localKeys != "" {
+		config.Encryption.LocalKeys = map[string][]byte{}
+		for _, pair := range strings.Split(localKeys, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) != 2 {
+				config.problems = append(config.problems, "ENCRYPTION_LOCAL_KEYS isn't a list of <id>=<base64 key> pairs")
+				continue
+			}
+			key, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil || len(key) != 32 {
+				config.problems = append(config.problems, fmt.Sprintf("ENCRYPTION_LOCAL_KEYS key %q isn't a base64 encoded 256 bit key", parts[0]))
+				continue
+			}
+			config.Encryption.LocalKeys[parts[0]] = key
+		}
+	}
+
+	if config.Profile == "" {
+		switch {
+		case env["AUTO_TEST"] == "true":
+			config.Profile = ProfileTest
+		case env["AWS_SAM_LOCAL"] == "true":
+			config.Profile = ProfileLocal
+		default:
+			config.Profile = ProfileProduction
+		}
+	}
+
+	if config.Profile != ProfileProduction {
+		config.DynamoDBEndpoint = env["AWS_DYNAMODB_ENDPOINT"]
+		if config.DynamoDBEndpoint == "" {
+			config.DynamoDBEndpoint = "http://localhost:8000"
+		}
+	}
+
+	if config.Secrets.Source == "" {
+		switch fake, ok := env["RETURN_FAKE_SECRETS"]; {
+		case fake == "true" || (!ok && config.Profile != ProfileProduction):
+			config.Secrets.Source = SecretSourceFake
+		default:
+			config.Secrets.Source = SecretSourceSSM
+		}
+	}
+	if config.Secrets.Source != SecretSourceSSM {
+		defaultString(&config.Secrets.ClientIDName, "AUTOMATIC_CLIENT_ID")
+		defaultString(&config.Secrets.ClientSecretName, "AUTOMATIC_CLIENT_SECRET")
+		defaultString(&config.Secrets.SigningName, "SIGNING_SECRET")
+		defaultString(&config.Secrets.ContactKeyingName, "CONTACT_KEYING_SECRET")
+	}
+	if config.Encryption.Source == "" {
+		config.Encryption.Source = KeySourceKMS
+		if config.Profile != ProfileProduction {
+			config.Encryption.Source = KeySourceLocal
+		}
+	}
+	if config.Encryption.Source == KeySourceLocal && config.Encryption.LocalKeys == nil {
+		development := DevelopmentKeys()
+		config.Encryption.LocalKeys = development.Keys
+		defaultString(&config.Encryption.KeyID, development.Current)
+	}
+	if config.Profile == ProfileLocal && config.APIBaseURL == "" {
+		config.APIBaseURL = "http://127.0.0.1:3000"
+	}
+	if config.AutomaticAPIURL == "" {
+		config.AutomaticAPIURL = automatic.DefaultAPIURL
+	}
+	if config.AutomaticAccountsURL == "" {
+		config.AutomaticAccountsURL = automatic.DefaultAccountsURL
+	}
+
+	return config
+}
+
+func defaultString(s *string, value string) {
+	if *s == "" {
+		*s = value
+	}
+}
+
+// configEnv returns the environment with the config file's variables under it
+func configEnv(path string) (map[string]string, error) {
+	env := map[string]string{}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	for _, variable := range os.Environ() {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	return env, nil
+}
+
+var configInstance *Config
+
+// DefaultConfig returns the shared config. Programs load theirs with LoadConfig
+// and set it with SetDefaultConfig when they start, so settings are validated
+// before anything is served. It panics if the config hasn't been set, rather
+// than falling back to an unvalidated config read from the environment.
+func DefaultConfig() *Config {
+	if configInstance == nil {
+		panic("auto: DefaultConfig used before SetDefaultConfig")
+	}
+	return configInstance
+}
+
+// SetDefaultConfig replaces the shared config. It must be called before the config is used.
+func SetDefaultConfig(c *Config) {
+	configInstance = c
+}
@@ -0,0 +1,151 @@
+package auto
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func copyEnv(env map[string]string) map[string]string {
+	copied := make(map[string]string, len(env))
+	for name, value := range env {
+		copied[name] = value
+	}
+	return copied
+}
+
+func TestConfig(t *testing.T) {
+	production := map[string]string{
+		"DYNAMODB_TABLE_NAME":                              "auto-table",
+		"API_BASE_URL":                                     "https://api.example.com",
+		"SECRETS_CLIENT_ID_PARAMETER_NAME":                 "/auto/client-id",
+		"SECRETS_CLIENT_SECRET_PARAMETER_NAME":             "/auto/client-secret",
+		"SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME": "/auto/signing",
+		"SECRETS_CONTACT_KEYING_SECRET_PARAMETER_NAME":     "/auto/contact-keying",
+		"ENCRYPTION_KEY_ID":                                "arn:aws:kms:us-east-1:111122223333:key/auto",
+	}
+
+	t.Run("picks the profile from the environment", func(t *testing.T) {
+		assert.Equal(t, ProfileProduction, newConfig(map[string]string{}).Profile)
+		assert.Equal(t, ProfileLocal, newConfig(map[string]string{"AWS_SAM_LOCAL": "true"}).Profile)
+		assert.Equal(t, ProfileTest, newConfig(map[string]string{"AWS_SAM_LOCAL": "true", "AUTO_TEST": "true"}).Profile)
+		assert.Equal(t, ProfileLocal, newConfig(map[string]string{"AUTO_TEST": "true", "APP_PROFILE": "local"}).Profile)
+	})
+
+	t.Run("accepts a complete production config", func(t *testing.T) {
+		config := newConfig(production)
+		require.NoError(t, config.Validate())
+
+		assert.Equal(t, "auto-table", config.TableName)
+		assert.Equal(t, "/auto/signing", config.Secrets.SigningName)
+		assert.Equal(t, SecretSourceSSM, config.Secrets.Source)
+		assert.Empty(t, config.DynamoDBEndpoint)
+		assert.Equal(t, automatic.DefaultAPIURL, config.AutomaticAPIURL)
+		assert.Equal(t, KeySourceKMS, config.Encryption.Source)
+	})
+
+	t.Run("reports every problem at once", func(t *testing.T) {
+		err := newConfig(map[string]string{"RETURN_FAKE_SECRETS": "true", "AUTOMATIC_API_URL": "api.automatic.com"}).Validate()
+
+		configErr := &ConfigError{}
+		require.True(t, errors.As(err, &configErr))
+		assert.Equal(t, []string{
+			"API_BASE_URL is required",
+			`AUTOMATIC_API_URL "api.automatic.com" isn't an absolute URL`,
+			"DYNAMODB_TABLE_NAME is required",
+			"ENCRYPTION_KEY_ID is required",
+			"fake secrets aren't allowed in production",
+		}, configErr.Problems)
+
+		err = newConfig(map[string]string{"AUTO_TEST": "true", "RETURN_FAKE_SECRETS": "false", "SECRETS_TTL": "often"}).Validate()
+		require.True(t, errors.As(err, &configErr))
+		assert.Equal(t, []string{
+			"SECRETS_CLIENT_ID_PARAMETER_NAME is required",
+			"SECRETS_CLIENT_SECRET_PARAMETER_NAME is required",
+			"SECRETS_CONTACT_KEYING_SECRET_PARAMETER_NAME is required",
+			"SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME is required",
+			`SECRETS_TTL "often" isn't a duration`,
+			"SECRETS_TTL must be a positive duration",
+		}, configErr.Problems)
+	})
+
+	t.Run("defaults local settings", func(t *testing.T) {
+		config := newConfig(map[string]string{"AWS_SAM_LOCAL": "true", "DYNAMODB_TABLE_NAME": "auto-table-development"})
+		require.NoError(t, config.Validate())
+
+		assert.Equal(t, SecretSourceFake, config.Secrets.Source)
+		assert.Equal(t, "SIGNING_SECRET", config.Secrets.SigningName)
+		assert.Equal(t, "CONTACT_KEYING_SECRET", config.Secrets.ContactKeyingName)
+		assert.Equal(t, 5*time.Minute, config.Secrets.TTL)
+		assert.Equal(t, "http://localhost:8000", config.DynamoDBEndpoint)
+		assert.Equal(t, "http://dynamodb:8000", newConfig(map[string]string{"AWS_SAM_LOCAL": "true", "AWS_DYNAMODB_ENDPOINT": "http://dynamodb:8000"}).DynamoDBEndpoint)
+		assert.Equal(t, "http://127.0.0.1:3000", config.APIBaseURL)
+		assert.Equal(t, KeySourceLocal, config.Encryption.Source)
+		assert.Equal(t, DevelopmentKeys().Current, config.Encryption.KeyID)
+
+		assert.Error(t, newConfig(map[string]string{"AWS_SAM_LOCAL": "true"}).Validate())
+		assert.Error(t, newConfig(map[string]string{"APP_PROFILE": "staging"}).Validate())
+	})
+
+	t.Run("reads local encryption keys", func(t *testing.T) {
+		config := newConfig(map[string]string{
+			"AUTO_TEST":             "true",
+			"ENCRYPTION_KEY_ID":     "second",
+			"ENCRYPTION_LOCAL_KEYS": "first=" + strings.Repeat("A", 43) + "=, second=" + strings.Repeat("B", 43) + "=",
+		})
+		require.NoError(t, config.Validate())
+		assert.Len(t, config.Encryption.LocalKeys, 2)
+		assert.Len(t, config.Encryption.LocalKeys["second"], 32)
+
+		err := newConfig(map[string]string{"AUTO_TEST": "true", "ENCRYPTION_KEY_ID": "third", "ENCRYPTION_LOCAL_KEYS": "first=c2hvcnQ="}).Validate()
+		configErr := &ConfigError{}
+		require.True(t, errors.As(err, &configErr))
+		assert.Equal(t, []string{
+			`ENCRYPTION_KEY_ID "third" isn't one of ENCRYPTION_LOCAL_KEYS`,
+			`ENCRYPTION_LOCAL_KEYS key "first" isn't a base64 encoded 256 bit key`,
+		}, configErr.Problems)
+
+		env := copyEnv(production)
+		env["ENCRYPTION_KEY_SOURCE"] = KeySourceLocal
+		assert.Error(t, newConfig(env).Validate(), "local keys aren't allowed in production")
+	})
+
+	t.Run("reads a file the environment overrides", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "config")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "config.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(`{"APP_PROFILE":"local","DYNAMODB_TABLE_NAME":"from-file","AUTO_CONFIG_TEST_VALUE":"from-file"}`), 0644))
+
+		os.Setenv("AUTO_CONFIG_TEST_VALUE", "from-env")
+		defer os.Unsetenv("AUTO_CONFIG_TEST_VALUE")
+
+		env, err := configEnv(path)
+		require.NoError(t, err)
+		assert.Equal(t, "from-file", env["DYNAMODB_TABLE_NAME"])
+		assert.Equal(t, "from-env", env["AUTO_CONFIG_TEST_VALUE"])
+
+		_, err = configEnv(filepath.Join(dir, "missing.json"))
+		assert.Error(t, err)
+	})
+
+	t.Run("the shared config must be set before it's used", func(t *testing.T) {
+		defer SetDefaultConfig(configInstance)
+
+		SetDefaultConfig(nil)
+		assert.Panics(t, func() { DefaultConfig() })
+
+		config := newConfig(map[string]string{"AUTO_TEST": "true"})
+		SetDefaultConfig(config)
+		assert.Equal(t, config, DefaultConfig())
+	})
+}
@@ -0,0 +1,123 @@
+package auto
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+// ContactType is the kind of address a contact holds
+type ContactType string
+
+const (
+	// ContactTypeEmail is an email address
+	ContactTypeEmail ContactType = "EMAIL"
+)
+
+// Contact is an address an account can be reached at
+type Contact struct {
+	AccountID      string `json:"-"`
+	Type           ContactType
+	Value          string
+	ReceiveContact bool
+}
+
+// AccountContacts returns all of the account's contacts
+func (s *DynamoStore) AccountContacts(ctx context.Context, accountID string) ([]*Contact, error) {
+	items := make([]map[string]*dynamodb.AttributeValue, 0)
+
+	err := s.queryPrefix(ctx, accountID, keys.ContactSortKey.Prefix(), func(item map[string]*dynamodb.AttributeValue) {
+		items = append(items, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make([]*Contact, 0, len(items))
+	for _, item := range items {
+		contact, err := contactFromDynamo(ctx, s.encryption(), item)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	return contacts, nil
+}
+
+// primaryKey returns the primary key for DynamoDB. The value is keyed with the secret.
+func (c *Contact) primaryKey(secret string) (PrimaryKey, error) {
+	return keys.Contact(secret, c.AccountID, string(c.Type), c.Value)
+}
+
+func (c *Contact) dynamo(ctx context.Context, p KeyProvider, secret string) (map[string]*dynamodb.AttributeValue, error) {
+	receive := int64(0)
+	if c.ReceiveContact {
+		receive = 1
+	}
+
+	key, err := c.primaryKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	item := key.Dynamo()
+	item["ContactType"] = &dynamodb.AttributeValue{S: aws.String(string(c.Type))}
+	item["ReceiveContact"] = DynamoInt(receive)
+
+	if err := sealAttributes(ctx, p, item, map[string]string{"ContactValue": c.Value}); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func contactFromDynamo(ctx context.Context, p KeyProvider, item map[string]*dynamodb.AttributeValue) (*Contact, error) {
+	secrets, err := openAttributes(ctx, p, item)
+	if err != nil {
+		return nil, err
+	}
+	return contactFromAttributes(item, secrets), nil
+}
+
+func contactFromAttributes(item map[string]*dynamodb.AttributeValue, secrets map[string]string) *Contact {
+	return &Contact{
+		AccountID:      StringFromDynamo(item[keys.HashKeyAttribute]),
+		Type:           ContactType(StringFromDynamo(item["ContactType"])),
+		Value:          secrets["ContactValue"],
+		ReceiveContact: IntFromDynamo(item["ReceiveContact"]) == 1,
+	}
+}
+
+// RekeyContactItem moves a contact item to the key its value's HMAC under the
+// secret gives it, re-encrypting the value for the new key. It returns false and
+// leaves the item alone when it isn't a contact, or it's keyed that way already.
+// The value can be a plain string written before contacts were encrypted.
+func RekeyContactItem(ctx context.Context, p KeyProvider, secret string, item map[string]*dynamodb.AttributeValue) (bool, error) {
+	if !keys.ContactSortKey.Matches(StringFromDynamo(item[keys.RangeKeyAttribute])) {
+		return false, nil
+	}
+
+	secrets, err := storedAttributes(ctx, p, item)
+	if err != nil {
+		return false, err
+	}
+	contact := contactFromAttributes(item, secrets)
+
+	key, err := contact.primaryKey(secret)
+	if err != nil {
+		return false, err
+	}
+	if key.SortKey == StringFromDynamo(item[keys.RangeKeyAttribute]) {
+		return false, nil
+	}
+
+	item[keys.RangeKeyAttribute] = &dynamodb.AttributeValue{S: aws.String(key.SortKey)}
+	if err := sealAttributes(ctx, p, item, map[string]string{"ContactValue": contact.Value}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package auto
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type contextKey int
+
+const (
+	requestIDContextKey contextKey = iota
+	logFieldsContextKey
+)
+
+// WithRequestID returns a context carrying the ID of the request it was created for
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestID returns the ID of the request the context was created for, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// InterruptContext returns a context that's cancelled by the first interrupt or
+// SIGTERM, so programs can stop their work cleanly. Later signals aren't caught.
+func InterruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,34 @@
+package auto
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptContext(t *testing.T) {
+	t.Run("is cancelled by an interrupt", func(t *testing.T) {
+		ctx, cancel := InterruptContext()
+		defer cancel()
+
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("the context wasn't cancelled")
+		}
+	})
+
+	t.Run("is cancelled by its cancel function", func(t *testing.T) {
+		ctx, cancel := InterruptContext()
+		cancel()
+
+		<-ctx.Done()
+		assert.Error(t, ctx.Err())
+	})
+}
@@ -0,0 +1,184 @@
+package auto
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DigestItem is a single due-soon entry in a notification digest
+type DigestItem struct {
+	Title   string
+	Detail  string `json:",omitempty"`
+	DueAt   time.Time
+	Actions []DigestAction `json:",omitempty"`
+}
+
+// DigestAction is a one-click link embedded in a digest message
+type DigestAction struct {
+	Title string
+	URL   string
+}
+
+// Digest batches every due-soon item for an account into one message
+type Digest struct {
+	AccountID   string
+	Frequency   DigestFrequency
+	GeneratedAt time.Time
+	Items       []DigestItem
+}
+
+// DeliveryDue returns true if the scheduler should send the account a notification now.
+//
+// `lastDeliveredAt` is when the previous notification was sent, or the zero time
+// if one never has been. An account that has never been sent one is due once
+// today's delivery time has passed, as long as it's outside quiet hours.
+func (p *NotificationPreferences) DeliveryDue(now, lastDeliveredAt time.Time, loc *time.Location) bool {
+	if lastDeliveredAt.IsZero() {
+		local := now.In(loc)
+		today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+		return !p.NextDelivery(today, loc).After(local) && !p.InQuietHours(local)
+	}
+
+	next := p.NextDelivery(lastDeliveredAt.Add(time.Minute), loc)
+
+	return !next.After(now)
+}
+
+// DigestActions returns the one-click links added to a reminder's digest item
+type DigestActions func(r *Reminder) ([]DigestAction, error)
+
+// DigestSender delivers a digest to the account over the channels its preferences enable
+type DigestSender func(ctx context.Context, account *Account, prefs *NotificationPreferences, digest *Digest) error
+
+// SendDigest sends the account its digest if a delivery is due at `now` in the
+// account's time zone, and returns the digest that was sent. Nil is returned if
+// no delivery is due or nothing is due soon. Every item carries the links
+// actions returns for its reminder.
+//
+// The delivery is recorded before the digest is sent, so an account is sent
+// each digest at most once. ErrRecordConflict is returned if another scheduler
+// recorded it first. The record is undone if the digest can't be sent, so the
+// next run tries again.
+func SendDigest(ctx context.Context, store Store, accountID string, now time.Time, actions DigestActions, send DigestSender) (*Digest, error) {
+	account, err := store.FindAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := store.FindNotificationPreferences(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if !prefs.DeliveryDue(now, account.LastDigestAt, account.Location()) {
+		return nil, nil
+	}
+
+	reminders, err := store.AccountReminders(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	odometers := make(map[string]float64)
+	items := make([]DigestItem, 0, len(reminders))
+	for _, r := range reminders {
+		odometer, ok := odometers[r.VehicleID]
+		if !ok && r.VehicleID != "" {
+			latest, err := store.LatestOdometerReading(ctx, accountID, r.VehicleID)
+			if err == nil {
+				odometer = latest.Reading
+			} else if err != ErrRecordNotFound {
+				return nil, err
+			}
+			odometers[r.VehicleID] = odometer
+		}
+
+		item, ok := r.DigestItem(now, odometer)
+		if !ok {
+			continue
+		}
+		if item.Actions, err = actions(r); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	digest := BuildDigest(prefs, now, items)
+	if digest == nil {
+		return nil, nil
+	}
+
+	previous := account.LastDigestAt
+	if err := store.RecordDigestDelivery(ctx, account, now); err != nil {
+		return nil, err
+	}
+	if err := send(ctx, account, prefs, digest); err != nil {
+		if undoErr := store.RecordDigestDelivery(ctx, account, previous); undoErr != nil {
+			return nil, fmt.Errorf("%w (undoing the delivery record failed: %v)", err, undoErr)
+		}
+		return nil, err
+	}
+
+	return digest, nil
+}
+
+// BuildDigest assembles the items that are overdue or due before the next
+// digest would be sent. Nil is returned if there is nothing to send.
+func BuildDigest(prefs *NotificationPreferences, now time.Time, items []DigestItem) *Digest {
+	cutoff := now.Add(prefs.DigestWindow())
+
+	due := make([]DigestItem, 0, len(items))
+	for _, item := range items {
+		if item.DueAt.After(cutoff) {
+			continue
+		}
+		due = append(due, item)
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].DueAt.Before(due[j].DueAt)
+	})
+
+	return &Digest{
+		AccountID:   prefs.AccountID,
+		Frequency:   prefs.DigestFrequency,
+		GeneratedAt: now,
+		Items:       due,
+	}
+}
+
+// Subject returns the subject line for the digest message
+func (d *Digest) Subject() string {
+	if len(d.Items) == 1 {
+		return fmt.Sprintf("Reminder: %s", d.Items[0].Title)
+	}
+	return fmt.Sprintf("You have %d upcoming reminders", len(d.Items))
+}
+
+// Text renders the digest as a plain text message with times in the passed location
+func (d *Digest) Text(loc *time.Location) string {
+	var b strings.Builder
+
+	for _, item := range d.Items {
+		due := item.DueAt.In(loc)
+
+		if due.Before(d.GeneratedAt) {
+			fmt.Fprintf(&b, "- %s (overdue since %s)\n", item.Title, due.Format("Mon Jan 2"))
+		} else {
+			fmt.Fprintf(&b, "- %s (due %s)\n", item.Title, due.Format("Mon Jan 2"))
+		}
+		if item.Detail != "" {
+			fmt.Fprintf(&b, "  %s\n", item.Detail)
+		}
+		for _, action := range item.Actions {
+			fmt.Fprintf(&b, "  %s: %s\n", action.Title, action.URL)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package auto
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+// DynamoStore is a Store backed by the app's single DynamoDB table
+type DynamoStore struct {
+	db    dynamodbiface.DynamoDBAPI
+	table *string
+
+	// keyProvider encrypts sensitive attributes. DefaultKeyProvider is used when it's nil.
+	keyProvider KeyProvider
+	// cursorSecret signs listing cursors. The app's signing secret is used when it's empty.
+	cursorSecret string
+	// contactSecret keys the HMAC contact values are stored under. The app's contact keying secret is used when it's empty.
+	contactSecret string
+}
+
+// NewDynamoStore returns a store that reads and writes the passed table
+func NewDynamoStore(db dynamodbiface.DynamoDBAPI, table string) *DynamoStore {
+	return &DynamoStore{db: db, table: aws.String(table)}
+}
+
+var _ Store = (*DynamoStore)(nil)
+
+// SetKeyProvider sets the provider of the keys the store encrypts sensitive attributes with, instead of DefaultKeyProvider
+func (s *DynamoStore) SetKeyProvider(p KeyProvider) {
+	s.keyProvider = p
+}
+
+// SetCursorSecret sets the secret listing cursors are signed with, instead of the app's signing secret
+func (s *DynamoStore) SetCursorSecret(secret string) {
+	s.cursorSecret = secret
+}
+
+// SetContactSecret sets the secret contact keys are derived with, instead of the app's contact keying secret
+func (s *DynamoStore) SetContactSecret(secret string) {
+	s.contactSecret = secret
+}
+
+func (s *DynamoStore) cursorSigning() (string, error) {
+	return secretOrSigning(s.cursorSecret)
+}
+
+func (s *DynamoStore) contactKeying() (string, error) {
+	if s.contactSecret != "" {
+		return s.contactSecret, nil
+	}
+	secrets, err := Secrets()
+	if err != nil {
+		return "", err
+	}
+	return secrets.ContactKeying, nil
+}
+
+// secretOrSigning returns the secret, or the app's signing secret when it's empty
+func secretOrSigning(secret string) (string, error) {
+	if secret != "" {
+		return secret, nil
+	}
+	secrets, err := Secrets()
+	if err != nil {
+		return "", err
+	}
+	return secrets.Signing, nil
+}
+
+func (s *DynamoStore) encryption() KeyProvider {
+	if s.keyProvider != nil {
+		return s.keyProvider
+	}
+	return DefaultKeyProvider()
+}
+
+func (s *DynamoStore) getItem(ctx context.Context, key PrimaryKey) (map[string]*dynamodb.AttributeValue, error) {
+	// An account's hash key is its ID, so an empty ID can't match anything.
+	if key.HashKey == "" || key.SortKey == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	result, err := s.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: s.table,
+		Key:       key.Dynamo(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return result.Item, nil
+}
+
+// parsedID returns the single ID part of the item's sort key
+func parsedID(p keys.Pattern, item map[string]*dynamodb.AttributeValue) string {
+	parts, err := p.Parse(StringFromDynamo(item[keys.RangeKeyAttribute]))
+	if err != nil {
+		return ""
+	}
+	return parts[0]
+}
+
+// queryPrefix calls fn with every item under the hash key whose sort key starts with prefix
+func (s *DynamoStore) queryPrefix(ctx context.Context, hashKey, prefix string, fn func(map[string]*dynamodb.AttributeValue)) error {
+	return s.db.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              s.table,
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(keys.HashKeyAttribute),
+			"#sk": aws.String(keys.RangeKeyAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(hashKey)},
+			":sk": {S: aws.String(prefix)},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			fn(item)
+		}
+		return true
+	})
+}
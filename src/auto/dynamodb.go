@@ -2,15 +2,17 @@ package auto
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
-	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/maddiesch/serverless"
+	"github.com/maddiesch/serverless/amazon"
 )
 
 var (
@@ -21,14 +23,28 @@ var (
 // DynamoDB returns the shared DynamoDB client instance
 func DynamoDB() *dynamodb.DynamoDB {
 	dbSetup.Do(func() {
-		dbInstance = serverless.NewDB("", "").Client
+		ses := amazon.BaseSession().Copy()
+		if endpoint := DefaultConfig().DynamoDBEndpoint; endpoint != "" {
+			ses.Config.Endpoint = aws.String(endpoint)
+		}
+		dbInstance = dynamodb.New(ses)
 	})
 	return dbInstance
 }
 
 // TableName returns the DynamoDB table name
 func TableName() *string {
-	return aws.String(os.Getenv("DYNAMODB_TABLE_NAME"))
+	return aws.String(DefaultConfig().TableName)
+}
+
+// isTransactionConflict returns true when a transaction was cancelled because
+// one of its conditions failed or another transaction was writing the same items
+func isTransactionConflict(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != dynamodb.ErrCodeTransactionCanceledException {
+		return false
+	}
+	return strings.Contains(aerr.Message(), "ConditionalCheckFailed") || strings.Contains(aerr.Message(), "TransactionConflict")
 }
 
 // FormatString returns the DynamoDB AttributeValue String Value with a format
@@ -36,11 +52,57 @@ func FormatString(format string, args ...interface{}) *dynamodb.AttributeValue {
 	return &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf(format, args...))}
 }
 
+// StringFromDynamo returns the string value of an attribute that may be missing from an item
+func StringFromDynamo(a *dynamodb.AttributeValue) string {
+	if a == nil {
+		return ""
+	}
+	return aws.StringValue(a.S)
+}
+
 // DynamoTime returns the DynamoDB AttributeValue for a time
 func DynamoTime(t time.Time) *dynamodb.AttributeValue {
 	return &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", t.Unix()))}
 }
 
+// DynamoInt returns the DynamoDB AttributeValue for an integer
+func DynamoInt(i int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(i, 10))}
+}
+
+// IntFromDynamo parses and returns the integer from a dynamodb attribute value
+func IntFromDynamo(a *dynamodb.AttributeValue) int64 {
+	if a == nil || a.N == nil {
+		return 0
+	}
+
+	value, err := strconv.ParseInt(aws.StringValue(a.N), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// DynamoFloat returns the DynamoDB AttributeValue for a float
+func DynamoFloat(f float64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(f, 'f', -1, 64))}
+}
+
+// FloatFromDynamo parses and returns the float from a dynamodb attribute value
+func FloatFromDynamo(a *dynamodb.AttributeValue) float64 {
+	if a == nil || a.N == nil {
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(aws.StringValue(a.N), 64)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
 // HashString performs a SHA-256 on the passed string and returns the hex-encoded bytes
 func HashString(s string) string {
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
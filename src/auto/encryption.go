@@ -0,0 +1,367 @@
+package auto
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/serverless/amazon"
+)
+
+// Sensitive attributes are envelope encrypted. Each item gets its own data key,
+// which encrypts the item's sensitive attributes with AES-GCM and is stored in
+// the item wrapped by a key provider's key. The ID of that key is stored too, so
+// items can be found and re-encrypted when the key is rotated.
+const (
+	// EncryptionKeyIDAttribute is the ID of the key the item's data key is wrapped with
+	EncryptionKeyIDAttribute = "EncryptionKeyID"
+	// EncryptedDataKeyAttribute is the item's wrapped data key
+	EncryptedDataKeyAttribute = "EncryptedDataKey"
+	// EncryptionVersionAttribute is what the item's values were authenticated with
+	EncryptionVersionAttribute = "EncryptionVersion"
+)
+
+// encryptionVersionItemKey authenticates the item's primary key and the attribute
+// name. It's the only version values are sealed or opened with.
+const encryptionVersionItemKey = 2
+
+// SensitiveAttributes are encrypted in every item they're stored in
+var SensitiveAttributes = []string{"AccessToken", "RefreshToken", "ContactValue"}
+
+// ErrUnknownKey is returned when a data key is wrapped with a key the provider doesn't have
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider generates data keys and unwraps them
+type KeyProvider interface {
+	// CurrentKeyID is the ID of the key new data keys are wrapped with
+	CurrentKeyID() string
+	// GenerateDataKey returns a new 256 bit data key, and the key wrapped with the current key
+	GenerateDataKey(ctx context.Context) (plaintext, wrapped []byte, err error)
+	// DecryptDataKey unwraps a data key that was wrapped with the key ID
+	DecryptDataKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// kmsEncryptionContext is bound to every data key KMS generates, so they can't be decrypted for another purpose
+var kmsEncryptionContext = aws.StringMap(map[string]string{"purpose": "auto-attribute-encryption"})
+
+// KMSKeys wraps data keys with a KMS key. KeyID should be the key's ARN, as
+// it's compared with the ID stored in items to find the ones to re-encrypt.
+type KMSKeys struct {
+	Client kmsiface.KMSAPI
+	KeyID  string
+}
+
+// CurrentKeyID returns the KMS key ID
+func (k KMSKeys) CurrentKeyID() string {
+	return k.KeyID
+}
+
+// GenerateDataKey has KMS generate a data key under the key
+func (k KMSKeys) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	output, err := k.Client.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(k.KeyID),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+// DecryptDataKey has KMS decrypt the data key. The wrapped key names the KMS key it was wrapped with, so keyID isn't needed.
+func (k KMSKeys) DecryptDataKey(ctx context.Context, _ string, wrapped []byte) ([]byte, error) {
+	output, err := k.Client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
+
+// LocalKeys wraps data keys with AES-GCM keys held in memory, for development
+// and tests. Keys holds every key that might have wrapped a stored data key,
+// by ID, and Current names the one new data keys are wrapped with.
+type LocalKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+// developmentKeyID and developmentKey are the local key used outside production when none are configured
+const (
+	developmentKeyID = "local-development"
+	developmentKey   = "automatic-reminders-development!"
+)
+
+// DevelopmentKeys returns the local key used outside production when none are configured
+func DevelopmentKeys() LocalKeys {
+	return LocalKeys{Current: developmentKeyID, Keys: map[string][]byte{developmentKeyID: []byte(developmentKey)}}
+}
+
+// CurrentKeyID returns the ID of the current key
+func (l LocalKeys) CurrentKeyID() string {
+	return l.Current
+}
+
+// GenerateDataKey returns a random data key wrapped with the current key
+func (l LocalKeys) GenerateDataKey(_ context.Context) ([]byte, []byte, error) {
+	key, ok := l.Keys[l.Current]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, l.Current)
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := seal(key, dataKey, []byte(l.Current))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, wrapped, nil
+}
+
+// DecryptDataKey unwraps the data key with the key ID
+func (l LocalKeys) DecryptDataKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := l.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return open(key, wrapped, []byte(keyID))
+}
+
+var (
+	keyProviderInstance KeyProvider
+	keyProviderSetup    sync.Once
+)
+
+// DefaultKeyProvider returns the shared provider the config's encryption source picks
+func DefaultKeyProvider() KeyProvider {
+	keyProviderSetup.Do(func() {
+		config := DefaultConfig().Encryption
+
+		switch config.Source {
+		case KeySourceLocal:
+			keyProviderInstance = LocalKeys{Current: config.KeyID, Keys: config.LocalKeys}
+		default:
+			keyProviderInstance = KMSKeys{Client: kms.New(amazon.BaseSession()), KeyID: config.KeyID}
+		}
+	})
+	return keyProviderInstance
+}
+
+// SetDefaultKeyProvider replaces the shared provider. It must be called before anything is encrypted.
+func SetDefaultKeyProvider(p KeyProvider) {
+	keyProviderSetup.Do(func() {})
+	keyProviderInstance = p
+}
+
+// sealAttributes encrypts the values with a new data key and writes them to
+// the item, along with the wrapped data key and the ID of the key wrapping it.
+//
+// The item's primary key must be set, as it's authenticated with every value.
+// An item that moves to another key has to be re-encrypted.
+func sealAttributes(ctx context.Context, p KeyProvider, item map[string]*dynamodb.AttributeValue, values map[string]string) error {
+	if StringFromDynamo(item[keys.HashKeyAttribute]) == "" || StringFromDynamo(item[keys.RangeKeyAttribute]) == "" {
+		return errors.New("sealing attributes of an item without a primary key")
+	}
+
+	dataKey, wrapped, err := p.GenerateDataKey(ctx)
+	if err != nil {
+		return fmt.Errorf("generating data key: %w", err)
+	}
+
+	for name, value := range values {
+		sealed, err := seal(dataKey, []byte(value), additionalData(item, name))
+		if err != nil {
+			return err
+		}
+		item[name] = &dynamodb.AttributeValue{B: sealed}
+	}
+	item[EncryptionKeyIDAttribute] = &dynamodb.AttributeValue{S: aws.String(p.CurrentKeyID())}
+	item[EncryptedDataKeyAttribute] = &dynamodb.AttributeValue{B: wrapped}
+	item[EncryptionVersionAttribute] = DynamoInt(encryptionVersionItemKey)
+
+	return nil
+}
+
+// additionalData is what a value is authenticated with. The attribute name stops
+// one encrypted value being swapped for another in the item, and the item's
+// primary key stops it being copied into another item, like another account's.
+func additionalData(item map[string]*dynamodb.AttributeValue, name string) []byte {
+	// Neither key nor attribute names contain a NUL, so the parts can't run together.
+	return []byte(StringFromDynamo(item[keys.HashKeyAttribute]) + "\x00" + StringFromDynamo(item[keys.RangeKeyAttribute]) + "\x00" + name)
+}
+
+// encryptionVersion returns the version the item's values were sealed with, or 0 when it has none
+func encryptionVersion(item map[string]*dynamodb.AttributeValue) int64 {
+	if item[EncryptionVersionAttribute] == nil {
+		return 0
+	}
+	return IntFromDynamo(item[EncryptionVersionAttribute])
+}
+
+// openAttributes returns the item's sensitive attributes decrypted. A sensitive
+// attribute that isn't sealed is an error, so a plain string written over a
+// sealed value is never trusted.
+func openAttributes(ctx context.Context, p KeyProvider, item map[string]*dynamodb.AttributeValue) (map[string]string, error) {
+	values := make(map[string]string)
+
+	var dataKey []byte
+	for _, name := range SensitiveAttributes {
+		value := item[name]
+		switch {
+		case value == nil:
+			continue
+		case value.B == nil:
+			return nil, fmt.Errorf("%s isn't encrypted", name)
+		}
+
+		if dataKey == nil {
+			if version := encryptionVersion(item); version != encryptionVersionItemKey {
+				return nil, fmt.Errorf("%s is sealed with unsupported encryption version %d", name, version)
+			}
+
+			wrapped := item[EncryptedDataKeyAttribute]
+			if wrapped == nil || wrapped.B == nil {
+				return nil, fmt.Errorf("%s is encrypted but the item has no data key", name)
+			}
+
+			var err error
+			dataKey, err = p.DecryptDataKey(ctx, StringFromDynamo(item[EncryptionKeyIDAttribute]), wrapped.B)
+			if err != nil {
+				return nil, fmt.Errorf("decrypting data key: %w", err)
+			}
+		}
+
+		plaintext, err := open(dataKey, value.B, additionalData(item, name))
+		if err != nil {
+			return nil, fmt.Errorf("decrypting %s: %w", name, err)
+		}
+		values[name] = string(plaintext)
+	}
+
+	return values, nil
+}
+
+// DecryptItem replaces the item's encrypted attributes with their plaintext values
+func DecryptItem(ctx context.Context, p KeyProvider, item map[string]*dynamodb.AttributeValue) error {
+	values, err := openAttributes(ctx, p, item)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		item[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+	}
+	delete(item, EncryptionKeyIDAttribute)
+	delete(item, EncryptedDataKeyAttribute)
+	delete(item, EncryptionVersionAttribute)
+
+	return nil
+}
+
+// ReencryptItem encrypts the item's sensitive attributes with a new data key
+// wrapped with the provider's current key. It returns false and leaves the item
+// alone when it has no sensitive attributes, or they're all encrypted under the
+// current key and authenticated with the item's key already.
+func ReencryptItem(ctx context.Context, p KeyProvider, item map[string]*dynamodb.AttributeValue) (bool, error) {
+	found := false
+	current := StringFromDynamo(item[EncryptionKeyIDAttribute]) == p.CurrentKeyID() && encryptionVersion(item) == encryptionVersionItemKey
+	for _, name := range SensitiveAttributes {
+		if value := item[name]; value != nil {
+			found = true
+			current = current && value.B != nil
+		}
+	}
+	if !found || current {
+		return false, nil
+	}
+
+	values, err := storedAttributes(ctx, p, item)
+	if err != nil {
+		return false, err
+	}
+	if err := sealAttributes(ctx, p, item, values); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// storedAttributes returns the sensitive attributes of an item being migrated.
+// Values written before they were encrypted are plain strings in an item without
+// a data key. Only migrations read them; everything else uses openAttributes.
+func storedAttributes(ctx context.Context, p KeyProvider, item map[string]*dynamodb.AttributeValue) (map[string]string, error) {
+	if item[EncryptedDataKeyAttribute] == nil {
+		return plaintextAttributes(item)
+	}
+	return openAttributes(ctx, p, item)
+}
+
+// plaintextAttributes returns the sensitive attributes of an item written before
+// they were encrypted. Every one has to be a plain string.
+func plaintextAttributes(item map[string]*dynamodb.AttributeValue) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, name := range SensitiveAttributes {
+		value := item[name]
+		switch {
+		case value == nil:
+			continue
+		case value.S == nil:
+			return nil, fmt.Errorf("%s is encrypted but the item has no data key", name)
+		}
+		values[name] = aws.StringValue(value.S)
+	}
+	return values, nil
+}
+
+// seal encrypts plaintext with AES-GCM, returning the nonce followed by the ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts what seal returned
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
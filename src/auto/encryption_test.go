@@ -0,0 +1,164 @@
+package auto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS "wraps" data keys by prefixing them with the key ID
+type fakeKMS struct {
+	kmsiface.KMSAPI
+	generated int
+}
+
+func (f *fakeKMS) GenerateDataKeyWithContext(_ aws.Context, input *kms.GenerateDataKeyInput, _ ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	f.generated++
+	plaintext := bytes.Repeat([]byte{byte(f.generated)}, 32)
+	return &kms.GenerateDataKeyOutput{
+		KeyId:          input.KeyId,
+		Plaintext:      plaintext,
+		CiphertextBlob: append([]byte(aws.StringValue(input.KeyId)+"|"), plaintext...),
+	}, nil
+}
+
+func (f *fakeKMS) DecryptWithContext(_ aws.Context, input *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	parts := bytes.SplitN(input.CiphertextBlob, []byte("|"), 2)
+	return &kms.DecryptOutput{KeyId: aws.String(string(parts[0])), Plaintext: parts[1]}, nil
+}
+
+// mustKey returns a key built from parts the test knows are valid
+func mustKey(key PrimaryKey, err error) PrimaryKey {
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	values := map[string]string{"AccessToken": "access", "RefreshToken": "refresh"}
+
+	t.Run("seals and opens attributes", func(t *testing.T) {
+		for name, provider := range map[string]KeyProvider{
+			"local": DevelopmentKeys(),
+			"kms":   KMSKeys{Client: &fakeKMS{}, KeyID: "arn:aws:kms:us-east-1:111122223333:key/auto"},
+		} {
+			item := mustKey(keys.AccessToken("acct_1", "tok_1")).Dynamo()
+			require.NoError(t, sealAttributes(ctx, provider, item, values), name)
+
+			assert.Nil(t, item["AccessToken"].S, name)
+			assert.NotContains(t, string(item["AccessToken"].B), "access", name)
+			assert.Equal(t, provider.CurrentKeyID(), StringFromDynamo(item[EncryptionKeyIDAttribute]), name)
+
+			opened, err := openAttributes(ctx, provider, item)
+			require.NoError(t, err, name)
+			assert.Equal(t, values, opened, name)
+		}
+	})
+
+	t.Run("rejects plaintext values", func(t *testing.T) {
+		item := map[string]*dynamodb.AttributeValue{"ContactValue": {S: aws.String("test@email.test")}}
+
+		_, err := openAttributes(ctx, DevelopmentKeys(), item)
+		assert.EqualError(t, err, "ContactValue isn't encrypted")
+
+		item = mustKey(keys.AccessToken("acct_1", "tok_1")).Dynamo()
+		require.NoError(t, sealAttributes(ctx, DevelopmentKeys(), item, values))
+		item["AccessToken"] = &dynamodb.AttributeValue{S: aws.String("forged")}
+
+		_, err = openAttributes(ctx, DevelopmentKeys(), item)
+		assert.EqualError(t, err, "AccessToken isn't encrypted", "a plain string written over a sealed value isn't read")
+
+		_, err = ReencryptItem(ctx, DevelopmentKeys(), item)
+		assert.Error(t, err, "nor is it re-encrypted")
+	})
+
+	t.Run("rejects values moved between attributes", func(t *testing.T) {
+		item := mustKey(keys.AccessToken("acct_1", "tok_1")).Dynamo()
+		require.NoError(t, sealAttributes(ctx, DevelopmentKeys(), item, values))
+		item["AccessToken"] = item["RefreshToken"]
+
+		_, err := openAttributes(ctx, DevelopmentKeys(), item)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects values copied into another item", func(t *testing.T) {
+		item := mustKey(keys.AccessToken("acct_1", "tok_1")).Dynamo()
+		require.NoError(t, sealAttributes(ctx, DevelopmentKeys(), item, values))
+
+		copied := mustKey(keys.AccessToken("acct_2", "tok_1")).Dynamo()
+		for name, value := range item {
+			if name != keys.HashKeyAttribute && name != keys.RangeKeyAttribute {
+				copied[name] = value
+			}
+		}
+
+		_, err := openAttributes(ctx, DevelopmentKeys(), copied)
+		assert.Error(t, err)
+	})
+
+	t.Run("requires the item's primary key", func(t *testing.T) {
+		assert.Error(t, sealAttributes(ctx, DevelopmentKeys(), map[string]*dynamodb.AttributeValue{}, values))
+	})
+
+	t.Run("rejects values sealed without a version", func(t *testing.T) {
+		provider := DevelopmentKeys()
+		dataKey, wrapped, err := provider.GenerateDataKey(ctx)
+		require.NoError(t, err)
+		sealed, err := seal(dataKey, []byte("access"), []byte("AccessToken"))
+		require.NoError(t, err)
+
+		item := mustKey(keys.AccessToken("acct_1", "tok_1")).Dynamo()
+		item["AccessToken"] = &dynamodb.AttributeValue{B: sealed}
+		item[EncryptionKeyIDAttribute] = &dynamodb.AttributeValue{S: aws.String(provider.CurrentKeyID())}
+		item[EncryptedDataKeyAttribute] = &dynamodb.AttributeValue{B: wrapped}
+
+		_, err = openAttributes(ctx, provider, item)
+		assert.EqualError(t, err, "AccessToken is sealed with unsupported encryption version 0")
+	})
+
+	t.Run("re-encrypts items under the current key", func(t *testing.T) {
+		first := DevelopmentKeys()
+		rotated := LocalKeys{Current: "rotated", Keys: map[string][]byte{
+			first.Current: first.Keys[first.Current],
+			"rotated":     bytes.Repeat([]byte("k"), 32),
+		}}
+
+		legacy := mustKey(keys.AccessToken("acct_1", "tok_1")).Dynamo()
+		legacy["AccessToken"] = &dynamodb.AttributeValue{S: aws.String("access")}
+		changed, err := ReencryptItem(ctx, first, legacy)
+		require.NoError(t, err)
+		assert.True(t, changed, "plaintext values are encrypted")
+
+		changed, err = ReencryptItem(ctx, first, legacy)
+		require.NoError(t, err)
+		assert.False(t, changed, "items under the current key are left alone")
+
+		changed, err = ReencryptItem(ctx, rotated, legacy)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "rotated", StringFromDynamo(legacy[EncryptionKeyIDAttribute]))
+
+		_, err = openAttributes(ctx, first, legacy)
+		assert.True(t, errors.Is(err, ErrUnknownKey), "the old key can't read re-encrypted items")
+
+		opened, err := openAttributes(ctx, rotated, legacy)
+		require.NoError(t, err)
+		assert.Equal(t, "access", opened["AccessToken"])
+
+		changed, err = ReencryptItem(ctx, rotated, keys.Account("acct_1").Dynamo())
+		require.NoError(t, err)
+		assert.False(t, changed, "items without sensitive attributes are left alone")
+	})
+}
@@ -4,5 +4,8 @@ go 1.13
 
 require (
 	github.com/aws/aws-sdk-go v1.23.21
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/maddiesch/serverless v0.1.0
+	github.com/segmentio/ksuid v1.0.2
+	github.com/stretchr/testify v1.4.0
 )
@@ -0,0 +1,392 @@
+// Package keys builds and parses every key stored in the app's single DynamoDB table.
+//
+// Keys are made of a fixed prefix followed by slash separated parts, e.g.
+// "reminder/<reminder id>". Each pattern is declared once here, and writers go
+// through Item so an index is never written with only half of its key.
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	// ErrIncompleteKey is returned when a key is missing its hash or range half
+	ErrIncompleteKey = errors.New("incomplete key")
+
+	// ErrMalformedKey is returned when a key doesn't match the pattern it's parsed with
+	ErrMalformedKey = errors.New("malformed key")
+
+	// ErrInvalidPart is returned when a key is built from an empty part, or a
+	// part containing a slash. It wraps ErrMalformedKey.
+	ErrInvalidPart = fmt.Errorf("%w: invalid part", ErrMalformedKey)
+
+	// ErrMissingSecret is returned when a contact key is built without a secret
+	ErrMissingSecret = errors.New("missing contact keying secret")
+)
+
+// Table key attribute names
+const (
+	HashKeyAttribute  = "PK"
+	RangeKeyAttribute = "SK"
+)
+
+// Index is a secondary index on the table
+type Index struct {
+	Name           string
+	HashAttribute  string
+	RangeAttribute string
+	// Local indexes share the table's hash key
+	Local bool
+	// KeysOnly indexes only project the table and index keys
+	KeysOnly bool
+}
+
+// The table's secondary indexes
+var (
+	LSI1 = Index{Name: "LSI1", HashAttribute: HashKeyAttribute, RangeAttribute: "LSI1SK", Local: true}
+	LSI2 = Index{Name: "LSI2", HashAttribute: HashKeyAttribute, RangeAttribute: "LSI2SK", Local: true, KeysOnly: true}
+	GSI1 = Index{Name: "GSI1", HashAttribute: "GSI1PK", RangeAttribute: "GSI1SK"}
+	GSI2 = Index{Name: "GSI2", HashAttribute: "GSI2PK", RangeAttribute: "GSI2SK", KeysOnly: true}
+
+	// Indexes is every secondary index on the table
+	Indexes = []Index{LSI1, LSI2, GSI1, GSI2}
+)
+
+// Pattern is a key format: a fixed prefix followed by a number of slash separated parts
+type Pattern struct {
+	prefix string
+	parts  int
+}
+
+// Patterns used by the table
+var (
+	AccountSortKey                 = Pattern{prefix: "_USER_ACCOUNT"}
+	NotificationPreferencesSortKey = Pattern{prefix: "_NOTIFICATION_PREFERENCES"}
+	OAuthStateSortKey              = Pattern{prefix: "_REQUEST"}
+	AutomaticAccountIndexSortKey   = Pattern{prefix: "_AUTOMATIC_ACCOUNT"}
+
+	OAuthStateHashKey            = Pattern{prefix: "integration/automatic", parts: 1}
+	AutomaticAccountIndexHashKey = Pattern{prefix: "automatic", parts: 1}
+	AccessTokenSortKey           = Pattern{prefix: "access-token", parts: 1}
+	AccessTokenIndexHashKey      = Pattern{prefix: "access-token", parts: 1}
+	ContactSortKey               = Pattern{prefix: "contact", parts: 2}
+	ReminderSortKey              = Pattern{prefix: "reminder", parts: 1}
+	VehicleSortKey               = Pattern{prefix: "vehicle", parts: 1}
+	OdometerSortKey              = Pattern{prefix: "odometer", parts: 3}
+	OdometerTimelineSortKey      = Pattern{prefix: "odometer-timeline", parts: 1}
+
+	ReminderVehicleIndexSortKey = Pattern{prefix: "reminder-vehicle", parts: 2}
+	ReminderDueIndexSortKey     = Pattern{prefix: "reminder-due", parts: 2}
+
+	MigrationHashKey = Pattern{prefix: "_MIGRATIONS"}
+	MigrationSortKey = Pattern{prefix: "migration", parts: 1}
+
+	RateLimitHashKey = Pattern{prefix: "rate-limit", parts: 2}
+	RateLimitSortKey = Pattern{prefix: "_RATE_LIMIT"}
+)
+
+// Build returns the key for the passed parts. Parts can't be empty or contain a slash.
+func (p Pattern) Build(parts ...string) (string, error) {
+	if len(parts) != p.parts {
+		return "", fmt.Errorf("%w: %s needs %d parts, got %d", ErrMalformedKey, p.prefix, p.parts, len(parts))
+	}
+	if err := p.validate(parts); err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{p.prefix}, parts...), "/"), nil
+}
+
+func (p Pattern) validate(parts []string) error {
+	for _, part := range parts {
+		if part == "" || strings.Contains(part, "/") {
+			return fmt.Errorf("%w %q for %s", ErrInvalidPart, part, p.prefix)
+		}
+	}
+	return nil
+}
+
+// MustBuild is Build for parts that are known to be valid. It panics on error.
+func (p Pattern) MustBuild(parts ...string) string {
+	key, err := p.Build(parts...)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Prefix returns the prefix shared by every key the pattern builds, for begins_with queries
+func (p Pattern) Prefix() string {
+	return p.prefix + "/"
+}
+
+// BuildPrefix returns the prefix shared by every key starting with the passed
+// parts, for begins_with queries. Parts are validated like Build's.
+func (p Pattern) BuildPrefix(parts ...string) (string, error) {
+	if len(parts) >= p.parts {
+		return "", fmt.Errorf("%w: %s needs fewer than %d parts for a prefix, got %d", ErrMalformedKey, p.prefix, p.parts, len(parts))
+	}
+	if err := p.validate(parts); err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{p.prefix}, parts...), "/") + "/", nil
+}
+
+// Parse returns the parts of a key built by the pattern
+func (p Pattern) Parse(key string) ([]string, error) {
+	if p.parts == 0 {
+		if key != p.prefix {
+			return nil, fmt.Errorf("%w: %q is not %s", ErrMalformedKey, key, p.prefix)
+		}
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(key, p.Prefix()) {
+		return nil, fmt.Errorf("%w: %q doesn't start with %s", ErrMalformedKey, key, p.Prefix())
+	}
+
+	parts := strings.Split(strings.TrimPrefix(key, p.Prefix()), "/")
+	if len(parts) != p.parts {
+		return nil, fmt.Errorf("%w: %q has %d parts, %s has %d", ErrMalformedKey, key, len(parts), p.prefix, p.parts)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("%w: %q has an empty part", ErrMalformedKey, key)
+		}
+	}
+
+	return parts, nil
+}
+
+// Matches returns true if the key was built by the pattern
+func (p Pattern) Matches(key string) bool {
+	_, err := p.Parse(key)
+	return err == nil
+}
+
+// String returns the pattern's prefix
+func (p Pattern) String() string {
+	return p.prefix
+}
+
+// Primary contains the compound key for a records primary key
+type Primary struct {
+	HashKey string
+	SortKey string
+}
+
+// Dynamo returns the key attributes for DynamoDB
+func (p Primary) Dynamo() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		HashKeyAttribute:  {S: aws.String(p.HashKey)},
+		RangeKeyAttribute: {S: aws.String(p.SortKey)},
+	}
+}
+
+// IndexKey is an item's key in a secondary index
+type IndexKey struct {
+	Index    Index
+	HashKey  string
+	RangeKey string
+}
+
+// Item is every key attribute written with an item
+type Item struct {
+	Primary Primary
+	Indexes []IndexKey
+}
+
+// Attributes validates the keys and returns them as DynamoDB attributes.
+//
+// Both halves of every key are required; an index key missing one half would
+// silently leave the item out of the index. Local index keys take the table's
+// hash key, so only their range key is set.
+func (i Item) Attributes() (map[string]*dynamodb.AttributeValue, error) {
+	if i.Primary.HashKey == "" || i.Primary.SortKey == "" {
+		return nil, fmt.Errorf("%w: primary key %+v", ErrIncompleteKey, i.Primary)
+	}
+
+	attributes := i.Primary.Dynamo()
+
+	for _, key := range i.Indexes {
+		if key.Index.Local {
+			if key.HashKey != "" && key.HashKey != i.Primary.HashKey {
+				return nil, fmt.Errorf("%w: %s shares the table's hash key", ErrMalformedKey, key.Index.Name)
+			}
+		} else if key.HashKey == "" {
+			return nil, fmt.Errorf("%w: %s hash key", ErrIncompleteKey, key.Index.Name)
+		}
+		if key.RangeKey == "" {
+			return nil, fmt.Errorf("%w: %s range key", ErrIncompleteKey, key.Index.Name)
+		}
+		if _, ok := attributes[key.Index.RangeAttribute]; ok {
+			return nil, fmt.Errorf("%w: %s is written more than once", ErrMalformedKey, key.Index.Name)
+		}
+
+		if !key.Index.Local {
+			attributes[key.Index.HashAttribute] = &dynamodb.AttributeValue{S: aws.String(key.HashKey)}
+		}
+		attributes[key.Index.RangeAttribute] = &dynamodb.AttributeValue{S: aws.String(key.RangeKey)}
+	}
+
+	return attributes, nil
+}
+
+// Account returns the primary key for an account
+func Account(accountID string) Primary {
+	return Primary{HashKey: accountID, SortKey: AccountSortKey.MustBuild()}
+}
+
+// AutomaticAccount returns the GSI2 key used to find an account by its Automatic user
+func AutomaticAccount(automaticID string) (IndexKey, error) {
+	hashKey, err := AutomaticAccountIndexHashKey.Build(automaticID)
+	if err != nil {
+		return IndexKey{}, err
+	}
+	return IndexKey{Index: GSI2, HashKey: hashKey, RangeKey: AutomaticAccountIndexSortKey.MustBuild()}, nil
+}
+
+// AccessToken returns the primary key for an Automatic access token
+func AccessToken(accountID, tokenID string) (Primary, error) {
+	return sortKey(accountID, AccessTokenSortKey, tokenID)
+}
+
+// AccessTokenIndex returns the GSI1 key used to find tokens by Automatic user, newest last
+func AccessTokenIndex(automaticID, tokenID string) (IndexKey, error) {
+	hashKey, err := AccessTokenIndexHashKey.Build(automaticID)
+	if err != nil {
+		return IndexKey{}, err
+	}
+	rangeKey, err := AccessTokenSortKey.Build(tokenID)
+	if err != nil {
+		return IndexKey{}, err
+	}
+	return IndexKey{Index: GSI1, HashKey: hashKey, RangeKey: rangeKey}, nil
+}
+
+// Contact returns the primary key for a contact. The value is replaced by its
+// HMAC under the secret, so it can contain any character, and a key read from
+// the table can't be checked against guessed addresses without the secret.
+// The secret can't be empty.
+func Contact(secret, accountID, contactType, value string) (Primary, error) {
+	if secret == "" {
+		return Primary{}, ErrMissingSecret
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+
+	return sortKey(accountID, ContactSortKey, fmt.Sprintf("%x", mac.Sum(nil)), "_"+contactType)
+}
+
+// OAuthState returns the primary key for an in-flight OAuth request
+func OAuthState(state string) (Primary, error) {
+	hashKey, err := OAuthStateHashKey.Build(state)
+	if err != nil {
+		return Primary{}, err
+	}
+	return Primary{HashKey: hashKey, SortKey: OAuthStateSortKey.MustBuild()}, nil
+}
+
+// NotificationPreferences returns the primary key for an account's notification preferences
+func NotificationPreferences(accountID string) Primary {
+	return Primary{HashKey: accountID, SortKey: NotificationPreferencesSortKey.MustBuild()}
+}
+
+// Reminder returns the primary key for a reminder
+func Reminder(accountID, reminderID string) (Primary, error) {
+	return sortKey(accountID, ReminderSortKey, reminderID)
+}
+
+// ReminderVehicleIndex returns the LSI1 key used to list a vehicle's reminders, oldest first
+func ReminderVehicleIndex(vehicleID, reminderID string) (IndexKey, error) {
+	rangeKey, err := ReminderVehicleIndexSortKey.Build(vehicleID, reminderID)
+	if err != nil {
+		return IndexKey{}, err
+	}
+	return IndexKey{Index: LSI1, RangeKey: rangeKey}, nil
+}
+
+// ReminderDueIndex returns the LSI2 key used to list reminders by when they're
+// next due. Reminders without a due time sort after every dated reminder.
+func ReminderDueIndex(dueAt time.Time, reminderID string) (IndexKey, error) {
+	due := "undated"
+	if !dueAt.IsZero() {
+		due = dueAt.UTC().Format(dueIndexFormat)
+	}
+	rangeKey, err := ReminderDueIndexSortKey.Build(due, reminderID)
+	if err != nil {
+		return IndexKey{}, err
+	}
+	return IndexKey{Index: LSI2, RangeKey: rangeKey}, nil
+}
+
+// dueIndexFormat sorts in time order and doesn't contain a slash
+const dueIndexFormat = "20060102T150405Z"
+
+// Vehicle returns the primary key for a vehicle
+func Vehicle(accountID, vehicleID string) (Primary, error) {
+	return sortKey(accountID, VehicleSortKey, vehicleID)
+}
+
+// OdometerReading returns the primary key for a reading. Readings sort by the
+// unix time they were read at, then by ID, so readings in the same second don't collide.
+func OdometerReading(accountID, vehicleID string, unix int64, readingID string) (Primary, error) {
+	return sortKey(accountID, OdometerSortKey, vehicleID, fmt.Sprintf("%012d", unix), readingID)
+}
+
+// OdometerTimeline returns the primary key for the item that versions a
+// vehicle's readings, so readings are checked against their neighbors and
+// written atomically
+func OdometerTimeline(accountID, vehicleID string) (Primary, error) {
+	return sortKey(accountID, OdometerTimelineSortKey, vehicleID)
+}
+
+// Migration returns the primary key for the record of a schema migration
+func Migration(id string) (Primary, error) {
+	sortKey, err := MigrationSortKey.Build(id)
+	if err != nil {
+		return Primary{}, err
+	}
+	return Primary{HashKey: MigrationHashKey.MustBuild(), SortKey: sortKey}, nil
+}
+
+// RateLimit returns the primary key for an outbound rate limit budget. Each
+// budget has its own partition so busy budgets don't share throughput.
+func RateLimit(kind, id string) (Primary, error) {
+	hashKey, err := RateLimitHashKey.Build(kind, id)
+	if err != nil {
+		return Primary{}, err
+	}
+	return Primary{HashKey: hashKey, SortKey: RateLimitSortKey.MustBuild()}, nil
+}
+
+// sortKey returns the key for an item in the account's partition
+func sortKey(accountID string, p Pattern, parts ...string) (Primary, error) {
+	key, err := p.Build(parts...)
+	if err != nil {
+		return Primary{}, err
+	}
+	return Primary{HashKey: accountID, SortKey: key}, nil
+}
+
+// ParseOdometerReading returns the vehicle ID, unix time and reading ID from a reading's sort key
+func ParseOdometerReading(sortKey string) (string, int64, string, error) {
+	parts, err := OdometerSortKey.Parse(sortKey)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	var unix int64
+	if _, err := fmt.Sscanf(parts[1], "%d", &unix); err != nil {
+		return "", 0, "", fmt.Errorf("%w: %q has an invalid time", ErrMalformedKey, sortKey)
+	}
+
+	return parts[0], unix, parts[2], nil
+}
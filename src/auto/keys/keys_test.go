@@ -0,0 +1,166 @@
+package keys
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatterns(t *testing.T) {
+	cases := []struct {
+		pattern Pattern
+		parts   []string
+		key     string
+	}{
+		{AccountSortKey, []string{}, "_USER_ACCOUNT"},
+		{AutomaticAccountIndexHashKey, []string{"U_1"}, "automatic/U_1"},
+		{AccessTokenSortKey, []string{"1ZxE"}, "access-token/1ZxE"},
+		{ContactSortKey, []string{"abc", "_EMAIL"}, "contact/abc/_EMAIL"},
+		{OAuthStateHashKey, []string{"state"}, "integration/automatic/state"},
+		{ReminderSortKey, []string{"rem:1"}, "reminder/rem:1"},
+		{VehicleSortKey, []string{"veh:1"}, "vehicle/veh:1"},
+		{OdometerSortKey, []string{"veh:1", "001569931200", "odo:1"}, "odometer/veh:1/001569931200/odo:1"},
+		{ReminderVehicleIndexSortKey, []string{"veh:1", "rem:1"}, "reminder-vehicle/veh:1/rem:1"},
+		{ReminderDueIndexSortKey, []string{"20191001T120000Z", "rem:1"}, "reminder-due/20191001T120000Z/rem:1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			key, err := c.pattern.Build(c.parts...)
+			require.NoError(t, err)
+			assert.Equal(t, c.key, key)
+
+			parts, err := c.pattern.Parse(key)
+			require.NoError(t, err)
+			assert.Equal(t, c.parts, parts)
+		})
+	}
+
+	t.Run("rejects invalid parts", func(t *testing.T) {
+		for _, parts := range [][]string{{}, {""}, {"a/b"}, {"a", "b"}} {
+			_, err := ReminderSortKey.Build(parts...)
+			assert.True(t, errors.Is(err, ErrMalformedKey), "%v", parts)
+		}
+
+		_, err := ReminderSortKey.Build("a/b")
+		assert.True(t, errors.Is(err, ErrInvalidPart))
+	})
+
+	t.Run("returns the error for IDs that can't be part of a key", func(t *testing.T) {
+		_, err := Reminder("auid:1", "rem:1/../rem:2")
+		assert.True(t, errors.Is(err, ErrInvalidPart))
+
+		_, err = Vehicle("auid:1", "")
+		assert.True(t, errors.Is(err, ErrInvalidPart))
+
+		_, err = ReminderVehicleIndex("veh/1", "rem:1")
+		assert.True(t, errors.Is(err, ErrInvalidPart))
+	})
+
+	t.Run("builds prefixes from valid parts", func(t *testing.T) {
+		prefix, err := OdometerSortKey.BuildPrefix("veh:1")
+		require.NoError(t, err)
+		assert.Equal(t, "odometer/veh:1/", prefix)
+
+		_, err = OdometerSortKey.BuildPrefix("veh:1/0")
+		assert.True(t, errors.Is(err, ErrInvalidPart))
+
+		_, err = ReminderSortKey.BuildPrefix("rem:1")
+		assert.True(t, errors.Is(err, ErrMalformedKey), "a prefix has fewer parts than a key")
+	})
+
+	t.Run("rejects keys from other patterns", func(t *testing.T) {
+		for _, key := range []string{"vehicle/veh:1", "reminder/", "reminder/a/b", "reminders/a"} {
+			assert.False(t, ReminderSortKey.Matches(key), key)
+		}
+	})
+
+	t.Run("round trips odometer readings", func(t *testing.T) {
+		key, err := OdometerReading("auid:1", "veh:1", 1569931200, "odo:1")
+		require.NoError(t, err)
+		vehicleID, unix, readingID, err := ParseOdometerReading(key.SortKey)
+		require.NoError(t, err)
+		assert.Equal(t, "veh:1", vehicleID)
+		assert.Equal(t, int64(1569931200), unix)
+		assert.Equal(t, "odo:1", readingID)
+
+		timeline, err := OdometerTimeline("auid:1", "veh:1")
+		require.NoError(t, err)
+		prefix, err := OdometerSortKey.BuildPrefix("veh:1")
+		require.NoError(t, err)
+
+		assert.False(t, OdometerSortKey.Matches(timeline.SortKey))
+		assert.False(t, strings.HasPrefix(timeline.SortKey, prefix), "the timeline isn't listed with the readings")
+	})
+
+	t.Run("keys contacts by an HMAC of the value", func(t *testing.T) {
+		key, err := Contact("secret", "auid:1", "EMAIL", "test@email.test")
+		require.NoError(t, err)
+		assert.True(t, ContactSortKey.Matches(key.SortKey))
+		assert.NotContains(t, key.SortKey, "test@email.test")
+
+		same, err := Contact("secret", "auid:1", "EMAIL", "test@email.test")
+		require.NoError(t, err)
+		assert.Equal(t, key, same)
+
+		other, err := Contact("other", "auid:1", "EMAIL", "test@email.test")
+		require.NoError(t, err)
+		assert.NotEqual(t, key, other, "the key depends on the secret")
+
+		_, err = Contact("", "auid:1", "EMAIL", "test@email.test")
+		assert.Equal(t, ErrMissingSecret, err, "an unkeyed hash is never written")
+	})
+
+	t.Run("sorts undated reminders last", func(t *testing.T) {
+		dated, err := ReminderDueIndex(time.Date(2099, 12, 31, 23, 0, 0, 0, time.FixedZone("MST", -7*60*60)), "rem:1")
+		require.NoError(t, err)
+		assert.Equal(t, "reminder-due/21000101T060000Z/rem:1", dated.RangeKey)
+
+		undated, err := ReminderDueIndex(time.Time{}, "rem:1")
+		require.NoError(t, err)
+		assert.Less(t, dated.RangeKey, undated.RangeKey)
+	})
+}
+
+func TestItemAttributes(t *testing.T) {
+	t.Run("writes both halves of each index key", func(t *testing.T) {
+		primary, err := AccessToken("auid:1", "tok")
+		require.NoError(t, err)
+		index, err := AccessTokenIndex("U_1", "tok")
+		require.NoError(t, err)
+
+		attributes, err := Item{
+			Primary: primary,
+			Indexes: []IndexKey{index, {Index: LSI1, RangeKey: "lsi"}},
+		}.Attributes()
+		require.NoError(t, err)
+
+		assert.Equal(t, "access-token/U_1", *attributes["GSI1PK"].S)
+		assert.Equal(t, "access-token/tok", *attributes["GSI1SK"].S)
+		assert.Equal(t, "lsi", *attributes["LSI1SK"].S)
+		assert.Nil(t, attributes["GSI2SK"])
+		assert.Len(t, attributes, 5)
+	})
+
+	t.Run("rejects incomplete keys", func(t *testing.T) {
+		items := []Item{
+			{Primary: Primary{HashKey: "auid:1"}},
+			{Primary: Account("auid:1"), Indexes: []IndexKey{{Index: GSI2, RangeKey: AutomaticAccountIndexSortKey.MustBuild()}}},
+			{Primary: Account("auid:1"), Indexes: []IndexKey{{Index: GSI1, HashKey: "a"}}},
+			{Primary: Account("auid:1"), Indexes: []IndexKey{{Index: LSI2}}},
+		}
+		for _, item := range items {
+			_, err := item.Attributes()
+			assert.True(t, errors.Is(err, ErrIncompleteKey), "%+v", item)
+		}
+	})
+
+	t.Run("local indexes share the table's hash key", func(t *testing.T) {
+		_, err := Item{Primary: Account("auid:1"), Indexes: []IndexKey{{Index: LSI1, HashKey: "other", RangeKey: "x"}}}.Attributes()
+		assert.True(t, errors.Is(err, ErrMalformedKey))
+	})
+}
@@ -0,0 +1,217 @@
+package auto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log line
+type LogLevel int
+
+// Log levels, from least to most severe
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLogLevel returns the level with the name
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LogInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Fields are the structured values of a log line
+type Fields map[string]interface{}
+
+// Logger writes one JSON object per line. Every line has the time, level and
+// message, the request ID and fields carried by the context, and the fields
+// passed with it. Tokens and email addresses are redacted.
+type Logger struct {
+	out   io.Writer
+	level LogLevel
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewLogger returns a logger writing lines at the level or above to out
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	return &Logger{out: out, level: level}
+}
+
+// Debug logs a line at LogDebug
+func (l *Logger) Debug(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LogDebug, msg, fields)
+}
+
+// Info logs a line at LogInfo
+func (l *Logger) Info(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LogInfo, msg, fields)
+}
+
+// Warn logs a line at LogWarn
+func (l *Logger) Warn(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LogWarn, msg, fields)
+}
+
+// Error logs a line at LogError
+func (l *Logger) Error(ctx context.Context, msg string, fields Fields) {
+	l.Log(ctx, LogError, msg, fields)
+}
+
+// Log writes a line if the level is at or above the logger's level
+func (l *Logger) Log(ctx context.Context, level LogLevel, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+
+	line := make(map[string]interface{}, len(fields)+4)
+	for name, value := range logFields(ctx) {
+		line[name] = redactField(name, value)
+	}
+	for name, value := range fields {
+		line[name] = redactField(name, value)
+	}
+	if id := RequestID(ctx); id != "" {
+		line["request_id"] = id
+	}
+	line["time"] = l.clock().UTC().Format(time.RFC3339Nano)
+	line["level"] = level.String()
+	line["msg"] = Redact(msg)
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"time":  line["time"],
+			"level": LogError.String(),
+			"msg":   "log line couldn't be encoded",
+			"error": err.Error(),
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(data, '\n'))
+}
+
+func (l *Logger) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// WithLogFields returns a context whose log lines carry the fields, as well as any the context already carries
+func WithLogFields(ctx context.Context, fields Fields) context.Context {
+	merged := Fields{}
+	for name, value := range logFields(ctx) {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, logFieldsContextKey, merged)
+}
+
+func logFields(ctx context.Context) Fields {
+	fields, _ := ctx.Value(logFieldsContextKey).(Fields)
+	return fields
+}
+
+// redactedFields are fields whose values are never logged
+var redactedFields = map[string]bool{
+	"access_token":  true,
+	"authorization": true,
+	"client_secret": true,
+	"contact":       true,
+	"email":         true,
+	"password":      true,
+	"refresh_token": true,
+	"token":         true,
+}
+
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// Credentials in query strings and form bodies, including OAuth codes and states
+	{regexp.MustCompile(`(?i)\b((?:access_token|refresh_token|client_secret|code|token|password|state)=)[^&\s"]+`), "${1}[redacted]"},
+	// Credentials in JSON
+	{regexp.MustCompile(`(?i)("(?:access_?token|refresh_?token|client_?secret|token|password)"\s*:\s*)"[^"]*"`), `${1}"[redacted]"`},
+	{regexp.MustCompile(`(?i)(bearer\s+)\S+`), "${1}[redacted]"},
+	// JWTs, such as API and action tokens in paths
+	{regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]*`), "[redacted]"},
+	{regexp.MustCompile(`[\w.%+-]+@[\w-]+(?:\.[\w-]+)+`), "[redacted-email]"},
+}
+
+// Redact replaces tokens and email addresses in s
+func Redact(s string) string {
+	for _, r := range redactions {
+		s = r.pattern.ReplaceAllString(s, r.replacement)
+	}
+	return s
+}
+
+func redactField(name string, value interface{}) interface{} {
+	if redactedFields[strings.ToLower(name)] {
+		return "[redacted]"
+	}
+
+	switch v := value.(type) {
+	case string:
+		return Redact(v)
+	case error:
+		return Redact(v.Error())
+	case fmt.Stringer:
+		return Redact(v.String())
+	default:
+		return v
+	}
+}
+
+var (
+	loggerInstance *Logger
+	loggerSetup    sync.Once
+)
+
+// DefaultLogger returns the shared logger. Unless one has been set it writes
+// LogInfo and above to stderr, which Lambda sends to CloudWatch Logs.
+func DefaultLogger() *Logger {
+	loggerSetup.Do(func() {
+		loggerInstance = NewLogger(os.Stderr, LogInfo)
+	})
+	return loggerInstance
+}
+
+// SetDefaultLogger replaces the shared logger
+func SetDefaultLogger(l *Logger) {
+	loggerSetup.Do(func() {})
+	loggerInstance = l
+}
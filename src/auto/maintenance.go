@@ -0,0 +1,150 @@
+package auto
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MaintenanceTemplate is a set of reminders recommended for matching vehicles
+type MaintenanceTemplate struct {
+	ID          string
+	Title       string
+	Description string `json:",omitempty"`
+	Match       MaintenanceTemplateMatch
+	Reminders   []MaintenanceTemplateReminder
+}
+
+// MaintenanceTemplateMatch selects the vehicles a template applies to.
+//
+// Empty fields match every vehicle. Makes and models are compared case-insensitively.
+type MaintenanceTemplateMatch struct {
+	Makes        []string `json:",omitempty"`
+	Models       []string `json:",omitempty"`
+	ExcludeMakes []string `json:",omitempty"`
+	MinYear      int      `json:",omitempty"`
+	MaxYear      int      `json:",omitempty"`
+}
+
+// MaintenanceTemplateReminder is a single reminder created when a template is applied
+type MaintenanceTemplateReminder struct {
+	Title            string
+	IntervalDays     int
+	IntervalDistance float64
+}
+
+var (
+	maintenanceCatalog      []*MaintenanceTemplate
+	maintenanceCatalogSetup sync.Once
+)
+
+// MaintenanceCatalog returns the built-in maintenance templates
+func MaintenanceCatalog() []*MaintenanceTemplate {
+	maintenanceCatalogSetup.Do(func() {
+		err := json.Unmarshal([]byte(maintenanceCatalogJSON), &maintenanceCatalog)
+		if err != nil {
+			panic(err)
+		}
+	})
+	return maintenanceCatalog
+}
+
+// FindMaintenanceTemplate returns the built-in template with the passed ID
+func FindMaintenanceTemplate(id string) (*MaintenanceTemplate, error) {
+	for _, template := range MaintenanceCatalog() {
+		if template.ID == id {
+			return template, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// SuggestedMaintenanceTemplates returns the templates that apply to the vehicle, most specific first
+func SuggestedMaintenanceTemplates(v *Vehicle) []*MaintenanceTemplate {
+	templates := make([]*MaintenanceTemplate, 0)
+	for _, template := range MaintenanceCatalog() {
+		if template.Match.Matches(v) {
+			templates = append(templates, template)
+		}
+	}
+
+	sort.SliceStable(templates, func(i, j int) bool {
+		return templates[i].Match.specificity() > templates[j].Match.specificity()
+	})
+
+	return templates
+}
+
+// Matches returns true if the vehicle satisfies every part of the match
+func (m MaintenanceTemplateMatch) Matches(v *Vehicle) bool {
+	if containsFold(m.ExcludeMakes, v.Make) {
+		return false
+	}
+	if len(m.Makes) > 0 && !containsFold(m.Makes, v.Make) {
+		return false
+	}
+	if len(m.Models) > 0 && !containsFold(m.Models, v.Model) {
+		return false
+	}
+	if m.MinYear > 0 && v.Year < m.MinYear {
+		return false
+	}
+	if m.MaxYear > 0 && (v.Year == 0 || v.Year > m.MaxYear) {
+		return false
+	}
+	return true
+}
+
+func (m MaintenanceTemplateMatch) specificity() int {
+	score := 0
+	if len(m.Makes) > 0 {
+		score += 4
+	}
+	if len(m.Models) > 0 {
+		score += 2
+	}
+	if m.MinYear > 0 || m.MaxYear > 0 {
+		score++
+	}
+	return score
+}
+
+// NewReminders returns new, unsaved reminders for the vehicle from the template.
+//
+// Reminders whose titles match one of the existing reminders are skipped.
+func (t *MaintenanceTemplate) NewReminders(v *Vehicle, existing []*Reminder) []*Reminder {
+	reminders := make([]*Reminder, 0, len(t.Reminders))
+
+	for _, tr := range t.Reminders {
+		duplicate := false
+		for _, r := range existing {
+			if r.VehicleID == v.ID && strings.EqualFold(r.Title, tr.Title) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		reminders = append(reminders, &Reminder{
+			AccountID:        v.AccountID,
+			VehicleID:        v.ID,
+			Title:            tr.Title,
+			IntervalDays:     tr.IntervalDays,
+			IntervalDistance: tr.IntervalDistance,
+		})
+	}
+
+	return reminders
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
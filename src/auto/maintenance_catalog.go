@@ -0,0 +1,116 @@
+package auto
+
+// maintenanceCatalogJSON is the built-in maintenance template catalog.
+//
+// Distances are in kilometers. Make specific templates should be listed after
+// the generic templates they refine.
+const maintenanceCatalogJSON = `[
+  {
+    "ID": "standard",
+    "Title": "Standard maintenance",
+    "Description": "Common maintenance for gasoline and diesel vehicles.",
+    "Match": {
+      "ExcludeMakes": ["Tesla", "Polestar", "Rivian"]
+    },
+    "Reminders": [
+      { "Title": "Oil change", "IntervalDays": 180, "IntervalDistance": 8000 },
+      { "Title": "Tire rotation", "IntervalDays": 180, "IntervalDistance": 10000 },
+      { "Title": "Brake fluid", "IntervalDays": 730 },
+      { "Title": "Engine air filter", "IntervalDays": 365, "IntervalDistance": 24000 },
+      { "Title": "Cabin air filter", "IntervalDays": 365, "IntervalDistance": 24000 }
+    ]
+  },
+  {
+    "ID": "standard-pre-2008",
+    "Title": "Older vehicle maintenance",
+    "Description": "Shorter oil intervals and coolant service for vehicles built before 2008.",
+    "Match": {
+      "ExcludeMakes": ["Tesla", "Polestar", "Rivian"],
+      "MaxYear": 2007
+    },
+    "Reminders": [
+      { "Title": "Oil change", "IntervalDays": 90, "IntervalDistance": 5000 },
+      { "Title": "Tire rotation", "IntervalDays": 180, "IntervalDistance": 10000 },
+      { "Title": "Brake fluid", "IntervalDays": 730 },
+      { "Title": "Engine air filter", "IntervalDays": 365, "IntervalDistance": 24000 },
+      { "Title": "Coolant flush", "IntervalDays": 730, "IntervalDistance": 48000 },
+      { "Title": "Spark plugs", "IntervalDistance": 96000 }
+    ]
+  },
+  {
+    "ID": "toyota",
+    "Title": "Toyota maintenance",
+    "Description": "Toyota's recommended schedule for vehicles using synthetic oil.",
+    "Match": {
+      "Makes": ["Toyota", "Lexus", "Scion"],
+      "MinYear": 2011
+    },
+    "Reminders": [
+      { "Title": "Oil change", "IntervalDays": 365, "IntervalDistance": 16000 },
+      { "Title": "Tire rotation", "IntervalDays": 180, "IntervalDistance": 8000 },
+      { "Title": "Brake fluid", "IntervalDays": 730 },
+      { "Title": "Engine air filter", "IntervalDays": 730, "IntervalDistance": 48000 },
+      { "Title": "Cabin air filter", "IntervalDays": 365, "IntervalDistance": 24000 }
+    ]
+  },
+  {
+    "ID": "honda",
+    "Title": "Honda maintenance",
+    "Description": "An approximation of Honda's Maintenance Minder schedule.",
+    "Match": {
+      "Makes": ["Honda", "Acura"],
+      "MinYear": 2006
+    },
+    "Reminders": [
+      { "Title": "Oil change", "IntervalDays": 365, "IntervalDistance": 12000 },
+      { "Title": "Tire rotation", "IntervalDays": 365, "IntervalDistance": 12000 },
+      { "Title": "Brake fluid", "IntervalDays": 1095 },
+      { "Title": "Engine air filter", "IntervalDays": 730, "IntervalDistance": 24000 },
+      { "Title": "Cabin air filter", "IntervalDays": 365, "IntervalDistance": 24000 }
+    ]
+  },
+  {
+    "ID": "subaru",
+    "Title": "Subaru maintenance",
+    "Description": "Subaru's schedule, including rotations to keep all-wheel drive tires matched.",
+    "Match": {
+      "Makes": ["Subaru"]
+    },
+    "Reminders": [
+      { "Title": "Oil change", "IntervalDays": 180, "IntervalDistance": 9600 },
+      { "Title": "Tire rotation", "IntervalDays": 180, "IntervalDistance": 9600 },
+      { "Title": "Brake fluid", "IntervalDays": 1095, "IntervalDistance": 48000 },
+      { "Title": "Engine air filter", "IntervalDays": 730, "IntervalDistance": 48000 },
+      { "Title": "Differential fluid", "IntervalDays": 1095, "IntervalDistance": 48000 }
+    ]
+  },
+  {
+    "ID": "electric",
+    "Title": "Electric vehicle maintenance",
+    "Description": "Electric vehicles don't need oil changes, but tires, brakes and filters still need attention.",
+    "Match": {
+      "Makes": ["Tesla", "Polestar", "Rivian"]
+    },
+    "Reminders": [
+      { "Title": "Tire rotation", "IntervalDays": 365, "IntervalDistance": 10000 },
+      { "Title": "Brake fluid", "IntervalDays": 1460 },
+      { "Title": "Cabin air filter", "IntervalDays": 730 },
+      { "Title": "Brake caliper service", "IntervalDays": 365 }
+    ]
+  },
+  {
+    "ID": "nissan-leaf",
+    "Title": "Nissan Leaf maintenance",
+    "Description": "Electric vehicle maintenance for the Nissan Leaf.",
+    "Match": {
+      "Makes": ["Nissan"],
+      "Models": ["Leaf"]
+    },
+    "Reminders": [
+      { "Title": "Tire rotation", "IntervalDays": 180, "IntervalDistance": 12000 },
+      { "Title": "Brake fluid", "IntervalDays": 730 },
+      { "Title": "Cabin air filter", "IntervalDays": 365 },
+      { "Title": "Battery coolant check", "IntervalDays": 365 }
+    ]
+  }
+]`
@@ -0,0 +1,66 @@
+package auto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceCatalog(t *testing.T) {
+	t.Run("every template is valid", func(t *testing.T) {
+		ids := make(map[string]bool)
+
+		for _, template := range MaintenanceCatalog() {
+			assert.NotEmpty(t, template.ID)
+			assert.False(t, ids[template.ID], "duplicate template %s", template.ID)
+			ids[template.ID] = true
+
+			require.NotEmpty(t, template.Reminders, template.ID)
+			for _, r := range template.Reminders {
+				assert.NotEmpty(t, r.Title, template.ID)
+				assert.True(t, r.IntervalDays > 0 || r.IntervalDistance > 0, "%s: %s has no interval", template.ID, r.Title)
+			}
+		}
+	})
+
+	t.Run("suggests the most specific template first", func(t *testing.T) {
+		templates := SuggestedMaintenanceTemplates(&Vehicle{Make: "toyota", Model: "Corolla", Year: 2015})
+
+		require.Len(t, templates, 2)
+		assert.Equal(t, "toyota", templates[0].ID)
+		assert.Equal(t, "standard", templates[1].ID)
+	})
+
+	t.Run("matches by year", func(t *testing.T) {
+		templates := SuggestedMaintenanceTemplates(&Vehicle{Make: "Ford", Model: "Ranger", Year: 2003})
+
+		require.Len(t, templates, 2)
+		assert.Equal(t, "standard-pre-2008", templates[0].ID)
+	})
+
+	t.Run("excluded makes don't get standard maintenance", func(t *testing.T) {
+		templates := SuggestedMaintenanceTemplates(&Vehicle{Make: "Tesla", Model: "Model 3", Year: 2019})
+
+		require.Len(t, templates, 1)
+		assert.Equal(t, "electric", templates[0].ID)
+	})
+
+	t.Run("skips reminders the vehicle already has", func(t *testing.T) {
+		template, err := FindMaintenanceTemplate("standard")
+		require.NoError(t, err)
+
+		vehicle := &Vehicle{ID: "veh:test", AccountID: "auid:test"}
+		reminders := template.NewReminders(vehicle, []*Reminder{
+			{VehicleID: "veh:test", Title: "oil change"},
+			{VehicleID: "veh:other", Title: "Tire rotation"},
+		})
+
+		require.Len(t, reminders, len(template.Reminders)-1)
+		for _, r := range reminders {
+			assert.NotEqual(t, "Oil change", r.Title)
+			assert.Equal(t, "veh:test", r.VehicleID)
+			assert.Equal(t, "auid:test", r.AccountID)
+		}
+	})
+}
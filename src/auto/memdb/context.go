@@ -0,0 +1,80 @@
+package memdb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// contextError returns the error the SDK returns for a request whose context is done
+func contextError(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+// GetItemWithContext is GetItem that fails once the context is done
+func (d *DB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.GetItem(input)
+}
+
+// BatchGetItemWithContext is BatchGetItem that fails once the context is done
+func (d *DB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, _ ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.BatchGetItem(input)
+}
+
+// PutItemWithContext is PutItem that fails once the context is done
+func (d *DB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.PutItem(input)
+}
+
+// UpdateItemWithContext is UpdateItem that fails once the context is done
+func (d *DB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, _ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.UpdateItem(input)
+}
+
+// DeleteItemWithContext is DeleteItem that fails once the context is done
+func (d *DB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.DeleteItem(input)
+}
+
+// TransactWriteItemsWithContext is TransactWriteItems that fails once the context is done
+func (d *DB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, _ ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.TransactWriteItems(input)
+}
+
+// QueryWithContext is Query that fails once the context is done
+func (d *DB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, _ ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.Query(input)
+}
+
+// ScanWithContext is Scan that fails once the context is done
+func (d *DB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, _ ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return d.Scan(input)
+}
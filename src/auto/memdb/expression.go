@@ -0,0 +1,501 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// The expression grammar is the subset of DynamoDB's condition, key condition,
+// projection and update expressions the app uses. Anything outside of it is a
+// ValidationException, the same as an expression DynamoDB can't parse.
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenName
+	tokenValue
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expr)
+
+	isIdent := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || r == ':' || isIdent(r):
+			start := i
+			i++
+			for i < len(runes) && isIdent(runes[i]) {
+				i++
+			}
+			kind := tokenIdent
+			if r == '#' {
+				kind = tokenName
+			} else if r == ':' {
+				kind = tokenValue
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[start:i])})
+		case strings.ContainsRune("(),.[]=+-", r):
+			tokens = append(tokens, token{kind: tokenPunct, text: string(r)})
+			i++
+		case r == '<' || r == '>':
+			text := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				text += string(runes[i+1])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: text})
+			i++
+		default:
+			return nil, validationError("Invalid character %q in expression", r)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// parser turns an expression into closures evaluated against an item
+type parser struct {
+	tokens []token
+	pos    int
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+}
+
+func newParser(expr string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*parser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens, names: names, values: values}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) punct(text string) bool {
+	t := p.peek()
+	if t.kind == tokenPunct && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.punct(text) {
+		return validationError("Expected %q in expression, found %q", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) done() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return validationError("Unexpected %q in expression", t.text)
+	}
+	return nil
+}
+
+// path is an attribute path. Each element is a map key, or a list index when index is >= 0.
+type path []pathElement
+
+type pathElement struct {
+	name  string
+	index int
+}
+
+func (p path) String() string {
+	parts := make([]string, len(p))
+	for i, e := range p {
+		if e.index >= 0 {
+			parts[i] = fmt.Sprintf("[%d]", e.index)
+		} else {
+			parts[i] = e.name
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func (p *parser) name(t token) (string, error) {
+	switch t.kind {
+	case tokenName:
+		name, ok := p.names[t.text]
+		if !ok || name == nil {
+			return "", validationError("An expression attribute name used in the document path is not defined; attribute name: %s", t.text)
+		}
+		return *name, nil
+	case tokenIdent:
+		return t.text, nil
+	default:
+		return "", validationError("Expected an attribute name, found %q", t.text)
+	}
+}
+
+func (p *parser) parsePath() (path, error) {
+	first, err := p.name(p.next())
+	if err != nil {
+		return nil, err
+	}
+	result := path{{name: first, index: -1}}
+
+	for {
+		if p.punct(".") {
+			name, err := p.name(p.next())
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, pathElement{name: name, index: -1})
+		} else if p.punct("[") {
+			t := p.next()
+			var index int
+			if _, err := fmt.Sscanf(t.text, "%d", &index); err != nil || index < 0 {
+				return nil, validationError("Invalid list index %q", t.text)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			result = append(result, pathElement{index: index})
+		} else {
+			return result, nil
+		}
+	}
+}
+
+func (p *parser) value(t token) (*dynamodb.AttributeValue, error) {
+	value, ok := p.values[t.text]
+	if !ok || value == nil {
+		return nil, validationError("An expression attribute value used in expression is not defined; attribute value: %s", t.text)
+	}
+	return value, nil
+}
+
+// operand is evaluated against an item. It returns nil when a path doesn't resolve.
+type operand func(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue
+
+func (p *parser) parseOperand() (operand, error) {
+	t := p.peek()
+
+	if t.kind == tokenValue {
+		p.next()
+		value, err := p.value(t)
+		if err != nil {
+			return nil, err
+		}
+		return func(map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue { return value }, nil
+	}
+
+	if t.kind == tokenIdent && strings.EqualFold(t.text, "size") && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		target, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+			n, ok := size(resolve(item, target))
+			if !ok {
+				return nil
+			}
+			return numberValue(float64(n))
+		}, nil
+	}
+
+	target, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return func(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue { return resolve(item, target) }, nil
+}
+
+// condition is a parsed condition or key condition expression
+type condition func(item map[string]*dynamodb.AttributeValue) bool
+
+func parseCondition(expr string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (condition, error) {
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	return cond, p.done()
+}
+
+func (p *parser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(item map[string]*dynamodb.AttributeValue) bool { return l(item) || r(item) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(item map[string]*dynamodb.AttributeValue) bool { return l(item) && r(item) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (condition, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(item map[string]*dynamodb.AttributeValue) bool { return !inner(item) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (condition, error) {
+	if p.punct("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+
+	if t := p.peek(); t.kind == tokenIdent && p.tokens[p.pos+1].text == "(" && !strings.EqualFold(t.text, "size") {
+		return p.parseFunction()
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("BETWEEN") {
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, validationError("Expected AND in BETWEEN")
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return func(item map[string]*dynamodb.AttributeValue) bool {
+			v := left(item)
+			lo, okLo := compare(v, low(item))
+			hi, okHi := compare(v, high(item))
+			return okLo && okHi && lo >= 0 && hi <= 0
+		}, nil
+	}
+
+	if p.keyword("IN") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		options := make([]operand, 0)
+		for {
+			option, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, option)
+			if !p.punct(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(item map[string]*dynamodb.AttributeValue) bool {
+			v := left(item)
+			for _, option := range options {
+				if equal(v, option(item)) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokenPunct {
+		return nil, validationError("Expected a comparator, found %q", op.text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.text {
+	case "=":
+		return func(item map[string]*dynamodb.AttributeValue) bool { return equal(left(item), right(item)) }, nil
+	case "<>":
+		return func(item map[string]*dynamodb.AttributeValue) bool {
+			l, r := left(item), right(item)
+			return l != nil && r != nil && !equal(l, r)
+		}, nil
+	case "<", "<=", ">", ">=":
+		return func(item map[string]*dynamodb.AttributeValue) bool {
+			c, ok := compare(left(item), right(item))
+			if !ok {
+				return false
+			}
+			switch op.text {
+			case "<":
+				return c < 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			default:
+				return c >= 0
+			}
+		}, nil
+	default:
+		return nil, validationError("Unknown comparator %q", op.text)
+	}
+}
+
+func (p *parser) parseFunction() (condition, error) {
+	fn := strings.ToLower(p.next().text)
+	p.next() // (
+
+	target, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+
+	var arg operand
+	if p.punct(",") {
+		if arg, err = p.parseOperand(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	needsArg := fn == "begins_with" || fn == "contains" || fn == "attribute_type"
+	if needsArg != (arg != nil) {
+		return nil, validationError("Invalid number of arguments to %s", fn)
+	}
+
+	switch fn {
+	case "attribute_exists":
+		return func(item map[string]*dynamodb.AttributeValue) bool { return resolve(item, target) != nil }, nil
+	case "attribute_not_exists":
+		return func(item map[string]*dynamodb.AttributeValue) bool { return resolve(item, target) == nil }, nil
+	case "attribute_type":
+		return func(item map[string]*dynamodb.AttributeValue) bool {
+			v, t := resolve(item, target), arg(item)
+			return v != nil && t != nil && t.S != nil && typeOf(v) == *t.S
+		}, nil
+	case "begins_with":
+		return func(item map[string]*dynamodb.AttributeValue) bool {
+			v, prefix := resolve(item, target), arg(item)
+			return v != nil && prefix != nil && v.S != nil && prefix.S != nil && strings.HasPrefix(*v.S, *prefix.S)
+		}, nil
+	case "contains":
+		return func(item map[string]*dynamodb.AttributeValue) bool { return contains(resolve(item, target), arg(item)) }, nil
+	default:
+		return nil, validationError("Invalid function name; function: %s", fn)
+	}
+}
+
+// parseProjection returns the top level attributes named in a projection expression
+func parseProjection(expr string, names map[string]*string) ([]string, error) {
+	p, err := newParser(expr, names, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make([]string, 0)
+	for {
+		target, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, target[0].name)
+		if !p.punct(",") {
+			break
+		}
+	}
+
+	return attributes, p.done()
+}
+
+func resolve(item map[string]*dynamodb.AttributeValue, target path) *dynamodb.AttributeValue {
+	current := &dynamodb.AttributeValue{M: item}
+	for _, e := range target {
+		if e.index >= 0 {
+			if current.L == nil || e.index >= len(current.L) {
+				return nil
+			}
+			current = current.L[e.index]
+		} else {
+			if current.M == nil {
+				return nil
+			}
+			current = current.M[e.name]
+		}
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
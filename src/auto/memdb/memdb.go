@@ -0,0 +1,645 @@
+// Package memdb is an in-memory stand-in for a single DynamoDB table.
+//
+// It implements the parts of the DynamoDB API the app uses with the same
+// semantics: key conditions on the table and its secondary indexes, KEYS_ONLY
+// projections, conditional writes, update expressions, batch reads, paginated
+// queries and scans, and all-or-nothing transactions. The WithContext variants
+// fail the way the SDK does once their context is done. Calling any other API
+// method panics.
+package memdb
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Index is a local or global secondary index
+type Index struct {
+	Name     string
+	HashKey  string
+	RangeKey string
+	KeysOnly bool
+}
+
+// Schema describes the table's keys and indexes. All keys are strings.
+type Schema struct {
+	HashKey  string
+	RangeKey string
+	Indexes  []Index
+}
+
+// DB is an in-memory table. It's safe for concurrent use.
+type DB struct {
+	// Unimplemented API methods fall through to the nil interface and panic.
+	dynamodbiface.DynamoDBAPI
+
+	schema Schema
+	mu     sync.Mutex
+	items  map[string]map[string]*dynamodb.AttributeValue
+}
+
+// New returns an empty table with the passed schema
+func New(schema Schema) *DB {
+	return &DB{
+		schema: schema,
+		items:  make(map[string]map[string]*dynamodb.AttributeValue),
+	}
+}
+
+// Len returns the number of items in the table
+func (d *DB) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.items)
+}
+
+// GetItem returns a copy of the item with the passed key
+func (d *DB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id, err := d.id(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	item := d.items[id]
+	if item == nil {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+
+	item, err = project(item, input.ProjectionExpression, input.ExpressionAttributeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+// BatchGetItem returns copies of the items with the passed keys. Keys that
+// don't match an item are left out, and the response isn't in key order.
+func (d *DB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count := 0
+	for _, request := range input.RequestItems {
+		count += len(request.Keys)
+	}
+	if count == 0 || count > 100 {
+		return nil, validationError("Too many items requested for the BatchGetItem call")
+	}
+
+	output := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	for table, request := range input.RequestItems {
+		seen := make(map[string]bool, len(request.Keys))
+		items := make([]map[string]*dynamodb.AttributeValue, 0, len(request.Keys))
+		for _, key := range request.Keys {
+			id, err := d.id(key)
+			if err != nil {
+				return nil, err
+			}
+			if seen[id] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[id] = true
+
+			item := d.items[id]
+			if item == nil {
+				continue
+			}
+			projected, err := project(item, request.ProjectionExpression, request.ExpressionAttributeNames)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, projected)
+		}
+		output.Responses[table] = items
+	}
+
+	return output, nil
+}
+
+// PutItem writes the item, replacing any existing item with the same key
+func (d *DB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	write, err := d.preparePut(input.Item, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old := write.apply(d)
+
+	output := &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, nil
+}
+
+// UpdateItem applies the update expression to the item, creating it if it doesn't exist
+func (d *DB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	write, err := d.prepareUpdate(input.Key, input.UpdateExpression, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old := write.apply(d)
+
+	output := &dynamodb.UpdateItemOutput{}
+	switch aws.StringValue(input.ReturnValues) {
+	case dynamodb.ReturnValueAllOld:
+		output.Attributes = old
+	case dynamodb.ReturnValueAllNew:
+		output.Attributes = copyItem(write.item)
+	}
+	return output, nil
+}
+
+// DeleteItem removes the item with the passed key
+func (d *DB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	write, err := d.prepareDelete(input.Key, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	old := write.apply(d)
+
+	output := &dynamodb.DeleteItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, nil
+}
+
+// TransactWriteItems applies every write or none of them
+func (d *DB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > 25 {
+		return nil, validationError("Member must have length less than or equal to 25 and greater than or equal to 1")
+	}
+
+	writes := make([]*write, 0, len(input.TransactItems))
+	reasons := make([]string, len(input.TransactItems))
+	seen := make(map[string]bool)
+	failed := false
+
+	for i, t := range input.TransactItems {
+		var w *write
+		var err error
+
+		switch {
+		case t.Put != nil:
+			w, err = d.preparePut(t.Put.Item, t.Put.ConditionExpression, t.Put.ExpressionAttributeNames, t.Put.ExpressionAttributeValues)
+		case t.Update != nil:
+			w, err = d.prepareUpdate(t.Update.Key, t.Update.UpdateExpression, t.Update.ConditionExpression, t.Update.ExpressionAttributeNames, t.Update.ExpressionAttributeValues)
+		case t.Delete != nil:
+			w, err = d.prepareDelete(t.Delete.Key, t.Delete.ConditionExpression, t.Delete.ExpressionAttributeNames, t.Delete.ExpressionAttributeValues)
+		case t.ConditionCheck != nil:
+			w, err = d.prepareCheck(t.ConditionCheck.Key, t.ConditionCheck.ConditionExpression, t.ConditionCheck.ExpressionAttributeNames, t.ConditionCheck.ExpressionAttributeValues)
+		default:
+			return nil, validationError("TransactItems can only contain one of Check, Put, Update or Delete")
+		}
+
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			reasons[i] = "ConditionalCheckFailed"
+			failed = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if seen[w.id] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[w.id] = true
+
+		reasons[i] = "None"
+		writes = append(writes, w)
+	}
+
+	if failed {
+		return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException, "Transaction cancelled, please refer cancellation reasons for specific reasons ["+strings.Join(reasons, ", ")+"]", nil)
+	}
+
+	for _, w := range writes {
+		w.apply(d)
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// Query returns the items matching the key condition on the table or one of its indexes
+func (d *DB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index, err := d.index(aws.StringValue(input.IndexName))
+	if err != nil {
+		return nil, err
+	}
+
+	if input.KeyConditionExpression == nil {
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request")
+	}
+	keyCondition, err := parseCondition(*input.KeyConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter condition
+	if input.FilterExpression != nil {
+		if filter, err = parseCondition(*input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]map[string]*dynamodb.AttributeValue, 0)
+	for _, item := range d.items {
+		if item[index.HashKey] == nil || (index.RangeKey != "" && item[index.RangeKey] == nil) {
+			continue
+		}
+		if keyCondition(item) {
+			matches = append(matches, item)
+		}
+	}
+
+	d.sortForIndex(matches, index, aws.BoolValue(input.ScanIndexForward) || input.ScanIndexForward == nil)
+
+	if input.ExclusiveStartKey != nil {
+		if _, err := d.id(input.ExclusiveStartKey); err != nil {
+			return nil, err
+		}
+		// Like DynamoDB, the page starts after the key's position rather than
+		// the item, so it doesn't matter if the item has since changed or gone.
+		forward := aws.BoolValue(input.ScanIndexForward) || input.ScanIndexForward == nil
+		i := 0
+		for i < len(matches) && !d.after(matches[i], input.ExclusiveStartKey, index, forward) {
+			i++
+		}
+		matches = matches[i:]
+	}
+
+	output := &dynamodb.QueryOutput{Items: make([]map[string]*dynamodb.AttributeValue, 0)}
+
+	limit := int(aws.Int64Value(input.Limit))
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+		output.LastEvaluatedKey = d.keyFor(matches[limit-1], index)
+	}
+	output.ScannedCount = aws.Int64(int64(len(matches)))
+
+	for _, item := range matches {
+		if filter != nil && !filter(item) {
+			continue
+		}
+		if aws.StringValue(input.Select) == dynamodb.SelectCount {
+			continue
+		}
+		if index.KeysOnly {
+			item = d.keyFor(item, index)
+		}
+		projected, err := project(item, input.ProjectionExpression, input.ExpressionAttributeNames)
+		if err != nil {
+			return nil, err
+		}
+		output.Items = append(output.Items, projected)
+	}
+	output.Count = aws.Int64(int64(len(output.Items)))
+
+	return output, nil
+}
+
+// QueryPages calls fn with each page of query results until fn returns false or the last page is reached
+func (d *DB) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	return d.QueryPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// QueryPagesWithContext is QueryPages that stops when the context is done
+func (d *DB) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, _ ...request.Option) error {
+	page := *input
+	for {
+		output, err := d.QueryWithContext(ctx, &page)
+		if err != nil {
+			return err
+		}
+		last := output.LastEvaluatedKey == nil
+		if !fn(output, last) || last {
+			return nil
+		}
+		page.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// Scan returns the table's items in key order
+func (d *DB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if input.IndexName != nil {
+		return nil, validationError("Scanning an index isn't supported")
+	}
+
+	var filter condition
+	if input.FilterExpression != nil {
+		var err error
+		if filter, err = parseCondition(*input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]string, 0, len(d.items))
+	for id := range d.items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if input.ExclusiveStartKey != nil {
+		start, err := d.id(input.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		ids = ids[sort.SearchStrings(ids, start+"\x00"):]
+	}
+
+	output := &dynamodb.ScanOutput{Items: make([]map[string]*dynamodb.AttributeValue, 0)}
+
+	limit := int(aws.Int64Value(input.Limit))
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		output.LastEvaluatedKey = d.keyFor(d.items[ids[limit-1]], Index{})
+	}
+	output.ScannedCount = aws.Int64(int64(len(ids)))
+
+	for _, id := range ids {
+		item := d.items[id]
+		if filter != nil && !filter(item) {
+			continue
+		}
+		projected, err := project(item, input.ProjectionExpression, input.ExpressionAttributeNames)
+		if err != nil {
+			return nil, err
+		}
+		output.Items = append(output.Items, projected)
+	}
+	output.Count = aws.Int64(int64(len(output.Items)))
+
+	return output, nil
+}
+
+// ScanPages calls fn with each page of scan results until fn returns false or the last page is reached
+func (d *DB) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	return d.ScanPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// ScanPagesWithContext is ScanPages that stops when the context is done
+func (d *DB) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	page := *input
+	for {
+		output, err := d.ScanWithContext(ctx, &page)
+		if err != nil {
+			return err
+		}
+		last := output.LastEvaluatedKey == nil
+		if !fn(output, last) || last {
+			return nil
+		}
+		page.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// write is a validated change waiting to be applied
+type write struct {
+	id     string
+	item   map[string]*dynamodb.AttributeValue
+	delete bool
+	check  bool
+}
+
+func (w *write) apply(d *DB) map[string]*dynamodb.AttributeValue {
+	old := d.items[w.id]
+	switch {
+	case w.check:
+		return old
+	case w.delete:
+		delete(d.items, w.id)
+	default:
+		d.items[w.id] = copyItem(w.item)
+	}
+	return copyItem(old)
+}
+
+func (d *DB) checkCondition(id string, expr *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) error {
+	if expr == nil {
+		return nil
+	}
+	cond, err := parseCondition(*expr, names, values)
+	if err != nil {
+		return err
+	}
+
+	existing := d.items[id]
+	if existing == nil {
+		existing = map[string]*dynamodb.AttributeValue{}
+	}
+	if !cond(existing) {
+		return conditionalCheckFailed()
+	}
+	return nil
+}
+
+func (d *DB) preparePut(item map[string]*dynamodb.AttributeValue, cond *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*write, error) {
+	id, err := d.id(item)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.validateIndexKeys(item); err != nil {
+		return nil, err
+	}
+	if err := d.checkCondition(id, cond, names, values); err != nil {
+		return nil, err
+	}
+	return &write{id: id, item: copyItem(item)}, nil
+}
+
+func (d *DB) prepareUpdate(key map[string]*dynamodb.AttributeValue, expr, cond *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*write, error) {
+	id, err := d.id(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCondition(id, cond, names, values); err != nil {
+		return nil, err
+	}
+
+	item := copyItem(d.items[id])
+	if item == nil {
+		item = d.keyFor(key, Index{})
+	}
+
+	if expr != nil {
+		apply, targets, err := parseUpdate(*expr, names, values)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			if name := target[0].name; name == d.schema.HashKey || name == d.schema.RangeKey {
+				return nil, validationError("Cannot update attribute %s. This attribute is part of the key", name)
+			}
+		}
+		if err := apply(item); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.validateIndexKeys(item); err != nil {
+		return nil, err
+	}
+
+	return &write{id: id, item: item}, nil
+}
+
+func (d *DB) prepareDelete(key map[string]*dynamodb.AttributeValue, cond *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*write, error) {
+	id, err := d.id(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCondition(id, cond, names, values); err != nil {
+		return nil, err
+	}
+	return &write{id: id, delete: true}, nil
+}
+
+func (d *DB) prepareCheck(key map[string]*dynamodb.AttributeValue, cond *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*write, error) {
+	if cond == nil {
+		return nil, validationError("ConditionCheck requires a ConditionExpression")
+	}
+	id, err := d.id(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCondition(id, cond, names, values); err != nil {
+		return nil, err
+	}
+	return &write{id: id, check: true}, nil
+}
+
+// id returns the storage key for an item, validating that both of the table's keys are present strings
+func (d *DB) id(item map[string]*dynamodb.AttributeValue) (string, error) {
+	hash, ok := item[d.schema.HashKey]
+	if !ok || hash == nil || hash.S == nil || *hash.S == "" {
+		return "", validationError("One or more parameter values were invalid: Missing the key %s in the item", d.schema.HashKey)
+	}
+	if d.schema.RangeKey == "" {
+		return *hash.S, nil
+	}
+	rng, ok := item[d.schema.RangeKey]
+	if !ok || rng == nil || rng.S == nil || *rng.S == "" {
+		return "", validationError("One or more parameter values were invalid: Missing the key %s in the item", d.schema.RangeKey)
+	}
+	return *hash.S + "\x00" + *rng.S, nil
+}
+
+func (d *DB) validateIndexKeys(item map[string]*dynamodb.AttributeValue) error {
+	for _, index := range d.schema.Indexes {
+		for _, name := range []string{index.HashKey, index.RangeKey} {
+			if value, ok := item[name]; ok && name != "" && (value == nil || value.S == nil || *value.S == "") {
+				return validationError("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: S IndexName: %s", name, index.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DB) index(name string) (Index, error) {
+	if name == "" {
+		return Index{HashKey: d.schema.HashKey, RangeKey: d.schema.RangeKey}, nil
+	}
+	for _, index := range d.schema.Indexes {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return Index{}, validationError("The table does not have the specified index: %s", name)
+}
+
+// keyFor returns the table and index keys of the item
+func (d *DB) keyFor(item map[string]*dynamodb.AttributeValue, index Index) map[string]*dynamodb.AttributeValue {
+	key := make(map[string]*dynamodb.AttributeValue)
+	for _, name := range []string{d.schema.HashKey, d.schema.RangeKey, index.HashKey, index.RangeKey} {
+		if value, ok := item[name]; ok && name != "" {
+			key[name] = copyValue(value)
+		}
+	}
+	return key
+}
+
+// sortForIndex orders items by the index's range key. Ties, which only happen on
+// secondary indexes, are broken by the table key so pagination is stable.
+func (d *DB) sortForIndex(items []map[string]*dynamodb.AttributeValue, index Index, forward bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		c := d.compareForIndex(items[i], items[j], index)
+		if forward {
+			return c < 0
+		}
+		return c > 0
+	})
+}
+
+// after returns true if the item comes after the key in the index's order
+func (d *DB) after(item, key map[string]*dynamodb.AttributeValue, index Index, forward bool) bool {
+	c := d.compareForIndex(item, key, index)
+	if forward {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (d *DB) compareForIndex(a, b map[string]*dynamodb.AttributeValue, index Index) int {
+	c := 0
+	if index.RangeKey != "" {
+		c, _ = compare(a[index.RangeKey], b[index.RangeKey])
+	}
+	if c == 0 {
+		idA, _ := d.id(a)
+		idB, _ := d.id(b)
+		if idA < idB {
+			c = -1
+		} else if idA > idB {
+			c = 1
+		}
+	}
+	return c
+}
+
+func project(item map[string]*dynamodb.AttributeValue, expr *string, names map[string]*string) (map[string]*dynamodb.AttributeValue, error) {
+	if expr == nil {
+		return copyItem(item), nil
+	}
+
+	attributes, err := parseProjection(*expr, names)
+	if err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]*dynamodb.AttributeValue, len(attributes))
+	for _, name := range attributes {
+		if value, ok := item[name]; ok {
+			projected[name] = copyValue(value)
+		}
+	}
+	return projected, nil
+}
@@ -0,0 +1,323 @@
+package memdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDB(t *testing.T) *DB {
+	db := New(Schema{
+		HashKey:  "PK",
+		RangeKey: "SK",
+		Indexes: []Index{
+			{Name: "GSI1", HashKey: "GSI1PK", RangeKey: "GSI1SK"},
+			{Name: "GSI2", HashKey: "GSI2PK", RangeKey: "GSI2SK", KeysOnly: true},
+		},
+	})
+
+	for _, sk := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		_, err := db.PutItem(&dynamodb.PutItemInput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"PK":     {S: aws.String("p")},
+				"SK":     {S: aws.String(sk)},
+				"GSI2PK": {S: aws.String("index")},
+				"GSI2SK": {S: aws.String(sk)},
+				"Value":  {N: aws.String("1")},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	return db
+}
+
+func errCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func TestQuery(t *testing.T) {
+	db := testDB(t)
+
+	query := func(input *dynamodb.QueryInput) []string {
+		output, err := db.Query(input)
+		require.NoError(t, err)
+		keys := make([]string, len(output.Items))
+		for i, item := range output.Items {
+			keys[i] = aws.StringValue(item["SK"].S)
+		}
+		return keys
+	}
+
+	t.Run("begins_with on the sort key", func(t *testing.T) {
+		assert.Equal(t, []string{"a/1", "a/2", "a/3"}, query(&dynamodb.QueryInput{
+			KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :sk)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("p")}, ":sk": {S: aws.String("a/")}},
+		}))
+	})
+
+	t.Run("between in reverse with a limit", func(t *testing.T) {
+		output, err := db.Query(&dynamodb.QueryInput{
+			KeyConditionExpression:   aws.String("#pk = :pk AND #sk BETWEEN :from AND :to"),
+			ExpressionAttributeNames: map[string]*string{"#pk": aws.String("PK"), "#sk": aws.String("SK")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":pk":   {S: aws.String("p")},
+				":from": {S: aws.String("a/2")},
+				":to":   {S: aws.String("b/1")},
+			},
+			ScanIndexForward: aws.Bool(false),
+			Limit:            aws.Int64(2),
+		})
+		require.NoError(t, err)
+		require.Len(t, output.Items, 2)
+		assert.Equal(t, "b/1", aws.StringValue(output.Items[0]["SK"].S))
+		assert.Equal(t, "a/3", aws.StringValue(output.Items[1]["SK"].S))
+		assert.NotNil(t, output.LastEvaluatedKey)
+	})
+
+	t.Run("pages through every item", func(t *testing.T) {
+		keys := make([]string, 0)
+		pages := 0
+		err := db.QueryPages(&dynamodb.QueryInput{
+			KeyConditionExpression:    aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("p")}},
+			Limit:                     aws.Int64(3),
+		}, func(page *dynamodb.QueryOutput, last bool) bool {
+			pages++
+			for _, item := range page.Items {
+				keys = append(keys, aws.StringValue(item["SK"].S))
+			}
+			return true
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a/1", "a/2", "a/3", "b/1"}, keys)
+		assert.Equal(t, 2, pages)
+	})
+
+	t.Run("pages start after the key even once its item is gone", func(t *testing.T) {
+		db := testDB(t)
+		_, err := db.DeleteItem(&dynamodb.DeleteItemInput{Key: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/2")}}})
+		require.NoError(t, err)
+
+		output, err := db.Query(&dynamodb.QueryInput{
+			KeyConditionExpression:    aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("p")}},
+			ExclusiveStartKey:         map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/2")}},
+		})
+		require.NoError(t, err)
+		require.Len(t, output.Items, 2)
+		assert.Equal(t, "a/3", aws.StringValue(output.Items[0]["SK"].S))
+	})
+
+	t.Run("keys only indexes project keys", func(t *testing.T) {
+		output, err := db.Query(&dynamodb.QueryInput{
+			IndexName:                 aws.String("GSI2"),
+			KeyConditionExpression:    aws.String("GSI2PK = :pk AND GSI2SK = :sk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("index")}, ":sk": {S: aws.String("b/1")}},
+		})
+		require.NoError(t, err)
+		require.Len(t, output.Items, 1)
+		assert.Len(t, output.Items[0], 4)
+		assert.Nil(t, output.Items[0]["Value"])
+	})
+
+	t.Run("items missing an index key aren't in the index", func(t *testing.T) {
+		output, err := db.Query(&dynamodb.QueryInput{
+			IndexName:                 aws.String("GSI1"),
+			KeyConditionExpression:    aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("index")}},
+		})
+		require.NoError(t, err)
+		assert.Empty(t, output.Items)
+	})
+
+	t.Run("unknown indexes are rejected", func(t *testing.T) {
+		_, err := db.Query(&dynamodb.QueryInput{
+			IndexName:                 aws.String("GSI9"),
+			KeyConditionExpression:    aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("p")}},
+		})
+		assert.Equal(t, errCodeValidation, errCode(err))
+	})
+}
+
+func TestBatchGetItem(t *testing.T) {
+	db := testDB(t)
+	key := func(sk string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String(sk)}}
+	}
+
+	t.Run("returns the items that exist", func(t *testing.T) {
+		output, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{"table": {Keys: []map[string]*dynamodb.AttributeValue{key("a/1"), key("c/1"), key("b/1")}}},
+		})
+		require.NoError(t, err)
+		require.Len(t, output.Responses["table"], 2)
+		assert.Equal(t, "1", aws.StringValue(output.Responses["table"][0]["Value"].N))
+	})
+
+	t.Run("duplicate keys are rejected", func(t *testing.T) {
+		_, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{"table": {Keys: []map[string]*dynamodb.AttributeValue{key("a/1"), key("a/1")}}},
+		})
+		assert.Equal(t, errCodeValidation, errCode(err))
+	})
+}
+
+func TestScan(t *testing.T) {
+	db := testDB(t)
+
+	keys := make([]string, 0)
+	pages := 0
+	err := db.ScanPages(&dynamodb.ScanInput{
+		FilterExpression:          aws.String("begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":prefix": {S: aws.String("a/")}},
+		Limit:                     aws.Int64(2),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		pages++
+		for _, item := range page.Items {
+			keys = append(keys, aws.StringValue(item["SK"].S))
+		}
+		return true
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a/1", "a/2", "a/3"}, keys)
+	assert.Equal(t, 2, pages)
+}
+
+func TestConditionalWrites(t *testing.T) {
+	db := testDB(t)
+	key := map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/1")}}
+
+	t.Run("attribute_not_exists fails for an existing item", func(t *testing.T) {
+		_, err := db.PutItem(&dynamodb.PutItemInput{
+			Item:                key,
+			ConditionExpression: aws.String("attribute_not_exists(PK)"),
+		})
+		assert.Equal(t, dynamodb.ErrCodeConditionalCheckFailedException, errCode(err))
+	})
+
+	t.Run("updates apply SET, ADD and REMOVE", func(t *testing.T) {
+		output, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			Key:                 key,
+			ConditionExpression: aws.String("attribute_exists(PK) AND #v < :max"),
+			UpdateExpression:    aws.String("SET #n = :name, #v = #v + :one REMOVE GSI2PK, GSI2SK ADD Tags :tags"),
+			ExpressionAttributeNames: map[string]*string{
+				"#n": aws.String("Name"),
+				"#v": aws.String("Value"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":name": {S: aws.String("first")},
+				":one":  {N: aws.String("1")},
+				":max":  {N: aws.String("10")},
+				":tags": {SS: aws.StringSlice([]string{"x"})},
+			},
+			ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "first", aws.StringValue(output.Attributes["Name"].S))
+		assert.Equal(t, "2", aws.StringValue(output.Attributes["Value"].N))
+		assert.Equal(t, []string{"x"}, aws.StringValueSlice(output.Attributes["Tags"].SS))
+		assert.Nil(t, output.Attributes["GSI2PK"])
+	})
+
+	t.Run("key attributes can't be updated", func(t *testing.T) {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			Key:                       key,
+			UpdateExpression:          aws.String("SET SK = :sk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":sk": {S: aws.String("z")}},
+		})
+		assert.Equal(t, errCodeValidation, errCode(err))
+	})
+
+	t.Run("stored items don't alias the input", func(t *testing.T) {
+		item := map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("q")}, "SK": {S: aws.String("1")}, "Name": {S: aws.String("before")}}
+		_, err := db.PutItem(&dynamodb.PutItemInput{Item: item})
+		require.NoError(t, err)
+
+		item["Name"].S = aws.String("after")
+
+		output, err := db.GetItem(&dynamodb.GetItemInput{Key: item})
+		require.NoError(t, err)
+		assert.Equal(t, "before", aws.StringValue(output.Item["Name"].S))
+	})
+}
+
+func TestTransactWriteItems(t *testing.T) {
+	db := testDB(t)
+	count := db.Len()
+
+	t.Run("a failed condition cancels every write", func(t *testing.T) {
+		_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{Put: &dynamodb.Put{Item: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("c/1")}}}},
+				{Delete: &dynamodb.Delete{Key: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/2")}}}},
+				{ConditionCheck: &dynamodb.ConditionCheck{
+					Key:                 map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("missing")}},
+					ConditionExpression: aws.String("attribute_exists(PK)"),
+				}},
+			},
+		})
+		assert.Equal(t, dynamodb.ErrCodeTransactionCanceledException, errCode(err))
+		assert.Contains(t, err.Error(), "[None, None, ConditionalCheckFailed]")
+		assert.Equal(t, count, db.Len())
+	})
+
+	t.Run("two operations on one item are rejected", func(t *testing.T) {
+		key := map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/1")}}
+		_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{Put: &dynamodb.Put{Item: key}},
+				{Delete: &dynamodb.Delete{Key: key}},
+			},
+		})
+		assert.Equal(t, errCodeValidation, errCode(err))
+	})
+
+	t.Run("applies every write", func(t *testing.T) {
+		_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{Put: &dynamodb.Put{Item: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("c/1")}}}},
+				{Delete: &dynamodb.Delete{Key: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/2")}}}},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, count, db.Len())
+
+		output, err := db.GetItem(&dynamodb.GetItemInput{Key: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/2")}}})
+		require.NoError(t, err)
+		assert.Empty(t, output.Item)
+	})
+}
+
+func TestContext(t *testing.T) {
+	db := testDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{Key: map[string]*dynamodb.AttributeValue{"PK": {S: aws.String("p")}, "SK": {S: aws.String("a/1")}}})
+	assert.Equal(t, request.CanceledErrorCode, errCode(err))
+
+	pages := 0
+	err = db.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		KeyConditionExpression:    aws.String("PK = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("p")}},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		pages++
+		return true
+	})
+	assert.Equal(t, request.CanceledErrorCode, errCode(err))
+	assert.Zero(t, pages)
+}
@@ -0,0 +1,251 @@
+package memdb
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// update is a parsed update expression. It mutates the item in place.
+type update func(item map[string]*dynamodb.AttributeValue) error
+
+func parseUpdate(expr string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (update, []path, error) {
+	p, err := newParser(expr, names, values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actions := make([]update, 0)
+	targets := make([]path, 0)
+
+	for p.peek().kind != tokenEOF {
+		clause := strings.ToUpper(p.next().text)
+
+		for {
+			target, err := p.parsePath()
+			if err != nil {
+				return nil, nil, err
+			}
+			targets = append(targets, target)
+
+			var action update
+			switch clause {
+			case "SET":
+				action, err = p.parseSetAction(target)
+			case "REMOVE":
+				action = func(item map[string]*dynamodb.AttributeValue) error {
+					return assign(item, target, nil)
+				}
+			case "ADD", "DELETE":
+				action, err = p.parseAddAction(clause, target)
+			default:
+				return nil, nil, validationError("Invalid UpdateExpression: unknown clause %q", clause)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			actions = append(actions, action)
+
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+
+	if len(actions) == 0 {
+		return nil, nil, validationError("Invalid UpdateExpression: the expression can not be empty")
+	}
+
+	return func(item map[string]*dynamodb.AttributeValue) error {
+		for _, action := range actions {
+			if err := action(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, targets, nil
+}
+
+func (p *parser) parseSetAction(target path) (update, error) {
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	left, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	value := left
+	if t := p.peek(); t.kind == tokenPunct && (t.text == "+" || t.text == "-") {
+		p.next()
+		right, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		value = func(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+			x, okX := number(left(item))
+			y, okY := number(right(item))
+			if !okX || !okY {
+				return nil
+			}
+			if t.text == "+" {
+				return &dynamodb.AttributeValue{N: aws.String(new(big.Float).Add(x, y).Text('f', -1))}
+			}
+			return &dynamodb.AttributeValue{N: aws.String(new(big.Float).Sub(x, y).Text('f', -1))}
+		}
+	}
+
+	return func(item map[string]*dynamodb.AttributeValue) error {
+		v := value(item)
+		if v == nil {
+			return validationError("The provided expression refers to an attribute that does not exist in the item")
+		}
+		return assign(item, target, copyValue(v))
+	}, nil
+}
+
+func (p *parser) parseSetOperand() (operand, error) {
+	t := p.peek()
+	if t.kind != tokenIdent || p.tokens[p.pos+1].text != "(" {
+		return p.parseOperand()
+	}
+
+	fn := strings.ToLower(t.text)
+	p.pos += 2
+
+	first, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	second, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	switch fn {
+	case "if_not_exists":
+		return func(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+			if v := first(item); v != nil {
+				return v
+			}
+			return second(item)
+		}, nil
+	case "list_append":
+		return func(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+			a, b := first(item), second(item)
+			if a == nil || b == nil || a.L == nil || b.L == nil {
+				return nil
+			}
+			return &dynamodb.AttributeValue{L: append(append([]*dynamodb.AttributeValue{}, a.L...), b.L...)}
+		}, nil
+	default:
+		return nil, validationError("Invalid function name; function: %s", fn)
+	}
+}
+
+func (p *parser) parseAddAction(clause string, target path) (update, error) {
+	t := p.next()
+	if t.kind != tokenValue {
+		return nil, validationError("Invalid UpdateExpression: %s requires a value", clause)
+	}
+	value, err := p.value(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(item map[string]*dynamodb.AttributeValue) error {
+		current := resolve(item, target)
+
+		if value.N != nil && clause == "ADD" {
+			sum, _ := number(value)
+			if current != nil {
+				existing, ok := number(current)
+				if !ok {
+					return validationError("An operand in the update expression has an incorrect data type")
+				}
+				sum = new(big.Float).Add(existing, sum)
+			}
+			return assign(item, target, &dynamodb.AttributeValue{N: aws.String(sum.Text('f', -1))})
+		}
+
+		if value.SS == nil && value.NS == nil {
+			return validationError("An operand in the update expression has an incorrect data type")
+		}
+
+		set := &dynamodb.AttributeValue{}
+		if current != nil {
+			set = copyValue(current)
+		}
+		for _, member := range value.SS {
+			set.SS = updateSet(set.SS, member, clause == "ADD")
+		}
+		for _, member := range value.NS {
+			set.NS = updateSet(set.NS, member, clause == "ADD")
+		}
+		if len(set.SS) == 0 && len(set.NS) == 0 {
+			return assign(item, target, nil)
+		}
+		return assign(item, target, set)
+	}, nil
+}
+
+func updateSet(set []*string, member *string, add bool) []*string {
+	for i, existing := range set {
+		if aws.StringValue(existing) == aws.StringValue(member) {
+			if add {
+				return set
+			}
+			return append(set[:i:i], set[i+1:]...)
+		}
+	}
+	if add {
+		return append(set, aws.String(aws.StringValue(member)))
+	}
+	return set
+}
+
+// assign sets or, when value is nil, removes the attribute at the path
+func assign(item map[string]*dynamodb.AttributeValue, target path, value *dynamodb.AttributeValue) error {
+	parent := &dynamodb.AttributeValue{M: item}
+	if len(target) > 1 {
+		parent = resolve(item, target[:len(target)-1])
+		if parent == nil {
+			return validationError("The document path provided in the update expression is invalid for update")
+		}
+	}
+
+	last := target[len(target)-1]
+	if last.index >= 0 {
+		if parent.L == nil {
+			return validationError("The document path provided in the update expression is invalid for update")
+		}
+		switch {
+		case value == nil && last.index < len(parent.L):
+			parent.L = append(parent.L[:last.index:last.index], parent.L[last.index+1:]...)
+		case value != nil && last.index < len(parent.L):
+			parent.L[last.index] = value
+		case value != nil:
+			parent.L = append(parent.L, value)
+		}
+		return nil
+	}
+
+	if parent.M == nil {
+		return validationError("The document path provided in the update expression is invalid for update")
+	}
+	if value == nil {
+		delete(parent.M, last.name)
+	} else {
+		parent.M[last.name] = value
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const errCodeValidation = "ValidationException"
+
+func validationError(format string, args ...interface{}) error {
+	return awserr.New(errCodeValidation, fmt.Sprintf(format, args...), nil)
+}
+
+func conditionalCheckFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func number(a *dynamodb.AttributeValue) (*big.Float, bool) {
+	if a == nil || a.N == nil {
+		return nil, false
+	}
+	f, ok := new(big.Float).SetString(*a.N)
+	return f, ok
+}
+
+func numberValue(f float64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(big.NewFloat(f).Text('f', -1))}
+}
+
+func typeOf(a *dynamodb.AttributeValue) string {
+	switch {
+	case a.S != nil:
+		return "S"
+	case a.N != nil:
+		return "N"
+	case a.B != nil:
+		return "B"
+	case a.BOOL != nil:
+		return "BOOL"
+	case a.NULL != nil:
+		return "NULL"
+	case a.SS != nil:
+		return "SS"
+	case a.NS != nil:
+		return "NS"
+	case a.BS != nil:
+		return "BS"
+	case a.L != nil:
+		return "L"
+	case a.M != nil:
+		return "M"
+	default:
+		return ""
+	}
+}
+
+// compare orders two scalar values of the same type. ok is false when they can't be ordered.
+func compare(a, b *dynamodb.AttributeValue) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	switch {
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	case a.N != nil && b.N != nil:
+		x, okX := number(a)
+		y, okY := number(b)
+		if !okX || !okY {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B), true
+	default:
+		return 0, false
+	}
+}
+
+func equal(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	return typeOf(a) == typeOf(b) && reflect.DeepEqual(a, b)
+}
+
+func size(a *dynamodb.AttributeValue) (int, bool) {
+	if a == nil {
+		return 0, false
+	}
+	switch {
+	case a.S != nil:
+		return len([]rune(*a.S)), true
+	case a.B != nil:
+		return len(a.B), true
+	case a.SS != nil:
+		return len(a.SS), true
+	case a.NS != nil:
+		return len(a.NS), true
+	case a.BS != nil:
+		return len(a.BS), true
+	case a.L != nil:
+		return len(a.L), true
+	case a.M != nil:
+		return len(a.M), true
+	default:
+		return 0, false
+	}
+}
+
+func contains(a, v *dynamodb.AttributeValue) bool {
+	if a == nil || v == nil {
+		return false
+	}
+	switch {
+	case a.S != nil && v.S != nil:
+		return strings.Contains(*a.S, *v.S)
+	case a.SS != nil && v.S != nil:
+		for _, s := range a.SS {
+			if aws.StringValue(s) == *v.S {
+				return true
+			}
+		}
+	case a.NS != nil && v.N != nil:
+		for _, n := range a.NS {
+			if equal(&dynamodb.AttributeValue{N: n}, v) {
+				return true
+			}
+		}
+	case a.L != nil:
+		for _, e := range a.L {
+			if equal(e, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func copyValue(a *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if a == nil {
+		return nil
+	}
+
+	c := &dynamodb.AttributeValue{}
+	if a.S != nil {
+		c.S = aws.String(*a.S)
+	}
+	if a.N != nil {
+		c.N = aws.String(*a.N)
+	}
+	if a.B != nil {
+		c.B = append([]byte{}, a.B...)
+	}
+	if a.BOOL != nil {
+		c.BOOL = aws.Bool(*a.BOOL)
+	}
+	if a.NULL != nil {
+		c.NULL = aws.Bool(*a.NULL)
+	}
+	if a.SS != nil {
+		c.SS = aws.StringSlice(aws.StringValueSlice(a.SS))
+	}
+	if a.NS != nil {
+		c.NS = aws.StringSlice(aws.StringValueSlice(a.NS))
+	}
+	if a.BS != nil {
+		c.BS = make([][]byte, len(a.BS))
+		for i, b := range a.BS {
+			c.BS[i] = append([]byte{}, b...)
+		}
+	}
+	if a.L != nil {
+		c.L = make([]*dynamodb.AttributeValue, len(a.L))
+		for i, e := range a.L {
+			c.L[i] = copyValue(e)
+		}
+	}
+	if a.M != nil {
+		c.M = copyItem(a.M)
+	}
+	return c
+}
+
+func copyItem(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	c := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		c[name] = copyValue(value)
+	}
+	return c
+}
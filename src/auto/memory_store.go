@@ -0,0 +1,33 @@
+package auto
+
+import (
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/automatic-reminders/auto/memdb"
+)
+
+// memoryTableSchema mirrors CreateTableInput
+func memoryTableSchema() memdb.Schema {
+	schema := memdb.Schema{HashKey: keys.HashKeyAttribute, RangeKey: keys.RangeKeyAttribute}
+	for _, index := range keys.Indexes {
+		schema.Indexes = append(schema.Indexes, memdb.Index{
+			Name:     index.Name,
+			HashKey:  index.HashAttribute,
+			RangeKey: index.RangeAttribute,
+			KeysOnly: index.KeysOnly,
+		})
+	}
+	return schema
+}
+
+// NewMemoryStore returns a store backed by an empty in-memory table.
+//
+// It behaves like the DynamoDB table, so tests can run without DynamoDB Local.
+// Sensitive attributes are encrypted with the development key, and cursors and
+// contact keys are signed with the fake signing secret.
+func NewMemoryStore() *DynamoStore {
+	store := NewDynamoStore(memdb.New(memoryTableSchema()), "memory")
+	store.SetKeyProvider(DevelopmentKeys())
+	store.SetCursorSecret(fakeSecrets.Signing)
+	store.SetContactSecret(fakeSecrets.ContactKeying)
+	return store
+}
@@ -0,0 +1,363 @@
+// Package migrate rewrites existing items in the table as record shapes change.
+//
+// A migration scans the whole table a page at a time and passes every item to
+// its Rewrite function. The runner records each migration in the table: a
+// checkpoint is written after every page, so an interrupted run picks up where
+// it stopped, and a completed migration is never run again.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+const defaultPageSize = 100
+
+// Item is a table item as returned by DynamoDB
+type Item = map[string]*dynamodb.AttributeValue
+
+// Migration is a change applied to every existing item in the table
+type Migration struct {
+	// ID is unique and sorts in the order migrations are applied, e.g. "0001-token-index-keys"
+	ID          string
+	Description string
+
+	// Rewrite returns the new item, or nil to leave the item as it is. It can
+	// modify and return the passed item. Returning an item with a different
+	// primary key moves the item. Encrypted values are bound to the item's key,
+	// so an item with encrypted attributes has to be re-encrypted when it moves.
+	//
+	// Rewrite must be idempotent: a resumed run can see a page twice, and a moved
+	// item can be scanned again at its new key. The context is the run's, and
+	// it's done when the run is interrupted.
+	Rewrite func(context.Context, Item) (Item, error)
+}
+
+// Record is the state of a migration, stored in the table
+type Record struct {
+	ID          string
+	Description string
+	StartedAt   time.Time
+	CompletedAt time.Time
+	// Checkpoint is the last key scanned by an incomplete run
+	Checkpoint Item
+	Scanned    int64
+	Rewritten  int64
+}
+
+// Completed returns true if the migration has been applied to the whole table
+func (r *Record) Completed() bool {
+	return !r.CompletedAt.IsZero()
+}
+
+// Report is the result of running a migration
+type Report struct {
+	ID string
+	// Skipped is true when the migration had already been applied
+	Skipped bool
+	// Resumed is true when the run started from a checkpoint
+	Resumed   bool
+	Scanned   int64
+	Rewritten int64
+}
+
+// Runner applies migrations to a table
+type Runner struct {
+	DB    dynamodbiface.DynamoDBAPI
+	Table string
+
+	// DryRun scans and rewrites items without writing anything, including checkpoints
+	DryRun bool
+
+	// PageSize is the number of items scanned between checkpoints
+	PageSize int64
+
+	// Logf is called with progress messages. It's optional.
+	Logf func(format string, args ...interface{})
+}
+
+// Status returns the stored record for each migration. Migrations that haven't started have an empty record.
+func (r *Runner) Status(ctx context.Context, migrations []Migration) ([]*Record, error) {
+	records := make([]*Record, len(migrations))
+	for i, m := range migrations {
+		record, err := r.findRecord(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+// Run applies each migration in order, skipping the ones that have completed. It stops at the first error,
+// or when the context is done; either way the next run resumes from the last checkpoint.
+func (r *Runner) Run(ctx context.Context, migrations []Migration) ([]*Report, error) {
+	if err := validate(migrations); err != nil {
+		return nil, err
+	}
+
+	reports := make([]*Report, 0, len(migrations))
+	for _, m := range migrations {
+		report, err := r.run(ctx, m)
+		if report != nil {
+			reports = append(reports, report)
+		}
+		if err != nil {
+			return reports, fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+	}
+
+	return reports, nil
+}
+
+func validate(migrations []Migration) error {
+	seen := make(map[string]bool, len(migrations))
+	for _, m := range migrations {
+		if _, err := keys.MigrationSortKey.Build(m.ID); err != nil {
+			return fmt.Errorf("invalid migration ID %q", m.ID)
+		}
+		if m.Rewrite == nil {
+			return fmt.Errorf("migration %s has no Rewrite function", m.ID)
+		}
+		if seen[m.ID] {
+			return fmt.Errorf("migration %s is listed twice", m.ID)
+		}
+		seen[m.ID] = true
+	}
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, m Migration) (*Report, error) {
+	record, err := r.findRecord(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{ID: m.ID}
+	if record.Completed() {
+		report.Skipped = true
+		r.logf("%s: already applied at %s", m.ID, record.CompletedAt.Format(time.RFC3339))
+		return report, nil
+	}
+
+	if record.StartedAt.IsZero() {
+		record.StartedAt = time.Now()
+	}
+	if record.Checkpoint != nil {
+		report.Resumed = true
+		r.logf("%s: resuming after %s", m.ID, describeKey(record.Checkpoint))
+	} else {
+		r.logf("%s: %s", m.ID, m.Description)
+	}
+
+	pageSize := r.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	for {
+		page, err := r.DB.ScanWithContext(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String(r.Table),
+			ExclusiveStartKey: record.Checkpoint,
+			Limit:             aws.Int64(pageSize),
+			ConsistentRead:    aws.Bool(true),
+		})
+		if err != nil {
+			return report, err
+		}
+
+		var scanned, rewritten int64
+		for _, item := range page.Items {
+			if isRecord(item) {
+				continue
+			}
+			scanned++
+
+			changed, err := r.rewrite(ctx, m, item)
+			if err != nil {
+				return report, fmt.Errorf("%s: %w", describeKey(item), err)
+			}
+			if changed {
+				rewritten++
+			}
+		}
+
+		report.Scanned += scanned
+		report.Rewritten += rewritten
+		record.Scanned += scanned
+		record.Rewritten += rewritten
+		record.Checkpoint = page.LastEvaluatedKey
+		if record.Checkpoint == nil {
+			record.CompletedAt = time.Now()
+		}
+
+		if err := r.saveRecord(ctx, record); err != nil {
+			return report, err
+		}
+		if record.Checkpoint == nil {
+			break
+		}
+	}
+
+	r.logf("%s: scanned %d, rewrote %d", m.ID, report.Scanned, report.Rewritten)
+
+	return report, nil
+}
+
+// rewrite writes the migrated item and returns true if the item changed
+func (r *Runner) rewrite(ctx context.Context, m Migration, item Item) (bool, error) {
+	from := primaryKey(item)
+
+	updated, err := m.Rewrite(ctx, item)
+	if err != nil || updated == nil {
+		return false, err
+	}
+
+	to := primaryKey(updated)
+	if to.HashKey == "" || to.SortKey == "" {
+		return false, keys.ErrIncompleteKey
+	}
+
+	if r.DryRun {
+		if to != from {
+			r.logf("%s: would move %s to %s", m.ID, describePrimary(from), describePrimary(to))
+		} else {
+			r.logf("%s: would rewrite %s", m.ID, describePrimary(from))
+		}
+		return true, nil
+	}
+
+	if to == from {
+		// The condition keeps an item deleted during the scan from being written back.
+		_, err := r.DB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(r.Table),
+			Item:                     updated,
+			ConditionExpression:      aws.String("attribute_exists(#pk)"),
+			ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+		})
+		if isConditionalCheckFailure(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	_, err = r.DB.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Put: &dynamodb.Put{
+				TableName:                aws.String(r.Table),
+				Item:                     updated,
+				ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+				ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+			}},
+			{Delete: &dynamodb.Delete{
+				TableName:                aws.String(r.Table),
+				Key:                      from.Dynamo(),
+				ConditionExpression:      aws.String("attribute_exists(#pk)"),
+				ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+			}},
+		},
+	})
+
+	return err == nil, err
+}
+
+func (r *Runner) findRecord(ctx context.Context, m Migration) (*Record, error) {
+	record := &Record{ID: m.ID, Description: m.Description}
+
+	key, err := keys.Migration(m.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.DB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(r.Table),
+		Key:            key.Dynamo(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return record, nil
+	}
+
+	record.StartedAt = auto.TimeFromDynamo(result.Item["StartedAt"])
+	record.CompletedAt = auto.TimeFromDynamo(result.Item["CompletedAt"])
+	record.Scanned = auto.IntFromDynamo(result.Item["Scanned"])
+	record.Rewritten = auto.IntFromDynamo(result.Item["Rewritten"])
+	if checkpoint := result.Item["Checkpoint"]; checkpoint != nil && len(checkpoint.M) > 0 {
+		record.Checkpoint = checkpoint.M
+	}
+
+	return record, nil
+}
+
+func (r *Runner) saveRecord(ctx context.Context, record *Record) error {
+	if r.DryRun {
+		return nil
+	}
+
+	key, err := keys.Migration(record.ID)
+	if err != nil {
+		return err
+	}
+
+	item := key.Dynamo()
+	item["Description"] = &dynamodb.AttributeValue{S: aws.String(record.Description)}
+	item["StartedAt"] = auto.DynamoTime(record.StartedAt)
+	item["Scanned"] = auto.DynamoInt(record.Scanned)
+	item["Rewritten"] = auto.DynamoInt(record.Rewritten)
+	if record.Completed() {
+		item["CompletedAt"] = auto.DynamoTime(record.CompletedAt)
+	}
+	if record.Checkpoint != nil {
+		item["Checkpoint"] = &dynamodb.AttributeValue{M: record.Checkpoint}
+	}
+
+	_, err = r.DB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.Table),
+		Item:      item,
+	})
+
+	return err
+}
+
+func (r *Runner) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
+
+// isRecord returns true for the runner's own records, which migrations never see
+func isRecord(item Item) bool {
+	return auto.StringFromDynamo(item[keys.HashKeyAttribute]) == keys.MigrationHashKey.MustBuild()
+}
+
+func primaryKey(item Item) keys.Primary {
+	return keys.Primary{
+		HashKey: auto.StringFromDynamo(item[keys.HashKeyAttribute]),
+		SortKey: auto.StringFromDynamo(item[keys.RangeKeyAttribute]),
+	}
+}
+
+func describePrimary(key keys.Primary) string {
+	return key.HashKey + " " + key.SortKey
+}
+
+func describeKey(item Item) string {
+	return describePrimary(primaryKey(item))
+}
+
+func isConditionalCheckFailure(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,269 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/automatic-reminders/auto/memdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyContactSortKey is test@email.test's key before contacts were keyed with an HMAC
+var legacyContactSortKey = fmt.Sprintf("contact/%x/_EMAIL", sha256.Sum256([]byte("test@email.test")))
+
+func testTable(t *testing.T) *memdb.DB {
+	db := memdb.New(memdb.Schema{
+		HashKey:  keys.HashKeyAttribute,
+		RangeKey: keys.RangeKeyAttribute,
+		Indexes: []memdb.Index{
+			{Name: keys.GSI1.Name, HashKey: keys.GSI1.HashAttribute, RangeKey: keys.GSI1.RangeAttribute},
+		},
+	})
+
+	items := []Item{
+		{
+			"PK":          {S: aws.String("acct_1")},
+			"SK":          {S: aws.String("_USER_ACCOUNT")},
+			"AutomaticID": {S: aws.String("U_1")},
+		},
+		{
+			"PK":          {S: aws.String("acct_1")},
+			"SK":          {S: aws.String("access-token/tok_1")},
+			"GSI1PK":      {S: aws.String("access_token/U_1")},
+			"GSI2SK":      {S: aws.String("token-for/acct_1")},
+			"AccessToken": {S: aws.String("secret")},
+		},
+		{
+			"PK": {S: aws.String("acct_1")},
+			"SK": {S: aws.String("reminder/rem_1")},
+		},
+		{
+			"PK":           {S: aws.String("acct_1")},
+			"SK":           {S: aws.String(legacyContactSortKey)},
+			"ContactType":  {S: aws.String("EMAIL")},
+			"ContactValue": {S: aws.String("test@email.test")},
+		},
+	}
+	for _, item := range items {
+		_, err := db.PutItem(&dynamodb.PutItemInput{Item: item})
+		require.NoError(t, err)
+	}
+
+	return db
+}
+
+// mustKey returns a key built from parts the test knows are valid
+func mustKey(key keys.Primary, err error) keys.Primary {
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func getItem(t *testing.T, db *memdb.DB, key keys.Primary) Item {
+	output, err := db.GetItem(&dynamodb.GetItemInput{Key: key.Dynamo()})
+	require.NoError(t, err)
+	return output.Item
+}
+
+func TestRunner(t *testing.T) {
+	os.Setenv("AUTO_TEST", "true")
+	config, err := auto.LoadConfig("")
+	require.NoError(t, err)
+	auto.SetDefaultConfig(config)
+
+	auto.SetDefaultKeyProvider(auto.DevelopmentKeys())
+	names := config.Secrets
+	auto.SetDefaultSecretProvider(auto.StaticSecrets{
+		names.ClientIDName:      "client-id",
+		names.ClientSecretName:  "client-secret",
+		names.SigningName:       "signing",
+		names.ContactKeyingName: "contact-keying",
+	})
+
+	t.Run("dry run doesn't write", func(t *testing.T) {
+		db := testTable(t)
+		runner := &Runner{DB: db, Table: "test", DryRun: true}
+
+		reports, err := runner.Run(context.Background(), All)
+		require.NoError(t, err)
+
+		require.Len(t, reports, 4)
+		assert.Equal(t, int64(4), reports[0].Scanned)
+		assert.Equal(t, int64(1), reports[0].Rewritten)
+		assert.Equal(t, int64(1), reports[1].Rewritten)
+		assert.Equal(t, int64(2), reports[2].Rewritten)
+		assert.Equal(t, int64(1), reports[3].Rewritten)
+
+		assert.Equal(t, 4, db.Len())
+		assert.Equal(t, "access_token/U_1", aws.StringValue(getItem(t, db, mustKey(keys.AccessToken("acct_1", "tok_1")))["GSI1PK"].S))
+	})
+
+	t.Run("applies every migration once", func(t *testing.T) {
+		db := testTable(t)
+		runner := &Runner{DB: db, Table: "test", PageSize: 2}
+
+		reports, err := runner.Run(context.Background(), All)
+		require.NoError(t, err)
+		require.Len(t, reports, 4)
+		assert.Equal(t, int64(1), reports[0].Rewritten)
+
+		token := getItem(t, db, mustKey(keys.AccessToken("acct_1", "tok_1")))
+		assert.Equal(t, "access-token/U_1", aws.StringValue(token["GSI1PK"].S))
+		assert.Equal(t, "access-token/tok_1", aws.StringValue(token["GSI1SK"].S))
+		assert.Nil(t, token["GSI2SK"])
+		assert.Nil(t, token["AccessToken"].S, "the token is encrypted")
+		require.NoError(t, auto.DecryptItem(context.Background(), auto.DevelopmentKeys(), token))
+		assert.Equal(t, "secret", aws.StringValue(token["AccessToken"].S))
+
+		output, err := db.Query(&dynamodb.QueryInput{
+			IndexName:                 aws.String(keys.GSI1.Name),
+			KeyConditionExpression:    aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("access-token/U_1")}},
+		})
+		require.NoError(t, err)
+		assert.Len(t, output.Items, 1)
+
+		assert.Equal(t, "UTC", aws.StringValue(getItem(t, db, keys.Account("acct_1"))["TimeZone"].S))
+
+		contact := getItem(t, db, mustKey(keys.Contact("contact-keying", "acct_1", "EMAIL", "test@email.test")))
+		require.NotEmpty(t, contact)
+		require.NoError(t, auto.DecryptItem(context.Background(), auto.DevelopmentKeys(), contact))
+		assert.Equal(t, "test@email.test", aws.StringValue(contact["ContactValue"].S))
+		assert.Empty(t, getItem(t, db, keys.Primary{HashKey: "acct_1", SortKey: legacyContactSortKey}), "the contact moved")
+
+		records, err := runner.Status(context.Background(), All)
+		require.NoError(t, err)
+		for _, record := range records {
+			assert.True(t, record.Completed())
+			assert.Nil(t, record.Checkpoint)
+		}
+
+		reports, err = runner.Run(context.Background(), All)
+		require.NoError(t, err)
+		for _, report := range reports {
+			assert.True(t, report.Skipped)
+		}
+	})
+
+	t.Run("resumes from the last checkpoint", func(t *testing.T) {
+		db := testTable(t)
+		runner := &Runner{DB: db, Table: "test", PageSize: 1}
+
+		fail := true
+		seen := make([]string, 0)
+		migration := Migration{
+			ID: "0000-test",
+			Rewrite: func(_ context.Context, item Item) (Item, error) {
+				key := primaryKey(item)
+				if fail && key.SortKey == "access-token/tok_1" {
+					return nil, errors.New("interrupted")
+				}
+				seen = append(seen, key.SortKey)
+				item["Migrated"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+				return item, nil
+			},
+		}
+
+		_, err := runner.Run(context.Background(), []Migration{migration})
+		require.Error(t, err)
+
+		records, err := runner.Status(context.Background(), []Migration{migration})
+		require.NoError(t, err)
+		assert.False(t, records[0].Completed())
+		assert.NotNil(t, records[0].Checkpoint)
+
+		fail = false
+		reports, err := runner.Run(context.Background(), []Migration{migration})
+		require.NoError(t, err)
+		assert.True(t, reports[0].Resumed)
+
+		assert.Equal(t, []string{"_USER_ACCOUNT", "access-token/tok_1", legacyContactSortKey, "reminder/rem_1"}, seen)
+		// Four items and the migration record
+		assert.Equal(t, 5, db.Len())
+	})
+
+	t.Run("moves items to a new key", func(t *testing.T) {
+		db := testTable(t)
+		runner := &Runner{DB: db, Table: "test"}
+
+		_, err := runner.Run(context.Background(), []Migration{{
+			ID: "0000-move",
+			Rewrite: func(_ context.Context, item Item) (Item, error) {
+				if aws.StringValue(item["SK"].S) != "reminder/rem_1" {
+					return nil, nil
+				}
+				item["SK"] = &dynamodb.AttributeValue{S: aws.String("reminder/rem_2")}
+				return item, nil
+			},
+		}})
+		require.NoError(t, err)
+
+		assert.Empty(t, getItem(t, db, mustKey(keys.Reminder("acct_1", "rem_1"))))
+		assert.NotEmpty(t, getItem(t, db, mustKey(keys.Reminder("acct_1", "rem_2"))))
+	})
+
+	t.Run("rewrites with the run's context", func(t *testing.T) {
+		runner := &Runner{DB: testTable(t), Table: "test"}
+
+		type contextKey struct{}
+		ctx := context.WithValue(context.Background(), contextKey{}, "run")
+
+		_, err := runner.Run(ctx, []Migration{{
+			ID: "0000-context",
+			Rewrite: func(ctx context.Context, item Item) (Item, error) {
+				if ctx.Value(contextKey{}) != "run" {
+					return nil, errors.New("rewritten without the run's context")
+				}
+				return nil, nil
+			},
+		}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("re-encrypts under a rotated key", func(t *testing.T) {
+		db := testTable(t)
+		runner := &Runner{DB: db, Table: "test"}
+
+		_, err := runner.Run(context.Background(), All)
+		require.NoError(t, err)
+
+		development := auto.DevelopmentKeys()
+		rotated := auto.LocalKeys{Current: "rotated", Keys: map[string][]byte{
+			development.Current: development.Keys[development.Current],
+			"rotated":           []byte("rotated-key-rotated-key-rotated!"),
+		}}
+
+		reports, err := runner.Run(context.Background(), []Migration{Reencrypt(rotated)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), reports[0].Rewritten)
+
+		token := getItem(t, db, mustKey(keys.AccessToken("acct_1", "tok_1")))
+		assert.Equal(t, "rotated", aws.StringValue(token[auto.EncryptionKeyIDAttribute].S))
+		require.NoError(t, auto.DecryptItem(context.Background(), rotated, token))
+		assert.Equal(t, "secret", aws.StringValue(token["AccessToken"].S))
+
+		reports, err = runner.Run(context.Background(), []Migration{Reencrypt(rotated)})
+		require.NoError(t, err)
+		assert.True(t, reports[0].Skipped, "the job runs once for each key")
+	})
+
+	t.Run("rejects invalid migrations", func(t *testing.T) {
+		runner := &Runner{DB: testTable(t), Table: "test"}
+
+		_, err := runner.Run(context.Background(), []Migration{{ID: "bad/id", Rewrite: rewriteAccountTimeZone}})
+		assert.Error(t, err)
+
+		_, err = runner.Run(context.Background(), []Migration{All[0], All[0]})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,160 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+// All is every migration, in the order they're applied. Append new migrations; never reorder or remove one.
+var All = []Migration{
+	{
+		ID:          "0001-token-index-keys",
+		Description: "Move access tokens from the legacy access_token GSI1 key to the access-token key and drop the stray GSI2 range key",
+		Rewrite:     rewriteTokenIndexKeys,
+	},
+	{
+		ID:          "0002-account-time-zone",
+		Description: "Store UTC as the time zone of accounts that don't have one",
+		Rewrite:     rewriteAccountTimeZone,
+	},
+	{
+		ID:          "0003-encrypt-sensitive-attributes",
+		Description: "Encrypt the tokens and contact values stored in plaintext",
+		Rewrite:     reencrypt(auto.DefaultKeyProvider),
+	},
+	{
+		ID:          "0004-contact-key-hmac",
+		Description: "Move contacts from keys with an unkeyed hash of their value to keys with an HMAC of it",
+		Rewrite:     rewriteContactKeys,
+	},
+}
+
+// Reencrypt returns a migration that re-encrypts every item whose sensitive
+// attributes aren't encrypted under the provider's current key. Its ID is
+// derived from the key, so it runs once after each rotation.
+func Reencrypt(p auto.KeyProvider) Migration {
+	// Key IDs can be ARNs, which can't be part of a key.
+	sum := sha256.Sum256([]byte(p.CurrentKeyID()))
+
+	return Migration{
+		ID:          fmt.Sprintf("reencrypt-%x", sum[:8]),
+		Description: fmt.Sprintf("Re-encrypt sensitive attributes under %s", p.CurrentKeyID()),
+		Rewrite:     reencrypt(func() auto.KeyProvider { return p }),
+	}
+}
+
+// reencryptTimeout caps the key provider calls made for one item
+const reencryptTimeout = 10 * time.Second
+
+// reencrypt looks the provider up when the migration runs rather than when All is built
+func reencrypt(provider func() auto.KeyProvider) func(context.Context, Item) (Item, error) {
+	return func(ctx context.Context, item Item) (Item, error) {
+		ctx, cancel := context.WithTimeout(ctx, reencryptTimeout)
+		defer cancel()
+
+		changed, err := auto.ReencryptItem(ctx, provider(), item)
+		if err != nil || !changed {
+			return nil, err
+		}
+		return item, nil
+	}
+}
+
+// Find returns the migrations with the passed IDs, in the order they appear in All
+func Find(ids ...string) ([]Migration, bool) {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	found := make([]Migration, 0, len(ids))
+	for _, m := range All {
+		if wanted[m.ID] {
+			found = append(found, m)
+		}
+	}
+
+	return found, len(found) == len(wanted)
+}
+
+// legacyAccessTokenIndexPrefix starts the GSI1PK tokens were written with before the keys package
+const legacyAccessTokenIndexPrefix = "access_token/"
+
+// Tokens written before the keys package set GSI1PK to access_token/<automatic id>,
+// left GSI1SK empty so they were never in the index, and set GSI2SK without a GSI2PK.
+func rewriteTokenIndexKeys(_ context.Context, item Item) (Item, error) {
+	key := primaryKey(item)
+
+	tokenID, err := keys.AccessTokenSortKey.Parse(key.SortKey)
+	if err != nil {
+		return nil, nil
+	}
+
+	legacy := auto.StringFromDynamo(item[keys.GSI1.HashAttribute])
+	if !strings.HasPrefix(legacy, legacyAccessTokenIndexPrefix) {
+		return nil, nil
+	}
+
+	indexKey, err := keys.AccessTokenIndex(strings.TrimPrefix(legacy, legacyAccessTokenIndexPrefix), tokenID[0])
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := keys.Item{Primary: key, Indexes: []keys.IndexKey{indexKey}}.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range index {
+		item[name] = value
+	}
+	if item[keys.GSI2.HashAttribute] == nil {
+		delete(item, keys.GSI2.RangeAttribute)
+	}
+
+	return item, nil
+}
+
+func rewriteAccountTimeZone(_ context.Context, item Item) (Item, error) {
+	if !keys.AccountSortKey.Matches(auto.StringFromDynamo(item[keys.RangeKeyAttribute])) {
+		return nil, nil
+	}
+	if auto.StringFromDynamo(item["TimeZone"]) != "" {
+		return nil, nil
+	}
+
+	item["TimeZone"] = &dynamodb.AttributeValue{S: aws.String("UTC")}
+
+	return item, nil
+}
+
+// Contacts were keyed with a SHA-256 of their value, which anyone reading the
+// table could check guessed addresses against. The HMAC is keyed with the
+// signing secret, like the store's.
+func rewriteContactKeys(ctx context.Context, item Item) (Item, error) {
+	if !keys.ContactSortKey.Matches(auto.StringFromDynamo(item[keys.RangeKeyAttribute])) {
+		return nil, nil
+	}
+
+	secrets, err := auto.Secrets()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reencryptTimeout)
+	defer cancel()
+
+	changed, err := auto.RekeyContactItem(ctx, auto.DefaultKeyProvider(), secrets.ContactKeying, item)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,276 @@
+package auto
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/serverless"
+	"github.com/maddiesch/serverless/amazon"
+)
+
+const (
+	defaultDeliveryHour    = 9
+	defaultQuietHoursStart = 22
+	defaultQuietHoursEnd   = 7
+)
+
+// DigestFrequency controls how often due items are batched into a single message
+type DigestFrequency string
+
+const (
+	// DigestFrequencyNone sends each item as soon as it's due (outside of quiet hours)
+	DigestFrequencyNone DigestFrequency = "NONE"
+	// DigestFrequencyDaily sends one message a day at the delivery hour
+	DigestFrequencyDaily DigestFrequency = "DAILY"
+	// DigestFrequencyWeekly sends one message a week on the digest weekday at the delivery hour
+	DigestFrequencyWeekly DigestFrequency = "WEEKLY"
+)
+
+// NotificationChannel is a way of contacting an account
+type NotificationChannel string
+
+const (
+	// NotificationChannelEmail delivers notifications to the account's email contacts
+	NotificationChannelEmail NotificationChannel = "EMAIL"
+	// NotificationChannelPush delivers notifications to the account's devices
+	NotificationChannelPush NotificationChannel = "PUSH"
+)
+
+// NotificationPreferences controls when and how an account is notified.
+//
+// All hours are in the account's time zone. Quiet hours may wrap past midnight
+// (e.g. 22 to 7), and are disabled when the start and end hours are equal.
+type NotificationPreferences struct {
+	AccountID       string                       `json:"-"`
+	DeliveryHour    int                          `validate:"min=0,max=23"`
+	QuietHoursStart int                          `validate:"min=0,max=23"`
+	QuietHoursEnd   int                          `validate:"min=0,max=23"`
+	DigestFrequency DigestFrequency              `validate:"oneof=NONE DAILY WEEKLY"`
+	DigestWeekday   time.Weekday                 `validate:"min=0,max=6"`
+	Channels        map[NotificationChannel]bool `validate:"required"`
+	UpdatedAt       time.Time
+}
+
+// DefaultNotificationPreferences returns the preferences used until an account saves its own
+func DefaultNotificationPreferences(accountID string) *NotificationPreferences {
+	return &NotificationPreferences{
+		AccountID:       accountID,
+		DeliveryHour:    defaultDeliveryHour,
+		QuietHoursStart: defaultQuietHoursStart,
+		QuietHoursEnd:   defaultQuietHoursEnd,
+		DigestFrequency: DigestFrequencyDaily,
+		DigestWeekday:   time.Saturday,
+		Channels: map[NotificationChannel]bool{
+			NotificationChannelEmail: true,
+			NotificationChannelPush:  false,
+		},
+	}
+}
+
+// FindNotificationPreferences returns the notification preferences for the account.
+//
+// If the account has never saved preferences the defaults are returned.
+func (s *DynamoStore) FindNotificationPreferences(ctx context.Context, accountID string) (*NotificationPreferences, error) {
+	prefs := DefaultNotificationPreferences(accountID)
+
+	item, err := s.getItem(ctx, keys.NotificationPreferences(prefs.AccountID))
+	if err == ErrRecordNotFound {
+		return prefs, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	prefs.DeliveryHour = int(IntFromDynamo(item["DeliveryHour"]))
+	prefs.QuietHoursStart = int(IntFromDynamo(item["QuietHoursStart"]))
+	prefs.QuietHoursEnd = int(IntFromDynamo(item["QuietHoursEnd"]))
+	prefs.DigestFrequency = DigestFrequency(StringFromDynamo(item["DigestFrequency"]))
+	prefs.DigestWeekday = time.Weekday(IntFromDynamo(item["DigestWeekday"]))
+	prefs.UpdatedAt = TimeFromDynamo(item["UpdatedAt"])
+
+	if channels := item["Channels"]; channels != nil {
+		for name, value := range channels.M {
+			prefs.Channels[NotificationChannel(name)] = aws.BoolValue(value.BOOL)
+		}
+	}
+
+	return prefs, nil
+}
+
+// SaveNotificationPreferences writes the preferences and the account's time zone together
+func (s *DynamoStore) SaveNotificationPreferences(ctx context.Context, account *Account, prefs *NotificationPreferences) error {
+	if err := serverless.GetValidator().Struct(prefs); err != nil {
+		return err
+	}
+	if account.TimeZone == "" {
+		account.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(account.TimeZone); err != nil {
+		return err
+	}
+
+	prefs.AccountID = account.ID
+	prefs.UpdatedAt = time.Now()
+
+	channels := make(map[string]*dynamodb.AttributeValue, len(prefs.Channels))
+	for name, enabled := range prefs.Channels {
+		channels[string(name)] = &dynamodb.AttributeValue{BOOL: aws.Bool(enabled)}
+	}
+
+	item := keys.NotificationPreferences(prefs.AccountID).Dynamo()
+	item["DeliveryHour"] = DynamoInt(int64(prefs.DeliveryHour))
+	item["QuietHoursStart"] = DynamoInt(int64(prefs.QuietHoursStart))
+	item["QuietHoursEnd"] = DynamoInt(int64(prefs.QuietHoursEnd))
+	item["DigestFrequency"] = &dynamodb.AttributeValue{S: aws.String(string(prefs.DigestFrequency))}
+	item["DigestWeekday"] = DynamoInt(int64(prefs.DigestWeekday))
+	item["Channels"] = &dynamodb.AttributeValue{M: channels}
+	item["UpdatedAt"] = DynamoTime(prefs.UpdatedAt)
+
+	_, err := s.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName:           s.table,
+					Key:                 keys.Account(account.ID).Dynamo(),
+					ConditionExpression: aws.String("attribute_exists(#pk)"),
+					UpdateExpression:    aws.String("SET #tz = :tz, #ua = :ua"),
+					ExpressionAttributeNames: map[string]*string{
+						"#pk": aws.String(keys.HashKeyAttribute),
+						"#tz": aws.String("TimeZone"),
+						"#ua": aws.String("UpdatedAt"),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":tz": {S: aws.String(account.TimeZone)},
+						":ua": DynamoTime(prefs.UpdatedAt),
+					},
+				},
+			},
+			{
+				Put: &dynamodb.Put{
+					TableName: s.table,
+					Item:      item,
+				},
+			},
+		},
+	})
+
+	return err
+}
+
+// RecordDigestDelivery sets the account's LastDigestAt. A zero deliveredAt
+// clears it, which undoes the first delivery.
+//
+// The write is conditioned on the LastDigestAt the account was read with, so
+// when two schedulers race for the same digest only one records it. The other
+// gets ErrRecordConflict.
+func (s *DynamoStore) RecordDigestDelivery(ctx context.Context, account *Account, deliveredAt time.Time) error {
+	condition := "attribute_exists(#pk) AND attribute_not_exists(#last)"
+	update := "REMOVE #last"
+	values := map[string]*dynamodb.AttributeValue{}
+	if !account.LastDigestAt.IsZero() {
+		condition = "attribute_exists(#pk) AND #last = :previous"
+		values[":previous"] = DynamoTime(account.LastDigestAt)
+	}
+	if !deliveredAt.IsZero() {
+		update = "SET #last = :delivered"
+		values[":delivered"] = DynamoTime(deliveredAt)
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           s.table,
+		Key:                 keys.Account(account.ID).Dynamo(),
+		ConditionExpression: aws.String(condition),
+		UpdateExpression:    aws.String(update),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":   aws.String(keys.HashKeyAttribute),
+			"#last": aws.String("LastDigestAt"),
+		},
+	}
+	// DynamoDB rejects an empty map of values
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+
+	_, err := s.db.UpdateItemWithContext(ctx, input)
+	if amazon.IsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return ErrRecordConflict
+	} else if err != nil {
+		return err
+	}
+
+	account.LastDigestAt = deliveredAt
+	return nil
+}
+
+// PrimaryKey returns the primary key for DynamoDB
+func (p *NotificationPreferences) PrimaryKey() (PrimaryKey, error) {
+	return keys.NotificationPreferences(p.AccountID), nil
+}
+
+// ChannelEnabled returns true if the account wants to be notified over the channel
+func (p *NotificationPreferences) ChannelEnabled(channel NotificationChannel) bool {
+	return p.Channels[channel]
+}
+
+// InQuietHours returns true if the time falls inside the quiet hours.
+//
+// The time must already be in the account's location.
+func (p *NotificationPreferences) InQuietHours(t time.Time) bool {
+	if p.QuietHoursStart == p.QuietHoursEnd {
+		return false
+	}
+
+	hour := t.Hour()
+	if p.QuietHoursStart < p.QuietHoursEnd {
+		return hour >= p.QuietHoursStart && hour < p.QuietHoursEnd
+	}
+	return hour >= p.QuietHoursStart || hour < p.QuietHoursEnd
+}
+
+// DigestWindow returns how far ahead of a delivery an item is considered due soon
+func (p *NotificationPreferences) DigestWindow() time.Duration {
+	switch p.DigestFrequency {
+	case DigestFrequencyDaily:
+		return 24 * time.Hour
+	case DigestFrequencyWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// NextDelivery returns the first time at or after `after` that a notification
+// may be sent to the account in the passed location.
+func (p *NotificationPreferences) NextDelivery(after time.Time, loc *time.Location) time.Time {
+	local := after.In(loc)
+
+	if p.DigestFrequency == DigestFrequencyNone || p.DigestFrequency == "" {
+		return p.afterQuietHours(local)
+	}
+
+	next := time.Date(local.Year(), local.Month(), local.Day(), p.DeliveryHour, 0, 0, 0, loc)
+	if next.Before(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	if p.DigestFrequency == DigestFrequencyWeekly {
+		for next.Weekday() != p.DigestWeekday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+
+	return p.afterQuietHours(next)
+}
+
+func (p *NotificationPreferences) afterQuietHours(t time.Time) time.Time {
+	if !p.InQuietHours(t) {
+		return t
+	}
+
+	end := time.Date(t.Year(), t.Month(), t.Day(), p.QuietHoursEnd, 0, 0, 0, t.Location())
+	if end.Before(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
@@ -0,0 +1,162 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	loc, err := time.LoadLocation("America/Denver")
+	require.NoError(t, err)
+
+	t.Run("quiet hours wrap past midnight", func(t *testing.T) {
+		prefs := DefaultNotificationPreferences("auid:test")
+
+		assert.True(t, prefs.InQuietHours(time.Date(2019, 10, 1, 23, 0, 0, 0, loc)))
+		assert.True(t, prefs.InQuietHours(time.Date(2019, 10, 1, 6, 59, 0, 0, loc)))
+		assert.False(t, prefs.InQuietHours(time.Date(2019, 10, 1, 7, 0, 0, 0, loc)))
+	})
+
+	t.Run("daily delivery is at the delivery hour in the account's time zone", func(t *testing.T) {
+		prefs := DefaultNotificationPreferences("auid:test")
+
+		next := prefs.NextDelivery(time.Date(2019, 10, 1, 18, 0, 0, 0, time.UTC), loc)
+
+		assert.Equal(t, time.Date(2019, 10, 2, 9, 0, 0, 0, loc), next)
+	})
+
+	t.Run("weekly delivery waits for the digest weekday", func(t *testing.T) {
+		prefs := DefaultNotificationPreferences("auid:test")
+		prefs.DigestFrequency = DigestFrequencyWeekly
+
+		next := prefs.NextDelivery(time.Date(2019, 10, 1, 12, 0, 0, 0, loc), loc)
+
+		assert.Equal(t, time.Saturday, next.Weekday())
+		assert.Equal(t, time.Date(2019, 10, 5, 9, 0, 0, 0, loc), next)
+	})
+
+	t.Run("immediate delivery is deferred until quiet hours end", func(t *testing.T) {
+		prefs := DefaultNotificationPreferences("auid:test")
+		prefs.DigestFrequency = DigestFrequencyNone
+
+		next := prefs.NextDelivery(time.Date(2019, 10, 1, 23, 30, 0, 0, loc), loc)
+
+		assert.Equal(t, time.Date(2019, 10, 2, 7, 0, 0, 0, loc), next)
+	})
+
+	t.Run("delivery is due once per day", func(t *testing.T) {
+		prefs := DefaultNotificationPreferences("auid:test")
+		delivered := time.Date(2019, 10, 1, 9, 0, 0, 0, loc)
+
+		assert.False(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 15, 0, 0, 0, loc), delivered, loc))
+		assert.True(t, prefs.DeliveryDue(time.Date(2019, 10, 2, 9, 5, 0, 0, loc), delivered, loc))
+	})
+
+	t.Run("the first delivery waits for the delivery hour, outside quiet hours", func(t *testing.T) {
+		prefs := DefaultNotificationPreferences("auid:test")
+		prefs.DigestFrequency = DigestFrequencyNone
+
+		assert.True(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 12, 0, 0, 0, loc), time.Time{}, loc))
+		assert.False(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 23, 0, 0, 0, loc), time.Time{}, loc))
+
+		prefs.DigestFrequency = DigestFrequencyDaily
+		assert.False(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 8, 0, 0, 0, loc), time.Time{}, loc), "before the delivery hour")
+		assert.True(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 12, 0, 0, 0, loc), time.Time{}, loc))
+		assert.False(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 23, 0, 0, 0, loc), time.Time{}, loc), "in quiet hours")
+
+		prefs.DigestFrequency = DigestFrequencyWeekly
+		assert.False(t, prefs.DeliveryDue(time.Date(2019, 10, 1, 12, 0, 0, 0, loc), time.Time{}, loc), "a Tuesday")
+		assert.True(t, prefs.DeliveryDue(time.Date(2019, 10, 5, 12, 0, 0, 0, loc), time.Time{}, loc), "the digest weekday")
+	})
+}
+
+func TestSendDigest(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	loc, err := time.LoadLocation("America/Denver")
+	require.NoError(t, err)
+
+	account := &Account{ID: "auid:test", TimeZone: "America/Denver"}
+	require.NoError(t, store.SaveAutomaticAuthentication(ctx, account, AutomaticAccessToken{UserID: "U_test", AccessToken: "access"}, nil))
+
+	delivered := time.Date(2019, 10, 1, 9, 0, 0, 0, loc)
+	require.NoError(t, store.RecordDigestDelivery(ctx, account, delivered))
+	require.NoError(t, store.SaveReminder(ctx, &Reminder{ID: "rem:1", AccountID: account.ID, Title: "Oil change", IntervalDays: 90, LastCompletedAt: delivered.AddDate(0, 0, -90)}))
+
+	actions := func(r *Reminder) ([]DigestAction, error) {
+		return []DigestAction{{Title: "Dismiss", URL: "https://autorem.test/dismiss/" + r.ID}}, nil
+	}
+
+	sent := make([]*Digest, 0)
+	send := func(ctx context.Context, account *Account, prefs *NotificationPreferences, digest *Digest) error {
+		sent = append(sent, digest)
+		return nil
+	}
+	failing := func(ctx context.Context, account *Account, prefs *NotificationPreferences, digest *Digest) error {
+		return errors.New("mail is down")
+	}
+
+	// 9:30 in UTC is still the middle of the night in Denver
+	digest, err := SendDigest(ctx, store, account.ID, time.Date(2019, 10, 2, 9, 30, 0, 0, time.UTC), actions, send)
+	require.NoError(t, err)
+	assert.Nil(t, digest)
+
+	now := time.Date(2019, 10, 2, 9, 30, 0, 0, loc)
+	_, err = SendDigest(ctx, store, account.ID, now, actions, failing)
+	assert.EqualError(t, err, "mail is down")
+	found, err := store.FindAccount(ctx, account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, delivered.Unix(), found.LastDigestAt.Unix(), "a digest that wasn't sent isn't recorded")
+
+	digest, err = SendDigest(ctx, store, account.ID, now, actions, send)
+	require.NoError(t, err)
+	require.NotNil(t, digest)
+	assert.Equal(t, "Reminder: Oil change", digest.Subject())
+	require.Len(t, sent, 1)
+	assert.Equal(t, "https://autorem.test/dismiss/rem:1", sent[0].Items[0].Actions[0].URL, "items carry their reminder's links")
+
+	found, err = store.FindAccount(ctx, account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, now.Unix(), found.LastDigestAt.Unix())
+
+	digest, err = SendDigest(ctx, store, account.ID, now.Add(time.Hour), actions, send)
+	require.NoError(t, err)
+	assert.Nil(t, digest, "the day's digest was sent")
+	assert.Len(t, sent, 1)
+
+	assert.Equal(t, ErrRecordConflict, store.RecordDigestDelivery(ctx, &Account{ID: account.ID, LastDigestAt: delivered}, now), "another scheduler recorded it first")
+
+	first := &Account{ID: "auid:first", TimeZone: "America/Denver"}
+	require.NoError(t, store.SaveAutomaticAuthentication(ctx, first, AutomaticAccessToken{UserID: "U_first", AccessToken: "access"}, nil))
+	require.NoError(t, store.RecordDigestDelivery(ctx, first, now))
+	require.NoError(t, store.RecordDigestDelivery(ctx, first, time.Time{}))
+	found, err = store.FindAccount(ctx, first.ID)
+	require.NoError(t, err)
+	assert.True(t, found.LastDigestAt.IsZero(), "an undone first delivery leaves the account never sent one")
+}
+
+func TestBuildDigest(t *testing.T) {
+	now := time.Date(2019, 10, 1, 9, 0, 0, 0, time.UTC)
+	prefs := DefaultNotificationPreferences("auid:test")
+
+	digest := BuildDigest(prefs, now, []DigestItem{
+		{Title: "Tire rotation", DueAt: now.Add(12 * time.Hour)},
+		{Title: "Oil change", DueAt: now.Add(-48 * time.Hour)},
+		{Title: "Brake fluid", DueAt: now.Add(30 * 24 * time.Hour)},
+	})
+	require.NotNil(t, digest)
+
+	require.Len(t, digest.Items, 2)
+	assert.Equal(t, "Oil change", digest.Items[0].Title)
+	assert.Equal(t, "Tire rotation", digest.Items[1].Title)
+	assert.Equal(t, "You have 2 upcoming reminders", digest.Subject())
+	assert.Contains(t, digest.Text(time.UTC), "Oil change (overdue since Sun Sep 29)")
+
+	assert.Nil(t, BuildDigest(prefs, now, nil))
+}
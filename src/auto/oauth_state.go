@@ -0,0 +1,90 @@
+package auto
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+const oauthStateLifetime = 48 * time.Hour
+
+// OAuthState is an authorization request that's waiting for the provider to call back
+type OAuthState struct {
+	State     string
+	StartedAt time.Time
+	ExpiresAt time.Time
+}
+
+// CreateOAuthState writes the state. It expires after two days unless ExpiresAt is set.
+func (s *DynamoStore) CreateOAuthState(ctx context.Context, state *OAuthState) error {
+	if state.StartedAt.IsZero() {
+		state.StartedAt = time.Now()
+	}
+	if state.ExpiresAt.IsZero() {
+		state.ExpiresAt = state.StartedAt.Add(oauthStateLifetime)
+	}
+
+	key, err := state.PrimaryKey()
+	if err != nil {
+		return err
+	}
+
+	item := key.Dynamo()
+	item["StartedAt"] = DynamoTime(state.StartedAt)
+	item[TimeToLiveAttribute] = DynamoTime(state.ExpiresAt)
+
+	_, err = s.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: s.table,
+		Item:      item,
+	})
+
+	return err
+}
+
+// FindOAuthState returns the state if it exists and hasn't expired
+func (s *DynamoStore) FindOAuthState(ctx context.Context, state string) (*OAuthState, error) {
+	key, err := keys.OAuthState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.getItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	found := &OAuthState{
+		State:     state,
+		StartedAt: TimeFromDynamo(item["StartedAt"]),
+		ExpiresAt: TimeFromDynamo(item["ExpiresAt"]),
+	}
+
+	// TTL deletes happen eventually, so expired items can still be read for a while.
+	if !found.ExpiresAt.IsZero() && time.Now().After(found.ExpiresAt) {
+		return nil, ErrRecordNotFound
+	}
+
+	return found, nil
+}
+
+// DeleteOAuthState removes the state so it can't be used again
+func (s *DynamoStore) DeleteOAuthState(ctx context.Context, state string) error {
+	key, err := keys.OAuthState(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: s.table,
+		Key:       key.Dynamo(),
+	})
+
+	return err
+}
+
+// PrimaryKey returns the primary key for DynamoDB
+func (o *OAuthState) PrimaryKey() (PrimaryKey, error) {
+	return keys.OAuthState(o.State)
+}
@@ -0,0 +1,288 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/serverless"
+)
+
+// ErrOdometerNotMonotonic is returned when a reading is lower than an earlier reading or higher than a later one
+var ErrOdometerNotMonotonic = errors.New("odometer readings must not decrease over time")
+
+// OdometerSource is where an odometer reading came from
+type OdometerSource string
+
+const (
+	// OdometerSourceManual readings are entered by hand
+	OdometerSourceManual OdometerSource = "MANUAL"
+	// OdometerSourceTrip readings are derived by adding a trip's distance to the previous reading
+	OdometerSourceTrip OdometerSource = "TRIP"
+)
+
+// OdometerReading is a single entry in a vehicle's odometer timeline. Readings are in kilometers.
+type OdometerReading struct {
+	ID        string
+	AccountID string         `json:"-"`
+	VehicleID string         `validate:"required"`
+	Reading   float64        `validate:"min=0"`
+	ReadAt    time.Time      `validate:"required"`
+	Source    OdometerSource `validate:"oneof=MANUAL TRIP"`
+	TripID    string         `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// OdometerTimeline returns every reading for the vehicle, oldest first
+func (s *DynamoStore) OdometerTimeline(ctx context.Context, accountID, vehicleID string) ([]*OdometerReading, error) {
+	prefix, err := keys.OdometerSortKey.BuildPrefix(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	readings := make([]*OdometerReading, 0)
+
+	err = s.queryPrefix(ctx, accountID, prefix, func(item map[string]*dynamodb.AttributeValue) {
+		readings = append(readings, odometerReadingFromDynamo(item))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return readings, nil
+}
+
+// LatestOdometerReading returns the vehicle's most recent reading, regardless of its source
+func (s *DynamoStore) LatestOdometerReading(ctx context.Context, accountID, vehicleID string) (*OdometerReading, error) {
+	prefix, err := keys.OdometerSortKey.BuildPrefix(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	return s.odometerNeighbor(ctx, accountID, vehicleID, "<=", prefix+"~")
+}
+
+// AddOdometerReading validates the reading against its neighbors in the timeline and writes it.
+//
+// The vehicle's timeline is versioned, so a reading is only written if no
+// other reading was added since its neighbors were read. ErrRecordConflict is
+// returned if one was. The reading's ID is the timeline's new version, so
+// readings in the same second are in the order they were added.
+func (s *DynamoStore) AddOdometerReading(ctx context.Context, r *OdometerReading) error {
+	if r.ReadAt.IsZero() {
+		r.ReadAt = time.Now()
+	}
+	r.ReadAt = r.ReadAt.Truncate(time.Second)
+	if err := serverless.GetValidator().Struct(r); err != nil {
+		return err
+	}
+
+	version, err := s.odometerTimelineVersion(ctx, r.AccountID, r.VehicleID)
+	if err != nil {
+		return err
+	}
+	r.ID = odometerReadingID(version + 1)
+
+	key, err := r.PrimaryKey()
+	if err != nil {
+		return err
+	}
+	timelineKey, err := keys.OdometerTimeline(r.AccountID, r.VehicleID)
+	if err != nil {
+		return err
+	}
+
+	before, err := s.odometerNeighbor(ctx, r.AccountID, r.VehicleID, "<", key.SortKey)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+	after, err := s.odometerNeighbor(ctx, r.AccountID, r.VehicleID, ">", key.SortKey)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+	if err := checkOdometerReading(before, r, after); err != nil {
+		return err
+	}
+
+	r.CreatedAt = time.Now()
+
+	timeline := &dynamodb.Update{
+		TableName:                s.table,
+		Key:                      timelineKey.Dynamo(),
+		UpdateExpression:         aws.String("SET #version = :next"),
+		ConditionExpression:      aws.String("attribute_not_exists(#version)"),
+		ExpressionAttributeNames: map[string]*string{"#version": aws.String("Version")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":next": DynamoInt(version + 1),
+		},
+	}
+	if version > 0 {
+		timeline.ConditionExpression = aws.String("#version = :version")
+		timeline.ExpressionAttributeValues[":version"] = DynamoInt(version)
+	}
+
+	_, err = s.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Update: timeline},
+			{
+				Put: &dynamodb.Put{
+					TableName:                s.table,
+					Item:                     r.dynamo(key),
+					ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+					ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+				},
+			},
+		},
+	})
+	if isTransactionConflict(err) {
+		return ErrRecordConflict
+	}
+
+	return err
+}
+
+// odometerTimelineVersion returns the number of readings added to the vehicle's timeline.
+// Readings written before the timeline was versioned aren't counted.
+func (s *DynamoStore) odometerTimelineVersion(ctx context.Context, accountID, vehicleID string) (int64, error) {
+	key, err := keys.OdometerTimeline(accountID, vehicleID)
+	if err != nil {
+		return 0, err
+	}
+
+	item, err := s.getItem(ctx, key)
+	if err == ErrRecordNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return IntFromDynamo(item["Version"]), nil
+}
+
+// AddTripOdometerReading derives a reading from a trip by adding its distance to the reading before it.
+//
+// Trip readings need a starting point, so ErrRecordNotFound is returned if the
+// vehicle has no readings from before the trip ended.
+func (s *DynamoStore) AddTripOdometerReading(ctx context.Context, accountID, vehicleID, tripID string, endedAt time.Time, distance float64) (*OdometerReading, error) {
+	reading := &OdometerReading{
+		ID:        lastOdometerReadingID,
+		AccountID: accountID,
+		VehicleID: vehicleID,
+		ReadAt:    endedAt,
+		Source:    OdometerSourceTrip,
+		TripID:    tripID,
+	}
+
+	// The trip's reading is added after any other reading in the same second
+	key, err := reading.PrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.odometerNeighbor(ctx, accountID, vehicleID, "<", key.SortKey)
+	if err != nil {
+		return nil, err
+	}
+	reading.Reading = previous.Reading + distance
+
+	if err := s.AddOdometerReading(ctx, reading); err != nil {
+		return nil, err
+	}
+
+	return reading, nil
+}
+
+// PrimaryKey returns the primary key for DynamoDB
+func (r *OdometerReading) PrimaryKey() (PrimaryKey, error) {
+	return keys.OdometerReading(r.AccountID, r.VehicleID, r.ReadAt.Unix(), r.ID)
+}
+
+// lastOdometerReadingID sorts after the ID of every reading
+const lastOdometerReadingID = "odo:~"
+
+// odometerReadingID returns the ID of the reading added as the timeline's version.
+func odometerReadingID(version int64) string {
+	return fmt.Sprintf("odo:%012d", version)
+}
+
+func checkOdometerReading(before, r, after *OdometerReading) error {
+	if before != nil && r.Reading < before.Reading {
+		return ErrOdometerNotMonotonic
+	}
+	if after != nil && r.Reading > after.Reading {
+		return ErrOdometerNotMonotonic
+	}
+	return nil
+}
+
+// odometerNeighbor returns the closest reading whose sort key compares to the passed key with op (<, <=, >)
+func (s *DynamoStore) odometerNeighbor(ctx context.Context, accountID, vehicleID, op, sortKey string) (*OdometerReading, error) {
+	prefix, err := keys.OdometerSortKey.BuildPrefix(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, forward := prefix, sortKey, false
+	if op == ">" {
+		from, to, forward = sortKey, prefix+"~", true
+	}
+
+	result, err := s.db.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              s.table,
+		KeyConditionExpression: aws.String("#pk = :pk AND #sk BETWEEN :from AND :to"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(keys.HashKeyAttribute),
+			"#sk": aws.String(keys.RangeKeyAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk":   {S: aws.String(accountID)},
+			":from": {S: aws.String(from)},
+			":to":   {S: aws.String(to)},
+		},
+		ScanIndexForward: aws.Bool(forward),
+		Limit:            aws.Int64(2),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range result.Items {
+		// BETWEEN is inclusive, so skip an exact match when a strict comparison was asked for.
+		if op != "<=" && StringFromDynamo(item[keys.RangeKeyAttribute]) == sortKey {
+			continue
+		}
+		return odometerReadingFromDynamo(item), nil
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (r *OdometerReading) dynamo(key PrimaryKey) map[string]*dynamodb.AttributeValue {
+	item := key.Dynamo()
+	item["ID"] = &dynamodb.AttributeValue{S: aws.String(r.ID)}
+	item["VehicleID"] = &dynamodb.AttributeValue{S: aws.String(r.VehicleID)}
+	item["Reading"] = DynamoFloat(r.Reading)
+	item["ReadAt"] = DynamoTime(r.ReadAt)
+	item["Source"] = &dynamodb.AttributeValue{S: aws.String(string(r.Source))}
+	item["CreatedAt"] = DynamoTime(r.CreatedAt)
+
+	if r.TripID != "" {
+		item["TripID"] = &dynamodb.AttributeValue{S: aws.String(r.TripID)}
+	}
+
+	return item
+}
+
+func odometerReadingFromDynamo(item map[string]*dynamodb.AttributeValue) *OdometerReading {
+	return &OdometerReading{
+		ID:        StringFromDynamo(item["ID"]),
+		AccountID: StringFromDynamo(item[keys.HashKeyAttribute]),
+		VehicleID: StringFromDynamo(item["VehicleID"]),
+		Reading:   FloatFromDynamo(item["Reading"]),
+		ReadAt:    TimeFromDynamo(item["ReadAt"]),
+		Source:    OdometerSource(StringFromDynamo(item["Source"])),
+		TripID:    StringFromDynamo(item["TripID"]),
+		CreatedAt: TimeFromDynamo(item["CreatedAt"]),
+	}
+}
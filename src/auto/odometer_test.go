@@ -0,0 +1,36 @@
+package auto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOdometerReading(t *testing.T) {
+	at := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("readings must not decrease over time", func(t *testing.T) {
+		before := &OdometerReading{Reading: 1000, ReadAt: at.Add(-time.Hour)}
+		after := &OdometerReading{Reading: 1200, ReadAt: at.Add(time.Hour)}
+
+		assert.NoError(t, checkOdometerReading(before, &OdometerReading{Reading: 1100}, after))
+		assert.NoError(t, checkOdometerReading(before, &OdometerReading{Reading: 1000}, after))
+		assert.NoError(t, checkOdometerReading(nil, &OdometerReading{Reading: 10}, nil))
+		assert.Equal(t, ErrOdometerNotMonotonic, checkOdometerReading(before, &OdometerReading{Reading: 999}, after))
+		assert.Equal(t, ErrOdometerNotMonotonic, checkOdometerReading(before, &OdometerReading{Reading: 1201}, after))
+	})
+
+	t.Run("sort keys order the timeline by time", func(t *testing.T) {
+		early := &OdometerReading{ID: "odo:2", AccountID: "auid:test", VehicleID: "veh:test", ReadAt: at}
+		late := &OdometerReading{ID: "odo:1", AccountID: "auid:test", VehicleID: "veh:test", ReadAt: at.AddDate(10, 0, 0)}
+
+		prefix, err := keys.OdometerSortKey.BuildPrefix("veh:test")
+		require.NoError(t, err)
+
+		assert.True(t, mustKey(early.PrimaryKey()).SortKey < mustKey(late.PrimaryKey()).SortKey)
+		assert.True(t, mustKey(late.PrimaryKey()).SortKey < prefix+"~")
+	})
+}
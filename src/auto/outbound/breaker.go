@@ -0,0 +1,124 @@
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for requests to a host that has been failing
+var ErrCircuitOpen = errors.New("circuit open")
+
+// Breakers keeps a circuit breaker for every host requests are sent to.
+//
+// A host's circuit opens after Threshold failures in a row. While it's open,
+// requests fail immediately. Once Cooldown has passed a single trial request is
+// let through: if it succeeds the circuit closes, otherwise it opens again.
+//
+// Breakers are kept in memory, so each Lambda container learns about a down
+// host separately, after at most Threshold requests.
+type Breakers struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+	now   func() time.Time
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// trial is true while the request let through after the cooldown is in flight
+	trial bool
+}
+
+// NewBreakers returns breakers that open after threshold consecutive failures for the cooldown
+func NewBreakers(threshold int, cooldown time.Duration) *Breakers {
+	return &Breakers{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Open returns true if requests to the host are currently being rejected
+func (b *Breakers) Open(host string) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.hosts[host]
+	return ok && (state.trial || b.clock().Before(state.openUntil))
+}
+
+func (b *Breakers) allow(host string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.hosts[host]
+	if !ok || state.openUntil.IsZero() {
+		return nil
+	}
+	if state.trial || b.clock().Before(state.openUntil) {
+		return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	}
+
+	state.trial = true
+	return nil
+}
+
+func (b *Breakers) record(host string, failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.hosts == nil {
+		b.hosts = make(map[string]*breaker)
+	}
+	state, ok := b.hosts[host]
+	if !ok {
+		state = &breaker{}
+		b.hosts[host] = state
+	}
+
+	if !failed {
+		delete(b.hosts, host)
+		return
+	}
+
+	state.failures++
+	if state.trial || (b.Threshold > 0 && state.failures >= b.Threshold) {
+		state.openUntil = b.clock().Add(b.Cooldown)
+	}
+	state.trial = false
+}
+
+// skip forgets an attempt that was allowed but never sent. If it was the trial
+// request, the next request is let through as the trial instead.
+func (b *Breakers) skip(host string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if state, ok := b.hosts[host]; ok {
+		state.trial = false
+	}
+}
+
+func (b *Breakers) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,215 @@
+package outbound
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrRateLimited is returned for a request that would go over a rate limit when there's no time to wait for more budget
+var ErrRateLimited = errors.New("rate limited")
+
+// Kinds of rate limit key
+const (
+	LimitKindHost  = "host"
+	LimitKindToken = "token"
+)
+
+// LimitKey identifies a rate limit budget
+type LimitKey struct {
+	Kind string
+	ID   string
+}
+
+func (k LimitKey) String() string {
+	return k.Kind + "/" + k.ID
+}
+
+// Limit is a number of requests allowed in each window of time
+type Limit struct {
+	Requests int64
+	Window   time.Duration
+}
+
+// LimitStore keeps rate limit budgets. Processes sharing a store share the budgets.
+type LimitStore interface {
+	// TakeLimit takes one request from the key's budget for the window containing
+	// now. When the budget is spent it returns false and the time it can next be
+	// taken from.
+	TakeLimit(ctx context.Context, key LimitKey, limit Limit, now time.Time) (bool, time.Time, error)
+
+	// BlockLimit stops requests being taken from the key's budget until the passed time
+	BlockLimit(ctx context.Context, key LimitKey, until time.Time) error
+}
+
+// Throttle describes a request that was held back by a rate limit
+type Throttle struct {
+	Key LimitKey
+	// Wait is how long the request waited, or would have had to wait when it's rejected
+	Wait time.Duration
+	// Rejected is true when the request failed with ErrRateLimited instead of waiting
+	Rejected bool
+}
+
+// Limiter holds requests back so they stay within a budget per host and per
+// access token. It also honors the X-RateLimit-* headers the server returns,
+// so budget the server says is spent isn't used even if the limits allow it.
+type Limiter struct {
+	Store LimitStore
+
+	// HostLimit applies to every request to a host
+	HostLimit Limit
+	// TokenLimit applies to every request with the same Authorization header
+	TokenLimit Limit
+
+	// MaxWait is the longest a request waits for budget before failing with ErrRateLimited
+	MaxWait time.Duration
+
+	// OnThrottle is called for every request held back by a limit. It's optional.
+	OnThrottle func(context.Context, Throttle)
+
+	// OnBlockError is called when the server's rate limit headers can't be
+	// recorded in the store. The response is returned anyway, since it can hold
+	// something that can't be asked for again, like a rotated refresh token.
+	// It's optional.
+	OnBlockError func(context.Context, LimitKey, error)
+
+	now func() time.Time
+}
+
+// limitError is an error from the limiter rather than the request it held back.
+// It says nothing about the host, so Send doesn't retry it or count it against
+// the host's circuit.
+type limitError struct {
+	err error
+}
+
+func (e *limitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *limitError) Unwrap() error {
+	return e.err
+}
+
+// Limit returns a SendFunc that waits for budget before each attempt and records the server's rate limit headers after it
+func (l *Limiter) Limit(send SendFunc) SendFunc {
+	if l == nil {
+		return send
+	}
+
+	return func(r *http.Request) (*http.Response, error) {
+		hostKey, tokenKey := limitKeys(r)
+
+		if err := l.take(r.Context(), hostKey, l.HostLimit); err != nil {
+			return nil, &limitError{err}
+		}
+		if tokenKey != nil {
+			if err := l.take(r.Context(), *tokenKey, l.TokenLimit); err != nil {
+				return nil, &limitError{err}
+			}
+		}
+
+		response, err := send(r)
+		if err != nil {
+			return response, err
+		}
+
+		// The server's budget is per token when there is one
+		key := hostKey
+		if tokenKey != nil {
+			key = *tokenKey
+		}
+		if until, ok := l.blockedUntil(response); ok {
+			if err := l.Store.BlockLimit(r.Context(), key, until); err != nil && l.OnBlockError != nil {
+				l.OnBlockError(r.Context(), key, err)
+			}
+		}
+
+		return response, nil
+	}
+}
+
+func (l *Limiter) take(ctx context.Context, key LimitKey, limit Limit) error {
+	if limit.Requests <= 0 || limit.Window <= 0 {
+		return nil
+	}
+
+	var waited time.Duration
+	for {
+		ok, retryAt, err := l.Store.TakeLimit(ctx, key, limit, l.clock())
+		if err != nil {
+			return err
+		}
+		if ok {
+			if waited > 0 && l.OnThrottle != nil {
+				l.OnThrottle(ctx, Throttle{Key: key, Wait: waited})
+			}
+			return nil
+		}
+
+		wait := retryAt.Sub(l.clock())
+		if wait < 0 {
+			wait = 0
+		}
+		if waited+wait > l.MaxWait || !fitsDeadline(ctx, wait) {
+			if l.OnThrottle != nil {
+				l.OnThrottle(ctx, Throttle{Key: key, Wait: waited + wait, Rejected: true})
+			}
+			return fmt.Errorf("%w: %s until %s", ErrRateLimited, key.Kind, retryAt.UTC().Format(time.RFC3339))
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		waited += wait
+	}
+}
+
+// blockedUntil returns when the server will accept requests again, if it has said it won't until then
+func (l *Limiter) blockedUntil(response *http.Response) (time.Time, bool) {
+	if response.StatusCode == http.StatusTooManyRequests {
+		if after, ok := retryAfter(response); ok {
+			return l.clock().Add(after), true
+		}
+	}
+
+	remaining, err := strconv.ParseInt(response.Header.Get("X-RateLimit-Remaining"), 10, 64)
+	if err != nil || remaining > 0 {
+		return time.Time{}, false
+	}
+
+	reset, err := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// Reset is a unix time, but some servers send the seconds until the reset instead
+	if reset < 1000000000 {
+		return l.clock().Add(time.Duration(reset) * time.Second), true
+	}
+	return time.Unix(reset, 0), true
+}
+
+func (l *Limiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// limitKeys returns the budgets a request is taken from. The access token is
+// hashed so it's never stored.
+func limitKeys(r *http.Request) (LimitKey, *LimitKey) {
+	host := LimitKey{Kind: LimitKindHost, ID: r.URL.Host}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return host, nil
+	}
+
+	return host, &LimitKey{Kind: LimitKindToken, ID: fmt.Sprintf("%x", sha256.Sum256([]byte(authorization)))[:32]}
+}
@@ -0,0 +1,182 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLimits is a LimitStore for a single process
+type memoryLimits struct {
+	counts  map[LimitKey]int64
+	windows map[LimitKey]time.Time
+	blocked map[LimitKey]time.Time
+}
+
+func newMemoryLimits() *memoryLimits {
+	return &memoryLimits{counts: map[LimitKey]int64{}, windows: map[LimitKey]time.Time{}, blocked: map[LimitKey]time.Time{}}
+}
+
+func (m *memoryLimits) TakeLimit(ctx context.Context, key LimitKey, limit Limit, now time.Time) (bool, time.Time, error) {
+	if until := m.blocked[key]; until.After(now) {
+		return false, until, nil
+	}
+	window := now.Truncate(limit.Window)
+	if !m.windows[key].Equal(window) {
+		m.windows[key], m.counts[key] = window, 0
+	}
+	if m.counts[key] >= limit.Requests {
+		return false, window.Add(limit.Window), nil
+	}
+	m.counts[key]++
+	return true, time.Time{}, nil
+}
+
+func (m *memoryLimits) BlockLimit(ctx context.Context, key LimitKey, until time.Time) error {
+	m.blocked[key] = until
+	return nil
+}
+
+func ok(r *http.Request) (*http.Response, error) {
+	return httptest.NewRecorder().Result(), nil
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("rejects requests over the host limit", func(t *testing.T) {
+		throttles := make([]Throttle, 0)
+		limiter := &Limiter{
+			Store:      newMemoryLimits(),
+			HostLimit:  Limit{Requests: 2, Window: time.Hour},
+			OnThrottle: func(ctx context.Context, th Throttle) { throttles = append(throttles, th) },
+		}
+		send := limiter.Limit(ok)
+
+		for i := 0; i < 2; i++ {
+			_, err := send(httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil))
+			require.NoError(t, err)
+		}
+
+		_, err := send(httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil))
+		assert.True(t, errors.Is(err, ErrRateLimited))
+		require.Len(t, throttles, 1)
+		assert.True(t, throttles[0].Rejected)
+		assert.Equal(t, LimitKindHost, throttles[0].Key.Kind)
+
+		_, err = send(httptest.NewRequest(http.MethodGet, "https://other.test/", nil))
+		assert.NoError(t, err, "each host has its own budget")
+	})
+
+	t.Run("waits for the next window when there's time", func(t *testing.T) {
+		throttles := make([]Throttle, 0)
+		limiter := &Limiter{
+			Store:      newMemoryLimits(),
+			TokenLimit: Limit{Requests: 1, Window: 20 * time.Millisecond},
+			MaxWait:    time.Second,
+			OnThrottle: func(ctx context.Context, th Throttle) { throttles = append(throttles, th) },
+		}
+		send := limiter.Limit(ok)
+
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil)
+			r.Header.Set("Authorization", "Bearer access")
+			_, err := send(r)
+			require.NoError(t, err)
+		}
+
+		require.Len(t, throttles, 1)
+		assert.False(t, throttles[0].Rejected)
+		assert.Equal(t, LimitKindToken, throttles[0].Key.Kind)
+		assert.NotContains(t, throttles[0].Key.ID, "access", "tokens are hashed")
+	})
+
+	t.Run("honors the server's rate limit headers", func(t *testing.T) {
+		store := newMemoryLimits()
+		limiter := &Limiter{Store: store, TokenLimit: Limit{Requests: 100, Window: time.Hour}}
+		reset := time.Now().Add(time.Hour).Unix()
+
+		send := limiter.Limit(func(r *http.Request) (*http.Response, error) {
+			recorder := httptest.NewRecorder()
+			recorder.Header().Set("X-RateLimit-Limit", "100")
+			recorder.Header().Set("X-RateLimit-Remaining", "0")
+			recorder.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+			return recorder.Result(), nil
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "https://api.test/trip/", nil)
+		r.Header.Set("Authorization", "Bearer access")
+		_, err := send(r)
+		require.NoError(t, err)
+
+		_, err = send(r)
+		assert.True(t, errors.Is(err, ErrRateLimited), "the spent budget isn't used")
+
+		_, tokenKey := limitKeys(r)
+		assert.Equal(t, time.Unix(reset, 0), store.blocked[*tokenKey])
+	})
+}
+
+// failingLimits is a LimitStore whose table is unavailable
+type failingLimits struct{}
+
+func (failingLimits) TakeLimit(ctx context.Context, key LimitKey, limit Limit, now time.Time) (bool, time.Time, error) {
+	return false, time.Time{}, errors.New("table unavailable")
+}
+
+func (failingLimits) BlockLimit(ctx context.Context, key LimitKey, until time.Time) error {
+	return errors.New("table unavailable")
+}
+
+func TestLimiterWithSend(t *testing.T) {
+	t.Run("rejections don't retry or open the circuit", func(t *testing.T) {
+		breakers := NewBreakers(1, time.Minute)
+		attempts := 0
+		counted := func(r *http.Request) (*http.Response, error) {
+			attempts++
+			return ok(r)
+		}
+
+		for _, limiter := range []*Limiter{
+			{Store: newMemoryLimits(), HostLimit: Limit{Requests: 1, Window: time.Hour}},
+			{Store: failingLimits{}, HostLimit: Limit{Requests: 1, Window: time.Hour}},
+		} {
+			send := limiter.Limit(counted)
+			for i := 0; i < 3; i++ {
+				Send(httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil), testPolicy, breakers, send)
+			}
+			assert.False(t, breakers.Open("api.test"), "the host's circuit stays closed")
+		}
+		assert.Equal(t, 1, attempts, "rejected requests aren't retried")
+
+		_, err := Send(httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil), testPolicy, breakers, (&Limiter{Store: failingLimits{}, HostLimit: Limit{Requests: 1, Window: time.Hour}}).Limit(counted))
+		assert.EqualError(t, err, "table unavailable")
+	})
+
+	t.Run("returns the response when its rate limit headers can't be recorded", func(t *testing.T) {
+		blockErrors := make([]error, 0)
+		limiter := &Limiter{
+			Store:        failingLimits{},
+			OnBlockError: func(ctx context.Context, key LimitKey, err error) { blockErrors = append(blockErrors, err) },
+		}
+		send := limiter.Limit(func(r *http.Request) (*http.Response, error) {
+			recorder := httptest.NewRecorder()
+			recorder.Header().Set("X-RateLimit-Remaining", "0")
+			recorder.Header().Set("X-RateLimit-Reset", "60")
+			recorder.WriteString(`{"refresh_token":"rotated"}`)
+			return recorder.Result(), nil
+		})
+
+		response, err := send(httptest.NewRequest(http.MethodPost, "https://accounts.test/oauth/access_token/", nil))
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(response.Body)
+		assert.Equal(t, `{"refresh_token":"rotated"}`, string(body))
+		assert.Len(t, blockErrors, 1)
+	})
+}
@@ -0,0 +1,222 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	jitter = func(d time.Duration) time.Duration { return d }
+}
+
+var testPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// respond returns a SendFunc that answers with each status in turn and counts attempts
+func respond(attempts *int, statuses ...int) SendFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		status := statuses[*attempts]
+		*attempts++
+		recorder := httptest.NewRecorder()
+		recorder.WriteHeader(status)
+		return recorder.Result(), nil
+	}
+}
+
+func TestSend(t *testing.T) {
+	t.Run("retries idempotent requests on 5xx", func(t *testing.T) {
+		attempts := 0
+		r := httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil)
+
+		response, err := Send(r, testPolicy, nil, respond(&attempts, 503, 502, 200))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("returns the last response when attempts run out", func(t *testing.T) {
+		attempts := 0
+		r := httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil)
+
+		response, err := Send(r, testPolicy, nil, respond(&attempts, 500, 500, 500, 200))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, response.StatusCode)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("doesn't retry a POST or a 4xx", func(t *testing.T) {
+		attempts := 0
+		r := httptest.NewRequest(http.MethodPost, "https://api.test/oauth/access_token/", strings.NewReader("{}"))
+		_, err := Send(r, testPolicy, nil, respond(&attempts, 503, 200))
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempts)
+
+		attempts = 0
+		r = httptest.NewRequest(http.MethodGet, "https://api.test/user/U_1/", nil)
+		_, err = Send(r, testPolicy, nil, respond(&attempts, 404, 200))
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("replays the body of a retried PUT", func(t *testing.T) {
+		bodies := make([]string, 0)
+		r, err := http.NewRequest(http.MethodPut, "https://api.test/thing", strings.NewReader("payload"))
+		require.NoError(t, err)
+
+		_, err = Send(r, testPolicy, nil, func(r *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			if len(bodies) == 1 {
+				return nil, errors.New("connection reset")
+			}
+			return httptest.NewRecorder().Result(), nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"payload", "payload"}, bodies)
+	})
+
+	t.Run("honors Retry-After", func(t *testing.T) {
+		attempts := 0
+		waited := time.Duration(0)
+		started := time.Now()
+		send := func(r *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts == 2 {
+				waited = time.Since(started)
+				return httptest.NewRecorder().Result(), nil
+			}
+			recorder := httptest.NewRecorder()
+			recorder.Header().Set("Retry-After", "0")
+			recorder.WriteHeader(http.StatusTooManyRequests)
+			return recorder.Result(), nil
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "https://api.test/trip/", nil)
+		response, err := Send(r, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}, nil, send)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.True(t, waited < time.Second, "Retry-After replaces the backoff")
+
+		// A longer wait than the policy allows returns the 429 instead
+		attempts = 0
+		retryLater := func(r *http.Request) (*http.Response, error) {
+			attempts++
+			recorder := httptest.NewRecorder()
+			recorder.Header().Set("Retry-After", "120")
+			recorder.WriteHeader(http.StatusTooManyRequests)
+			return recorder.Result(), nil
+		}
+		response, err = Send(r, testPolicy, nil, retryLater)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops when the context would end before the next attempt", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+
+		attempts := 0
+		r := httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil).WithContext(ctx)
+		response, err := Send(r, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Second}, nil, respond(&attempts, 503, 200))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestBreakers(t *testing.T) {
+	now := time.Now()
+	breakers := NewBreakers(2, time.Minute)
+	breakers.now = func() time.Time { return now }
+
+	attempts := 0
+	failing := func(r *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, errors.New("connection refused")
+	}
+	request := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil)
+	}
+	noRetry := RetryPolicy{}
+
+	_, err := Send(request(), noRetry, breakers, failing)
+	require.Error(t, err)
+	assert.False(t, breakers.Open("api.test"))
+
+	_, err = Send(request(), noRetry, breakers, failing)
+	require.Error(t, err)
+	assert.True(t, breakers.Open("api.test"), "opens after the threshold")
+
+	_, err = Send(request(), noRetry, breakers, failing)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, 2, attempts, "requests aren't sent while the circuit is open")
+
+	other := httptest.NewRequest(http.MethodGet, "https://accounts.test/", nil)
+	_, err = Send(other, noRetry, breakers, func(r *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	})
+	assert.NoError(t, err, "other hosts are unaffected")
+
+	now = now.Add(2 * time.Minute)
+	_, err = Send(request(), noRetry, breakers, failing)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrCircuitOpen), "a trial request is let through after the cooldown")
+	assert.True(t, breakers.Open("api.test"), "a failed trial opens the circuit again")
+
+	now = now.Add(2 * time.Minute)
+	_, err = Send(request(), noRetry, breakers, func(r *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	})
+	require.NoError(t, err)
+	assert.False(t, breakers.Open("api.test"), "a successful trial closes the circuit")
+}
+
+func TestBreakersIgnoreCancelledRequests(t *testing.T) {
+	now := time.Now()
+	breakers := NewBreakers(2, time.Minute)
+	breakers.now = func() time.Time { return now }
+
+	failing := func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}
+	// cancelled is a request whose caller gives up while it's in flight
+	cancelled := func(r *http.Request) (*http.Response, error) {
+		return nil, context.Canceled
+	}
+	request := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "https://api.test/vehicle/", nil)
+	}
+	noRetry := RetryPolicy{}
+
+	_, err := Send(request(), noRetry, breakers, failing)
+	require.Error(t, err)
+
+	_, err = Send(request(), noRetry, breakers, cancelled)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	_, err = Send(request(), noRetry, breakers, failing)
+	require.Error(t, err)
+	assert.True(t, breakers.Open("api.test"), "a cancelled request doesn't clear the failure streak")
+
+	now = now.Add(2 * time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = Send(request().WithContext(ctx), noRetry, breakers, func(r *http.Request) (*http.Response, error) {
+		cancel()
+		return nil, r.Context().Err()
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	_, err = Send(request(), noRetry, breakers, failing)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrCircuitOpen), "the cancelled trial frees the slot for the next request")
+	assert.True(t, breakers.Open("api.test"), "the circuit was still half-open, so the failed trial opens it again")
+}
@@ -0,0 +1,190 @@
+// Package outbound makes the app's outbound HTTP requests resilient to a
+// failing upstream: idempotent requests are retried with jittered exponential
+// backoff, and a per-host circuit breaker stops sending requests to a host that
+// keeps failing so callers fail fast instead of waiting out their timeouts.
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SendFunc sends a single attempt of a request
+type SendFunc func(*http.Request) (*http.Response, error)
+
+// RetryPolicy decides which requests are retried and how long to wait between attempts
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt. Zero or one disables retries.
+	MaxAttempts int
+	// BaseDelay is the longest wait before the first retry. It doubles with every attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts, including one asked for by Retry-After
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy suits calls made while a user waits for a response
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// jitter returns a random duration in [0, d). It's a variable so tests can make backoff predictable.
+var jitter = func(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Send sends the request, retrying idempotent requests that fail with a
+// network error, a 429 or a 5xx. The last response or error is returned once
+// attempts run out, or when the request's context would end before the next
+// attempt could start.
+//
+// Breakers is optional. When it's set, requests to a host whose circuit is open
+// fail with ErrCircuitOpen without being sent. Requests a Limiter holds back
+// aren't retried and don't count for or against the host's circuit, and
+// neither do requests whose context is cancelled or times out.
+func Send(r *http.Request, policy RetryPolicy, breakers *Breakers, send SendFunc) (*http.Response, error) {
+	retryable := isIdempotent(r) && (r.Body == nil || r.Body == http.NoBody || r.GetBody != nil)
+
+	for attempt := 1; ; attempt++ {
+		if err := breakers.allow(r.URL.Host); err != nil {
+			return nil, err
+		}
+
+		response, err := send(r)
+		if errors.As(err, new(*limitError)) {
+			// The limiter held the request back, so it was never sent. Waiting
+			// for budget already happened there, and the host's health is unknown.
+			breakers.skip(r.URL.Host)
+			return nil, err
+		}
+		if err != nil && isCancelled(r.Context(), err) {
+			// The caller gave up, which says nothing about the host. The host's
+			// state is left as it was, and a trial request frees its slot.
+			breakers.skip(r.URL.Host)
+			return nil, err
+		}
+		breakers.record(r.URL.Host, isFailure(response, err))
+
+		if !retryable || attempt >= policy.MaxAttempts || !shouldRetry(r.Context(), response, err) {
+			return response, err
+		}
+
+		delay, ok := policy.delay(attempt, response)
+		if !ok || !fitsDeadline(r.Context(), delay) {
+			return response, err
+		}
+
+		if response != nil {
+			drain(response)
+		}
+		if err := sleep(r.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		if r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+	}
+}
+
+// delay returns how long to wait before the next attempt. It's false when the
+// server asked for a longer wait than the policy allows.
+func (p RetryPolicy) delay(attempt int, response *http.Response) (time.Duration, bool) {
+	if response != nil {
+		if after, ok := retryAfter(response); ok {
+			return after, p.MaxDelay <= 0 || after <= p.MaxDelay
+		}
+	}
+
+	backoff := p.BaseDelay << uint(attempt-1)
+	if p.MaxDelay > 0 && (backoff > p.MaxDelay || backoff <= 0) {
+		backoff = p.MaxDelay
+	}
+
+	return jitter(backoff), true
+}
+
+// retryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return r.Header.Get("Idempotency-Key") != ""
+	}
+}
+
+func shouldRetry(ctx context.Context, response *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+// isFailure returns true when the host looks down. A 429 means the host is up
+// and asking for fewer requests.
+func isFailure(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return response.StatusCode >= 500
+}
+
+// isCancelled returns true when the request failed because its context ended
+func isCancelled(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func fitsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > delay
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// drain reads what's left of a discarded response so its connection can be reused
+func drain(response *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+	response.Body.Close()
+}
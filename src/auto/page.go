@@ -0,0 +1,227 @@
+package auto
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+// Page sizes
+const (
+	// DefaultPageLimit is the number of items in a page when no limit is asked for
+	DefaultPageLimit = 25
+	// MaxPageLimit is the most items a page can hold
+	MaxPageLimit = 100
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't issued for the listing it's used with, or was altered
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest asks for one page of a listing
+type PageRequest struct {
+	// Limit is the most items returned. Zero is DefaultPageLimit, and anything
+	// over MaxPageLimit is MaxPageLimit.
+	Limit int
+	// Cursor is the Next of the page before, or empty for the first page
+	Cursor string
+	// Descending lists the items in reverse
+	Descending bool
+}
+
+// Page is where a listing continues
+type Page struct {
+	// Next is the cursor for the following page. It's empty on the last page.
+	Next string
+}
+
+// pageQuery is a listing: the items in a partition whose sort key in the index
+// starts with the prefix, in the index's order. The zero index is the table.
+type pageQuery struct {
+	HashKey string
+	Index   keys.Index
+	Prefix  string
+}
+
+func (q pageQuery) rangeAttribute() string {
+	if q.Index.Name == "" {
+		return keys.RangeKeyAttribute
+	}
+	return q.Index.RangeAttribute
+}
+
+// scope identifies the listing a cursor was issued for, so it can't be used to
+// continue another account's listing or the same listing in another order
+func (q pageQuery) scope(descending bool) string {
+	return strings.Join([]string{q.HashKey, q.Index.Name, q.Prefix, fmt.Sprint(descending)}, "\x00")
+}
+
+// queryPage returns one page of the listing. Items read through a keys only
+// index are fetched from the table, so they always have every attribute.
+func (s *DynamoStore) queryPage(ctx context.Context, q pageQuery, page PageRequest) ([]map[string]*dynamodb.AttributeValue, Page, error) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	} else if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	secret, err := s.cursorSigning()
+	if err != nil {
+		return nil, Page{}, err
+	}
+	scope := q.scope(page.Descending)
+
+	input := &dynamodb.QueryInput{
+		TableName:              s.table,
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(keys.HashKeyAttribute),
+			"#sk": aws.String(q.rangeAttribute()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(q.HashKey)},
+			":sk": {S: aws.String(q.Prefix)},
+		},
+		ScanIndexForward: aws.Bool(!page.Descending),
+		Limit:            aws.Int64(int64(limit)),
+	}
+	if q.Index.Name != "" {
+		input.IndexName = aws.String(q.Index.Name)
+	}
+	if page.Cursor != "" {
+		start, err := decodeCursor(secret, scope, page.Cursor)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		start[keys.HashKeyAttribute] = &dynamodb.AttributeValue{S: aws.String(q.HashKey)}
+		input.ExclusiveStartKey = start
+	}
+
+	output, err := s.db.QueryWithContext(ctx, input)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	items := output.Items
+	if q.Index.KeysOnly {
+		if items, err = s.batchGet(ctx, items); err != nil {
+			return nil, Page{}, err
+		}
+	}
+
+	next := Page{}
+	if len(output.LastEvaluatedKey) > 0 {
+		if next.Next, err = encodeCursor(secret, scope, output.LastEvaluatedKey); err != nil {
+			return nil, Page{}, err
+		}
+	}
+
+	return items, next, nil
+}
+
+// batchGet returns the table's items for the keys, in the same order. Keys whose item is gone are skipped.
+func (s *DynamoStore) batchGet(ctx context.Context, itemKeys []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	if len(itemKeys) == 0 {
+		return itemKeys, nil
+	}
+
+	id := func(item map[string]*dynamodb.AttributeValue) string {
+		return StringFromDynamo(item[keys.HashKeyAttribute]) + "\x00" + StringFromDynamo(item[keys.RangeKeyAttribute])
+	}
+
+	request := make([]map[string]*dynamodb.AttributeValue, len(itemKeys))
+	for i, key := range itemKeys {
+		request[i] = PrimaryKey{
+			HashKey: StringFromDynamo(key[keys.HashKeyAttribute]),
+			SortKey: StringFromDynamo(key[keys.RangeKeyAttribute]),
+		}.Dynamo()
+	}
+
+	found := make(map[string]map[string]*dynamodb.AttributeValue, len(itemKeys))
+	pending := map[string]*dynamodb.KeysAndAttributes{aws.StringValue(s.table): {Keys: request}}
+	for len(pending) > 0 {
+		output, err := s.db.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range output.Responses[aws.StringValue(s.table)] {
+			found[id(item)] = item
+		}
+		pending = output.UnprocessedKeys
+	}
+
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(itemKeys))
+	for _, key := range itemKeys {
+		if item, ok := found[id(key)]; ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// encodeCursor returns the opaque cursor for the key a page ended at. The
+// table's hash key is left out; it's the listing's, and is put back when the
+// cursor is decoded. The rest is signed with the scope so it can't be altered.
+func encodeCursor(secret, scope string, key map[string]*dynamodb.AttributeValue) (string, error) {
+	position := make(map[string]string, len(key))
+	for name, value := range key {
+		if name == keys.HashKeyAttribute {
+			continue
+		}
+		if value == nil || value.S == nil {
+			return "", fmt.Errorf("cursor: %s isn't a string", name)
+		}
+		position[name] = *value.S
+	}
+
+	data, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(cursorSignature(secret, scope, payload)), nil
+}
+
+// decodeCursor returns the key a cursor encodes, without the table's hash key
+func decodeCursor(secret, scope, cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, cursorSignature(secret, scope, parts[0])) {
+		return nil, ErrInvalidCursor
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	position := map[string]string{}
+	if err := json.Unmarshal(data, &position); err != nil || position[keys.RangeKeyAttribute] == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]*dynamodb.AttributeValue, len(position)+1)
+	for name, value := range position {
+		key[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+	}
+	return key, nil
+}
+
+func cursorSignature(secret, scope, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("cursor\x00" + scope + "\x00" + payload))
+	return mac.Sum(nil)
+}
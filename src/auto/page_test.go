@@ -0,0 +1,148 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListReminders(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	// Created oldest first, but due in the reverse order, with one undated reminder first
+	completed := time.Now().Add(-time.Hour)
+	require.NoError(t, store.SaveReminder(ctx, &Reminder{ID: "rem:0", AccountID: "auid:1", VehicleID: "veh:2", Title: "Tires", IntervalDistance: 10000}))
+	for i := 1; i <= 4; i++ {
+		vehicleID := "veh:1"
+		if i%2 == 0 {
+			vehicleID = "veh:2"
+		}
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{
+			ID:              fmt.Sprintf("rem:%d", i),
+			AccountID:       "auid:1",
+			VehicleID:       vehicleID,
+			Title:           "Oil",
+			IntervalDays:    10 - i,
+			LastCompletedAt: completed,
+		}))
+	}
+	require.NoError(t, store.SaveReminder(ctx, &Reminder{ID: "rem:9", AccountID: "auid:2", Title: "Oil", IntervalDays: 1}))
+
+	// list follows every page of the listing and returns the IDs in order
+	list := func(listing ReminderListing) ([]string, int) {
+		ids := make([]string, 0)
+		pages := 0
+		for {
+			reminders, page, err := store.ListReminders(ctx, "auid:1", listing)
+			require.NoError(t, err)
+			pages++
+			for _, r := range reminders {
+				ids = append(ids, r.ID)
+			}
+			if page.Next == "" {
+				return ids, pages
+			}
+			listing.Cursor = page.Next
+		}
+	}
+
+	t.Run("pages through the account's reminders", func(t *testing.T) {
+		ids, pages := list(ReminderListing{PageRequest: PageRequest{Limit: 2}})
+		assert.Equal(t, []string{"rem:0", "rem:1", "rem:2", "rem:3", "rem:4"}, ids)
+		assert.Equal(t, 3, pages)
+
+		ids, _ = list(ReminderListing{PageRequest: PageRequest{Limit: 2, Descending: true}})
+		assert.Equal(t, []string{"rem:4", "rem:3", "rem:2", "rem:1", "rem:0"}, ids)
+	})
+
+	t.Run("sorts by when reminders are due", func(t *testing.T) {
+		ids, _ := list(ReminderListing{PageRequest: PageRequest{Limit: 2}, Sort: ReminderSortDue})
+		assert.Equal(t, []string{"rem:4", "rem:3", "rem:2", "rem:1", "rem:0"}, ids)
+
+		reminders, _, err := store.ListReminders(ctx, "auid:1", ReminderListing{Sort: ReminderSortDue})
+		require.NoError(t, err)
+		assert.Equal(t, "Oil", reminders[0].Title, "reminders read through the keys only index have every attribute")
+	})
+
+	t.Run("filters by vehicle", func(t *testing.T) {
+		ids, _ := list(ReminderListing{PageRequest: PageRequest{Limit: 1}, VehicleID: "veh:2"})
+		assert.Equal(t, []string{"rem:0", "rem:2", "rem:4"}, ids)
+
+		_, _, err := store.ListReminders(ctx, "auid:1", ReminderListing{VehicleID: "veh:2", Sort: ReminderSortDue})
+		assert.True(t, errors.Is(err, ErrUnsupportedListing))
+	})
+
+	t.Run("dismissing moves a reminder in the due order", func(t *testing.T) {
+		reminder, err := store.FindReminder(ctx, "auid:1", "rem:4")
+		require.NoError(t, err)
+		reminder.Dismiss()
+		require.NoError(t, store.SaveReminder(ctx, reminder))
+
+		ids, _ := list(ReminderListing{Sort: ReminderSortDue})
+		assert.Equal(t, []string{"rem:3", "rem:2", "rem:1", "rem:4", "rem:0"}, ids)
+	})
+
+	t.Run("rejects cursors issued for another listing", func(t *testing.T) {
+		_, page, err := store.ListReminders(ctx, "auid:1", ReminderListing{PageRequest: PageRequest{Limit: 1}})
+		require.NoError(t, err)
+		require.NotEmpty(t, page.Next)
+
+		for name, listing := range map[string]ReminderListing{
+			"another order":   {PageRequest: PageRequest{Cursor: page.Next, Descending: true}},
+			"another index":   {PageRequest: PageRequest{Cursor: page.Next}, Sort: ReminderSortDue},
+			"another vehicle": {PageRequest: PageRequest{Cursor: page.Next}, VehicleID: "veh:1"},
+		} {
+			_, _, err := store.ListReminders(ctx, "auid:1", listing)
+			assert.Equal(t, ErrInvalidCursor, err, name)
+		}
+
+		_, _, err = store.ListReminders(ctx, "auid:2", ReminderListing{PageRequest: PageRequest{Cursor: page.Next}})
+		assert.Equal(t, ErrInvalidCursor, err, "another account")
+	})
+
+	t.Run("rejects altered cursors", func(t *testing.T) {
+		position, err := encodeCursor(fakeSecrets.Signing, pageQuery{HashKey: "auid:1"}.scope(false), map[string]*dynamodb.AttributeValue{"SK": {S: aws.String("reminder/rem:1")}})
+		require.NoError(t, err)
+		forged, err := encodeCursor("guessed", pageQuery{HashKey: "auid:1"}.scope(false), map[string]*dynamodb.AttributeValue{"SK": {S: aws.String("reminder/rem:1")}})
+		require.NoError(t, err)
+
+		parts := strings.Split(position, ".")
+		for _, cursor := range []string{forged, "garbage", parts[0], parts[0] + ".", "e30." + parts[1]} {
+			_, _, err := store.ListReminders(ctx, "auid:1", ReminderListing{PageRequest: PageRequest{Cursor: cursor}})
+			assert.Equal(t, ErrInvalidCursor, err, cursor)
+		}
+	})
+}
+
+func TestListVehicles(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	for i := 0; i < MaxPageLimit+1; i++ {
+		require.NoError(t, store.SaveVehicle(ctx, &Vehicle{ID: fmt.Sprintf("veh:%03d", i), AccountID: "auid:1", Make: "Subaru", Model: "Outback"}))
+	}
+
+	vehicles, page, err := store.ListVehicles(ctx, "auid:1", PageRequest{})
+	require.NoError(t, err)
+	assert.Len(t, vehicles, DefaultPageLimit)
+	assert.NotEmpty(t, page.Next)
+
+	vehicles, page, err = store.ListVehicles(ctx, "auid:1", PageRequest{Limit: MaxPageLimit * 2})
+	require.NoError(t, err)
+	assert.Len(t, vehicles, MaxPageLimit, "limits are capped")
+
+	vehicles, page, err = store.ListVehicles(ctx, "auid:1", PageRequest{Cursor: page.Next})
+	require.NoError(t, err)
+	require.Len(t, vehicles, 1)
+	assert.Equal(t, "veh:100", vehicles[0].ID)
+	assert.Empty(t, page.Next)
+}
@@ -0,0 +1,123 @@
+package auto
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
+	"github.com/maddiesch/serverless/amazon"
+)
+
+// TakeRateLimit takes one request from an outbound rate limit budget. Every
+// Lambda takes from the same item, so they share the budget.
+//
+// The budget is a counter for the current window. Incrementing it and starting
+// a new window are separate conditional updates, so two Lambdas racing to start
+// a window can't both reset the count.
+func (s *DynamoStore) TakeRateLimit(ctx context.Context, key outbound.LimitKey, limit outbound.Limit, now time.Time) (bool, time.Time, error) {
+	primary, err := keys.RateLimit(key.Kind, key.ID)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	window := now.Truncate(limit.Window)
+
+	names := map[string]*string{
+		"#window":  aws.String("Window"),
+		"#count":   aws.String("Count"),
+		"#blocked": aws.String("BlockedUntil"),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":window": DynamoTime(window),
+		":now":    DynamoTime(now),
+		":one":    DynamoInt(1),
+	}
+	notBlocked := "(attribute_not_exists(#blocked) OR #blocked <= :now)"
+
+	counted := map[string]*dynamodb.AttributeValue{":limit": DynamoInt(limit.Requests)}
+	for k, v := range values {
+		counted[k] = v
+	}
+	_, err = s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 s.table,
+		Key:                       primary.Dynamo(),
+		UpdateExpression:          aws.String("ADD #count :one"),
+		ConditionExpression:       aws.String("#window = :window AND #count < :limit AND " + notBlocked),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: counted,
+	})
+	if err == nil {
+		return true, time.Time{}, nil
+	} else if !amazon.IsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return false, time.Time{}, err
+	}
+
+	started := map[string]*dynamodb.AttributeValue{":expires": DynamoTime(window.Add(2 * limit.Window))}
+	for k, v := range values {
+		started[k] = v
+	}
+	names["#expires"] = aws.String(TimeToLiveAttribute)
+	_, err = s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 s.table,
+		Key:                       primary.Dynamo(),
+		UpdateExpression:          aws.String("SET #window = :window, #count = :one, #expires = :expires"),
+		ConditionExpression:       aws.String("(attribute_not_exists(#window) OR #window < :window) AND " + notBlocked),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: started,
+	})
+	if err == nil {
+		return true, time.Time{}, nil
+	} else if !amazon.IsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return false, time.Time{}, err
+	}
+
+	item, err := s.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      s.table,
+		Key:            primary.Dynamo(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	retryAt := now
+	if blocked := TimeFromDynamo(item.Item["BlockedUntil"]); blocked.After(retryAt) {
+		retryAt = blocked
+	}
+	if TimeFromDynamo(item.Item["Window"]).Equal(window) && IntFromDynamo(item.Item["Count"]) >= limit.Requests {
+		if end := window.Add(limit.Window); end.After(retryAt) {
+			retryAt = end
+		}
+	}
+
+	return false, retryAt, nil
+}
+
+// BlockRateLimit stops requests being taken from a budget until the passed time. An earlier block never shortens a later one.
+func (s *DynamoStore) BlockRateLimit(ctx context.Context, key outbound.LimitKey, until time.Time) error {
+	primary, err := keys.RateLimit(key.Kind, key.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           s.table,
+		Key:                 primary.Dynamo(),
+		UpdateExpression:    aws.String("SET #blocked = :until, #expires = :until"),
+		ConditionExpression: aws.String("attribute_not_exists(#blocked) OR #blocked < :until"),
+		ExpressionAttributeNames: map[string]*string{
+			"#blocked": aws.String("BlockedUntil"),
+			"#expires": aws.String(TimeToLiveAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": DynamoTime(until),
+		},
+	})
+	if amazon.IsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,59 @@
+package auto
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	key := outbound.LimitKey{Kind: outbound.LimitKindHost, ID: "api.automatic.com"}
+	limit := outbound.Limit{Requests: 2, Window: time.Minute}
+	now := time.Date(2019, 9, 1, 10, 0, 10, 0, time.UTC)
+
+	t.Run("takes from the budget until it's spent", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			ok, _, err := store.TakeRateLimit(ctx, key, limit, now)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		}
+
+		ok, retryAt, err := store.TakeRateLimit(ctx, key, limit, now)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, time.Date(2019, 9, 1, 10, 1, 0, 0, time.UTC), retryAt.UTC())
+	})
+
+	t.Run("starts a new budget every window", func(t *testing.T) {
+		ok, _, err := store.TakeRateLimit(ctx, key, limit, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("keeps budgets apart", func(t *testing.T) {
+		ok, _, err := store.TakeRateLimit(ctx, outbound.LimitKey{Kind: outbound.LimitKindToken, ID: "abc"}, limit, now)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("blocks a budget until the passed time", func(t *testing.T) {
+		later := now.Add(2 * time.Minute)
+		require.NoError(t, store.BlockRateLimit(ctx, key, later.Add(30*time.Second)))
+		require.NoError(t, store.BlockRateLimit(ctx, key, later.Add(10*time.Second)), "an earlier block is ignored")
+
+		ok, retryAt, err := store.TakeRateLimit(ctx, key, limit, later)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, later.Add(30*time.Second), retryAt.UTC())
+
+		ok, _, err = store.TakeRateLimit(ctx, key, limit, later.Add(30*time.Second))
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
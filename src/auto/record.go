@@ -3,25 +3,18 @@ package auto
 import (
 	"errors"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
 )
 
 var ErrRecordNotFound = errors.New("record not found")
 
-// PrimaryKey contains the compound key for a records primary key
-type PrimaryKey struct {
-	HashKey string
-	SortKey string
-}
+// ErrRecordConflict is returned when a record changed between being read and written.
+// Read it again and reapply the change.
+var ErrRecordConflict = errors.New("record changed since it was read")
 
-func (p PrimaryKey) Dynamo() map[string]*dynamodb.AttributeValue {
-	return map[string]*dynamodb.AttributeValue{
-		"PK": {S: aws.String(p.HashKey)},
-		"SK": {S: aws.String(p.SortKey)},
-	}
-}
+// PrimaryKey contains the compound key for a records primary key
+type PrimaryKey = keys.Primary
 
 type Record interface {
-	PrimaryKey() PrimaryKey
+	PrimaryKey() (PrimaryKey, error)
 }
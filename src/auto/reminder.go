@@ -0,0 +1,386 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/serverless"
+	"github.com/maddiesch/serverless/amazon"
+)
+
+// ReminderStatus is the result of evaluating a reminder
+type ReminderStatus string
+
+const (
+	// ReminderStatusOK means the reminder isn't due yet
+	ReminderStatusOK ReminderStatus = "OK"
+	// ReminderStatusDue means the reminder is due and should be sent
+	ReminderStatusDue ReminderStatus = "DUE"
+	// ReminderStatusSnoozed means the reminder is due but has been snoozed
+	ReminderStatusSnoozed ReminderStatus = "SNOOZED"
+)
+
+// Reminder is a recurring maintenance item for a vehicle.
+//
+// A reminder repeats every IntervalDays and/or every IntervalDistance
+// kilometers from when it was last completed, whichever comes first.
+type Reminder struct {
+	ID                    string
+	AccountID             string `json:"-"`
+	VehicleID             string
+	Title                 string  `validate:"required,max=128"`
+	IntervalDays          int     `validate:"min=0"`
+	IntervalDistance      float64 `validate:"min=0"`
+	LastCompletedAt       time.Time
+	LastCompletedOdometer float64
+	SkippedOccurrences    int
+	SnoozedUntil          time.Time
+	SnoozedUntilOdometer  float64
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// Reminder listing orders
+const (
+	// ReminderSortCreated lists reminders oldest first
+	ReminderSortCreated = "created"
+	// ReminderSortDue lists reminders by when they're next due, soonest first.
+	// Reminders without a time interval come last.
+	ReminderSortDue = "due"
+)
+
+// ErrUnsupportedListing is returned for a filter and order that can't be read from a single index
+var ErrUnsupportedListing = errors.New("unsupported listing")
+
+// ReminderListing selects the reminders ListReminders returns
+type ReminderListing struct {
+	PageRequest
+	// VehicleID only lists the vehicle's reminders. They're read through LSI1, so they're only in created order.
+	VehicleID string
+	// Sort is ReminderSortCreated, the default, or ReminderSortDue, which is read through LSI2
+	Sort string
+}
+
+// FindReminder returns the account's reminder with the passed ID
+func (s *DynamoStore) FindReminder(ctx context.Context, accountID, reminderID string) (*Reminder, error) {
+	key, err := keys.Reminder(accountID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.getItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return reminderFromDynamo(item), nil
+}
+
+// AccountReminders returns all of the account's reminders
+func (s *DynamoStore) AccountReminders(ctx context.Context, accountID string) ([]*Reminder, error) {
+	reminders := make([]*Reminder, 0)
+
+	err := s.queryPrefix(ctx, accountID, keys.ReminderSortKey.Prefix(), func(item map[string]*dynamodb.AttributeValue) {
+		reminders = append(reminders, reminderFromDynamo(item))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+// ListReminders returns a page of the account's reminders
+func (s *DynamoStore) ListReminders(ctx context.Context, accountID string, listing ReminderListing) ([]*Reminder, Page, error) {
+	q := pageQuery{HashKey: accountID, Prefix: keys.ReminderSortKey.Prefix()}
+
+	switch {
+	case listing.VehicleID != "" && listing.Sort == ReminderSortDue:
+		return nil, Page{}, fmt.Errorf("%w: a vehicle's reminders can't be sorted by due", ErrUnsupportedListing)
+	case listing.VehicleID != "":
+		prefix, err := keys.ReminderVehicleIndexSortKey.BuildPrefix(listing.VehicleID)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		q.Index = keys.LSI1
+		q.Prefix = prefix
+	case listing.Sort == ReminderSortDue:
+		q.Index = keys.LSI2
+		q.Prefix = keys.ReminderDueIndexSortKey.Prefix()
+	case listing.Sort != "" && listing.Sort != ReminderSortCreated:
+		return nil, Page{}, fmt.Errorf("%w: unknown sort %q", ErrUnsupportedListing, listing.Sort)
+	}
+
+	items, page, err := s.queryPage(ctx, q, listing.PageRequest)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	reminders := make([]*Reminder, len(items))
+	for i, item := range items {
+		reminders[i] = reminderFromDynamo(item)
+	}
+
+	return reminders, page, nil
+}
+
+// SaveReminder validates and writes the reminder.
+//
+// A reminder that was read from the store is only written if it hasn't changed
+// since, and ErrRecordConflict is returned if it has. A new reminder, one
+// without an UpdatedAt, is only written if it doesn't exist yet.
+func (s *DynamoStore) SaveReminder(ctx context.Context, r *Reminder) error {
+	if err := serverless.GetValidator().Struct(r); err != nil {
+		return err
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	if r.LastCompletedAt.IsZero() {
+		r.LastCompletedAt = r.CreatedAt
+	}
+
+	previous := r.UpdatedAt
+	r.UpdatedAt = time.Now()
+	// UpdatedAt is stored to the second, so it has to move for the next write's condition to notice this one
+	if !previous.IsZero() && r.UpdatedAt.Unix() <= previous.Unix() {
+		r.UpdatedAt = time.Unix(previous.Unix()+1, 0)
+	}
+
+	item, err := r.dynamo()
+	if err != nil {
+		r.UpdatedAt = previous
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:                s.table,
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+		ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+	}
+	if !previous.IsZero() {
+		input.ConditionExpression = aws.String("#updated = :previous")
+		input.ExpressionAttributeNames = map[string]*string{"#updated": aws.String("UpdatedAt")}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":previous": DynamoTime(previous)}
+	}
+
+	_, err = s.db.PutItemWithContext(ctx, input)
+	if amazon.IsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException) {
+		r.UpdatedAt = previous
+		return ErrRecordConflict
+	}
+
+	return err
+}
+
+// CreateReminders validates and writes a set of new reminders in a single transaction
+func (s *DynamoStore) CreateReminders(ctx context.Context, reminders []*Reminder) error {
+	items := make([]*dynamodb.TransactWriteItem, len(reminders))
+
+	for i, r := range reminders {
+		if err := serverless.GetValidator().Struct(r); err != nil {
+			return err
+		}
+		r.CreatedAt = time.Now()
+		r.UpdatedAt = r.CreatedAt
+		if r.LastCompletedAt.IsZero() {
+			r.LastCompletedAt = r.CreatedAt
+		}
+
+		item, err := r.dynamo()
+		if err != nil {
+			return err
+		}
+
+		items[i] = &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:                s.table,
+				Item:                     item,
+				ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+				ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+			},
+		}
+	}
+
+	_, err := s.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	return err
+}
+
+// PrimaryKey returns the primary key for DynamoDB
+func (r *Reminder) PrimaryKey() (PrimaryKey, error) {
+	return keys.Reminder(r.AccountID, r.ID)
+}
+
+// NextDueAt returns when the current occurrence is due, or the zero time if the reminder has no time interval
+func (r *Reminder) NextDueAt() time.Time {
+	if r.IntervalDays == 0 {
+		return time.Time{}
+	}
+	return r.LastCompletedAt.AddDate(0, 0, r.IntervalDays*(r.SkippedOccurrences+1))
+}
+
+// NextDueOdometer returns the odometer reading the current occurrence is due at, or 0 if the reminder has no distance interval
+func (r *Reminder) NextDueOdometer() float64 {
+	if r.IntervalDistance == 0 {
+		return 0
+	}
+	return r.LastCompletedOdometer + r.IntervalDistance*float64(r.SkippedOccurrences+1)
+}
+
+// IsDue returns true if the current occurrence is due, ignoring any snooze
+func (r *Reminder) IsDue(now time.Time, odometer float64) bool {
+	if due := r.NextDueAt(); !due.IsZero() && !now.Before(due) {
+		return true
+	}
+	if due := r.NextDueOdometer(); due > 0 && odometer >= due {
+		return true
+	}
+	return false
+}
+
+// IsSnoozed returns true if the reminder has been snoozed past now.
+//
+// When a reminder is snoozed by both time and distance the snooze ends at
+// whichever comes first.
+func (r *Reminder) IsSnoozed(now time.Time, odometer float64) bool {
+	byTime := !r.SnoozedUntil.IsZero()
+	byDistance := r.SnoozedUntilOdometer > 0
+	if !byTime && !byDistance {
+		return false
+	}
+
+	return (!byTime || now.Before(r.SnoozedUntil)) && (!byDistance || odometer < r.SnoozedUntilOdometer)
+}
+
+// Evaluate returns the status of the reminder at the passed time and odometer reading
+func (r *Reminder) Evaluate(now time.Time, odometer float64) ReminderStatus {
+	if !r.IsDue(now, odometer) {
+		return ReminderStatusOK
+	}
+	if r.IsSnoozed(now, odometer) {
+		return ReminderStatusSnoozed
+	}
+	return ReminderStatusDue
+}
+
+// Snooze holds the reminder until the passed time and/or odometer reading.
+// A zero value for either leaves that half of the snooze unset.
+func (r *Reminder) Snooze(until time.Time, untilOdometer float64) {
+	r.SnoozedUntil = until
+	r.SnoozedUntilOdometer = untilOdometer
+}
+
+// Dismiss skips the current occurrence without resetting the interval
+func (r *Reminder) Dismiss() {
+	r.SkippedOccurrences++
+	r.Snooze(time.Time{}, 0)
+}
+
+// DigestItem returns the notification digest entry for the reminder.
+//
+// False is returned if the reminder is snoozed, or if it has no time interval and isn't due by distance.
+func (r *Reminder) DigestItem(now time.Time, odometer float64) (DigestItem, bool) {
+	if r.IsSnoozed(now, odometer) {
+		return DigestItem{}, false
+	}
+
+	if due := r.NextDueOdometer(); due > 0 && odometer >= due {
+		return DigestItem{
+			Title:  r.Title,
+			Detail: fmt.Sprintf("Due at %.0f km", due),
+			DueAt:  now,
+		}, true
+	}
+
+	due := r.NextDueAt()
+	if due.IsZero() {
+		return DigestItem{}, false
+	}
+
+	return DigestItem{Title: r.Title, DueAt: due}, true
+}
+
+// keyItem returns the reminder's primary key and its keys in the indexes it's
+// listed through. Reminders without a vehicle aren't in the vehicle index.
+func (r *Reminder) keyItem() (keys.Item, error) {
+	primary, err := r.PrimaryKey()
+	if err != nil {
+		return keys.Item{}, err
+	}
+
+	due, err := keys.ReminderDueIndex(r.NextDueAt(), r.ID)
+	if err != nil {
+		return keys.Item{}, err
+	}
+	item := keys.Item{Primary: primary, Indexes: []keys.IndexKey{due}}
+
+	if r.VehicleID != "" {
+		vehicle, err := keys.ReminderVehicleIndex(r.VehicleID, r.ID)
+		if err != nil {
+			return keys.Item{}, err
+		}
+		item.Indexes = append(item.Indexes, vehicle)
+	}
+
+	return item, nil
+}
+
+func (r *Reminder) dynamo() (map[string]*dynamodb.AttributeValue, error) {
+	keyItem, err := r.keyItem()
+	if err != nil {
+		return nil, err
+	}
+	item, err := keyItem.Attributes()
+	if err != nil {
+		return nil, err
+	}
+
+	item["ID"] = &dynamodb.AttributeValue{S: aws.String(r.ID)}
+	item["Title"] = &dynamodb.AttributeValue{S: aws.String(r.Title)}
+	item["IntervalDays"] = DynamoInt(int64(r.IntervalDays))
+	item["IntervalDistance"] = DynamoFloat(r.IntervalDistance)
+	item["LastCompletedAt"] = DynamoTime(r.LastCompletedAt)
+	item["LastCompletedOdometer"] = DynamoFloat(r.LastCompletedOdometer)
+	item["SkippedOccurrences"] = DynamoInt(int64(r.SkippedOccurrences))
+	item["CreatedAt"] = DynamoTime(r.CreatedAt)
+	item["UpdatedAt"] = DynamoTime(r.UpdatedAt)
+
+	if r.VehicleID != "" {
+		item["VehicleID"] = &dynamodb.AttributeValue{S: aws.String(r.VehicleID)}
+	}
+	if !r.SnoozedUntil.IsZero() {
+		item["SnoozedUntil"] = DynamoTime(r.SnoozedUntil)
+	}
+	if r.SnoozedUntilOdometer > 0 {
+		item["SnoozedUntilOdometer"] = DynamoFloat(r.SnoozedUntilOdometer)
+	}
+
+	return item, nil
+}
+
+func reminderFromDynamo(item map[string]*dynamodb.AttributeValue) *Reminder {
+	return &Reminder{
+		ID:                    parsedID(keys.ReminderSortKey, item),
+		AccountID:             StringFromDynamo(item[keys.HashKeyAttribute]),
+		VehicleID:             StringFromDynamo(item["VehicleID"]),
+		Title:                 StringFromDynamo(item["Title"]),
+		IntervalDays:          int(IntFromDynamo(item["IntervalDays"])),
+		IntervalDistance:      FloatFromDynamo(item["IntervalDistance"]),
+		LastCompletedAt:       TimeFromDynamo(item["LastCompletedAt"]),
+		LastCompletedOdometer: FloatFromDynamo(item["LastCompletedOdometer"]),
+		SkippedOccurrences:    int(IntFromDynamo(item["SkippedOccurrences"])),
+		SnoozedUntil:          TimeFromDynamo(item["SnoozedUntil"]),
+		SnoozedUntilOdometer:  FloatFromDynamo(item["SnoozedUntilOdometer"]),
+		CreatedAt:             TimeFromDynamo(item["CreatedAt"]),
+		UpdatedAt:             TimeFromDynamo(item["UpdatedAt"]),
+	}
+}
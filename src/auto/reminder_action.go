@@ -0,0 +1,92 @@
+package auto
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Actions taken on a reminder by the one-click links embedded in notifications
+const (
+	ReminderActionSnooze  = "snooze"
+	ReminderActionDismiss = "dismiss"
+
+	// ReminderActionSnoozeDays is how long a digest's snooze link snoozes the reminder for
+	ReminderActionSnoozeDays = 7
+
+	// ActionTokenAudience is the audience of action links, so they're never accepted as API tokens
+	ActionTokenAudience = "autorem://api/v1/actions"
+
+	actionTokenLifetime = 30 * 24 * time.Hour
+)
+
+// ReminderActionClaims are carried by the signed links embedded in notifications.
+//
+// Occurrence pins the link to the occurrence it was sent for, so following a
+// dismiss link twice doesn't skip two occurrences. Updated pins a snooze link
+// to the reminder as it was when the link was sent, so the link is only used
+// once and replaying it doesn't push the snooze further out.
+type ReminderActionClaims struct {
+	jwt.StandardClaims
+	ReminderID string `json:"rid"`
+	Action     string `json:"act"`
+	Occurrence int    `json:"occ"`
+	Updated    int64  `json:"upd,omitempty"`
+	SnoozeDays int    `json:"snd,omitempty"`
+}
+
+// NewReminderActionToken returns a signed token for a link that takes the action on the reminder
+func NewReminderActionToken(r *Reminder, action string, snoozeDays int) (string, error) {
+	secrets, err := Secrets()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := ReminderActionClaims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(actionTokenLifetime).Unix(),
+			Issuer:    APITokenIssuer,
+			Audience:  ActionTokenAudience,
+			Subject:   r.AccountID,
+			IssuedAt:  now.Unix(),
+		},
+		ReminderID: r.ID,
+		Action:     action,
+		Occurrence: r.SkippedOccurrences,
+		SnoozeDays: snoozeDays,
+	}
+	if action == ReminderActionSnooze {
+		claims.Updated = r.UpdatedAt.Unix()
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(secrets.Signing))
+}
+
+// ReminderActionURL returns the API URL that follows the action link
+func ReminderActionURL(token string) string {
+	return fmt.Sprintf("%s/v1/actions/%s", strings.TrimSuffix(DefaultConfig().APIBaseURL, "/"), token)
+}
+
+// ReminderDigestActions returns the reminder's one-click snooze & dismiss
+// links. It's the DigestActions digests are sent with.
+func ReminderDigestActions(r *Reminder) ([]DigestAction, error) {
+	snooze, err := NewReminderActionToken(r, ReminderActionSnooze, ReminderActionSnoozeDays)
+	if err != nil {
+		return nil, err
+	}
+	dismiss, err := NewReminderActionToken(r, ReminderActionDismiss, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return []DigestAction{
+		{Title: fmt.Sprintf("Snooze for %d days", ReminderActionSnoozeDays), URL: ReminderActionURL(snooze)},
+		{Title: "Dismiss", URL: ReminderActionURL(dismiss)},
+	}, nil
+}
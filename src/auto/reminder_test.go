@@ -0,0 +1,78 @@
+package auto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReminderEvaluate(t *testing.T) {
+	completed := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	newReminder := func() *Reminder {
+		return &Reminder{
+			Title:                 "Oil change",
+			IntervalDays:          90,
+			IntervalDistance:      5000,
+			LastCompletedAt:       completed,
+			LastCompletedOdometer: 20000,
+		}
+	}
+
+	t.Run("is due by time or distance, whichever comes first", func(t *testing.T) {
+		r := newReminder()
+
+		assert.Equal(t, ReminderStatusOK, r.Evaluate(completed.AddDate(0, 0, 30), 21000))
+		assert.Equal(t, ReminderStatusDue, r.Evaluate(completed.AddDate(0, 0, 90), 21000))
+		assert.Equal(t, ReminderStatusDue, r.Evaluate(completed.AddDate(0, 0, 30), 25000))
+	})
+
+	t.Run("snoozing by time", func(t *testing.T) {
+		r := newReminder()
+		r.Snooze(completed.AddDate(0, 0, 100), 0)
+
+		assert.Equal(t, ReminderStatusSnoozed, r.Evaluate(completed.AddDate(0, 0, 95), 21000))
+		assert.Equal(t, ReminderStatusDue, r.Evaluate(completed.AddDate(0, 0, 100), 21000))
+	})
+
+	t.Run("snoozing by distance", func(t *testing.T) {
+		r := newReminder()
+		r.Snooze(time.Time{}, 25500)
+
+		assert.Equal(t, ReminderStatusSnoozed, r.Evaluate(completed.AddDate(0, 0, 30), 25100))
+		assert.Equal(t, ReminderStatusDue, r.Evaluate(completed.AddDate(0, 0, 30), 25500))
+	})
+
+	t.Run("a time and distance snooze ends at whichever comes first", func(t *testing.T) {
+		r := newReminder()
+		r.Snooze(completed.AddDate(0, 0, 120), 25500)
+
+		assert.Equal(t, ReminderStatusDue, r.Evaluate(completed.AddDate(0, 0, 100), 25600))
+		assert.Equal(t, ReminderStatusDue, r.Evaluate(completed.AddDate(0, 0, 121), 25100))
+	})
+
+	t.Run("dismissing skips one occurrence without resetting the interval", func(t *testing.T) {
+		r := newReminder()
+		r.Snooze(completed.AddDate(0, 0, 100), 0)
+		r.Dismiss()
+
+		assert.True(t, r.SnoozedUntil.IsZero())
+		assert.Equal(t, completed, r.LastCompletedAt)
+		assert.Equal(t, completed.AddDate(0, 0, 180), r.NextDueAt())
+		assert.Equal(t, float64(30000), r.NextDueOdometer())
+		assert.Equal(t, ReminderStatusOK, r.Evaluate(completed.AddDate(0, 0, 95), 26000))
+	})
+
+	t.Run("snoozed reminders are left out of digests", func(t *testing.T) {
+		r := newReminder()
+		now := completed.AddDate(0, 0, 95)
+
+		_, ok := r.DigestItem(now, 21000)
+		assert.True(t, ok)
+
+		r.Snooze(completed.AddDate(0, 0, 100), 0)
+		_, ok = r.DigestItem(now, 21000)
+		assert.False(t, ok)
+	})
+}
@@ -1,12 +1,20 @@
 package auto
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ssm"
-	"github.com/maddiesch/serverless"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/maddiesch/serverless/amazon"
 )
 
@@ -15,53 +23,255 @@ type Secret struct {
 	ClientID     string
 	ClientSecret string
 	Signing      string
+	// ContactKeying keys the HMACs contacts are stored under. It's separate from
+	// Signing and must never be rotated, or stored contacts can't be found.
+	ContactKeying string
 }
 
+// ErrSecretMissing is returned for a secret that doesn't exist or is empty
+var ErrSecretMissing = errors.New("secret missing")
+
+// SecretProvider looks secrets up by name
+type SecretProvider interface {
+	// GetSecrets returns the value of every name. A name that doesn't exist is
+	// an ErrSecretMissing error, but an existing empty value isn't.
+	GetSecrets(ctx context.Context, names ...string) (map[string]string, error)
+}
+
+// SSMSecrets reads secrets from SSM parameters, decrypting SecureStrings
+type SSMSecrets struct {
+	Client ssmiface.SSMAPI
+}
+
+// GetSecrets reads the parameters with the names
+func (s SSMSecrets) GetSecrets(ctx context.Context, names ...string) (map[string]string, error) {
+	output, err := s.Client.GetParametersWithContext(ctx, &ssm.GetParametersInput{
+		Names:          aws.StringSlice(names),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.InvalidParameters) > 0 {
+		return nil, missingSecrets(aws.StringValueSlice(output.InvalidParameters))
+	}
+
+	values := make(map[string]string, len(output.Parameters))
+	for _, param := range output.Parameters {
+		values[aws.StringValue(param.Name)] = aws.StringValue(param.Value)
+	}
+	return values, nil
+}
+
+// EnvSecrets reads secrets from the environment variables with the names
+type EnvSecrets struct{}
+
+// GetSecrets reads the environment variables
+func (EnvSecrets) GetSecrets(_ context.Context, names ...string) (map[string]string, error) {
+	return lookupSecrets(names, os.LookupEnv)
+}
+
+// FileSecrets reads secrets from a JSON object of names to values. The file is
+// read on every call, so a rotated file is picked up.
+type FileSecrets struct {
+	Path string
+}
+
+// GetSecrets reads the file
+func (f FileSecrets) GetSecrets(_ context.Context, names ...string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := map[string]string{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("secrets file %s: %w", f.Path, err)
+	}
+
+	return lookupSecrets(names, func(name string) (string, bool) {
+		value, ok := file[name]
+		return value, ok
+	})
+}
+
+// StaticSecrets is a fixed set of secrets
+type StaticSecrets map[string]string
+
+// GetSecrets returns the secrets with the names
+func (s StaticSecrets) GetSecrets(_ context.Context, names ...string) (map[string]string, error) {
+	return lookupSecrets(names, func(name string) (string, bool) {
+		value, ok := s[name]
+		return value, ok
+	})
+}
+
+func lookupSecrets(names []string, lookup func(string) (string, bool)) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	missing := make([]string, 0)
+	for _, name := range names {
+		value, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		values[name] = value
+	}
+	if len(missing) > 0 {
+		return nil, missingSecrets(missing)
+	}
+	return values, nil
+}
+
+func missingSecrets(names []string) error {
+	sort.Strings(names)
+	return fmt.Errorf("%w: %s", ErrSecretMissing, strings.Join(names, ", "))
+}
+
+// CachedSecrets keeps the secrets a provider returns for a time, so they
+// aren't fetched for every request but rotated values are picked up. When a
+// refresh fails the expired values keep being used until one succeeds.
+type CachedSecrets struct {
+	Provider SecretProvider
+	TTL      time.Duration
+
+	mu        sync.Mutex
+	values    map[string]string
+	fetchedAt time.Time
+	now       func() time.Time
+}
+
+// GetSecrets returns the cached values, fetching them all again when any has expired or isn't cached
+func (c *CachedSecrets) GetSecrets(ctx context.Context, names ...string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := make(map[string]string, len(names))
+	for _, name := range names {
+		value, ok := c.values[name]
+		if !ok {
+			cached = nil
+			break
+		}
+		cached[name] = value
+	}
+
+	if cached != nil && c.clock().Sub(c.fetchedAt) < c.TTL {
+		return cached, nil
+	}
+
+	values, err := c.Provider.GetSecrets(ctx, names...)
+	if err != nil {
+		if cached != nil {
+			DefaultLogger().Error(ctx, "refreshing secrets failed, using the expired ones", Fields{"error": err})
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	if c.values == nil {
+		c.values = map[string]string{}
+	}
+	for name, value := range values {
+		c.values[name] = value
+	}
+	c.fetchedAt = c.clock()
+
+	return values, nil
+}
+
+func (c *CachedSecrets) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Values of the fake secrets, used outside production
+var fakeSecrets = Secret{
+	ClientID:     "fake-client-id",
+	ClientSecret: "fake-client-secret",
+	Signing:      "super-sekret",
+	// ContactKeying is the fake contact keying secret
+	ContactKeying: "fake-contact-keying",
+}
+
+// secretsTimeout caps fetching the secrets. The fetch is shared by every request
+// waiting on it, so it isn't tied to any one request's context.
+const secretsTimeout = 5 * time.Second
+
 var (
-	secretsInstance Secret
+	secretsInstance SecretProvider
 	secretsSetup    sync.Once
 )
 
-// Secrets returns the shared secrets for the app
-func Secrets() Secret {
+// DefaultSecretProvider returns the shared provider the config's secrets source picks, cached for the config's TTL
+func DefaultSecretProvider() SecretProvider {
 	secretsSetup.Do(func() {
-		if os.Getenv("RETURN_FAKE_SECRETS") == "true" {
-			secretsInstance = Secret{
-				ClientID:     "fake-client-id",
-				ClientSecret: "fake-client-secret",
-				Signing:      "super-sekret",
-			}
-			return
-		}
-
-		client := ssm.New(amazon.BaseSession())
-
-		output, err := client.GetParameters(&ssm.GetParametersInput{
-			Names: aws.StringSlice([]string{
-				os.Getenv("SECRETS_CLIENT_ID_PARAMETER_NAME"),
-				os.Getenv("SECRETS_CLIENT_SECRET_PARAMETER_NAME"),
-				os.Getenv("SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME"),
-			}),
-		})
-		if err != nil {
-			serverless.GetLogger().Fatal(err)
-		}
+		config := DefaultConfig().Secrets
 
-		secrets := Secret{}
-
-		for _, param := range output.Parameters {
-			switch aws.StringValue(param.Name) {
-			case os.Getenv("SECRETS_CLIENT_ID_PARAMETER_NAME"):
-				secrets.ClientID = aws.StringValue(param.Value)
-			case os.Getenv("SECRETS_CLIENT_SECRET_PARAMETER_NAME"):
-				secrets.ClientSecret = aws.StringValue(param.Value)
-			case os.Getenv("SECRETS_PRODUCTION_SIGNING_SECRET_PARAMETER_NAME"):
-				secrets.Signing = aws.StringValue(param.Value)
-			default:
+		var provider SecretProvider
+		switch config.Source {
+		case SecretSourceFake:
+			provider = StaticSecrets{
+				config.ClientIDName:      fakeSecrets.ClientID,
+				config.ClientSecretName:  fakeSecrets.ClientSecret,
+				config.SigningName:       fakeSecrets.Signing,
+				config.ContactKeyingName: fakeSecrets.ContactKeying,
 			}
+		case SecretSourceEnv:
+			provider = EnvSecrets{}
+		case SecretSourceFile:
+			provider = FileSecrets{Path: config.File}
+		default:
+			provider = SSMSecrets{Client: ssm.New(amazon.BaseSession())}
 		}
 
-		secretsInstance = secrets
+		secretsInstance = &CachedSecrets{Provider: provider, TTL: config.TTL}
 	})
 	return secretsInstance
 }
+
+// SetDefaultSecretProvider replaces the shared provider. It must be called before secrets are used.
+func SetDefaultSecretProvider(p SecretProvider) {
+	secretsSetup.Do(func() {})
+	secretsInstance = p
+}
+
+// Secrets returns the app's secrets. It fails with ErrSecretMissing if any is missing or empty.
+func Secrets() (Secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+
+	return loadSecrets(ctx, DefaultSecretProvider(), DefaultConfig().Secrets)
+}
+
+func loadSecrets(ctx context.Context, provider SecretProvider, config SecretsConfig) (Secret, error) {
+	values, err := provider.GetSecrets(ctx, config.ClientIDName, config.ClientSecretName, config.SigningName, config.ContactKeyingName)
+	if err != nil {
+		return Secret{}, fmt.Errorf("secrets: %w", err)
+	}
+
+	secret := Secret{
+		ClientID:      values[config.ClientIDName],
+		ClientSecret:  values[config.ClientSecretName],
+		Signing:       values[config.SigningName],
+		ContactKeying: values[config.ContactKeyingName],
+	}
+
+	empty := make([]string, 0)
+	for name, value := range map[string]string{
+		config.ClientIDName:      secret.ClientID,
+		config.ClientSecretName:  secret.ClientSecret,
+		config.SigningName:       secret.Signing,
+		config.ContactKeyingName: secret.ContactKeying,
+	} {
+		if value == "" {
+			empty = append(empty, name)
+		}
+	}
+	if len(empty) > 0 {
+		return Secret{}, fmt.Errorf("secrets: %w", missingSecrets(empty))
+	}
+
+	return secret, nil
+}
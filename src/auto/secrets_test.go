@@ -0,0 +1,154 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	params map[string]string
+	input  *ssm.GetParametersInput
+}
+
+func (f *fakeSSM) GetParametersWithContext(_ aws.Context, input *ssm.GetParametersInput, _ ...request.Option) (*ssm.GetParametersOutput, error) {
+	f.input = input
+
+	output := &ssm.GetParametersOutput{}
+	for _, name := range aws.StringValueSlice(input.Names) {
+		if value, ok := f.params[name]; ok {
+			output.Parameters = append(output.Parameters, &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)})
+		} else {
+			output.InvalidParameters = append(output.InvalidParameters, aws.String(name))
+		}
+	}
+	return output, nil
+}
+
+// countingSecrets counts fetches and fails them when err is set
+type countingSecrets struct {
+	StaticSecrets
+	fetches int
+	err     error
+}
+
+func (c *countingSecrets) GetSecrets(ctx context.Context, names ...string) (map[string]string, error) {
+	c.fetches++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.StaticSecrets.GetSecrets(ctx, names...)
+}
+
+func TestSecretProviders(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("reads and decrypts SSM parameters", func(t *testing.T) {
+		client := &fakeSSM{params: map[string]string{"/auto/signing": "signing"}}
+
+		values, err := SSMSecrets{Client: client}.GetSecrets(ctx, "/auto/signing")
+		require.NoError(t, err)
+		assert.Equal(t, "signing", values["/auto/signing"])
+		assert.True(t, aws.BoolValue(client.input.WithDecryption))
+
+		_, err = SSMSecrets{Client: client}.GetSecrets(ctx, "/auto/signing", "/auto/client-id")
+		assert.True(t, errors.Is(err, ErrSecretMissing))
+		assert.Contains(t, err.Error(), "/auto/client-id")
+	})
+
+	t.Run("reads environment variables", func(t *testing.T) {
+		os.Setenv("AUTO_SECRETS_TEST_VALUE", "from-env")
+		defer os.Unsetenv("AUTO_SECRETS_TEST_VALUE")
+
+		values, err := EnvSecrets{}.GetSecrets(ctx, "AUTO_SECRETS_TEST_VALUE")
+		require.NoError(t, err)
+		assert.Equal(t, "from-env", values["AUTO_SECRETS_TEST_VALUE"])
+
+		_, err = EnvSecrets{}.GetSecrets(ctx, "AUTO_SECRETS_TEST_MISSING")
+		assert.True(t, errors.Is(err, ErrSecretMissing))
+	})
+
+	t.Run("reads the file again to pick up rotated values", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "secrets")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "secrets.json")
+		provider := FileSecrets{Path: path}
+
+		require.NoError(t, ioutil.WriteFile(path, []byte(`{"SIGNING_SECRET":"first"}`), 0600))
+		values, err := provider.GetSecrets(ctx, "SIGNING_SECRET")
+		require.NoError(t, err)
+		assert.Equal(t, "first", values["SIGNING_SECRET"])
+
+		require.NoError(t, ioutil.WriteFile(path, []byte(`{"SIGNING_SECRET":"second"}`), 0600))
+		values, err = provider.GetSecrets(ctx, "SIGNING_SECRET")
+		require.NoError(t, err)
+		assert.Equal(t, "second", values["SIGNING_SECRET"])
+	})
+}
+
+func TestCachedSecrets(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	upstream := &countingSecrets{StaticSecrets: StaticSecrets{"SIGNING_SECRET": "first"}}
+	cache := &CachedSecrets{Provider: upstream, TTL: time.Minute, now: func() time.Time { return now }}
+
+	get := func() string {
+		values, err := cache.GetSecrets(ctx, "SIGNING_SECRET")
+		require.NoError(t, err)
+		return values["SIGNING_SECRET"]
+	}
+
+	assert.Equal(t, "first", get())
+	upstream.StaticSecrets["SIGNING_SECRET"] = "rotated"
+	assert.Equal(t, "first", get(), "values are cached until the TTL passes")
+	assert.Equal(t, 1, upstream.fetches)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "rotated", get(), "rotated values are picked up once the TTL passes")
+	assert.Equal(t, 2, upstream.fetches)
+
+	now = now.Add(time.Minute)
+	upstream.err = errors.New("ssm is down")
+	assert.Equal(t, "rotated", get(), "expired values are used while a refresh fails")
+
+	_, err := cache.GetSecrets(ctx, "AUTOMATIC_CLIENT_ID")
+	assert.EqualError(t, err, "ssm is down", "there's nothing to fall back to for a secret that's never been fetched")
+}
+
+func TestLoadSecrets(t *testing.T) {
+	ctx := context.Background()
+	config := SecretsConfig{ClientIDName: "AUTOMATIC_CLIENT_ID", ClientSecretName: "AUTOMATIC_CLIENT_SECRET", SigningName: "SIGNING_SECRET", ContactKeyingName: "CONTACT_KEYING_SECRET"}
+
+	secret, err := loadSecrets(ctx, StaticSecrets{
+		"AUTOMATIC_CLIENT_ID":     "client-id",
+		"AUTOMATIC_CLIENT_SECRET": "client-secret",
+		"SIGNING_SECRET":          "signing",
+		"CONTACT_KEYING_SECRET":   "contact-keying",
+	}, config)
+	require.NoError(t, err)
+	assert.Equal(t, Secret{ClientID: "client-id", ClientSecret: "client-secret", Signing: "signing", ContactKeying: "contact-keying"}, secret)
+
+	_, err = loadSecrets(ctx, StaticSecrets{
+		"AUTOMATIC_CLIENT_ID":     "client-id",
+		"AUTOMATIC_CLIENT_SECRET": "client-secret",
+		"SIGNING_SECRET":          "",
+		"CONTACT_KEYING_SECRET":   "contact-keying",
+	}, config)
+	assert.True(t, errors.Is(err, ErrSecretMissing), "an empty signing secret is never used")
+	assert.EqualError(t, err, "secrets: secret missing: SIGNING_SECRET")
+}
@@ -0,0 +1,117 @@
+package auto
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
+)
+
+// AccountStore persists accounts
+type AccountStore interface {
+	FindAccount(ctx context.Context, accountID string) (*Account, error)
+	FindAccountByAutomaticID(ctx context.Context, automaticID string) (*Account, error)
+
+	// SaveAutomaticAuthentication writes the account, a newly issued Automatic
+	// token and any contacts together, so a failed sign in leaves nothing behind.
+	SaveAutomaticAuthentication(ctx context.Context, account *Account, token AutomaticAccessToken, contacts []*Contact) error
+
+	// RevokeSessions rejects every API token issued to the account so far and returns when they were revoked
+	RevokeSessions(ctx context.Context, accountID string) (time.Time, error)
+}
+
+// TokenStore persists Automatic access tokens
+type TokenStore interface {
+	LatestAutomaticAccessToken(ctx context.Context, account *Account) (*AutomaticAccessToken, error)
+	SaveAutomaticAccessToken(ctx context.Context, account *Account, token AutomaticAccessToken) error
+}
+
+// ContactStore persists the ways an account can be contacted
+type ContactStore interface {
+	AccountContacts(ctx context.Context, accountID string) ([]*Contact, error)
+}
+
+// OAuthStateStore persists the state of in-flight OAuth requests
+type OAuthStateStore interface {
+	CreateOAuthState(ctx context.Context, s *OAuthState) error
+	FindOAuthState(ctx context.Context, state string) (*OAuthState, error)
+	DeleteOAuthState(ctx context.Context, state string) error
+}
+
+// NotificationStore persists notification preferences
+type NotificationStore interface {
+	FindNotificationPreferences(ctx context.Context, accountID string) (*NotificationPreferences, error)
+	SaveNotificationPreferences(ctx context.Context, account *Account, prefs *NotificationPreferences) error
+
+	// RecordDigestDelivery sets the account's LastDigestAt, or clears it for the zero time, unless another digest was recorded since the account was read
+	RecordDigestDelivery(ctx context.Context, account *Account, deliveredAt time.Time) error
+}
+
+// ReminderStore persists reminders
+type ReminderStore interface {
+	FindReminder(ctx context.Context, accountID, reminderID string) (*Reminder, error)
+	AccountReminders(ctx context.Context, accountID string) ([]*Reminder, error)
+	ListReminders(ctx context.Context, accountID string, listing ReminderListing) ([]*Reminder, Page, error)
+	SaveReminder(ctx context.Context, r *Reminder) error
+	CreateReminders(ctx context.Context, reminders []*Reminder) error
+}
+
+// VehicleStore persists vehicles
+type VehicleStore interface {
+	FindVehicle(ctx context.Context, accountID, vehicleID string) (*Vehicle, error)
+	AccountVehicles(ctx context.Context, accountID string) ([]*Vehicle, error)
+	ListVehicles(ctx context.Context, accountID string, page PageRequest) ([]*Vehicle, Page, error)
+	SaveVehicle(ctx context.Context, v *Vehicle) error
+}
+
+// OdometerStore persists odometer timelines
+type OdometerStore interface {
+	OdometerTimeline(ctx context.Context, accountID, vehicleID string) ([]*OdometerReading, error)
+	LatestOdometerReading(ctx context.Context, accountID, vehicleID string) (*OdometerReading, error)
+	AddOdometerReading(ctx context.Context, r *OdometerReading) error
+	AddTripOdometerReading(ctx context.Context, accountID, vehicleID, tripID string, endedAt time.Time, distance float64) (*OdometerReading, error)
+}
+
+// RateLimitStore persists the outbound rate limit budgets every Lambda shares
+type RateLimitStore interface {
+	TakeRateLimit(ctx context.Context, key outbound.LimitKey, limit outbound.Limit, now time.Time) (bool, time.Time, error)
+	BlockRateLimit(ctx context.Context, key outbound.LimitKey, until time.Time) error
+}
+
+// Store is everything the app reads from and writes to storage.
+//
+// Lookups return ErrRecordNotFound when nothing matches, and listings return
+// ErrInvalidCursor for a cursor that wasn't issued for them. Every method takes
+// the context of the work it's done for, and stops when the context is done.
+type Store interface {
+	AccountStore
+	TokenStore
+	ContactStore
+	OAuthStateStore
+	NotificationStore
+	ReminderStore
+	VehicleStore
+	OdometerStore
+	RateLimitStore
+}
+
+var (
+	storeInstance Store
+	storeSetup    sync.Once
+)
+
+// DefaultStore returns the shared store. Unless another store has been set it's backed by the DynamoDB table.
+func DefaultStore() Store {
+	storeSetup.Do(func() {
+		storeInstance = NewDynamoStore(DynamoDB(), aws.StringValue(TableName()))
+	})
+	return storeInstance
+}
+
+// SetDefaultStore replaces the shared store. It must be called before the store is used.
+func SetDefaultStore(s Store) {
+	storeSetup.Do(func() {})
+	storeInstance = s
+}
@@ -0,0 +1,224 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamoStore(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	token := AutomaticAccessToken{
+		UserID:       "U_test",
+		AccessToken:  "access",
+		ExpiresIn:    3600,
+		Scope:        "scope:public scope:trip",
+		RefreshToken: "refresh",
+		TokenType:    "bearer",
+	}
+
+	account := &Account{ID: "auid:test", FirstName: "Testy"}
+
+	t.Run("saves an account with its token and contacts", func(t *testing.T) {
+		err := store.SaveAutomaticAuthentication(ctx, account, token, []*Contact{
+			{Type: ContactTypeEmail, Value: "test@email.test", ReceiveContact: true},
+		})
+		require.NoError(t, err)
+
+		found, err := store.FindAccountByAutomaticID(ctx, "U_test")
+		require.NoError(t, err)
+		assert.Equal(t, "Testy", found.FirstName)
+
+		latest, err := store.LatestAutomaticAccessToken(ctx, found)
+		require.NoError(t, err)
+		assert.Equal(t, "access", latest.AccessToken)
+		assert.Equal(t, token.Scope, latest.Scope)
+
+		indexed, err := store.db.Query(&dynamodb.QueryInput{
+			IndexName:                 aws.String(keys.GSI1.Name),
+			KeyConditionExpression:    aws.String("GSI1PK = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String(keys.AccessTokenIndexHashKey.MustBuild("U_test"))}},
+		})
+		require.NoError(t, err)
+		assert.Len(t, indexed.Items, 1, "tokens are indexed by Automatic user")
+
+		contacts, err := store.AccountContacts(ctx, account.ID)
+		require.NoError(t, err)
+		require.Len(t, contacts, 1)
+		assert.Equal(t, "test@email.test", contacts[0].Value)
+		assert.True(t, contacts[0].ReceiveContact)
+	})
+
+	t.Run("returns ErrRecordNotFound for missing records", func(t *testing.T) {
+		_, err := store.FindAccount(ctx, "auid:missing")
+		assert.Equal(t, ErrRecordNotFound, err)
+
+		_, err = store.FindAccountByAutomaticID(ctx, "U_missing")
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+
+	t.Run("returns the key error for IDs that can't be part of a key", func(t *testing.T) {
+		_, err := store.FindReminder(ctx, account.ID, "rem:1/x")
+		assert.True(t, errors.Is(err, keys.ErrInvalidPart))
+
+		_, err = store.OdometerTimeline(ctx, account.ID, "veh:1/x")
+		assert.True(t, errors.Is(err, keys.ErrInvalidPart))
+
+		err = store.SaveVehicle(ctx, &Vehicle{ID: "veh/1", AccountID: account.ID, Make: "Subaru", Model: "Outback"})
+		assert.True(t, errors.Is(err, keys.ErrInvalidPart))
+	})
+
+	t.Run("expired OAuth state can't be found", func(t *testing.T) {
+		require.NoError(t, store.CreateOAuthState(ctx, &OAuthState{State: "live"}))
+		require.NoError(t, store.CreateOAuthState(ctx, &OAuthState{State: "stale", ExpiresAt: time.Now().Add(-time.Minute)}))
+
+		_, err := store.FindOAuthState(ctx, "live")
+		assert.NoError(t, err)
+
+		_, err = store.FindOAuthState(ctx, "stale")
+		assert.Equal(t, ErrRecordNotFound, err)
+
+		require.NoError(t, store.DeleteOAuthState(ctx, "live"))
+		_, err = store.FindOAuthState(ctx, "live")
+		assert.Equal(t, ErrRecordNotFound, err)
+	})
+
+	t.Run("creating reminders is all or nothing", func(t *testing.T) {
+		existing := &Reminder{ID: "rem:1", AccountID: account.ID, Title: "Oil", IntervalDays: 90}
+		require.NoError(t, store.SaveReminder(ctx, existing))
+
+		err := store.CreateReminders(ctx, []*Reminder{
+			{ID: "rem:2", AccountID: account.ID, Title: "Tires", IntervalDays: 180},
+			{ID: "rem:1", AccountID: account.ID, Title: "Oil", IntervalDays: 90},
+		})
+		assert.Error(t, err)
+
+		reminders, err := store.AccountReminders(ctx, account.ID)
+		require.NoError(t, err)
+		assert.Len(t, reminders, 1)
+	})
+
+	t.Run("saving a reminder that changed since it was read conflicts", func(t *testing.T) {
+		require.NoError(t, store.SaveReminder(ctx, &Reminder{ID: "rem:3", AccountID: account.ID, Title: "Oil", IntervalDays: 90}))
+		assert.Equal(t, ErrRecordConflict, store.SaveReminder(ctx, &Reminder{ID: "rem:3", AccountID: account.ID, Title: "Oil", IntervalDays: 90}), "it exists already")
+
+		first, err := store.FindReminder(ctx, account.ID, "rem:3")
+		require.NoError(t, err)
+		second, err := store.FindReminder(ctx, account.ID, "rem:3")
+		require.NoError(t, err)
+
+		first.Dismiss()
+		require.NoError(t, store.SaveReminder(ctx, first))
+		second.Snooze(time.Now().AddDate(0, 0, 7), 0)
+		assert.Equal(t, ErrRecordConflict, store.SaveReminder(ctx, second))
+
+		stored, err := store.FindReminder(ctx, account.ID, "rem:3")
+		require.NoError(t, err)
+		assert.Equal(t, 1, stored.SkippedOccurrences)
+		assert.True(t, stored.SnoozedUntil.IsZero())
+
+		stored.Snooze(time.Now().AddDate(0, 0, 7), 0)
+		assert.NoError(t, store.SaveReminder(ctx, stored), "a reminder read again is saved")
+	})
+
+	t.Run("odometer readings must not decrease", func(t *testing.T) {
+		vehicle := &Vehicle{ID: "veh:1", AccountID: account.ID, Make: "Subaru", Model: "Outback"}
+		require.NoError(t, store.SaveVehicle(ctx, vehicle))
+
+		now := time.Now()
+		require.NoError(t, store.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 1000, ReadAt: now.Add(-48 * time.Hour), Source: OdometerSourceManual}))
+		require.NoError(t, store.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 1200, ReadAt: now, Source: OdometerSourceManual}))
+
+		err := store.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 1300, ReadAt: now.Add(-24 * time.Hour), Source: OdometerSourceManual})
+		assert.Equal(t, ErrOdometerNotMonotonic, err)
+
+		trip, err := store.AddTripOdometerReading(ctx, account.ID, vehicle.ID, "T_1", now.Add(-24*time.Hour), 50)
+		require.NoError(t, err)
+		assert.Equal(t, float64(1050), trip.Reading)
+
+		latest, err := store.LatestOdometerReading(ctx, account.ID, vehicle.ID)
+		require.NoError(t, err)
+		assert.Equal(t, float64(1200), latest.Reading)
+
+		timeline, err := store.OdometerTimeline(ctx, account.ID, vehicle.ID)
+		require.NoError(t, err)
+		assert.Len(t, timeline, 3)
+	})
+
+	t.Run("odometer readings in the same second don't collide", func(t *testing.T) {
+		vehicle := &Vehicle{ID: "veh:2", AccountID: account.ID, Make: "Subaru", Model: "Outback"}
+		require.NoError(t, store.SaveVehicle(ctx, vehicle))
+
+		now := time.Now()
+		for _, reading := range []float64{100, 100, 101} {
+			require.NoError(t, store.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: reading, ReadAt: now, Source: OdometerSourceManual}))
+		}
+
+		timeline, err := store.OdometerTimeline(ctx, account.ID, vehicle.ID)
+		require.NoError(t, err)
+		assert.Len(t, timeline, 3)
+	})
+
+	t.Run("odometer readings added since the neighbors were read conflict", func(t *testing.T) {
+		vehicle := &Vehicle{ID: "veh:3", AccountID: account.ID, Make: "Subaru", Model: "Outback"}
+		require.NoError(t, store.SaveVehicle(ctx, vehicle))
+		require.NoError(t, store.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 100, Source: OdometerSourceManual}))
+
+		// Another request adds a higher reading after this one has read the neighbors
+		db := &interleavedDB{DynamoDBAPI: store.db}
+		db.before = func() {
+			require.NoError(t, store.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 300, Source: OdometerSourceManual}))
+		}
+		racing := NewDynamoStore(db, aws.StringValue(store.table))
+
+		err := racing.AddOdometerReading(ctx, &OdometerReading{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 200, Source: OdometerSourceManual})
+		assert.Equal(t, ErrRecordConflict, err)
+
+		latest, err := store.LatestOdometerReading(ctx, account.ID, vehicle.ID)
+		require.NoError(t, err)
+		assert.Equal(t, float64(300), latest.Reading)
+	})
+
+	t.Run("notification preferences update the account's time zone", func(t *testing.T) {
+		prefs, err := store.FindNotificationPreferences(ctx, account.ID)
+		require.NoError(t, err)
+		assert.Equal(t, DigestFrequencyDaily, prefs.DigestFrequency)
+
+		account.TimeZone = "America/Denver"
+		prefs.DigestFrequency = DigestFrequencyWeekly
+		require.NoError(t, store.SaveNotificationPreferences(ctx, account, prefs))
+
+		found, err := store.FindAccount(ctx, account.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "America/Denver", found.TimeZone)
+
+		prefs, err = store.FindNotificationPreferences(ctx, account.ID)
+		require.NoError(t, err)
+		assert.Equal(t, DigestFrequencyWeekly, prefs.DigestFrequency)
+	})
+}
+
+// interleavedDB runs another write just before the next transaction, like a concurrent request would
+type interleavedDB struct {
+	dynamodbiface.DynamoDBAPI
+	before func()
+}
+
+func (d *interleavedDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, options ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if before := d.before; before != nil {
+		d.before = nil
+		before()
+	}
+	return d.DynamoDBAPI.TransactWriteItemsWithContext(ctx, input, options...)
+}
@@ -0,0 +1,72 @@
+package auto
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+// TimeToLiveAttribute is the attribute DynamoDB expires items by
+const TimeToLiveAttribute = "ExpiresAt"
+
+// CreateTableInput returns the definition of the app's table, built from the
+// indexes in the keys package. resources.yml declares the same table for
+// CloudFormation.
+func CreateTableInput(name string) *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(name),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		KeySchema:   keySchema(keys.HashKeyAttribute, keys.RangeKeyAttribute),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			stringAttribute(keys.HashKeyAttribute),
+			stringAttribute(keys.RangeKeyAttribute),
+		},
+	}
+
+	for _, index := range keys.Indexes {
+		projection := &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)}
+		if index.KeysOnly {
+			projection.ProjectionType = aws.String(dynamodb.ProjectionTypeKeysOnly)
+		}
+
+		if index.Local {
+			input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, &dynamodb.LocalSecondaryIndex{
+				IndexName:  aws.String(index.Name),
+				KeySchema:  keySchema(index.HashAttribute, index.RangeAttribute),
+				Projection: projection,
+			})
+		} else {
+			input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+				IndexName:  aws.String(index.Name),
+				KeySchema:  keySchema(index.HashAttribute, index.RangeAttribute),
+				Projection: projection,
+			})
+			input.AttributeDefinitions = append(input.AttributeDefinitions, stringAttribute(index.HashAttribute))
+		}
+		input.AttributeDefinitions = append(input.AttributeDefinitions, stringAttribute(index.RangeAttribute))
+	}
+
+	return input
+}
+
+// TimeToLiveInput returns the time to live setting for the app's table
+func TimeToLiveInput(name string) *dynamodb.UpdateTimeToLiveInput {
+	return &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(name),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(TimeToLiveAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	}
+}
+
+func keySchema(hash, rangeKey string) []*dynamodb.KeySchemaElement {
+	return []*dynamodb.KeySchemaElement{
+		{AttributeName: aws.String(hash), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		{AttributeName: aws.String(rangeKey), KeyType: aws.String(dynamodb.KeyTypeRange)},
+	}
+}
+
+func stringAttribute(name string) *dynamodb.AttributeDefinition {
+	return &dynamodb.AttributeDefinition{AttributeName: aws.String(name), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)}
+}
@@ -0,0 +1,43 @@
+package auto
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTableInput(t *testing.T) {
+	input := CreateTableInput("test")
+	require.NoError(t, input.Validate())
+
+	assert.Len(t, input.LocalSecondaryIndexes, 2)
+	assert.Len(t, input.GlobalSecondaryIndexes, 2)
+
+	t.Run("matches resources.yml", func(t *testing.T) {
+		data, err := ioutil.ReadFile("../../resources.yml")
+		require.NoError(t, err)
+
+		definitions := string(data)
+		definitions = definitions[strings.Index(definitions, "AttributeDefinitions:"):strings.Index(definitions, "KeySchema:")]
+
+		declared := make([]string, 0)
+		for _, match := range regexp.MustCompile(`AttributeName: (\w+)`).FindAllStringSubmatch(definitions, -1) {
+			declared = append(declared, match[1])
+		}
+
+		defined := make([]string, 0)
+		for _, attribute := range input.AttributeDefinitions {
+			defined = append(defined, aws.StringValue(attribute.AttributeName))
+		}
+
+		sort.Strings(declared)
+		sort.Strings(defined)
+		assert.Equal(t, declared, defined)
+	})
+}
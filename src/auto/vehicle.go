@@ -0,0 +1,170 @@
+package auto
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/automatic-reminders/auto/vin"
+	"github.com/maddiesch/serverless"
+)
+
+// Vehicle is a car belonging to an account
+type Vehicle struct {
+	ID          string
+	AccountID   string `json:"-"`
+	AutomaticID string `json:",omitempty"`
+	VIN         string `json:",omitempty"`
+	Make        string
+	Model       string
+	Submodel    string `json:",omitempty"`
+	Year        int
+	DisplayName string `json:",omitempty"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// FindVehicle returns the account's vehicle with the passed ID
+func (s *DynamoStore) FindVehicle(ctx context.Context, accountID, vehicleID string) (*Vehicle, error) {
+	key, err := keys.Vehicle(accountID, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.getItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return vehicleFromDynamo(item), nil
+}
+
+// AccountVehicles returns all of the account's vehicles
+func (s *DynamoStore) AccountVehicles(ctx context.Context, accountID string) ([]*Vehicle, error) {
+	vehicles := make([]*Vehicle, 0)
+
+	err := s.queryPrefix(ctx, accountID, keys.VehicleSortKey.Prefix(), func(item map[string]*dynamodb.AttributeValue) {
+		vehicles = append(vehicles, vehicleFromDynamo(item))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vehicles, nil
+}
+
+// ListVehicles returns a page of the account's vehicles, in ID order
+func (s *DynamoStore) ListVehicles(ctx context.Context, accountID string, page PageRequest) ([]*Vehicle, Page, error) {
+	items, next, err := s.queryPage(ctx, pageQuery{HashKey: accountID, Prefix: keys.VehicleSortKey.Prefix()}, page)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	vehicles := make([]*Vehicle, len(items))
+	for i, item := range items {
+		vehicles[i] = vehicleFromDynamo(item)
+	}
+
+	return vehicles, next, nil
+}
+
+// SaveVehicle validates and writes the vehicle
+func (s *DynamoStore) SaveVehicle(ctx context.Context, v *Vehicle) error {
+	if err := serverless.GetValidator().Struct(v); err != nil {
+		return err
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	v.UpdatedAt = time.Now()
+
+	item, err := v.dynamo()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: s.table,
+		Item:      item,
+	})
+
+	return err
+}
+
+// EnrichFromVIN fills in details missing from the vehicle using its VIN.
+//
+// A make that only differs from the decoded make by case or spacing is
+// replaced with the decoded make so vehicles are named consistently.
+func (v *Vehicle) EnrichFromVIN() error {
+	if v.VIN == "" {
+		return nil
+	}
+
+	decoded, err := vin.Decode(v.VIN)
+	if err != nil {
+		return err
+	}
+
+	v.VIN = decoded.VIN
+	if decoded.Make != "" && (v.Make == "" || strings.EqualFold(strings.TrimSpace(v.Make), decoded.Make)) {
+		v.Make = decoded.Make
+	}
+	if v.Year == 0 {
+		v.Year = decoded.ModelYear
+	}
+
+	return nil
+}
+
+// PrimaryKey returns the primary key for DynamoDB
+func (v *Vehicle) PrimaryKey() (PrimaryKey, error) {
+	return keys.Vehicle(v.AccountID, v.ID)
+}
+
+func (v *Vehicle) dynamo() (map[string]*dynamodb.AttributeValue, error) {
+	key, err := v.PrimaryKey()
+	if err != nil {
+		return nil, err
+	}
+
+	item := key.Dynamo()
+	item["ID"] = &dynamodb.AttributeValue{S: aws.String(v.ID)}
+	item["Year"] = DynamoInt(int64(v.Year))
+	item["CreatedAt"] = DynamoTime(v.CreatedAt)
+	item["UpdatedAt"] = DynamoTime(v.UpdatedAt)
+
+	optional := map[string]string{
+		"AutomaticID": v.AutomaticID,
+		"VIN":         v.VIN,
+		"Make":        v.Make,
+		"Model":       v.Model,
+		"Submodel":    v.Submodel,
+		"DisplayName": v.DisplayName,
+	}
+	for name, value := range optional {
+		if value != "" {
+			item[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+	}
+
+	return item, nil
+}
+
+func vehicleFromDynamo(item map[string]*dynamodb.AttributeValue) *Vehicle {
+	return &Vehicle{
+		ID:          parsedID(keys.VehicleSortKey, item),
+		AccountID:   StringFromDynamo(item[keys.HashKeyAttribute]),
+		AutomaticID: StringFromDynamo(item["AutomaticID"]),
+		VIN:         StringFromDynamo(item["VIN"]),
+		Make:        StringFromDynamo(item["Make"]),
+		Model:       StringFromDynamo(item["Model"]),
+		Submodel:    StringFromDynamo(item["Submodel"]),
+		Year:        int(IntFromDynamo(item["Year"])),
+		DisplayName: StringFromDynamo(item["DisplayName"]),
+		CreatedAt:   TimeFromDynamo(item["CreatedAt"]),
+		UpdatedAt:   TimeFromDynamo(item["UpdatedAt"]),
+	}
+}
@@ -0,0 +1,40 @@
+package auto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVehicleEnrichFromVIN(t *testing.T) {
+	t.Run("fills in missing details", func(t *testing.T) {
+		v := &Vehicle{VIN: "1hgcm82633a004352"}
+
+		assert.NoError(t, v.EnrichFromVIN())
+		assert.Equal(t, "1HGCM82633A004352", v.VIN)
+		assert.Equal(t, "Honda", v.Make)
+		assert.Equal(t, 2003, v.Year)
+	})
+
+	t.Run("normalizes inconsistent make names", func(t *testing.T) {
+		v := &Vehicle{VIN: "1HGCM82633A004352", Make: "HONDA ", Model: "Accord", Year: 2003}
+
+		assert.NoError(t, v.EnrichFromVIN())
+		assert.Equal(t, "Honda", v.Make)
+		assert.Equal(t, "Accord", v.Model)
+	})
+
+	t.Run("keeps details that disagree with the VIN", func(t *testing.T) {
+		v := &Vehicle{VIN: "1HGCM82633A004352", Make: "Acura", Year: 2004}
+
+		assert.NoError(t, v.EnrichFromVIN())
+		assert.Equal(t, "Acura", v.Make)
+		assert.Equal(t, 2004, v.Year)
+	})
+
+	t.Run("rejects invalid VINs", func(t *testing.T) {
+		v := &Vehicle{VIN: "1HGCM82643A004352"}
+
+		assert.Error(t, v.EnrichFromVIN())
+	})
+}
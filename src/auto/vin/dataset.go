@@ -0,0 +1,121 @@
+package vin
+
+// datasetJSON is the embedded decoding dataset.
+//
+// Countries are keyed by the first one or two VIN characters and Manufacturers
+// by the three character World Manufacturer Identifier. Plants are keyed by the
+// plant code at position 11 and are only listed for common assembly plants.
+const datasetJSON = `{
+  "Regions": {
+    "1": "North America", "2": "North America", "3": "North America", "4": "North America", "5": "North America",
+    "6": "Oceania", "7": "Oceania",
+    "8": "South America", "9": "South America",
+    "A": "Africa", "B": "Africa", "C": "Africa", "D": "Africa", "E": "Africa", "F": "Africa", "G": "Africa", "H": "Africa",
+    "J": "Asia", "K": "Asia", "L": "Asia", "M": "Asia", "N": "Asia", "P": "Asia", "R": "Asia",
+    "S": "Europe", "T": "Europe", "U": "Europe", "V": "Europe", "W": "Europe", "X": "Europe", "Y": "Europe", "Z": "Europe"
+  },
+  "Countries": {
+    "1": "United States", "4": "United States", "5": "United States",
+    "2": "Canada",
+    "3": "Mexico",
+    "6": "Australia",
+    "7": "New Zealand",
+    "8A": "Argentina", "8B": "Argentina", "8C": "Argentina", "8D": "Argentina", "8E": "Argentina",
+    "9A": "Brazil", "9B": "Brazil", "9C": "Brazil", "9D": "Brazil", "9E": "Brazil",
+    "AA": "South Africa", "AB": "South Africa", "AC": "South Africa", "AD": "South Africa", "AE": "South Africa", "AF": "South Africa", "AG": "South Africa", "AH": "South Africa",
+    "J": "Japan",
+    "KL": "South Korea", "KM": "South Korea", "KN": "South Korea", "KP": "South Korea", "KR": "South Korea",
+    "L": "China",
+    "MA": "India", "MB": "India", "MC": "India", "MD": "India", "ME": "India",
+    "MH": "Indonesia",
+    "ML": "Thailand", "MM": "Thailand", "MN": "Thailand", "MP": "Thailand", "MR": "Thailand",
+    "NL": "Turkey", "NM": "Turkey", "NR": "Turkey", "NS": "Turkey", "NT": "Turkey",
+    "PL": "Malaysia", "PM": "Malaysia", "PN": "Malaysia", "PP": "Malaysia", "PR": "Malaysia",
+    "SA": "United Kingdom", "SB": "United Kingdom", "SC": "United Kingdom", "SD": "United Kingdom", "SE": "United Kingdom", "SF": "United Kingdom", "SG": "United Kingdom", "SH": "United Kingdom", "SJ": "United Kingdom", "SK": "United Kingdom", "SL": "United Kingdom", "SM": "United Kingdom",
+    "SN": "Germany", "SP": "Germany", "SR": "Germany", "SS": "Germany", "ST": "Germany",
+    "SU": "Poland", "SV": "Poland", "SW": "Poland", "SX": "Poland", "SY": "Poland", "SZ": "Poland",
+    "TM": "Czech Republic", "TN": "Czech Republic", "TP": "Czech Republic", "TR": "Hungary", "TS": "Hungary",
+    "VA": "Austria", "VB": "Austria", "VC": "Austria", "VD": "Austria", "VE": "Austria",
+    "VF": "France", "VG": "France", "VH": "France", "VJ": "France", "VK": "France", "VL": "France", "VM": "France", "VN": "France", "VP": "France", "VR": "France",
+    "VS": "Spain", "VT": "Spain", "VU": "Spain", "VV": "Spain", "VW": "Spain",
+    "W": "Germany",
+    "XL": "Netherlands", "XM": "Netherlands", "XN": "Netherlands", "XP": "Netherlands", "XR": "Netherlands",
+    "XS": "Russia", "XT": "Russia", "XU": "Russia", "XV": "Russia", "XW": "Russia",
+    "YA": "Belgium", "YB": "Belgium", "YC": "Belgium", "YD": "Belgium", "YE": "Belgium",
+    "YF": "Finland", "YG": "Finland", "YH": "Finland", "YJ": "Finland", "YK": "Finland",
+    "YS": "Sweden", "YT": "Sweden", "YU": "Sweden", "YV": "Sweden", "YW": "Sweden",
+    "Z": "Italy"
+  },
+  "Manufacturers": {
+    "1C3": { "Manufacturer": "FCA US", "Make": "Chrysler" },
+    "1C4": { "Manufacturer": "FCA US", "Make": "Jeep" },
+    "1C6": { "Manufacturer": "FCA US", "Make": "Ram" },
+    "1D7": { "Manufacturer": "Chrysler", "Make": "Dodge" },
+    "1FA": { "Manufacturer": "Ford Motor Company", "Make": "Ford", "Plants": { "F": "Flat Rock, Michigan", "R": "Hermosillo, Mexico" } },
+    "1FM": { "Manufacturer": "Ford Motor Company", "Make": "Ford", "Plants": { "G": "Chicago, Illinois", "L": "Louisville, Kentucky" } },
+    "1FT": { "Manufacturer": "Ford Motor Company", "Make": "Ford", "Plants": { "F": "Dearborn, Michigan", "K": "Kansas City, Missouri" } },
+    "1G1": { "Manufacturer": "General Motors", "Make": "Chevrolet" },
+    "1GC": { "Manufacturer": "General Motors", "Make": "Chevrolet" },
+    "1GT": { "Manufacturer": "General Motors", "Make": "GMC" },
+    "1G6": { "Manufacturer": "General Motors", "Make": "Cadillac" },
+    "1GN": { "Manufacturer": "General Motors", "Make": "Chevrolet" },
+    "1HG": { "Manufacturer": "Honda of America", "Make": "Honda", "Plants": { "A": "Marysville, Ohio", "L": "East Liberty, Ohio" } },
+    "1J4": { "Manufacturer": "Chrysler", "Make": "Jeep" },
+    "1LN": { "Manufacturer": "Ford Motor Company", "Make": "Lincoln" },
+    "1M8": { "Manufacturer": "Motor Coach Industries", "Make": "MCI" },
+    "1N4": { "Manufacturer": "Nissan North America", "Make": "Nissan", "Plants": { "C": "Canton, Mississippi", "N": "Smyrna, Tennessee" } },
+    "1N6": { "Manufacturer": "Nissan North America", "Make": "Nissan" },
+    "19U": { "Manufacturer": "Honda of America", "Make": "Acura" },
+    "19X": { "Manufacturer": "Honda of America", "Make": "Honda" },
+    "2C3": { "Manufacturer": "Chrysler Canada", "Make": "Chrysler" },
+    "2G1": { "Manufacturer": "General Motors Canada", "Make": "Chevrolet" },
+    "2HG": { "Manufacturer": "Honda Canada", "Make": "Honda", "Plants": { "H": "Alliston, Ontario" } },
+    "2HK": { "Manufacturer": "Honda Canada", "Make": "Honda" },
+    "2T1": { "Manufacturer": "Toyota Motor Manufacturing Canada", "Make": "Toyota", "Plants": { "C": "Cambridge, Ontario" } },
+    "2T3": { "Manufacturer": "Toyota Motor Manufacturing Canada", "Make": "Toyota" },
+    "3FA": { "Manufacturer": "Ford Mexico", "Make": "Ford" },
+    "3GN": { "Manufacturer": "General Motors Mexico", "Make": "Chevrolet" },
+    "3N1": { "Manufacturer": "Nissan Mexicana", "Make": "Nissan" },
+    "3VW": { "Manufacturer": "Volkswagen de Mexico", "Make": "Volkswagen", "Plants": { "M": "Puebla, Mexico" } },
+    "4S3": { "Manufacturer": "Subaru of Indiana", "Make": "Subaru" },
+    "4S4": { "Manufacturer": "Subaru of Indiana", "Make": "Subaru", "Plants": { "3": "Lafayette, Indiana" } },
+    "4T1": { "Manufacturer": "Toyota Motor Manufacturing Kentucky", "Make": "Toyota", "Plants": { "U": "Georgetown, Kentucky" } },
+    "4T3": { "Manufacturer": "Toyota Motor Manufacturing Kentucky", "Make": "Toyota" },
+    "5FN": { "Manufacturer": "Honda Manufacturing of Alabama", "Make": "Honda" },
+    "5J6": { "Manufacturer": "Honda of America", "Make": "Honda" },
+    "5N1": { "Manufacturer": "Nissan North America", "Make": "Nissan" },
+    "5NP": { "Manufacturer": "Hyundai Motor Manufacturing Alabama", "Make": "Hyundai" },
+    "5TD": { "Manufacturer": "Toyota Motor Manufacturing Indiana", "Make": "Toyota" },
+    "5TF": { "Manufacturer": "Toyota Motor Manufacturing Texas", "Make": "Toyota" },
+    "5YJ": { "Manufacturer": "Tesla", "Make": "Tesla", "Plants": { "F": "Fremont, California", "A": "Austin, Texas" } },
+    "7SA": { "Manufacturer": "Tesla", "Make": "Tesla", "Country": "United States" },
+    "JF1": { "Manufacturer": "Fuji Heavy Industries", "Make": "Subaru" },
+    "JF2": { "Manufacturer": "Fuji Heavy Industries", "Make": "Subaru" },
+    "JHM": { "Manufacturer": "Honda Motor Company", "Make": "Honda", "Plants": { "C": "Sayama, Japan", "S": "Suzuka, Japan" } },
+    "JM1": { "Manufacturer": "Mazda", "Make": "Mazda" },
+    "JN1": { "Manufacturer": "Nissan Motor Company", "Make": "Nissan" },
+    "JN8": { "Manufacturer": "Nissan Motor Company", "Make": "Nissan" },
+    "JT2": { "Manufacturer": "Toyota Motor Corporation", "Make": "Toyota" },
+    "JTD": { "Manufacturer": "Toyota Motor Corporation", "Make": "Toyota" },
+    "JTH": { "Manufacturer": "Toyota Motor Corporation", "Make": "Lexus" },
+    "JTJ": { "Manufacturer": "Toyota Motor Corporation", "Make": "Lexus" },
+    "KMH": { "Manufacturer": "Hyundai Motor Company", "Make": "Hyundai" },
+    "KNA": { "Manufacturer": "Kia Motors", "Make": "Kia" },
+    "KND": { "Manufacturer": "Kia Motors", "Make": "Kia" },
+    "SAJ": { "Manufacturer": "Jaguar Land Rover", "Make": "Jaguar" },
+    "SAL": { "Manufacturer": "Jaguar Land Rover", "Make": "Land Rover" },
+    "WAU": { "Manufacturer": "Audi AG", "Make": "Audi" },
+    "WBA": { "Manufacturer": "BMW AG", "Make": "BMW" },
+    "WBS": { "Manufacturer": "BMW M GmbH", "Make": "BMW" },
+    "WDB": { "Manufacturer": "Daimler AG", "Make": "Mercedes-Benz" },
+    "WDD": { "Manufacturer": "Daimler AG", "Make": "Mercedes-Benz" },
+    "WMW": { "Manufacturer": "BMW AG", "Make": "MINI" },
+    "WP0": { "Manufacturer": "Porsche AG", "Make": "Porsche" },
+    "WVW": { "Manufacturer": "Volkswagen AG", "Make": "Volkswagen", "Plants": { "W": "Wolfsburg, Germany" } },
+    "WV2": { "Manufacturer": "Volkswagen Commercial Vehicles", "Make": "Volkswagen" },
+    "YV1": { "Manufacturer": "Volvo Cars", "Make": "Volvo" },
+    "YS3": { "Manufacturer": "Saab Automobile", "Make": "Saab" },
+    "ZFA": { "Manufacturer": "Fiat Automobiles", "Make": "Fiat" },
+    "ZFF": { "Manufacturer": "Ferrari", "Make": "Ferrari" }
+  }
+}`
@@ -0,0 +1,191 @@
+// Package vin validates and decodes vehicle identification numbers without a network lookup.
+package vin
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrInvalidLength is returned when a VIN isn't 17 characters long
+	ErrInvalidLength = errors.New("vin: must be 17 characters")
+
+	// ErrInvalidCharacter is returned when a VIN contains I, O, Q or a non-alphanumeric character
+	ErrInvalidCharacter = errors.New("vin: contains an invalid character")
+
+	// ErrInvalidCheckDigit is returned when a North American VIN's check digit doesn't match
+	ErrInvalidCheckDigit = errors.New("vin: check digit does not match")
+)
+
+// Decoded contains everything that can be learned from a VIN without a network lookup
+type Decoded struct {
+	VIN             string
+	WMI             string
+	Manufacturer    string `json:",omitempty"`
+	Make            string `json:",omitempty"`
+	Country         string `json:",omitempty"`
+	Region          string `json:",omitempty"`
+	ModelYear       int    `json:",omitempty"`
+	PlantCode       string
+	Plant           string `json:",omitempty"`
+	SerialNumber    string
+	CheckDigitValid bool
+}
+
+var (
+	transliteration = map[rune]int{
+		'A': 1, 'B': 2, 'C': 3, 'D': 4, 'E': 5, 'F': 6, 'G': 7, 'H': 8,
+		'J': 1, 'K': 2, 'L': 3, 'M': 4, 'N': 5, 'P': 7, 'R': 9,
+		'S': 2, 'T': 3, 'U': 4, 'V': 5, 'W': 6, 'X': 7, 'Y': 8, 'Z': 9,
+		'0': 0, '1': 1, '2': 2, '3': 3, '4': 4, '5': 5, '6': 6, '7': 7, '8': 8, '9': 9,
+	}
+
+	weights = [17]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+	// yearCodes cycle every 30 years starting in 1980. I, O, Q, U, Z and 0 are never used.
+	yearCodes = "ABCDEFGHJKLMNPRSTVWXY123456789"
+)
+
+// Normalize upper-cases the VIN and removes surrounding whitespace
+func Normalize(vin string) string {
+	return strings.ToUpper(strings.TrimSpace(vin))
+}
+
+// CheckDigit computes the expected check digit (position 9) for the VIN
+func CheckDigit(vin string) (byte, error) {
+	vin = Normalize(vin)
+	if len(vin) != 17 {
+		return 0, ErrInvalidLength
+	}
+
+	sum := 0
+	for i, r := range vin {
+		value, ok := transliteration[r]
+		if !ok {
+			return 0, ErrInvalidCharacter
+		}
+		sum += value * weights[i]
+	}
+
+	remainder := sum % 11
+	if remainder == 10 {
+		return 'X', nil
+	}
+	return byte('0' + remainder), nil
+}
+
+// Validate returns an error if the VIN is malformed.
+//
+// The check digit is only mandatory for vehicles built for North America, so
+// it's only enforced for VINs assigned there.
+func Validate(vin string) error {
+	vin = Normalize(vin)
+
+	expected, err := CheckDigit(vin)
+	if err != nil {
+		return err
+	}
+	if vin[8] != expected && requiresCheckDigit(vin) {
+		return ErrInvalidCheckDigit
+	}
+	return nil
+}
+
+// Decode validates the VIN and returns its decoded parts
+func Decode(vin string) (*Decoded, error) {
+	vin = Normalize(vin)
+	if err := Validate(vin); err != nil {
+		return nil, err
+	}
+
+	expected, _ := CheckDigit(vin)
+
+	decoded := &Decoded{
+		VIN:             vin,
+		WMI:             vin[0:3],
+		ModelYear:       modelYear(vin),
+		PlantCode:       vin[10:11],
+		SerialNumber:    vin[11:],
+		CheckDigitValid: vin[8] == expected,
+	}
+
+	region, country := regionAndCountry(vin)
+	decoded.Region = region
+	decoded.Country = country
+
+	if m, ok := lookupManufacturer(decoded.WMI); ok {
+		decoded.Manufacturer = m.Manufacturer
+		decoded.Make = m.Make
+		if m.Country != "" {
+			decoded.Country = m.Country
+		}
+		decoded.Plant = m.Plants[decoded.PlantCode]
+	}
+
+	return decoded, nil
+}
+
+func requiresCheckDigit(vin string) bool {
+	return strings.IndexByte("12345", vin[0]) >= 0
+}
+
+// modelYear decodes position 10. Position 7 disambiguates the 30 year cycle
+// for passenger vehicles: a digit means 1980-2009 and a letter means 2010-2039.
+func modelYear(vin string) int {
+	index := strings.IndexByte(yearCodes, vin[9])
+	if index < 0 {
+		return 0
+	}
+
+	year := 1980 + index
+	if vin[6] < '0' || vin[6] > '9' {
+		year += 30
+	}
+	return year
+}
+
+type manufacturer struct {
+	Manufacturer string
+	Make         string
+	Country      string
+	Plants       map[string]string
+}
+
+type dataset struct {
+	Regions       map[string]string
+	Countries     map[string]string
+	Manufacturers map[string]manufacturer
+}
+
+var (
+	data      dataset
+	dataSetup sync.Once
+)
+
+func loadDataset() dataset {
+	dataSetup.Do(func() {
+		if err := json.Unmarshal([]byte(datasetJSON), &data); err != nil {
+			panic(err)
+		}
+	})
+	return data
+}
+
+func lookupManufacturer(wmi string) (manufacturer, bool) {
+	m, ok := loadDataset().Manufacturers[wmi]
+	return m, ok
+}
+
+func regionAndCountry(vin string) (string, string) {
+	d := loadDataset()
+
+	region := d.Regions[vin[0:1]]
+
+	// Two character prefixes are more specific than the first character alone.
+	if country, ok := d.Countries[vin[0:2]]; ok {
+		return region, country
+	}
+	return region, d.Countries[vin[0:1]]
+}
@@ -0,0 +1,78 @@
+package vin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDigit(t *testing.T) {
+	for vin, expected := range map[string]byte{
+		"1M8GDM9AXKP042788": 'X',
+		"1HGCM82633A004352": '3',
+		"5YJ3E1EA2KF317000": '2',
+		"1ftfw1et9dfc10312": '9',
+	} {
+		digit, err := CheckDigit(vin)
+		require.NoError(t, err, vin)
+		assert.Equal(t, string(expected), string(digit), vin)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("1HGCM82633A004352"))
+	assert.Equal(t, ErrInvalidLength, Validate("1HGCM82633A00435"))
+	assert.Equal(t, ErrInvalidCharacter, Validate("1HGCM82633A00435O"))
+	assert.Equal(t, ErrInvalidCheckDigit, Validate("1HGCM82643A004352"))
+
+	t.Run("check digits are optional outside of North America", func(t *testing.T) {
+		assert.NoError(t, Validate("WVWZZZ1JZXW000001"))
+	})
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("north american honda", func(t *testing.T) {
+		decoded, err := Decode(" 1hgcm82633a004352 ")
+		require.NoError(t, err)
+
+		assert.Equal(t, "1HGCM82633A004352", decoded.VIN)
+		assert.Equal(t, "1HG", decoded.WMI)
+		assert.Equal(t, "Honda", decoded.Make)
+		assert.Equal(t, "United States", decoded.Country)
+		assert.Equal(t, "North America", decoded.Region)
+		assert.Equal(t, 2003, decoded.ModelYear)
+		assert.Equal(t, "A", decoded.PlantCode)
+		assert.Equal(t, "Marysville, Ohio", decoded.Plant)
+		assert.Equal(t, "004352", decoded.SerialNumber)
+		assert.True(t, decoded.CheckDigitValid)
+	})
+
+	t.Run("model years after 2009 use a letter in position 7", func(t *testing.T) {
+		decoded, err := Decode("5YJ3E1EA2KF317000")
+		require.NoError(t, err)
+
+		assert.Equal(t, "Tesla", decoded.Make)
+		assert.Equal(t, 2019, decoded.ModelYear)
+		assert.Equal(t, "Fremont, California", decoded.Plant)
+	})
+
+	t.Run("japanese honda", func(t *testing.T) {
+		decoded, err := Decode("JHMCM56557C404453")
+		require.NoError(t, err)
+
+		assert.Equal(t, "Japan", decoded.Country)
+		assert.Equal(t, "Asia", decoded.Region)
+		assert.Equal(t, 2007, decoded.ModelYear)
+	})
+
+	t.Run("unknown manufacturers still decode the country and year", func(t *testing.T) {
+		decoded, err := Decode("WVZZZZ1JZXW000001")
+		require.NoError(t, err)
+
+		assert.Equal(t, "Germany", decoded.Country)
+		assert.Empty(t, decoded.Make)
+		assert.Equal(t, 1999, decoded.ModelYear)
+		assert.False(t, decoded.CheckDigitValid)
+	})
+}
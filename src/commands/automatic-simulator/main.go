@@ -0,0 +1,92 @@
+// Command automatic-simulator serves a simulated Automatic on one address, so
+// the app can be developed without a real Automatic account.
+//
+//	automatic-simulator -addr 127.0.0.1:8090
+//	automatic-simulator -config users.json
+//	automatic-simulator -print-config > users.json
+//
+// Point the API handler at it by setting both AUTOMATIC_API_URL and
+// AUTOMATIC_ACCOUNTS_URL to the address. The default config's client matches
+// the app's fake secrets (RETURN_FAKE_SECRETS=true).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic/simulator"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8090", "the address to listen on")
+	configPath := flag.String("config", "", "a JSON config of the client and users, instead of the default")
+	printConfig := flag.Bool("print-config", false, "print the default config and exit")
+	flag.Parse()
+
+	if *printConfig {
+		data, err := json.MarshalIndent(simulator.DefaultConfig(), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+
+	config := simulator.DefaultConfig()
+	if *configPath != "" {
+		data, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if config, err = simulator.LoadConfig(data); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	server := &http.Server{Addr: *addr, Handler: logRequests(simulator.New(config))}
+
+	ctx, cancel := auto.InterruptContext()
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("simulating Automatic for %d users on http://%s", len(config.Users), *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// statusRecorder keeps the status written to the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, r)
+
+		log.Printf("%s %s %d %s", r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Millisecond))
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+// sendDigestCommand sends the account its digest if one is due, printing it
+// instead of emailing it, with the same action links. It records the delivery
+// like the scheduler does.
+func sendDigestCommand(ctx context.Context, a *app, args []string) error {
+	accountID, err := accountArgument(args)
+	if err != nil {
+		return err
+	}
+
+	digest, err := auto.SendDigest(ctx, a.store(), accountID, time.Now(), auto.ReminderDigestActions, printDigest)
+	if err != nil {
+		return fmt.Errorf("%s: %w", accountID, err)
+	}
+	if digest == nil {
+		fmt.Printf("digest: nothing due for %s\n", accountID)
+	}
+
+	return nil
+}
+
+func printDigest(ctx context.Context, account *auto.Account, prefs *auto.NotificationPreferences, digest *auto.Digest) error {
+	fmt.Printf("digest: %s\n\n%s", digest.Subject(), digest.Text(account.Location()))
+	return nil
+}
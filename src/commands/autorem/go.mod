@@ -0,0 +1,10 @@
+module github.com/maddiesch/automatic-reminders/commands/autorem
+
+go 1.13
+
+require (
+	github.com/aws/aws-sdk-go v1.23.21
+	github.com/maddiesch/automatic-reminders/auto v0.0.0
+)
+
+replace github.com/maddiesch/automatic-reminders/auto v0.0.0 => ../../auto
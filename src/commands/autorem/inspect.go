@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+)
+
+// secretAttributes are redacted by inspect unless -secrets is passed, which decrypts them instead
+var secretAttributes = []string{"AccessToken", "RefreshToken", auto.EncryptedDataKeyAttribute}
+
+// inspectCommand prints every item stored under the account, one JSON object per line
+func inspectCommand(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	secrets := flags.Bool("secrets", false, "print tokens and contact values decrypted instead of redacting them")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	accountID, err := accountArgument(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	count := 0
+
+	err = a.db.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(a.table),
+		KeyConditionExpression:   aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]*string{"#pk": aws.String(keys.HashKeyAttribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(accountID)},
+		},
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			if *secrets {
+				if err = auto.DecryptItem(ctx, auto.DevelopmentKeys(), item); err != nil {
+					return false
+				}
+			}
+			values := make(map[string]interface{})
+			if err = dynamodbattribute.UnmarshalMap(item, &values); err != nil {
+				return false
+			}
+			if !*secrets {
+				for _, name := range secretAttributes {
+					if _, ok := values[name]; ok {
+						values[name] = "[redacted]"
+					}
+				}
+			}
+			if err = encoder.Encode(values); err != nil {
+				return false
+			}
+			count++
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("no items for %s in %s", accountID, a.table)
+	}
+
+	return nil
+}
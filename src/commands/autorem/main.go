@@ -0,0 +1,159 @@
+// Command autorem administers the app's table. It's made for DynamoDB Local
+// and talks to http://127.0.0.1:8000/ unless -endpoint says otherwise.
+//
+//	autorem create-table [name]
+//	autorem delete-table <name>
+//	autorem cleanup-tables [-prefix test-table]
+//	autorem seed [-account auid:fixture]
+//	autorem inspect [-secrets] <account id>
+//	autorem revoke-sessions <account id>
+//	autorem send-digest <account id>
+//	autorem sync [-api http://127.0.0.1:3000] <account id>
+//
+// The table is named by -table, or DYNAMODB_TABLE_NAME when it's not passed.
+// The app's config is loaded like the API's, with the local profile unless
+// APP_PROFILE says otherwise, so secrets and encryption keys match the API's
+// when it's served locally.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+const (
+	defaultEndpoint = "http://127.0.0.1:8000/"
+	defaultTable    = "auto-table-development"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+// app is the state shared by every command
+type app struct {
+	db    *dynamodb.DynamoDB
+	table string
+}
+
+func (a *app) store() *auto.DynamoStore {
+	return auto.NewDynamoStore(a.db, a.table)
+}
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = map[string]command{
+	"create-table":    {"[name]", createTableCommand},
+	"delete-table":    {"<name>", deleteTableCommand},
+	"cleanup-tables":  {"[-prefix test-table]", cleanupTablesCommand},
+	"seed":            {"[-account auid:fixture]", seedCommand},
+	"inspect":         {"[-secrets] <account id>", inspectCommand},
+	"revoke-sessions": {"<account id>", revokeSessionsCommand},
+	"send-digest":     {"<account id>", sendDigestCommand},
+	"sync":            {"[-api url] <account id>", syncCommand},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	endpoint := flag.String("endpoint", defaultEndpoint, "the DynamoDB endpoint, empty for AWS")
+	region := flag.String("region", envDefault("AWS_DEFAULT_REGION", "us-west-2"), "the AWS region")
+	table := flag.String("table", envDefault("DYNAMODB_TABLE_NAME", defaultTable), "the table to use")
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	config, err := loadConfig(*table)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auto.SetDefaultConfig(config)
+
+	a := &app{db: dynamoClient(*endpoint, *region), table: *table}
+
+	ctx, cancel := auto.InterruptContext()
+	defer cancel()
+
+	err = cmd.run(ctx, a, flag.Args()[1:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: autorem %s %s\n", flag.Arg(0), cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: autorem [-endpoint url] [-region name] [-table name] <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+// dynamoClient returns a client for the endpoint. DynamoDB Local accepts any credentials, so fake ones are used for it.
+func dynamoClient(endpoint, region string) *dynamodb.DynamoDB {
+	config := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithCredentials(credentials.NewStaticCredentials("local", "local", ""))
+	}
+
+	return dynamodb.New(session.Must(session.NewSession(config)))
+}
+
+// loadConfig loads the app's config. autorem is made for local tables, so the
+// profile defaults to local, and the table to the one autorem uses.
+func loadConfig(table string) (*auto.Config, error) {
+	for name, value := range map[string]string{
+		"APP_PROFILE":         string(auto.ProfileLocal),
+		"DYNAMODB_TABLE_NAME": table,
+	} {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+
+	return auto.LoadConfig("")
+}
+
+// accountArgument returns the single account ID argument
+func accountArgument(args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", errUsage
+	}
+	return args[0], nil
+}
+
+func envDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+// seedCommand writes a fixture account with a vehicle, odometer readings and a reminder.
+// Running it again refreshes the fixtures instead of duplicating them.
+func seedCommand(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	accountID := flags.String("account", "auid:fixture", "the fixture account's ID")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	store := a.store()
+	suffix := strings.TrimPrefix(*accountID, "auid:")
+	now := time.Now()
+
+	account, err := store.FindAccount(ctx, *accountID)
+	if err == auto.ErrRecordNotFound {
+		account = &auto.Account{ID: *accountID, FirstName: "Fixture", LastName: "Driver", TimeZone: "America/Denver"}
+	} else if err != nil {
+		return err
+	}
+
+	err = store.SaveAutomaticAuthentication(ctx, account, auto.AutomaticAccessToken{
+		UserID:       "U_" + suffix,
+		AccessToken:  "fixture-access-token",
+		ExpiresIn:    31536000,
+		Scope:        "scope:public scope:vehicle:profile scope:trip",
+		RefreshToken: "fixture-refresh-token",
+		TokenType:    "bearer",
+	}, []*auto.Contact{
+		{Type: auto.ContactTypeEmail, Value: suffix + "@example.com", ReceiveContact: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := store.SaveNotificationPreferences(ctx, account, auto.DefaultNotificationPreferences(account.ID)); err != nil {
+		return err
+	}
+
+	vehicle := &auto.Vehicle{
+		ID:          "veh:" + suffix,
+		AccountID:   account.ID,
+		VIN:         "1HGCM82633A004352",
+		Make:        "Honda",
+		Model:       "Accord",
+		Year:        2003,
+		DisplayName: "Fixture Accord",
+	}
+	if existing, err := store.FindVehicle(ctx, account.ID, vehicle.ID); err == nil {
+		vehicle.CreatedAt = existing.CreatedAt
+	}
+	if err := store.SaveVehicle(ctx, vehicle); err != nil {
+		return err
+	}
+
+	// Readings can only be added in order, so an existing timeline is left alone.
+	if _, err := store.LatestOdometerReading(ctx, account.ID, vehicle.ID); err == auto.ErrRecordNotFound {
+		readings := []*auto.OdometerReading{
+			{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 118000, ReadAt: now.AddDate(0, 0, -60), Source: auto.OdometerSourceManual},
+			{AccountID: account.ID, VehicleID: vehicle.ID, Reading: 120500, ReadAt: now.AddDate(0, 0, -1), Source: auto.OdometerSourceManual},
+		}
+		for _, reading := range readings {
+			if err := store.AddOdometerReading(ctx, reading); err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	reminder := &auto.Reminder{
+		ID:                    "rem:" + suffix,
+		AccountID:             account.ID,
+		VehicleID:             vehicle.ID,
+		Title:                 "Oil change",
+		IntervalDays:          180,
+		IntervalDistance:      5000,
+		LastCompletedAt:       now.AddDate(0, 0, -200),
+		LastCompletedOdometer: 114000,
+	}
+	if existing, err := store.FindReminder(ctx, account.ID, reminder.ID); err == nil {
+		reminder.CreatedAt = existing.CreatedAt
+		reminder.UpdatedAt = existing.UpdatedAt
+	}
+	if err := store.SaveReminder(ctx, reminder); err != nil {
+		return err
+	}
+
+	fmt.Printf("seed: %s in %s\n", account.ID, a.table)
+
+	return nil
+}
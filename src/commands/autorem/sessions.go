@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// revokeSessionsCommand rejects every API token issued to the account so far
+func revokeSessionsCommand(ctx context.Context, a *app, args []string) error {
+	accountID, err := accountArgument(args)
+	if err != nil {
+		return err
+	}
+
+	revokedAt, err := a.store().RevokeSessions(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", accountID, err)
+	}
+
+	fmt.Printf("revoke: sessions for %s issued before %s\n", accountID, revokedAt.Format(time.RFC3339))
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+// syncCommand asks the API to sync the account with Automatic, the same as the app does.
+//
+// The request is signed with a newly minted API token, so the signing secret
+// has to match the API's. Both use the fake secrets by default when they run
+// with the local profile; otherwise run autorem with the API's config.
+func syncCommand(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	api := flags.String("api", envDefault("API_BASE_URL", "http://127.0.0.1:3000"), "the API's base URL")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	accountID, err := accountArgument(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.store().FindAccount(ctx, accountID); err != nil {
+		return fmt.Errorf("%s: %w", accountID, err)
+	}
+
+	token, err := auto.NewAPIToken(accountID)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(*api, "/")+"/v1/private/sync", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("sync failed with %s: %s", response.Status, body)
+	}
+
+	os.Stdout.Write(body)
+	fmt.Println()
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+func createTableCommand(ctx context.Context, a *app, args []string) error {
+	name := a.table
+	switch len(args) {
+	case 0:
+	case 1:
+		name = args[0]
+	default:
+		return errUsage
+	}
+
+	if _, err := a.db.CreateTableWithContext(ctx, auto.CreateTableInput(name)); err != nil {
+		return err
+	}
+	if err := a.db.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)}); err != nil {
+		return err
+	}
+	if _, err := a.db.UpdateTimeToLiveWithContext(ctx, auto.TimeToLiveInput(name)); err != nil {
+		return err
+	}
+
+	fmt.Printf("create: %s\n", name)
+
+	return nil
+}
+
+func deleteTableCommand(ctx context.Context, a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	return deleteTable(ctx, a, args[0])
+}
+
+func cleanupTablesCommand(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("cleanup-tables", flag.ContinueOnError)
+	prefix := flags.String("prefix", "test-table", "delete tables whose name starts with this")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || *prefix == "" {
+		return errUsage
+	}
+
+	names := make([]string, 0)
+	err := a.db.ListTablesPagesWithContext(ctx, &dynamodb.ListTablesInput{}, func(page *dynamodb.ListTablesOutput, last bool) bool {
+		for _, name := range aws.StringValueSlice(page.TableNames) {
+			if strings.HasPrefix(name, *prefix) {
+				names = append(names, name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := deleteTable(ctx, a, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteTable(ctx context.Context, a *app, name string) error {
+	if _, err := a.db.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(name)}); err != nil {
+		return err
+	}
+
+	fmt.Printf("delete: %s\n", name)
+
+	return nil
+}
@@ -0,0 +1,7 @@
+module github.com/maddiesch/automatic-reminders/commands/migrate
+
+go 1.13
+
+require github.com/maddiesch/automatic-reminders/auto v0.0.0
+
+replace github.com/maddiesch/automatic-reminders/auto v0.0.0 => ../../auto
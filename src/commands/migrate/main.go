@@ -0,0 +1,105 @@
+// Command migrate applies the schema migrations in auto/migrate to the app's table.
+//
+// It uses the same configuration as the functions: DYNAMODB_TABLE_NAME names
+// the table, and AWS_SAM_LOCAL=true points it at DynamoDB Local.
+//
+//	migrate -list
+//	migrate -dry-run
+//	migrate -only 0001-token-index-keys
+//	migrate -reencrypt
+//
+// After ENCRYPTION_KEY_ID is rotated, -reencrypt re-encrypts the items stored
+// under older keys. It runs once for each key.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/migrate"
+)
+
+func main() {
+	table := flag.String("table", os.Getenv("DYNAMODB_TABLE_NAME"), "the table to migrate")
+	dryRun := flag.Bool("dry-run", false, "report what would change without writing")
+	list := flag.Bool("list", false, "list migrations and their status")
+	only := flag.String("only", "", "comma separated IDs of the migrations to run")
+	pageSize := flag.Int64("page-size", 100, "items scanned between checkpoints")
+	reencrypt := flag.Bool("reencrypt", false, "re-encrypt items that aren't encrypted under the current key, instead of running the migrations")
+	flag.Parse()
+
+	if *table == "" {
+		log.Fatal("a table is required, pass -table or set DYNAMODB_TABLE_NAME")
+	}
+
+	config, err := auto.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	auto.SetDefaultConfig(config)
+
+	migrations := migrate.All
+	if *only != "" {
+		found, ok := migrate.Find(strings.Split(*only, ",")...)
+		if !ok {
+			log.Fatalf("unknown migration in %q", *only)
+		}
+		migrations = found
+	}
+	if *reencrypt {
+		migrations = []migrate.Migration{migrate.Reencrypt(auto.DefaultKeyProvider())}
+	}
+
+	// An interrupted run stops at the current page and resumes from its checkpoint next time.
+	ctx, cancel := auto.InterruptContext()
+	defer cancel()
+
+	runner := &migrate.Runner{
+		DB:       auto.DynamoDB(),
+		Table:    *table,
+		DryRun:   *dryRun,
+		PageSize: *pageSize,
+		Logf:     log.Printf,
+	}
+
+	if *list {
+		records, err := runner.Status(ctx, migrations)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i, record := range records {
+			fmt.Printf("%-28s %-10s %s\n", record.ID, status(record), migrations[i].Description)
+		}
+		return
+	}
+
+	reports, err := runner.Run(ctx, migrations)
+	for _, report := range reports {
+		if report.Skipped {
+			continue
+		}
+		verb := "rewrote"
+		if *dryRun {
+			verb = "would rewrite"
+		}
+		fmt.Printf("%s: scanned %d, %s %d\n", report.ID, report.Scanned, verb, report.Rewritten)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func status(record *migrate.Record) string {
+	switch {
+	case record.Completed():
+		return "applied"
+	case record.Checkpoint != nil:
+		return "partial"
+	default:
+		return "pending"
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 
@@ -9,17 +10,18 @@ import (
 )
 
 func getAccountHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
 	accountID := c.GetString(contextUserIDKey)
-	account, err := auto.FindAccount(accountID)
+	account, err := auto.DefaultStore().FindAccount(ctx, accountID)
 	if err != nil {
-		reportError(err, false)
 		respondWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, account)
+	respond(c, http.StatusOK, account)
 }
 
-func getAccount(accountID string) (*auto.Account, error) {
+func getAccount(ctx context.Context, accountID string) (*auto.Account, error) {
 	return nil, errors.New("WIP")
 }
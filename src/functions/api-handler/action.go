@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+// reminderActionPayload describes the action a link takes on a reminder.
+// Pending is false once the link has been used, or the reminder has changed
+// so the link no longer applies.
+type reminderActionPayload struct {
+	Action     string
+	SnoozeDays int `json:",omitempty"`
+	Pending    bool
+	Reminder   *reminderPayload
+}
+
+// reminderActionHandler describes what a link does without doing it. Links are
+// followed with GET by mail scanners and prefetchers as well as people, so the
+// action is only taken by performReminderActionHandler.
+func reminderActionHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	action, err := describeReminderAction(ctx, c.Param("token"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, action)
+}
+
+func performReminderActionHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	action, err := performReminderAction(ctx, c.Param("token"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, action)
+}
+
+func describeReminderAction(ctx context.Context, t string) (*reminderActionPayload, error) {
+	claims, reminder, err := findReminderAction(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	odometer, err := odometerCache{}.reading(ctx, reminder.AccountID, reminder.VehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReminderActionPayload(claims, reminder, reminderActionPending(claims, reminder), odometer), nil
+}
+
+func performReminderAction(ctx context.Context, t string) (*reminderActionPayload, error) {
+	claims, reminder, err := findReminderAction(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	odometer, err := odometerCache{}.reading(ctx, reminder.AccountID, reminder.VehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !reminderActionPending(claims, reminder) {
+		// The link has been used, or the reminder has changed since it was sent
+		return newReminderActionPayload(claims, reminder, false, odometer), nil
+	}
+
+	switch claims.Action {
+	case auto.ReminderActionSnooze:
+		reminder.Snooze(time.Now().AddDate(0, 0, claims.SnoozeDays), 0)
+	case auto.ReminderActionDismiss:
+		reminder.Dismiss()
+	}
+
+	if err := auto.DefaultStore().SaveReminder(ctx, reminder); err != nil {
+		return nil, err
+	}
+
+	return newReminderActionPayload(claims, reminder, false, odometer), nil
+}
+
+// findReminderAction returns the claims of a valid action link and the reminder it's for
+func findReminderAction(ctx context.Context, t string) (*auto.ReminderActionClaims, *auto.Reminder, error) {
+	claims, err := parseReminderActionToken(t)
+	if err != nil {
+		return nil, nil, newError(errUnauthorized, "Invalid or expired action link").causedBy(err)
+	}
+
+	switch claims.Action {
+	case auto.ReminderActionSnooze, auto.ReminderActionDismiss:
+	default:
+		return nil, nil, newError(errBadRequest, "Unknown action")
+	}
+
+	reminder, err := auto.DefaultStore().FindReminder(ctx, claims.Subject, claims.ReminderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return claims, reminder, nil
+}
+
+// reminderActionPending returns true if the link hasn't been used and still applies to the reminder
+func reminderActionPending(claims *auto.ReminderActionClaims, reminder *auto.Reminder) bool {
+	if reminder.SkippedOccurrences != claims.Occurrence {
+		return false
+	}
+	if claims.Action == auto.ReminderActionSnooze {
+		return reminder.UpdatedAt.Unix() == claims.Updated
+	}
+	return true
+}
+
+func newReminderActionPayload(claims *auto.ReminderActionClaims, reminder *auto.Reminder, pending bool, odometer float64) *reminderActionPayload {
+	return &reminderActionPayload{
+		Action:     claims.Action,
+		SnoozeDays: claims.SnoozeDays,
+		Pending:    pending,
+		Reminder:   newReminderPayload(reminder, time.Now(), odometer),
+	}
+}
+
+func parseReminderActionToken(t string) (*auto.ReminderActionClaims, error) {
+	claims := &auto.ReminderActionClaims{}
+
+	token, err := jwt.ParseWithClaims(t, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		secrets, err := auto.Secrets()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(secrets.Signing), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("Invalid token")
+	}
+	if !claims.VerifyAudience(auto.ActionTokenAudience, true) {
+		return nil, errors.New("Invalid token audience")
+	}
+
+	return claims, nil
+}
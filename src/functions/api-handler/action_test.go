@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReminderActionLinks(t *testing.T) {
+	reminder := &auto.Reminder{
+		ID:              "rem:test",
+		AccountID:       "auid:test",
+		Title:           "Oil change",
+		IntervalDays:    90,
+		LastCompletedAt: time.Now().AddDate(0, 0, -100),
+	}
+
+	t.Run("sent digests carry signed snooze and dismiss links for each item", func(t *testing.T) {
+		ctx := context.Background()
+		now := time.Date(2019, 10, 2, 9, 30, 0, 0, time.UTC)
+
+		account := &auto.Account{ID: "auid:" + ksuid.New().String()}
+		require.NoError(t, auto.DefaultStore().SaveAutomaticAuthentication(ctx, account, auto.AutomaticAccessToken{UserID: "U_" + ksuid.New().String(), AccessToken: "access"}, nil))
+		for _, id := range []string{"rem:oil", "rem:tires"} {
+			require.NoError(t, auto.DefaultStore().SaveReminder(ctx, &auto.Reminder{ID: id, AccountID: account.ID, Title: id, IntervalDays: 90, LastCompletedAt: now.AddDate(0, 0, -100)}))
+		}
+
+		var sent *auto.Digest
+		send := func(ctx context.Context, account *auto.Account, prefs *auto.NotificationPreferences, digest *auto.Digest) error {
+			sent = digest
+			return nil
+		}
+
+		_, err := auto.SendDigest(ctx, auto.DefaultStore(), account.ID, now, auto.ReminderDigestActions, send)
+		require.NoError(t, err)
+		require.NotNil(t, sent)
+		require.Len(t, sent.Items, 2)
+
+		for _, item := range sent.Items {
+			require.Len(t, item.Actions, 2, item.Title)
+
+			actions := make([]string, 0, len(item.Actions))
+			for _, action := range item.Actions {
+				uri, err := url.Parse(action.URL)
+				require.NoError(t, err)
+
+				claims, err := parseReminderActionToken(path.Base(uri.Path))
+				require.NoError(t, err)
+				assert.Equal(t, account.ID, claims.Subject)
+				assert.Equal(t, item.Title, claims.ReminderID)
+				actions = append(actions, claims.Action)
+			}
+			assert.Equal(t, []string{auto.ReminderActionSnooze, auto.ReminderActionDismiss}, actions)
+		}
+	})
+
+	t.Run("action tokens are not accepted as API tokens", func(t *testing.T) {
+		token, err := auto.NewReminderActionToken(reminder, auto.ReminderActionSnooze, 7)
+		require.NoError(t, err)
+
+		_, err = getAccountIDAndValidateToken(context.Background(), token)
+		assert.Error(t, err)
+	})
+
+	t.Run("API tokens are not accepted as action tokens", func(t *testing.T) {
+		token, err := apiTokenForAccount(&auto.Account{ID: "auid:test"})
+		require.NoError(t, err)
+
+		_, err = parseReminderActionToken(token)
+		assert.Error(t, err)
+
+		accountID, err := getAccountIDAndValidateToken(context.Background(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, "auid:test", accountID)
+	})
+
+	t.Run("describing a link doesn't change the reminder", func(t *testing.T) {
+		ctx := context.Background()
+		stored := &auto.Reminder{ID: "rem:describe", AccountID: "auid:actions", Title: "Oil change", IntervalDays: 90}
+		require.NoError(t, auto.DefaultStore().SaveReminder(ctx, stored))
+
+		token, err := auto.NewReminderActionToken(stored, auto.ReminderActionDismiss, 0)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			action, err := describeReminderAction(ctx, token)
+			require.NoError(t, err)
+			assert.True(t, action.Pending)
+			assert.Equal(t, 0, action.Reminder.SkippedOccurrences)
+		}
+
+		action, err := performReminderAction(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, 1, action.Reminder.SkippedOccurrences)
+
+		action, err = describeReminderAction(ctx, token)
+		require.NoError(t, err)
+		assert.False(t, action.Pending, "the link has been used")
+	})
+
+	t.Run("snooze links only take effect once", func(t *testing.T) {
+		ctx := context.Background()
+		stored := &auto.Reminder{ID: "rem:snooze", AccountID: "auid:actions", Title: "Oil change", IntervalDays: 90}
+		require.NoError(t, auto.DefaultStore().SaveReminder(ctx, stored))
+
+		token, err := auto.NewReminderActionToken(stored, auto.ReminderActionSnooze, 7)
+		require.NoError(t, err)
+
+		action, err := performReminderAction(ctx, token)
+		require.NoError(t, err)
+		snoozedUntil := action.Reminder.SnoozedUntil
+		assert.False(t, snoozedUntil.IsZero())
+
+		action, err = performReminderAction(ctx, token)
+		require.NoError(t, err)
+		assert.False(t, action.Pending)
+		assert.Equal(t, snoozedUntil.Unix(), action.Reminder.SnoozedUntil.Unix(), "replaying the link doesn't push the snooze out")
+	})
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"strings"
-	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
@@ -14,15 +13,7 @@ import (
 )
 
 func apiTokenForAccount(a *auto.Account) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		ExpiresAt: time.Now().AddDate(0, 0, 90).Unix(),
-		Issuer:    "autorem://api/v1",
-		Audience:  "autorem://api/v1",
-		Subject:   a.ID,
-		IssuedAt:  time.Now().Unix(),
-	})
-
-	return token.SignedString([]byte(auto.Secrets().Signing))
+	return auto.NewAPIToken(a.ID)
 }
 
 const (
@@ -32,18 +23,21 @@ const (
 
 // Authenticate performs authentication
 func Authenticate(c *gin.Context) {
-	accountID, err := performAuthentication(c.GetHeader("Authorization"))
+	ctx := requestContext(c)
+
+	accountID, err := performAuthentication(ctx, c.GetHeader("Authorization"))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"Error": err.Error()})
+		respondWithError(c, newError(errUnauthorized, "A valid API token is required").causedBy(err))
 		return
 	}
 
 	c.Set(contextUserIDKey, accountID)
+	c.Request = c.Request.WithContext(auto.WithLogFields(ctx, auto.Fields{"account_id": accountID}))
 
 	c.Header("X-User-ID", accountID)
 }
 
-func performAuthentication(header string) (string, error) {
+func performAuthentication(ctx context.Context, header string) (string, error) {
 	parts := strings.SplitN(header, " ", 2)
 	if len(parts) != 2 {
 		return "", fmt.Errorf("Invalid authorization header")
@@ -51,18 +45,22 @@ func performAuthentication(header string) (string, error) {
 
 	switch strings.ToLower(strings.TrimSpace(parts[0])) {
 	case "bearer":
-		return getAccountIDAndValidateToken(strings.TrimSpace(parts[1]))
+		return getAccountIDAndValidateToken(ctx, strings.TrimSpace(parts[1]))
 	default:
 		return "", fmt.Errorf("Invalid authorization type")
 	}
 }
 
-func getAccountIDAndValidateToken(t string) (string, error) {
+func getAccountIDAndValidateToken(ctx context.Context, t string) (string, error) {
 	token, err := jwt.Parse(t, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(auto.Secrets().Signing), nil
+		secrets, err := auto.Secrets()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(secrets.Signing), nil
 	})
 	if err != nil {
 		return "", err
@@ -83,6 +81,11 @@ func getAccountIDAndValidateToken(t string) (string, error) {
 			return "", err
 		}
 
+		// Action links are signed with the same key, so they must not be accepted as API tokens.
+		if !claims.VerifyAudience(auto.APITokenAudience, true) {
+			return "", errors.New("Invalid token audience")
+		}
+
 		token.Claims = claims
 	}
 
@@ -91,5 +94,26 @@ func getAccountIDAndValidateToken(t string) (string, error) {
 		return "", errors.New("Invalid token (claims)")
 	}
 
+	if err := verifySessionNotRevoked(ctx, claims); err != nil {
+		return "", err
+	}
+
 	return claims.Subject, nil
 }
+
+// verifySessionNotRevoked rejects tokens issued before the account's sessions were revoked.
+// Tokens for accounts that don't exist are left for the handlers to reject.
+func verifySessionNotRevoked(ctx context.Context, claims jwt.StandardClaims) error {
+	account, err := auto.DefaultStore().FindAccount(ctx, claims.Subject)
+	if err == auto.ErrRecordNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !account.SessionsValidAfter.IsZero() && claims.IssuedAt <= account.SessionsValidAfter.Unix() {
+		return errors.New("Token has been revoked")
+	}
+
+	return nil
+}
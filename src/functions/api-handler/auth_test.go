@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRevocation(t *testing.T) {
+	account := &auto.Account{ID: "auid:" + ksuid.New().String()}
+	require.NoError(t, auto.DefaultStore().SaveAutomaticAuthentication(context.Background(), account, auto.AutomaticAccessToken{
+		UserID:      "U_" + ksuid.New().String(),
+		AccessToken: "access",
+		ExpiresIn:   3600,
+	}, nil))
+
+	token, err := apiTokenForAccount(account)
+	require.NoError(t, err)
+
+	accountID, err := getAccountIDAndValidateToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, accountID)
+
+	_, err = auto.DefaultStore().RevokeSessions(context.Background(), account.ID)
+	require.NoError(t, err)
+
+	_, err = getAccountIDAndValidateToken(context.Background(), token)
+	assert.EqualError(t, err, "Token has been revoked")
+
+	_, err = auto.DefaultStore().RevokeSessions(context.Background(), "auid:missing")
+	assert.Equal(t, auto.ErrRecordNotFound, err)
+}
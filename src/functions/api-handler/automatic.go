@@ -1,74 +1,54 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"net/url"
-	"strings"
-	"time"
+	"sort"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/gin-gonic/gin"
 	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
 	"github.com/segmentio/ksuid"
 )
 
-const (
-	automaticIndexSortKeyValue = "_AUTOMATIC_ACCOUNT"
-)
-
-func automaticAccountsURL(path string) *url.URL {
-	return &url.URL{
-		Scheme: "https",
-		Host:   "accounts.automatic.com",
-		Path:   path,
+func automaticOAuth() (*automatic.OAuth, error) {
+	secrets, err := auto.Secrets()
+	if err != nil {
+		return nil, err
 	}
-}
 
-func automaticAPIURL(path string) *url.URL {
-	return &url.URL{
-		Scheme: "https",
-		Host:   "api.automatic.com",
-		Path:   path,
-	}
+	return &automatic.OAuth{
+		BaseURL:      auto.DefaultConfig().AutomaticAccountsURL,
+		ClientID:     secrets.ClientID,
+		ClientSecret: secrets.ClientSecret,
+		HTTP:         getHTTPStack(),
+	}, nil
 }
 
-func automaticAPISignedRequest(method, path, token string, body interface{}) (*http.Request, error) {
-	payload := bytes.NewBuffer(nil)
-	if body != nil {
-		if bodyBytes, ok := body.([]byte); ok {
-			payload = bytes.NewBuffer(bodyBytes)
-		} else {
-			bodyBytes, err := json.Marshal(body)
-			if err != nil {
-				return nil, err
-			}
-			payload = bytes.NewBuffer(bodyBytes)
-		}
-	}
-
-	request, err := http.NewRequest(method, automaticAPIURL(path).String(), payload)
-	request.Header.Set("Accept", "application/json")
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	if payload != nil {
-		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+func automaticClient(tokens automatic.TokenSource) *automatic.Client {
+	return &automatic.Client{
+		BaseURL: auto.DefaultConfig().AutomaticAPIURL,
+		HTTP:    getHTTPStack(),
+		Tokens:  tokens,
 	}
+}
 
+// automaticClientForAccount returns a client signed with the account's stored token, refreshing it as needed
+func automaticClientForAccount(account *auto.Account) (*automatic.Client, error) {
+	oauth, err := automaticOAuth()
 	if err != nil {
 		return nil, err
 	}
-	return request, nil
+
+	return automaticClient(auto.NewAutomaticTokenManager(auto.DefaultStore(), oauth, account)), nil
 }
 
 func integrationAutomaticAuthHandler(c *gin.Context) {
-	uri, err := integrationCreateAutomaticAuthenticationURL()
+	ctx := requestContext(c)
+
+	uri, err := integrationCreateAutomaticAuthenticationURL(ctx)
 	if err != nil {
-		reportError(err, false)
 		respondWithError(c, err)
 		return
 	}
@@ -76,21 +56,10 @@ func integrationAutomaticAuthHandler(c *gin.Context) {
 	c.Redirect(http.StatusTemporaryRedirect, uri)
 }
 
-func integrationCreateAutomaticAuthenticationURL() (string, error) {
+func integrationCreateAutomaticAuthenticationURL(ctx context.Context) (string, error) {
 	state := ksuid.New().String()
 
-	input := &dynamodb.PutItemInput{
-		TableName: auto.TableName(),
-		Item: map[string]*dynamodb.AttributeValue{
-			"PK":        auto.FormatString("integration/automatic/%s", state),
-			"SK":        {S: aws.String("_REQUEST")},
-			"StartedAt": auto.DynamoTime(time.Now()),
-			"ExpiresAt": auto.DynamoTime(time.Now().AddDate(0, 0, 2)),
-		},
-	}
-
-	_, err := auto.DynamoDB().PutItem(input)
-	if err != nil {
+	if err := auto.DefaultStore().CreateOAuthState(ctx, &auto.OAuthState{State: state}); err != nil {
 		return "", err
 	}
 
@@ -101,136 +70,94 @@ func integrationCreateAutomaticAuthenticationURL() (string, error) {
 		"scope:trip",
 	}
 
-	values := []string{
-		fmt.Sprintf("client_id=%s", auto.Secrets().ClientID),
-		fmt.Sprintf("response_type=code"),
-		fmt.Sprintf("scope=%s", strings.Join(scopes, "%20")),
-		fmt.Sprintf("state=%s", state),
+	oauth, err := automaticOAuth()
+	if err != nil {
+		return "", err
 	}
 
-	uri := automaticAccountsURL("/oauth/authorize/")
-	uri.RawQuery = strings.Join(values, "&")
-
-	return uri.String(), nil
+	return oauth.AuthorizeURL(state, scopes)
 }
 
 func integrationAutomaticAuthCallbackHandler(c *gin.Context) {
-	token, err := integrationAutomaticAuthCallback(c.DefaultQuery("code", ""), c.DefaultQuery("state", ""))
+	ctx := requestContext(c)
+
+	token, err := integrationAutomaticAuthCallback(ctx, c.DefaultQuery("code", ""), c.DefaultQuery("state", ""))
 	if err != nil {
-		reportError(err, false)
 		respondWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"Token": token})
+	respond(c, http.StatusOK, automaticAuthenticationPayload{Token: token})
 }
 
-func integrationAutomaticAuthCallback(code, state string) (string, error) {
-	if state == "" || code == "" {
-		return "", &Error{Status: http.StatusBadRequest, Detail: "Missing state or code"}
-	}
-
-	requestKey := map[string]*dynamodb.AttributeValue{
-		"PK": auto.FormatString("integration/automatic/%s", state),
-		"SK": {S: aws.String("_REQUEST")},
-	}
+// automaticAuthenticationPayload is the API token for the account that signed in with Automatic
+type automaticAuthenticationPayload struct {
+	Token string
+}
 
-	item, err := auto.DynamoDB().GetItem(&dynamodb.GetItemInput{
-		TableName:            auto.TableName(),
-		Key:                  requestKey,
-		ProjectionExpression: aws.String("StartedAt"),
-	})
-	if err != nil {
-		return "", err
-	}
-	if len(item.Item) == 0 {
-		return "", &Error{
-			Status: http.StatusNotFound,
-			Detail: "Failed to find a valid authentication request",
+func integrationAutomaticAuthCallback(ctx context.Context, code, state string) (string, error) {
+	if state == "" || code == "" {
+		parameter := "state"
+		if state != "" {
+			parameter = "code"
 		}
+		return "", newParameterError(errBadRequest, parameter, "Missing state or code")
 	}
 
-	payload, _ := json.Marshal(map[string]string{
-		"client_id":     auto.Secrets().ClientID,
-		"client_secret": auto.Secrets().ClientSecret,
-		"code":          code,
-		"grant_type":    "authorization_code",
-	})
-
-	req, err := http.NewRequest("POST", automaticAccountsURL("/oauth/access_token/").String(), bytes.NewBuffer([]byte(payload)))
-	req.Header.Set("Content-Type", "application/json")
-	if err != nil {
+	if _, err := auto.DefaultStore().FindOAuthState(ctx, state); err == auto.ErrRecordNotFound {
+		return "", newError(errAuthenticationRequestNotFound, "Failed to find a valid authentication request")
+	} else if err != nil {
 		return "", err
 	}
 
-	response, err := sendRequest(req)
+	oauth, err := automaticOAuth()
 	if err != nil {
 		return "", err
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
+	issued, err := oauth.Exchange(ctx, code)
 	if err != nil {
 		return "", err
 	}
 
-	token := auto.AutomaticAccessToken{}
+	token := auto.NewAutomaticAccessToken(issued)
 
-	err = json.Unmarshal(body, &token)
-	if err != nil {
-		return "", err
+	account, err := auto.DefaultStore().FindAccountByAutomaticID(ctx, token.UserID)
+	switch err {
+	case auto.ErrRecordNotFound:
+		account, err = integrationAutomaticAuthCreateAccount(ctx, token)
+	case nil:
+		err = auto.DefaultStore().SaveAutomaticAuthentication(ctx, account, token, nil)
 	}
-
-	result, err := auto.DynamoDB().Query(&dynamodb.QueryInput{
-		TableName:              auto.TableName(),
-		IndexName:              aws.String("GSI2"),
-		KeyConditionExpression: aws.String("#pk = :pk AND #sk = :sk"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":pk": auto.FormatString("automatic/%s", token.UserID),
-			":sk": {S: aws.String(automaticIndexSortKeyValue)},
-		},
-		ExpressionAttributeNames: map[string]*string{
-			"#pk": aws.String("GSI2PK"),
-			"#sk": aws.String("GSI2SK"),
-		},
-	})
 	if err != nil {
 		return "", err
 	}
 
-	var account *auto.Account
-	if len(result.Items) == 1 {
-		existingAccount, err := integrationAutomaticAuthExistingAccount(token, result.Items[0])
-		if err != nil {
-			return "", err
-		}
-		account = existingAccount
-	} else {
-		newAccount, err := integrationAutomaticAuthCreateAccount(token)
-		if err != nil {
-			return "", err
-		}
-		account = newAccount
-	}
-
-	if account == nil {
-		return "", errors.New("Failed to materialize account")
+	if err := auto.DefaultStore().DeleteOAuthState(ctx, state); err != nil {
+		reportError(ctx, err)
 	}
 
-	auto.DynamoDB().DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: auto.TableName(),
-		Key:       requestKey,
-	})
-
 	return apiTokenForAccount(account)
 }
 
-func integrationAutomaticAuthExistingAccount(token auto.AutomaticAccessToken, item map[string]*dynamodb.AttributeValue) (*auto.Account, error) {
-	account, err := auto.FindAccount(aws.StringValue(item["PK"].S))
+func integrationAutomaticAuthCreateAccount(ctx context.Context, token auto.AutomaticAccessToken) (*auto.Account, error) {
+	user, err := automaticClient(automatic.StaticToken(token.AccessToken)).User(ctx, token.UserID)
 	if err != nil {
 		return nil, err
 	}
 
-	err = integrationAutomaticWriteAccountInformation(account, nil, token)
+	account := &auto.Account{
+		ID:        fmt.Sprintf("auid:%s", ksuid.New().String()),
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+
+	var contacts []*auto.Contact
+	if user.Email != "" {
+		contacts = append(contacts, &auto.Contact{Type: auto.ContactTypeEmail, Value: user.Email, ReceiveContact: true})
+	}
+
+	err = auto.DefaultStore().SaveAutomaticAuthentication(ctx, account, token, contacts)
 	if err != nil {
 		return nil, err
 	}
@@ -238,112 +165,112 @@ func integrationAutomaticAuthExistingAccount(token auto.AutomaticAccessToken, it
 	return account, nil
 }
 
-type automaticUserStructure struct {
-	Username      string `json:"username"`
-	FirstName     string `json:"first_name"`
-	LastName      string `json:"last_name"`
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"email_verified"`
-}
+func integrationAutomaticSyncHandler(c *gin.Context) {
+	ctx := requestContext(c)
 
-func integrationAutomaticAuthCreateAccount(token auto.AutomaticAccessToken) (*auto.Account, error) {
-	request, err := automaticAPISignedRequest("GET", fmt.Sprintf("/user/%s", token.UserID), token.AccessToken, nil)
+	vehicles, err := integrationAutomaticSyncVehicles(ctx, c.GetString(contextUserIDKey))
 	if err != nil {
-		return nil, err
+		respondWithError(c, err)
+		return
 	}
 
-	response, err := sendRequest(request)
-	if err != nil {
-		return nil, err
-	}
+	respond(c, http.StatusOK, vehicles)
+}
 
-	body, err := ioutil.ReadAll(response.Body)
+func integrationAutomaticSyncVehicles(ctx context.Context, accountID string) ([]*auto.Vehicle, error) {
+	account, err := auto.DefaultStore().FindAccount(ctx, accountID)
 	if err != nil {
 		return nil, err
 	}
 
-	user := &automaticUserStructure{}
-	err = json.Unmarshal(body, user)
+	client, err := automaticClientForAccount(account)
 	if err != nil {
 		return nil, err
 	}
 
-	createdTime := time.Now()
+	vehicles := make([]*auto.Vehicle, 0)
+
+	err = client.Vehicles(ctx, func(page []automatic.Vehicle) error {
+		for _, result := range page {
+			vehicle := &auto.Vehicle{
+				ID:          fmt.Sprintf("veh:%s", result.ID),
+				AccountID:   account.ID,
+				AutomaticID: result.ID,
+				VIN:         result.VIN,
+				Make:        result.Make,
+				Model:       result.Model,
+				Submodel:    result.Submodel,
+				Year:        result.Year,
+				DisplayName: result.DisplayName,
+			}
+
+			if err := vehicle.EnrichFromVIN(); err != nil {
+				reportError(ctx, fmt.Errorf("vehicle %s: %v", vehicle.ID, err))
+			}
 
-	account := &auto.Account{
-		ID:          fmt.Sprintf("auid:%s", ksuid.New().String()),
-		FirstName:   user.FirstName,
-		LastName:    user.LastName,
-		CreatedAt:   createdTime,
-		UpdatedAt:   createdTime,
-		AutomaticID: token.UserID,
-	}
+			if existing, err := auto.DefaultStore().FindVehicle(ctx, account.ID, vehicle.ID); err == nil {
+				vehicle.CreatedAt = existing.CreatedAt
+			} else if err != auto.ErrRecordNotFound {
+				return err
+			}
 
-	err = integrationAutomaticWriteAccountInformation(account, user, token)
+			if err := auto.DefaultStore().SaveVehicle(ctx, vehicle); err != nil {
+				return err
+			}
+
+			if err := integrationAutomaticSyncTrips(ctx, client, vehicle); err != nil {
+				return err
+			}
+
+			vehicles = append(vehicles, vehicle)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return account, nil
+	return vehicles, nil
 }
 
-func integrationAutomaticWriteAccountInformation(account *auto.Account, user *automaticUserStructure, token auto.AutomaticAccessToken) error {
-	primaryKey := account.PrimaryKey()
-
-	items := []*dynamodb.TransactWriteItem{
-		&dynamodb.TransactWriteItem{
-			Put: &dynamodb.Put{
-				TableName: auto.TableName(),
-				Item: map[string]*dynamodb.AttributeValue{
-					"PK":                  {S: aws.String(primaryKey.HashKey)},
-					"SK":                  {S: aws.String(primaryKey.SortKey)},
-					"GSI2PK":              auto.FormatString("automatic/%s", token.UserID),
-					"GSI2SK":              {S: aws.String(automaticIndexSortKeyValue)},
-					"FirstName":           {S: aws.String(account.FirstName)},
-					"LastName":            {S: aws.String(account.LastName)},
-					"AutomaticID":         {S: aws.String(token.UserID)},
-					"CreatedAt":           auto.DynamoTime(account.CreatedAt),
-					"UpdatedAt":           auto.DynamoTime(time.Now()),
-					"LastAuthenticatedAt": auto.DynamoTime(time.Now()),
-				},
-			},
-		},
-		&dynamodb.TransactWriteItem{
-			Put: &dynamodb.Put{
-				TableName: auto.TableName(),
-				Item: map[string]*dynamodb.AttributeValue{
-					"PK":           {S: aws.String(primaryKey.HashKey)},
-					"SK":           auto.FormatString("access-token/%s", ksuid.New().String()),
-					"ExpiresAt":    auto.DynamoTime(time.Now().Add(time.Duration(token.ExpiresIn)/time.Second).AddDate(0, 0, 90)),
-					"ExpiresIn":    {N: aws.String(fmt.Sprintf("%d", token.ExpiresIn))},
-					"Scopes":       {SS: aws.StringSlice(strings.Split(token.Scope, " "))},
-					"AccessToken":  {S: aws.String(token.AccessToken)},
-					"RefreshToken": {S: aws.String(token.RefreshToken)},
-					"GSI1PK":       auto.FormatString("access_token/%s", token.UserID),
-					"GSI2SK":       auto.FormatString("token-for/%s", primaryKey.HashKey),
-				},
-			},
-		},
+// integrationAutomaticSyncTrips adds trip-derived odometer readings for trips
+// taken since the vehicle's latest reading. Vehicles without a reading have no
+// starting point, so their trips are skipped until one is entered by hand.
+func integrationAutomaticSyncTrips(ctx context.Context, client *automatic.Client, vehicle *auto.Vehicle) error {
+	latest, err := auto.DefaultStore().LatestOdometerReading(ctx, vehicle.AccountID, vehicle.ID)
+	if err == auto.ErrRecordNotFound {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	if user != nil {
-		items = append(items, &dynamodb.TransactWriteItem{
-			Put: &dynamodb.Put{
-				TableName: auto.TableName(),
-				Item: map[string]*dynamodb.AttributeValue{
-					"PK":             {S: aws.String(primaryKey.HashKey)},
-					"SK":             auto.FormatString("contact/%s/_EMAIL", auto.HashString(user.Email)),
-					"ContactValue":   {S: aws.String(user.Email)},
-					"ContactType":    {S: aws.String("EMAIL")},
-					"ReceiveContact": {N: aws.String("1")},
-				},
-			},
-		})
+	trips, err := client.AllTrips(ctx, automatic.TripQuery{
+		Vehicle:      vehicle.AutomaticID,
+		StartedAfter: latest.ReadAt,
+	})
+	if err != nil {
+		return err
 	}
 
-	_, err := auto.DynamoDB().TransactWriteItems(&dynamodb.TransactWriteItemsInput{
-		TransactItems: items,
+	// Each reading builds on the one before it, so trips must be added in order.
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].EndedAt.Before(trips[j].EndedAt)
 	})
 
-	return err
+	for _, trip := range trips {
+		if !trip.EndedAt.After(latest.ReadAt) {
+			continue
+		}
+
+		_, err := auto.DefaultStore().AddTripOdometerReading(ctx, vehicle.AccountID, vehicle.ID, trip.ID, trip.EndedAt, trip.Distance/1000)
+		if err == auto.ErrOdometerNotMonotonic {
+			// A later manual reading disagrees with the trip distance. The manual reading wins.
+			reportError(ctx, fmt.Errorf("trip %s: %v", trip.ID, err))
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
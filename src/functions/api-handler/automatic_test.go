@@ -1,34 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/maddiesch/automatic-reminders/auto/automatic/simulator"
+	"github.com/maddiesch/automatic-reminders/auto/cassette"
+	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestAuthenticationFlow(t *testing.T) {
 	t.Run("golden path", func(t *testing.T) {
-		handler := func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-
-			switch r.URL.Path {
-			case "/oauth/access_token/":
-				w.Write([]byte(`{"access_token":"7c503287a78fb78b278c9000b77720477e000000","scope":"scope:offline scope:public scope:trip scope:user:profile scope:vehicle:profile","expires_in":2591999,"refresh_token":"b1729476bc5e36c0000009ff6bbe0421d8000000","token_type":"bearer","user":{"id":"U_cfdca00556000000","sid":"U_cfdca005564e0000"},"user_id":"U_cfdca00556000000"}`))
-			case "/user/U_cfdca00556000000":
-				w.Write([]byte(`{"id":"U_cfdca00556000000","url":"https://api.automatic.com/user/U_cfdca00556000000/","username":"test@email.test","first_name":"Testy","last_name":"Mc Testerson","email":"test@email.test","email_verified":true}`))
-			default:
-				w.WriteHeader(http.StatusNotFound)
-			}
-		}
-
 		t.Run("first authentication", func(t *testing.T) {
 			var state string
 
 			t.Run("creates a redirect url", func(t *testing.T) {
-				redirect, err := integrationCreateAutomaticAuthenticationURL()
+				redirect, err := integrationCreateAutomaticAuthenticationURL(context.Background())
 				require.NoError(t, err)
 
 				uri, err := url.Parse(redirect)
@@ -37,9 +33,9 @@ func TestAuthenticationFlow(t *testing.T) {
 				state = uri.Query().Get("state")
 			})
 
-			withStubbedRequests(t, handler, func(t *testing.T) {
+			withCassette(t, "automatic-first-authentication", func(t *testing.T) {
 				t.Run("handles a response code", func(t *testing.T) {
-					_, err := integrationAutomaticAuthCallback("fake-code", state)
+					_, err := integrationAutomaticAuthCallback(context.Background(), "fake-code", state)
 
 					assert.NoError(t, err)
 				})
@@ -50,7 +46,7 @@ func TestAuthenticationFlow(t *testing.T) {
 			var state string
 
 			t.Run("creates a redirect url", func(t *testing.T) {
-				redirect, err := integrationCreateAutomaticAuthenticationURL()
+				redirect, err := integrationCreateAutomaticAuthenticationURL(context.Background())
 				require.NoError(t, err)
 
 				uri, err := url.Parse(redirect)
@@ -59,9 +55,9 @@ func TestAuthenticationFlow(t *testing.T) {
 				state = uri.Query().Get("state")
 			})
 
-			withStubbedRequests(t, handler, func(t *testing.T) {
+			withCassette(t, "automatic-second-authentication", func(t *testing.T) {
 				t.Run("handles a response code", func(t *testing.T) {
-					_, err := integrationAutomaticAuthCallback("fake-code", state)
+					_, err := integrationAutomaticAuthCallback(context.Background(), "fake-code", state)
 
 					assert.NoError(t, err)
 				})
@@ -69,3 +65,205 @@ func TestAuthenticationFlow(t *testing.T) {
 		})
 	})
 }
+
+// syncAccount returns a new account with an Automatic token and a vehicle with a manual odometer reading
+func syncAccount(t *testing.T, readAt time.Time) *auto.Account {
+	ctx := context.Background()
+
+	account := &auto.Account{ID: "auid:" + ksuid.New().String()}
+	require.NoError(t, auto.DefaultStore().SaveAutomaticAuthentication(ctx, account, auto.AutomaticAccessToken{
+		UserID:       "U_" + ksuid.New().String(),
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresIn:    3600,
+	}, nil))
+	require.NoError(t, auto.DefaultStore().SaveVehicle(ctx, &auto.Vehicle{ID: "veh:C_2", AccountID: account.ID, AutomaticID: "C_2"}))
+	require.NoError(t, auto.DefaultStore().AddOdometerReading(ctx, &auto.OdometerReading{
+		AccountID: account.ID,
+		VehicleID: "veh:C_2",
+		Reading:   1000,
+		ReadAt:    readAt,
+		Source:    auto.OdometerSourceManual,
+	}))
+
+	return account
+}
+
+func tripQuery(vehicleID string, startedAfter time.Time) url.Values {
+	return url.Values{
+		"vehicle":         {vehicleID},
+		"started_at__gte": {strconv.FormatInt(startedAfter.Unix(), 10)},
+		"limit":           {"250"},
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	readAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	vehicles := url.Values{"limit": {"250"}}
+
+	trip := func(id string, distance float64, endedAt time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         id,
+			"distance_m": distance,
+			"started_at": endedAt.Add(-time.Hour),
+			"ended_at":   endedAt,
+		}
+	}
+
+	t.Run("follows every page of vehicles and trips", func(t *testing.T) {
+		account := syncAccount(t, readAt)
+
+		interactions := cassette.Pages("/vehicle/", vehicles,
+			[]map[string]interface{}{{"id": "C_1", "make": "Honda", "year": 2015}},
+			[]map[string]interface{}{{"id": "C_2", "make": "Subaru", "year": 2018}},
+		)
+		interactions = append(interactions, cassette.Pages("/trip/", tripQuery("C_2", readAt),
+			[]interface{}{trip("T_2", 2500, readAt.Add(4*time.Hour))},
+			[]interface{}{trip("T_1", 12500, readAt.Add(2*time.Hour))},
+		)...)
+
+		withInteractions(t, interactions, func(t *testing.T) {
+			synced, err := integrationAutomaticSyncVehicles(ctx, account.ID)
+			require.NoError(t, err)
+			require.Len(t, synced, 2)
+			assert.Equal(t, "veh:C_1", synced[0].ID)
+			assert.Equal(t, "Subaru", synced[1].Make)
+
+			latest, err := auto.DefaultStore().LatestOdometerReading(ctx, account.ID, "veh:C_2")
+			require.NoError(t, err)
+			assert.Equal(t, 1015.0, latest.Reading, "trips are added in the order they ended")
+			assert.Equal(t, "T_2", latest.TripID)
+		})
+	})
+
+	t.Run("retries through a failure", func(t *testing.T) {
+		account := syncAccount(t, readAt)
+
+		interactions := []cassette.Interaction{
+			cassette.Fail(http.MethodGet, "/vehicle/", "connection reset by peer").WithQuery(vehicles),
+			cassette.Respond(http.MethodGet, "/vehicle/", http.StatusServiceUnavailable, "").WithQuery(vehicles),
+		}
+		interactions = append(interactions, cassette.Pages("/vehicle/", vehicles, []interface{}{})...)
+
+		withInteractions(t, interactions, func(t *testing.T) {
+			synced, err := integrationAutomaticSyncVehicles(ctx, account.ID)
+			require.NoError(t, err)
+			assert.Empty(t, synced)
+		})
+	})
+
+	t.Run("refreshes a rejected token", func(t *testing.T) {
+		account := syncAccount(t, readAt)
+
+		interactions := []cassette.Interaction{
+			cassette.Respond(http.MethodGet, "/vehicle/", http.StatusUnauthorized, map[string]string{"error": "err_unauthorized"}).WithQuery(vehicles),
+			cassette.Respond(http.MethodPost, "/oauth/access_token/", http.StatusOK, map[string]interface{}{
+				"access_token":  "refreshed",
+				"refresh_token": "refresh-2",
+				"expires_in":    3600,
+			}).WithRequestBody(map[string]string{
+				"client_id":     "fake-client-id",
+				"client_secret": "fake-client-secret",
+				"grant_type":    "refresh_token",
+				"refresh_token": "refresh",
+			}),
+		}
+		interactions = append(interactions, cassette.Pages("/vehicle/", vehicles, []interface{}{})...)
+
+		withInteractions(t, interactions, func(t *testing.T) {
+			_, err := integrationAutomaticSyncVehicles(ctx, account.ID)
+			require.NoError(t, err)
+
+			token, err := auto.DefaultStore().LatestAutomaticAccessToken(ctx, account)
+			require.NoError(t, err)
+			assert.Equal(t, "refreshed", token.AccessToken)
+		})
+	})
+
+	t.Run("reports Automatic being down", func(t *testing.T) {
+		account := syncAccount(t, readAt)
+
+		withInteractions(t, []cassette.Interaction{
+			cassette.Respond(http.MethodGet, "/vehicle/", http.StatusBadGateway, "").WithQuery(vehicles).Repeated(),
+		}, func(t *testing.T) {
+			_, err := integrationAutomaticSyncVehicles(ctx, account.ID)
+			assert.True(t, errors.Is(err, automatic.ErrUnavailable))
+		})
+	})
+
+	t.Run("gives up on a slow response when the request ends", func(t *testing.T) {
+		account := syncAccount(t, readAt)
+
+		withInteractions(t, []cassette.Interaction{
+			cassette.Respond(http.MethodGet, "/vehicle/", http.StatusOK, "{}").WithQuery(vehicles).WithLatency(time.Second),
+		}, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+
+			_, err := integrationAutomaticSyncVehicles(ctx, account.ID)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		})
+	})
+}
+
+func TestSimulatedAutomatic(t *testing.T) {
+	ctx := context.Background()
+	sim := simulator.New(simulator.DefaultConfig())
+	now := time.Now()
+	sim.Now = func() time.Time { return now }
+
+	withSimulator(t, sim, func(t *testing.T) {
+		redirect, err := integrationCreateAutomaticAuthenticationURL(ctx)
+		require.NoError(t, err)
+
+		// Sign in as the simulated user without following the redirect back to the app
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		response, err := client.Get(redirect + "&user=U_simulated")
+		require.NoError(t, err)
+		response.Body.Close()
+
+		callback, err := url.Parse(response.Header.Get("Location"))
+		require.NoError(t, err)
+
+		_, err = integrationAutomaticAuthCallback(ctx, callback.Query().Get("code"), callback.Query().Get("state"))
+		require.NoError(t, err)
+
+		account, err := auto.DefaultStore().FindAccountByAutomaticID(ctx, "U_simulated")
+		require.NoError(t, err)
+		assert.Equal(t, "Sim", account.FirstName)
+
+		vehicles, err := integrationAutomaticSyncVehicles(ctx, account.ID)
+		require.NoError(t, err)
+		require.Len(t, vehicles, 2)
+		assert.Equal(t, "veh:C_accord", vehicles[0].ID)
+
+		readAt := now.Add(-72 * time.Hour).Truncate(time.Second)
+		require.NoError(t, auto.DefaultStore().AddOdometerReading(ctx, &auto.OdometerReading{
+			AccountID: account.ID,
+			VehicleID: "veh:C_accord",
+			Reading:   1000,
+			ReadAt:    readAt,
+			Source:    auto.OdometerSourceManual,
+		}))
+
+		// Expiring the tokens makes the sync refresh them first
+		sim.ExpireTokens("U_simulated")
+
+		_, err = integrationAutomaticSyncVehicles(ctx, account.ID)
+		require.NoError(t, err)
+
+		expected := 1000.0
+		trips := sim.Trips("C_accord")
+		for i := len(trips) - 1; i >= 0; i-- {
+			if !trips[i].StartedAt.Before(readAt) {
+				expected += trips[i].Distance / 1000
+			}
+		}
+
+		latest, err := auto.DefaultStore().LatestOdometerReading(ctx, account.ID, "veh:C_accord")
+		require.NoError(t, err)
+		assert.InDelta(t, expected, latest.Reading, 0.001)
+		assert.Equal(t, trips[0].ID, latest.TripID)
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	validatorv8 "gopkg.in/go-playground/validator.v8"
+	validatorv9 "gopkg.in/go-playground/validator.v9"
+)
+
+// bindJSON decodes and validates the request body into v. A body that can't
+// be bound is a bad_request error pointing at the members that are wrong.
+func bindJSON(c *gin.Context, v interface{}) error {
+	if err := c.ShouldBindJSON(v); err != nil {
+		return invalidRequestError(err)
+	}
+	return nil
+}
+
+// invalidRequestError returns the bad_request error for an error decoding or validating a request
+func invalidRequestError(err error) *Error {
+	var (
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+		timeErr   *time.ParseError
+	)
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return newError(errBadRequest, "The request body is empty").causedBy(err)
+	case errors.As(err, &syntaxErr):
+		return newError(errBadRequest, "The request body isn't valid JSON").causedBy(err)
+	case errors.As(err, &typeErr):
+		pointer := fieldPointer(typeErr.Field)
+		return newFieldError(errBadRequest, pointer, fmt.Sprintf("%s must be %s", fieldName(pointer), jsonTypeName(typeErr.Type))).causedBy(err)
+	case errors.As(err, &timeErr):
+		return newError(errBadRequest, "Times must be RFC 3339 timestamps").causedBy(err)
+	}
+
+	if invalid := validationFields(err); len(invalid) > 0 {
+		return invalidFieldsError(invalid).causedBy(err)
+	}
+
+	return newError(errBadRequest, "The request body is invalid").causedBy(err)
+}
+
+// invalidFieldsError returns the bad_request error for the invalid fields
+func invalidFieldsError(invalid []InvalidField) *Error {
+	e := newError(errBadRequest, "The request is invalid")
+	if len(invalid) == 1 {
+		e.Detail = invalid[0].Detail
+	}
+	e.Invalid = invalid
+	return e
+}
+
+// validationFields returns the fields a validator rejected. Gin binds with
+// validator v8, and records are validated with v9.
+func validationFields(err error) []InvalidField {
+	var invalid []InvalidField
+
+	switch errs := err.(type) {
+	case validatorv8.ValidationErrors:
+		for _, fieldErr := range errs {
+			pointer := namespacePointer(fieldErr.NameNamespace)
+			invalid = append(invalid, InvalidField{Pointer: pointer, Detail: validationDetail(fieldName(pointer), fieldErr.Tag, fieldErr.Param)})
+		}
+	case validatorv9.ValidationErrors:
+		for _, fieldErr := range errs {
+			pointer := namespacePointer(fieldErr.Namespace())
+			invalid = append(invalid, InvalidField{Pointer: pointer, Detail: validationDetail(fieldName(pointer), fieldErr.Tag(), fieldErr.Param())})
+		}
+	}
+
+	// v8's errors are a map, so order them for a stable response
+	sort.Slice(invalid, func(i, j int) bool { return invalid[i].Pointer < invalid[j].Pointer })
+	return invalid
+}
+
+func validationDetail(name, tag, param string) string {
+	switch tag {
+	case "required", "exists":
+		return fmt.Sprintf("%s is required", name)
+	case "min", "gte":
+		return fmt.Sprintf("%s must be at least %s", name, param)
+	case "max", "lte":
+		return fmt.Sprintf("%s must be at most %s", name, param)
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", name, param)
+	case "lt":
+		return fmt.Sprintf("%s must be less than %s", name, param)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", name, strings.Join(strings.Fields(param), ", "))
+	default:
+		return fmt.Sprintf("%s is invalid", name)
+	}
+}
+
+var namespaceIndex = regexp.MustCompile(`\[(\w+)\]`)
+
+// namespacePointer returns the JSON Pointer for a validator namespace, e.g.
+// Reminder.Intervals[0].Days is /Intervals/0/Days. The struct's own name is dropped.
+// Request fields aren't renamed with json tags, so their names are their members'.
+func namespacePointer(namespace string) string {
+	namespace = namespaceIndex.ReplaceAllString(namespace, ".$1")
+	if i := strings.Index(namespace, "."); i >= 0 {
+		namespace = namespace[i+1:]
+	}
+	return fieldPointer(namespace)
+}
+
+// fieldPointer returns the JSON Pointer for a dotted path of JSON members
+func fieldPointer(path string) string {
+	if path == "" {
+		return ""
+	}
+	pointer := ""
+	for _, member := range strings.Split(path, ".") {
+		pointer = appendPointer(pointer, member)
+	}
+	return pointer
+}
+
+// appendPointer returns the JSON Pointer to a member of the value at pointer
+func appendPointer(pointer, member string) string {
+	return pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(member)
+}
+
+// fieldName returns the last member of a JSON Pointer, or "The body" for the whole body
+func fieldName(pointer string) string {
+	if pointer == "" {
+		return "The body"
+	}
+	name := pointer[strings.LastIndex(pointer, "/")+1:]
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+}
+
+func jsonTypeName(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "an RFC 3339 timestamp"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
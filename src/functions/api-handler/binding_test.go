@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/serverless"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindJSON(t *testing.T) {
+	bind := func(body string, v interface{}) *Error {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+		err := bindJSON(c, v)
+		if err == nil {
+			return nil
+		}
+		apiErr, ok := err.(*Error)
+		require.True(t, ok, "binding errors are API errors")
+		return apiErr
+	}
+
+	t.Run("binds a valid body", func(t *testing.T) {
+		request := addOdometerReadingRequest{}
+		assert.Nil(t, bind(`{"Reading":1200}`, &request))
+		assert.Equal(t, 1200.0, *request.Reading)
+
+		assert.Nil(t, bind(`{"Reading":0}`, &request), "zero is a value")
+		assert.Equal(t, 0.0, *request.Reading)
+	})
+
+	t.Run("points at a missing field", func(t *testing.T) {
+		err := bind(`{}`, &addOdometerReadingRequest{})
+		require.NotNil(t, err)
+		assert.Equal(t, errBadRequest.Code, err.Code)
+		assert.Equal(t, []InvalidField{{Pointer: "/Reading", Detail: "Reading is required"}}, err.Invalid)
+	})
+
+	t.Run("points at a field of the wrong type", func(t *testing.T) {
+		err := bind(`{"Reading":"lots"}`, &addOdometerReadingRequest{})
+		require.NotNil(t, err)
+		assert.Equal(t, []InvalidField{{Pointer: "/Reading", Detail: "Reading must be a number"}}, err.Invalid)
+	})
+
+	t.Run("rejects a body that isn't JSON", func(t *testing.T) {
+		err := bind(`{"Reading":`, &addOdometerReadingRequest{})
+		require.NotNil(t, err)
+		assert.Equal(t, errBadRequest.Code, err.Code)
+		assert.Empty(t, err.Invalid)
+		assert.NotContains(t, err.Detail, "unexpected", "decoder messages aren't returned")
+	})
+
+	t.Run("points at fields records reject", func(t *testing.T) {
+		record := struct {
+			Title string `validate:"required"`
+			Hour  int    `validate:"min=0,max=23"`
+		}{Hour: 25}
+
+		err := invalidRequestError(serverless.GetValidator().Struct(record))
+		assert.Equal(t, []InvalidField{
+			{Pointer: "/Hour", Detail: "Hour must be at most 23"},
+			{Pointer: "/Title", Detail: "Title is required"},
+		}, err.Invalid)
+	})
+}
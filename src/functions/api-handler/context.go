@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/serverless/middleware"
+	"github.com/segmentio/ksuid"
+)
+
+// lambdaDeadlineMargin is how long before the Lambda deadline work is cancelled, leaving time to respond
+const lambdaDeadlineMargin = time.Second
+
+// requestContext returns the context for work done by the request
+func requestContext(c *gin.Context) context.Context {
+	return c.Request.Context()
+}
+
+// requestIDHeader carries the request ID in requests and responses
+const requestIDHeader = "X-Request-Id"
+
+// validRequestID is what a request ID passed by the client can look like. Anything else is replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// AssignRequestID propagates the X-Request-Id header the client passes, or
+// assigns a new ID. The ID is returned in the response's X-Request-Id header
+// and added to the request's context, so it's available wherever the context
+// goes and is on every log line.
+func AssignRequestID(c *gin.Context) {
+	requestID := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(requestID) {
+		requestID = ksuid.New().String()
+	}
+
+	c.Set(middleware.GinContextRequestIDKey, requestID)
+	c.Header(requestIDHeader, requestID)
+
+	ctx := auto.WithRequestID(c.Request.Context(), requestID)
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// LogRequests logs every request once it's been handled
+func LogRequests(c *gin.Context) {
+	start := time.Now()
+
+	c.Next()
+
+	status := c.Writer.Status()
+	fields := auto.Fields{
+		"method":     c.Request.Method,
+		"route":      routeOf(c),
+		"status":     status,
+		"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if accountID := c.GetString(contextUserIDKey); accountID != "" {
+		fields["account_id"] = accountID
+	}
+
+	level := auto.LogInfo
+	if status >= http.StatusInternalServerError {
+		level = auto.LogError
+	}
+	auto.DefaultLogger().Log(requestContext(c), level, "request handled", fields)
+}
+
+var (
+	routesByHandler map[string]string
+	routesSetup     sync.Once
+)
+
+// routeOf returns the path pattern of the route that handled the request,
+// rather than the path, which can hold tokens. The engine's routes are looked up
+// by the name of their handler.
+func routeOf(c *gin.Context) string {
+	routesSetup.Do(func() {
+		routesByHandler = make(map[string]string)
+		for _, route := range getEngine().Routes() {
+			routesByHandler[route.Method+" "+route.Handler] = route.Path
+		}
+	})
+
+	if route, ok := routesByHandler[c.Request.Method+" "+c.HandlerName()]; ok {
+		return route
+	}
+	return "unmatched"
+}
+
+// lambdaProxy serves API Gateway events with the engine. Unlike the serverless
+// package's handler, requests carry the invocation's context, so their work is
+// cancelled shortly before the Lambda times out.
+type lambdaProxy struct {
+	core.RequestAccessor
+
+	engine *gin.Engine
+}
+
+func (p *lambdaProxy) handle(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-lambdaDeadlineMargin))
+		defer cancel()
+	}
+
+	request, err := p.ProxyEventToHTTPRequest(event)
+	if err != nil {
+		return core.GatewayTimeout(), core.NewLoggedError("Could not convert proxy event to request: %v", err)
+	}
+
+	writer := core.NewProxyResponseWriter()
+	p.engine.ServeHTTP(http.ResponseWriter(writer), request.WithContext(ctx))
+
+	response, err := writer.GetProxyResponse()
+	if err != nil {
+		return core.GatewayTimeout(), core.NewLoggedError("Error while generating proxy response: %v", err)
+	}
+
+	return response, nil
+}
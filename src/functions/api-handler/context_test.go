@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLambdaProxy(t *testing.T) {
+	var requestID string
+	var deadline time.Time
+
+	engine := gin.New()
+	engine.Use(AssignRequestID)
+	engine.GET("/context", func(c *gin.Context) {
+		ctx := requestContext(c)
+		requestID = auto.RequestID(ctx)
+		deadline, _ = ctx.Deadline()
+		c.Status(http.StatusNoContent)
+	})
+
+	invocationDeadline := time.Now().Add(5 * time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), invocationDeadline)
+	defer cancel()
+
+	proxy := &lambdaProxy{engine: engine}
+	response, err := proxy.handle(ctx, events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/context"})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.NotEmpty(t, requestID)
+	assert.Equal(t, response.Headers["X-Request-Id"], requestID)
+	assert.Equal(t, invocationDeadline.Add(-lambdaDeadlineMargin), deadline)
+}
+
+func TestRequestLogging(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previous := auto.DefaultLogger()
+	auto.SetDefaultLogger(auto.NewLogger(logs, auto.LogInfo))
+	defer auto.SetDefaultLogger(previous)
+
+	server := httptest.NewServer(getEngine())
+	defer server.Close()
+
+	account := &auto.Account{ID: "auid:" + ksuid.New().String(), FirstName: "Logged"}
+	require.NoError(t, auto.DefaultStore().SaveAutomaticAuthentication(context.Background(), account, auto.AutomaticAccessToken{
+		UserID:      "U_" + ksuid.New().String(),
+		AccessToken: "access",
+		ExpiresIn:   3600,
+	}, nil))
+	token, err := apiTokenForAccount(account)
+	require.NoError(t, err)
+
+	get := func(path, requestID string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+token)
+		request.Header.Set("X-Request-Id", requestID)
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		return response
+	}
+
+	response := get("/v1/private/", "client-request-1")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "client-request-1", response.Header.Get("X-Request-Id"), "the client's request ID is propagated")
+
+	response = get("/v1/private/", "not a valid id")
+	assert.NotEqual(t, "not a valid id", response.Header.Get("X-Request-Id"))
+	assert.NotEmpty(t, response.Header.Get("X-Request-Id"), "an invalid request ID is replaced")
+
+	var entry map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, `"request_id":"client-request-1"`) && strings.Contains(line, "request handled") {
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		}
+	}
+	require.NotNil(t, entry, "the request is logged")
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/v1/private/", entry["route"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+	assert.Equal(t, account.ID, entry["account_id"])
+	assert.Contains(t, entry, "latency_ms")
+	assert.NotContains(t, logs.String(), token, "tokens aren't logged")
+}
@@ -1,64 +1,280 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
 	"github.com/maddiesch/serverless"
 )
 
-const (
-	errCodeInternalServerError = "internal_server_error"
-	errCodeBadRequest          = "bad_request"
-	errCodeNotFound            = "not_found"
+// errorCode is a kind of error the API returns. Its Code is stable, so clients
+// can rely on it, and it's always returned with the same status.
+type errorCode struct {
+	Code   string
+	Status int
+	Title  string
+	// Description says when the error is returned, for the catalog
+	Description string
+}
+
+// The API's error codes. They're published by GET /v1/errors and in docs/errors.md.
+var (
+	errInternalServerError = errorCode{
+		Code: "internal_server_error", Status: http.StatusInternalServerError, Title: "Internal Server Error",
+		Description: "Something went wrong on the server. The response's request ID identifies the failure in the server's logs.",
+	}
+	errBadRequest = errorCode{
+		Code: "bad_request", Status: http.StatusBadRequest, Title: "Bad Request",
+		Description: "The request body or parameters are malformed or invalid. Detail says what's wrong.",
+	}
+	errInvalidVIN = errorCode{
+		Code: "invalid_vin", Status: http.StatusBadRequest, Title: "Invalid VIN",
+		Description: "The VIN can't be decoded. Detail says why.",
+	}
+	errInvalidCursor = errorCode{
+		Code: "invalid_cursor", Status: http.StatusBadRequest, Title: "Invalid cursor",
+		Description: "The cursor was altered, or was issued for a listing with other parameters or for another account. Start the listing again without a cursor.",
+	}
+	errUnauthorized = errorCode{
+		Code: "unauthorized", Status: http.StatusUnauthorized, Title: "Unauthorized",
+		Description: "The API token or action link is missing, invalid or expired.",
+	}
+	errNotFound = errorCode{
+		Code: "not_found", Status: http.StatusNotFound, Title: "Record not found",
+		Description: "The resource doesn't exist, or belongs to another account.",
+	}
+	errAuthenticationRequestNotFound = errorCode{
+		Code: "authentication_request_not_found", Status: http.StatusNotFound, Title: "Authentication request not found",
+		Description: "The Automatic sign in callback's state doesn't match a sign in that was started, or it's expired. Start signing in again.",
+	}
+	errConflict = errorCode{
+		Code: "conflict", Status: http.StatusConflict, Title: "Conflict",
+		Description: "The resource changed while the request was being handled. It's safe to retry.",
+	}
+	errNotAcceptable = errorCode{
+		Code: "not_acceptable", Status: http.StatusNotAcceptable, Title: "Not Acceptable",
+		Description: "The Accept header doesn't allow application/json or application/problem+json.",
+	}
+	errUnsupportedMediaType = errorCode{
+		Code: "unsupported_media_type", Status: http.StatusUnsupportedMediaType, Title: "Unsupported Media Type",
+		Description: "The request has a body that isn't application/json.",
+	}
+	errInvalidOdometerReading = errorCode{
+		Code: "invalid_odometer_reading", Status: http.StatusUnprocessableEntity, Title: "Invalid odometer reading",
+		Description: "The reading is lower than an earlier reading of the vehicle or higher than a later one.",
+	}
+	errAutomaticUnauthorized = errorCode{
+		Code: "automatic_unauthorized", Status: http.StatusForbidden, Title: "Automatic authorization revoked",
+		Description: "Automatic no longer accepts the account's authorization. Sign in with Automatic again.",
+	}
+	errAutomaticUnavailable = errorCode{
+		Code: "automatic_unavailable", Status: http.StatusServiceUnavailable, Title: "Automatic unavailable",
+		Description: "Automatic isn't responding or is limiting requests. Try again later.",
+	}
+	errRequestTimeout = errorCode{
+		Code: "request_timeout", Status: http.StatusGatewayTimeout, Title: "Request timed out",
+		Description: "The request took too long to handle. It's safe to retry.",
+	}
+
+	// errorCatalog is every error code, in the order they're published
+	errorCatalog = []errorCode{
+		errBadRequest,
+		errInvalidVIN,
+		errInvalidCursor,
+		errUnauthorized,
+		errAutomaticUnauthorized,
+		errNotFound,
+		errAuthenticationRequestNotFound,
+		errConflict,
+		errNotAcceptable,
+		errUnsupportedMediaType,
+		errInvalidOdometerReading,
+		errInternalServerError,
+		errAutomaticUnavailable,
+		errRequestTimeout,
+	}
 )
 
+// domainErrors maps errors from the app's packages to the error returned for them
+var domainErrors = []struct {
+	err    error
+	code   errorCode
+	detail string
+}{
+	{auto.ErrRecordNotFound, errNotFound, "The requested resource could not be found"},
+	{auto.ErrRecordConflict, errConflict, "The resource changed while the request was being handled. Try again."},
+	{keys.ErrInvalidPart, errBadRequest, "An ID in the request is empty or contains a slash"},
+	{auto.ErrOdometerNotMonotonic, errInvalidOdometerReading, "The reading is lower than an earlier reading or higher than a later one"},
+	{automatic.ErrUnauthorized, errAutomaticUnauthorized, "Automatic no longer accepts this account's authorization. Sign in with Automatic again."},
+	{automatic.ErrUnavailable, errAutomaticUnavailable, "Automatic isn't responding. Try again later."},
+	{automatic.ErrRateLimited, errAutomaticUnavailable, "Automatic isn't responding. Try again later."},
+	{outbound.ErrCircuitOpen, errAutomaticUnavailable, "Automatic isn't responding. Try again later."},
+	{outbound.ErrRateLimited, errAutomaticUnavailable, "Automatic isn't responding. Try again later."},
+	{context.DeadlineExceeded, errRequestTimeout, "The request took too long. Try again."},
+}
+
+// newError returns an error with the code
+func newError(code errorCode, detail string) *Error {
+	return &Error{Status: code.Status, Title: code.Title, Detail: detail, Code: code.Code}
+}
+
+// respondWithError aborts the request with the error's response and logs it.
+// Errors that aren't an *Error or a known domain error are internal: their
+// details are only logged, and the response carries the request ID to find them by.
 func respondWithError(c *gin.Context, err error) {
-	if err, ok := err.(*Error); ok {
-		validationErr := serverless.GetValidator().Struct(err)
-		if validationErr != nil {
-			panic(err)
-		}
-		c.AbortWithStatusJSON(err.Status, err)
-	} else if err == auto.ErrRecordNotFound {
-		respondWithError(c, &Error{
-			Status: http.StatusNotFound,
-			Title:  "Record not found",
-			Detail: "The requested resource could not be found",
-			Code:   errCodeNotFound,
-		})
+	ctx := requestContext(c)
+	response := errorResponse(ctx, err)
+
+	fields := auto.Fields{"error": err, "error_code": response.Code, "status": response.Status}
+	if response.cause != nil {
+		fields["error"] = response.cause
+	}
+	if response.Status >= http.StatusInternalServerError {
+		auto.DefaultLogger().Error(ctx, "request failed", fields)
 	} else {
-		respondWithError(c, &Error{
-			Status: http.StatusInternalServerError,
-			Title:  "Internal Server Error",
-			Detail: "An unknown error occurred.",
-			Code:   errCodeInternalServerError,
-			Meta: map[string]interface{}{
-				"SubError": fmt.Sprintf("%v", err),
-			},
-			SubError: err,
-		})
+		auto.DefaultLogger().Info(ctx, "request rejected", fields)
+	}
+
+	if errorMediaType(c) == mediaTypeProblem {
+		render(c, response.Status, mediaTypeProblem, newProblem(c, response))
+		c.Abort()
+		return
 	}
+
+	render(c, response.Status, mediaTypeJSON, errorDocument{
+		Errors: response.objects(),
+		Meta:   documentMeta(ctx),
+	})
+	c.Abort()
 }
 
-// Error is an API response error code
+// errorResponse returns a copy of the *Error the error wraps, the error for a domain error, or an internal error
+func errorResponse(ctx context.Context, err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		if validationErr := serverless.GetValidator().Struct(apiErr); validationErr != nil {
+			auto.DefaultLogger().Error(ctx, "invalid error response", auto.Fields{"error": validationErr})
+			return newError(errInternalServerError, "An unknown error occurred.")
+		}
+		response := *apiErr
+		return &response
+	}
+
+	for _, domain := range domainErrors {
+		if errors.Is(err, domain.err) {
+			return newError(domain.code, domain.detail)
+		}
+	}
+
+	return newError(errInternalServerError, "An unknown error occurred.")
+}
+
+// Error is an error the API returns. It's rendered as a JSON:API errors
+// document, or as an RFC 7807 problem for clients that accept application/problem+json.
 type Error struct {
-	Status   int                    `validate:"required,min=200,max=599"`
-	Title    string                 `json:",omitempty" validate:"max=128"`
-	Detail   string                 `json:",omitempty"`
-	Code     string                 `json:",omitempty"`
-	Meta     map[string]interface{} `json:",omitempty"`
-	SubError error                  `json:"-"`
+	Status int    `validate:"required,min=400,max=599"`
+	Title  string `validate:"max=128"`
+	Detail string
+	Code   string
+	Meta   map[string]interface{}
+
+	// Invalid are the parts of the request that caused the error
+	Invalid []InvalidField
+
+	// cause is the error behind the response. It's logged but never returned.
+	cause error
+}
+
+// InvalidField is a part of the request that's invalid: a member of the JSON
+// body, identified by a JSON Pointer, or a query or path parameter.
+type InvalidField struct {
+	Pointer   string
+	Parameter string
+	Detail    string
+}
+
+// newFieldError returns an error with the code caused by the body member at the JSON Pointer
+func newFieldError(code errorCode, pointer, detail string) *Error {
+	e := newError(code, detail)
+	e.Invalid = []InvalidField{{Pointer: pointer, Detail: detail}}
+	return e
+}
+
+// newParameterError returns an error with the code caused by the query or path parameter
+func newParameterError(code errorCode, parameter, detail string) *Error {
+	e := newError(code, detail)
+	e.Invalid = []InvalidField{{Parameter: parameter, Detail: detail}}
+	return e
+}
+
+// causedBy sets the error that's logged in place of the response
+func (e *Error) causedBy(err error) *Error {
+	e.cause = err
+	return e
 }
 
 func (e *Error) Error() string {
-	data, _ := json.Marshal(e)
-	return string(data)
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Title)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+// objects returns the JSON:API error objects for the error, one for each invalid field
+func (e *Error) objects() []errorObject {
+	object := errorObject{
+		Status: strconv.Itoa(e.Status),
+		Code:   e.Code,
+		Title:  e.Title,
+		Detail: e.Detail,
+		Meta:   e.Meta,
+	}
+	if len(e.Invalid) == 0 {
+		return []errorObject{object}
+	}
+
+	objects := make([]errorObject, len(e.Invalid))
+	for i, field := range e.Invalid {
+		objects[i] = object
+		objects[i].Detail = field.Detail
+		objects[i].Source = &errorSource{Pointer: field.Pointer, Parameter: field.Parameter}
+	}
+	return objects
+}
+
+// reportError logs an error the request recovered from. Errors that fail the request are logged by respondWithError.
+func reportError(ctx context.Context, err error) {
+	auto.DefaultLogger().Warn(ctx, "handled error", auto.Fields{"error": err})
+}
+
+// recoverPanic responds to a panicking handler with a 500, logging the panic and its stack
+func recoverPanic(c *gin.Context) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		auto.DefaultLogger().Error(requestContext(c), "handler panicked", auto.Fields{
+			"panic": fmt.Sprintf("%v", recovered),
+			"stack": string(debug.Stack()),
+		})
+		respondWithError(c, newError(errInternalServerError, "An unknown error occurred."))
+	}()
+
+	c.Next()
 }
 
-func reportError(err error, handled bool) {
-	serverless.GetLogger().Printf("[ERROR] - %v", err)
+// errorCatalogHandler lists the error codes the API returns
+func errorCatalogHandler(c *gin.Context) {
+	respond(c, http.StatusOK, errorCatalog)
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic"
+	"github.com/maddiesch/automatic-reminders/auto/keys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespondWithError(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previous := auto.DefaultLogger()
+	auto.SetDefaultLogger(auto.NewLogger(logs, auto.LogInfo))
+	defer auto.SetDefaultLogger(previous)
+
+	serve := func(err error, accept string) *httptest.ResponseRecorder {
+		engine := gin.New()
+		engine.Use(AssignRequestID)
+		engine.GET("/vehicles", func(c *gin.Context) { respondWithError(c, err) })
+
+		request := httptest.NewRequest(http.MethodGet, "/vehicles", nil)
+		request.Header.Set("Accept", accept)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	respond := func(err error) (*httptest.ResponseRecorder, errorObject) {
+		recorder := serve(err, "")
+		assert.Equal(t, "application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+		body := errorDocument{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		require.NotEmpty(t, body.Errors)
+		assert.Equal(t, recorder.Header().Get("X-Request-Id"), body.Meta["request_id"])
+		return recorder, body.Errors[0]
+	}
+
+	t.Run("hides the details of internal errors", func(t *testing.T) {
+		recorder, body := respond(errors.New("ValidationException: one or more parameter values were invalid"))
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, errInternalServerError.Code, body.Code)
+		assert.NotContains(t, recorder.Body.String(), "ValidationException")
+
+		assert.Equal(t, "500", body.Status)
+		assert.Contains(t, logs.String(), "ValidationException")
+		assert.Contains(t, logs.String(), recorder.Header().Get("X-Request-Id"), "the request ID correlates the response with the logs")
+	})
+
+	t.Run("maps domain errors", func(t *testing.T) {
+		for err, code := range map[error]errorCode{
+			auto.ErrRecordNotFound:                            errNotFound,
+			fmt.Errorf("vehicle: %w", auto.ErrRecordNotFound): errNotFound,
+			fmt.Errorf("%w %q", keys.ErrInvalidPart, "a/b"):   errBadRequest,
+			auto.ErrOdometerNotMonotonic:                      errInvalidOdometerReading,
+			fmt.Errorf("sync: %w", automatic.ErrUnauthorized): errAutomaticUnauthorized,
+			automatic.ErrRateLimited:                          errAutomaticUnavailable,
+			context.DeadlineExceeded:                          errRequestTimeout,
+		} {
+			recorder, body := respond(err)
+			assert.Equal(t, code.Status, recorder.Code, err.Error())
+			assert.Equal(t, strconv.Itoa(code.Status), body.Status, err.Error())
+			assert.Equal(t, code.Code, body.Code, err.Error())
+		}
+	})
+
+	t.Run("returns API errors without their cause", func(t *testing.T) {
+		recorder, body := respond(newError(errUnauthorized, "A valid API token is required").causedBy(errors.New("token is expired")))
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "A valid API token is required", body.Detail)
+		assert.NotContains(t, recorder.Body.String(), "expired")
+		assert.Contains(t, logs.String(), "token is expired")
+	})
+
+	t.Run("returns an error object for each invalid field", func(t *testing.T) {
+		err := newError(errBadRequest, "The request is invalid")
+		err.Invalid = []InvalidField{
+			{Pointer: "/Reading", Detail: "Reading is required"},
+			{Parameter: "limit", Detail: "limit must be at most 100"},
+		}
+
+		recorder := serve(err, "")
+		body := errorDocument{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+
+		require.Len(t, body.Errors, 2)
+		assert.Equal(t, &errorSource{Pointer: "/Reading"}, body.Errors[0].Source)
+		assert.Equal(t, "Reading is required", body.Errors[0].Detail)
+		assert.Equal(t, &errorSource{Parameter: "limit"}, body.Errors[1].Source)
+		assert.Equal(t, "bad_request", body.Errors[1].Code)
+	})
+
+	t.Run("renders problems for clients that prefer them", func(t *testing.T) {
+		recorder := serve(newFieldError(errInvalidOdometerReading, "/Reading", "The reading is lower than an earlier reading"), "application/problem+json, application/json;q=0.5")
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "application/problem+json; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+		body := problem{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		assert.Equal(t, problem{
+			Type:      auto.DefaultConfig().APIBaseURL + "/v1/errors#invalid_odometer_reading",
+			Title:     "Invalid odometer reading",
+			Status:    http.StatusUnprocessableEntity,
+			Detail:    "The reading is lower than an earlier reading",
+			Instance:  "/vehicles",
+			Code:      "invalid_odometer_reading",
+			RequestID: recorder.Header().Get("X-Request-Id"),
+			InvalidParams: []problemParam{
+				{Name: "Reading", Pointer: "/Reading", Reason: "The reading is lower than an earlier reading"},
+			},
+		}, body)
+	})
+
+	t.Run("returns an internal error for an invalid API error", func(t *testing.T) {
+		recorder, body := respond(&Error{Detail: "no status"})
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, errInternalServerError.Code, body.Code)
+	})
+}
+
+// The catalog in docs/errors.md is generated from errorCatalog. Rewrite it with
+//
+//	UPDATE_ERROR_CATALOG=true go test -run TestErrorCatalog
+func TestErrorCatalog(t *testing.T) {
+	codes := make(map[string]bool)
+	for _, code := range errorCatalog {
+		assert.False(t, codes[code.Code], "%s is listed twice", code.Code)
+		codes[code.Code] = true
+		assert.NotEmpty(t, code.Description, code.Code)
+	}
+	for _, domain := range domainErrors {
+		assert.True(t, codes[domain.code.Code], "%s isn't in the catalog", domain.code.Code)
+	}
+
+	var doc strings.Builder
+	doc.WriteString("# API error codes\n\n")
+	doc.WriteString("Every error has a stable `code` and is always returned with the same status. ")
+	doc.WriteString("The request ID identifies the request in the server's logs; include it when reporting a problem. ")
+	doc.WriteString("See [responses.md](responses.md) for how errors are rendered. ")
+	doc.WriteString("The catalog is also served by `GET /v1/errors`.\n\n")
+	doc.WriteString("This file is generated by `TestErrorCatalog` in src/functions/api-handler.\n\n")
+	doc.WriteString("| Code | Status | Title | Description |\n")
+	doc.WriteString("| --- | --- | --- | --- |\n")
+	for _, code := range errorCatalog {
+		fmt.Fprintf(&doc, "| `%s` | %d | %s | %s |\n", code.Code, code.Status, code.Title, code.Description)
+	}
+
+	path := filepath.Join("..", "..", "..", "docs", "errors.md")
+	if os.Getenv("UPDATE_ERROR_CATALOG") == "true" {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(doc.String()), 0644))
+	}
+
+	published, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, doc.String(), string(published), "docs/errors.md is out of date, run the test with UPDATE_ERROR_CATALOG=true")
+}
@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/aws/aws-lambda-go v1.13.2
 	github.com/aws/aws-sdk-go v1.23.21
+	github.com/awslabs/aws-lambda-go-api-proxy v0.2.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-gonic/gin v1.3.0
 	github.com/jarcoal/httpmock v1.0.4
@@ -12,6 +13,8 @@ require (
 	github.com/maddiesch/serverless v0.1.0
 	github.com/segmentio/ksuid v1.0.2
 	github.com/stretchr/testify v1.4.0
+	gopkg.in/go-playground/validator.v8 v8.18.2
+	gopkg.in/go-playground/validator.v9 v9.28.0
 )
 
 replace github.com/maddiesch/automatic-reminders/auto v0.0.0 => ../../auto
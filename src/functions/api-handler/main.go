@@ -1,31 +1,70 @@
 package main
 
 import (
-	"net/http"
+	"context"
+	"flag"
+	"os"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/gin-gonic/gin"
-	"github.com/maddiesch/serverless"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/serverless/middleware"
 )
 
+// The handler runs on Lambda unless it's given an address to listen on, e.g.
+//
+//	api-handler -addr 127.0.0.1:3000 -config local.json
+//
+// HTTP_ADDR sets the address when the flag isn't passed. The config and secrets
+// are checked before anything is served, so a missing setting or secret stops
+// the handler starting instead of failing requests.
 func main() {
-	lambda.Start(serverless.LambdaHandler(func() {
-		serverless.SharedApp().ConfigureGin(func(e *gin.Engine) {
-			v1 := e.Group("/v1")
-			{
-				v1.Handle("GET", "/", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
-
-				integration := v1.Group("/integration")
-				{
-					integration.Handle("GET", "/automatic/authenticate", integrationAutomaticAuthHandler)
-					integration.Handle("GET", "/automatic/authenticate/callback", integrationAutomaticAuthCallbackHandler)
-				}
-
-				private := v1.Group("/private", Authenticate)
-				{
-					private.Handle("GET", "/", getAccountHandler)
-				}
-			}
-		})
-	}))
+	addr := flag.String("addr", os.Getenv("HTTP_ADDR"), "serve HTTP on the address instead of running on Lambda")
+	configPath := flag.String("config", "", "a JSON file of environment variables to configure the handler with, instead of CONFIG_FILE")
+	flag.Parse()
+
+	config, err := auto.LoadConfig(*configPath)
+	if err != nil {
+		fatal("loading config failed", err)
+	}
+	auto.SetDefaultConfig(config)
+	auto.SetDefaultLogger(auto.NewLogger(os.Stderr, config.LogLevel))
+
+	if _, err := auto.Secrets(); err != nil {
+		fatal("loading secrets failed", err)
+	}
+
+	if *addr != "" {
+		if err := serve(*addr, getEngine()); err != nil {
+			fatal("serving HTTP failed", err)
+		}
+		return
+	}
+
+	lambda.Start((&lambdaProxy{engine: getEngine()}).handle)
+}
+
+var (
+	engineInstance *gin.Engine
+	engineSetup    sync.Once
+)
+
+// fatal logs the error that stopped the handler starting and exits
+func fatal(msg string, err error) {
+	auto.DefaultLogger().Error(context.Background(), msg, auto.Fields{"error": err})
+	os.Exit(1)
+}
+
+// getEngine returns the engine with every route. Lambda and HTTP serving use the same engine and middleware.
+func getEngine() *gin.Engine {
+	engineSetup.Do(func() {
+		e := gin.New()
+		engineInstance = e
+
+		e.Use(AssignRequestID, LogRequests, recoverPanic, NegotiateContent, middleware.Runtime())
+
+		registerRoutes(e.Group("/v1"), apiRoutes())
+	})
+	return engineInstance
 }
@@ -5,9 +5,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic/simulator"
+	"github.com/maddiesch/automatic-reminders/auto/cassette"
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
 )
 
 type envContent struct {
@@ -18,9 +24,12 @@ func TestMain(m *testing.M) {
 	os.Exit(runTestSuite(m))
 }
 
+// runTestSuite runs against an in-memory table by default. Setting
+// TESTING_ENV_FILE (see `make test-integration`) runs the same suite against
+// the DynamoDB table named by TEST_TABLE_NAME instead.
 func runTestSuite(m *testing.M) int {
-	{ // Setup the environment from the env file
-		data, err := ioutil.ReadFile(os.Getenv("TESTING_ENV_FILE"))
+	if path := os.Getenv("TESTING_ENV_FILE"); path != "" { // Setup the environment from the env file
+		data, err := ioutil.ReadFile(path)
 		if err != nil {
 			panic(err)
 		}
@@ -36,37 +45,95 @@ func runTestSuite(m *testing.M) int {
 				panic(err)
 			}
 		}
+
+		os.Setenv("DYNAMODB_TABLE_NAME", os.Getenv("TEST_TABLE_NAME"))
+	} else {
+		auto.SetDefaultStore(auto.NewMemoryStore())
 	}
 
 	{ // Setup environment overrides. MUST happen after the env file
 		os.Setenv("AWS_SAM_LOCAL", "true")
 		os.Setenv("RETURN_FAKE_SECRETS", "true")
-		os.Setenv("DYNAMODB_TABLE_NAME", os.Getenv("TEST_TABLE_NAME"))
 		os.Setenv("AUTO_TEST", "true")
 	}
 
+	config, err := auto.LoadConfig("")
+	if err != nil {
+		panic(err)
+	}
+	auto.SetDefaultConfig(config)
+
+	// Retries are tested, but there's no need to wait long between them
+	getHTTPStack().retry.BaseDelay = time.Millisecond
+
 	return m.Run()
 }
 
-func withStubbedRequests(t *testing.T, handler http.HandlerFunc, fn func(t *testing.T)) {
-	fakeServer := httptest.NewServer(handler)
-	testURL, _ := url.ParseRequestURI(fakeServer.URL)
+// withCassette answers outbound requests from testdata/cassettes/<name>.json.
+// With CASSETTE_MODE=record the requests are sent for real and the cassette is
+// rewritten with their responses.
+func withCassette(t *testing.T, name string, fn func(t *testing.T)) {
+	c, err := cassette.Load(filepath.Join("testdata", "cassettes", name+".json"), cassette.ModeFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	originalClient := getHTTPStack().client
-	originalSender := getHTTPStack().sender
+	useCassette(t, c, fn)
 
-	defer func(c *http.Client, s httpSendFunction) {
-		getHTTPStack().client = c
-		getHTTPStack().sender = originalSender
-	}(originalClient, originalSender)
-	defer fakeServer.Close()
+	if c.Mode == cassette.Record {
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
 
-	getHTTPStack().client = fakeServer.Client()
-	getHTTPStack().sender = func(c *http.Client, r *http.Request) (*http.Response, error) {
-		r.URL.Scheme = "http"
-		r.URL.Host = testURL.Host
-		return c.Do(r)
+// withInteractions answers outbound requests with the interactions, which are built in the test
+func withInteractions(t *testing.T, interactions []cassette.Interaction, fn func(t *testing.T)) {
+	useCassette(t, cassette.New(interactions...), fn)
+}
+
+// useCassette plugs the cassette into the HTTP stack's sender while fn runs.
+// Every request must match an interaction, and every interaction must be used.
+// Circuits start closed, so failures simulated by one cassette don't leak into the next.
+func useCassette(t *testing.T, c *cassette.Cassette, fn func(t *testing.T)) {
+	stack := getHTTPStack()
+
+	defer func(sender httpSendFunction, breakers *outbound.Breakers) {
+		stack.sender = sender
+		stack.breakers = breakers
+	}(stack.sender, stack.breakers)
+	stack.breakers = outbound.NewBreakers(stack.breakers.Threshold, stack.breakers.Cooldown)
+
+	upstream := stack.sender
+	c.Upstream = func(r *http.Request) (*http.Response, error) { return upstream(stack.client, r) }
+	stack.sender = c.Send
+
+	t.Run("with a cassette", fn)
+
+	for _, request := range c.Unmatched() {
+		t.Errorf("unmatched request: %s", request)
 	}
+	if c.Mode == cassette.Replay {
+		for _, request := range c.Unused() {
+			t.Errorf("unused interaction: %s", request)
+		}
+	}
+}
+
+// withSimulator points the Automatic endpoints at the simulator, served in-process, while fn runs
+func withSimulator(t *testing.T, sim *simulator.Server, fn func(t *testing.T)) {
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	config := auto.DefaultConfig()
+	defer func(api, accounts string) {
+		config.AutomaticAPIURL, config.AutomaticAccountsURL = api, accounts
+	}(config.AutomaticAPIURL, config.AutomaticAccountsURL)
+	config.AutomaticAPIURL, config.AutomaticAccountsURL = server.URL, server.URL
+
+	stack := getHTTPStack()
+	defer func(breakers *outbound.Breakers) { stack.breakers = breakers }(stack.breakers)
+	stack.breakers = outbound.NewBreakers(stack.breakers.Threshold, stack.breakers.Cooldown)
 
-	t.Run("with subbed requests", fn)
+	t.Run("with the simulator", fn)
 }
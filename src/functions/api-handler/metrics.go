@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
+)
+
+const metricsNamespace = "AutomaticReminders"
+
+// metricsOutput is where metrics are written. Lambda sends stdout to CloudWatch Logs.
+var metricsOutput io.Writer = os.Stdout
+
+// putMetric writes a metric in CloudWatch's embedded metric format, which
+// CloudWatch Logs turns into a metric without an API call.
+func putMetric(ctx context.Context, name, unit string, value float64, dimensions map[string]string) {
+	keys := make([]string, 0, len(dimensions))
+	entry := map[string]interface{}{
+		name:        value,
+		"RequestID": auto.RequestID(ctx),
+	}
+	for key, value := range dimensions {
+		keys = append(keys, key)
+		entry[key] = value
+	}
+	entry["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  metricsNamespace,
+			"Dimensions": [][]string{keys},
+			"Metrics":    []map[string]string{{"Name": name, "Unit": unit}},
+		}},
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		reportError(ctx, err)
+		return
+	}
+	fmt.Fprintln(metricsOutput, string(data))
+}
+
+// reportThrottle records a request held back by an outbound rate limit
+func reportThrottle(ctx context.Context, th outbound.Throttle) {
+	name := "OutboundThrottled"
+	if th.Rejected {
+		name = "OutboundRateLimited"
+	}
+	putMetric(ctx, name, "Count", 1, map[string]string{"LimitKind": th.Key.Kind})
+	putMetric(ctx, "OutboundThrottleWait", "Milliseconds", float64(th.Wait/time.Millisecond), map[string]string{"LimitKind": th.Key.Kind})
+}
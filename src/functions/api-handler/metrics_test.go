@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportThrottle(t *testing.T) {
+	output := &bytes.Buffer{}
+	defer func(original io.Writer) { metricsOutput = original }(metricsOutput)
+	metricsOutput = output
+
+	reportThrottle(context.Background(), outbound.Throttle{
+		Key:      outbound.LimitKey{Kind: outbound.LimitKindToken, ID: "abc"},
+		Wait:     1500 * time.Millisecond,
+		Rejected: true,
+	})
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 2)
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, float64(1), entry["OutboundRateLimited"])
+	assert.Equal(t, "token", entry["LimitKind"])
+	assert.NotContains(t, lines[0], "abc", "the hashed token isn't a dimension")
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, float64(1500), entry["OutboundThrottleWait"])
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/serverless"
+)
+
+type notificationPreferencesPayload struct {
+	*auto.NotificationPreferences
+	TimeZone       string
+	NextDeliveryAt time.Time
+}
+
+func getNotificationPreferencesHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	payload, err := getNotificationPreferences(ctx, c.GetString(contextUserIDKey))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, payload)
+}
+
+func getNotificationPreferences(ctx context.Context, accountID string) (*notificationPreferencesPayload, error) {
+	account, err := auto.DefaultStore().FindAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs, err := auto.DefaultStore().FindNotificationPreferences(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNotificationPreferencesPayload(account, prefs), nil
+}
+
+func updateNotificationPreferencesHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	payload, err := getNotificationPreferences(ctx, c.GetString(contextUserIDKey))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	// Binding over the current values lets clients send only the fields they want to change.
+	if err := bindJSON(c, payload); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	payload, err = updateNotificationPreferences(ctx, c.GetString(contextUserIDKey), payload)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, payload)
+}
+
+func updateNotificationPreferences(ctx context.Context, accountID string, payload *notificationPreferencesPayload) (*notificationPreferencesPayload, error) {
+	if _, err := time.LoadLocation(payload.TimeZone); err != nil {
+		return nil, newFieldError(errBadRequest, "/TimeZone", "Unknown time zone")
+	}
+	if err := serverless.GetValidator().Struct(payload.NotificationPreferences); err != nil {
+		return nil, invalidRequestError(err)
+	}
+
+	account, err := auto.DefaultStore().FindAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	account.TimeZone = payload.TimeZone
+
+	err = auto.DefaultStore().SaveNotificationPreferences(ctx, account, payload.NotificationPreferences)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNotificationPreferencesPayload(account, payload.NotificationPreferences), nil
+}
+
+func newNotificationPreferencesPayload(account *auto.Account, prefs *auto.NotificationPreferences) *notificationPreferencesPayload {
+	return &notificationPreferencesPayload{
+		NotificationPreferences: prefs,
+		TimeZone:                account.Location().String(),
+		NextDeliveryAt:          prefs.NextDelivery(time.Now(), account.Location()),
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIDocument is an OpenAPI 3 description of the API. It's generated from
+// the route table, so it can't fall behind the routes the engine serves.
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema               `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// schema is the subset of the OpenAPI schema object the API's types need
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+}
+
+const (
+	componentsPrefix   = "#/components/schemas/"
+	apiTokenSecurity   = "apiToken"
+	errorResponseKey   = "default"
+	openAPIVersion     = "3.0.3"
+	apiDocumentVersion = "1.0.0"
+)
+
+var (
+	openAPIInstance *openAPIDocument
+	openAPISetup    sync.Once
+)
+
+// openAPI returns the document for the API's routes
+func openAPI() *openAPIDocument {
+	openAPISetup.Do(func() {
+		openAPIInstance = newOpenAPIDocument(apiRoutes())
+	})
+	return openAPIInstance
+}
+
+func openAPIHandler(c *gin.Context) {
+	render(c, http.StatusOK, mediaTypeJSON, openAPI())
+}
+
+// newOpenAPIDocument describes the routes. Response types are described
+// before request types, so a type that's both keeps its name for its responses.
+func newOpenAPIDocument(routes []route) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       "Automatic Reminders API",
+			Description: "Successful responses are data documents. Errors are JSON:API errors documents, or RFC 7807 problems for clients that accept application/problem+json.",
+			Version:     apiDocumentVersion,
+		},
+		Servers: []openAPIServer{{URL: "/v1"}},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: make(map[string]*schema),
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				apiTokenSecurity: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+	g := &schemaGenerator{schemas: doc.Components.Schemas, names: make(map[schemaKey]string)}
+
+	errorResponse := &openAPIResponse{
+		Description: "An error. See GET /errors for the codes.",
+		Content: map[string]openAPIMediaType{
+			mediaTypeJSON:    {Schema: g.schemaOf(reflect.TypeOf(errorDocument{}), false)},
+			mediaTypeProblem: {Schema: g.schemaOf(reflect.TypeOf(problem{}), false)},
+		},
+	}
+
+	for _, r := range routes {
+		op := &openAPIOperation{
+			OperationID: r.Operation,
+			Summary:     r.Summary,
+			Responses: map[string]*openAPIResponse{
+				strconv.Itoa(r.Status): g.successResponse(r),
+				errorResponseKey:       errorResponse,
+			},
+		}
+		if r.Authenticated {
+			op.Security = []map[string][]string{{apiTokenSecurity: {}}}
+		}
+		for _, name := range pathParameters(r.Path) {
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: name, In: "path", Required: true, Schema: &schema{Type: "string"}})
+		}
+		for _, q := range r.parameters() {
+			s := q.Schema
+			if s == nil {
+				s = &schema{Type: "string"}
+			}
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: q.Name, In: "query", Description: q.Description, Required: q.Required, Schema: s})
+		}
+
+		path := openAPIPath(r.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = op
+	}
+
+	for _, r := range routes {
+		if r.Request == nil {
+			continue
+		}
+		doc.Paths[openAPIPath(r.Path)][strings.ToLower(r.Method)].RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]openAPIMediaType{mediaTypeJSON: {Schema: g.schemaOf(reflect.TypeOf(r.Request), true)}},
+		}
+	}
+
+	return doc
+}
+
+// operation returns the operation for the route
+func (d *openAPIDocument) operation(r route) *openAPIOperation {
+	return d.Paths[openAPIPath(r.Path)][strings.ToLower(r.Method)]
+}
+
+// resolve returns the schema a $ref points at
+func (d *openAPIDocument) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, componentsPrefix)]
+	}
+	return s
+}
+
+func (g *schemaGenerator) successResponse(r route) *openAPIResponse {
+	response := &openAPIResponse{Description: http.StatusText(r.Status)}
+
+	if r.Status >= 300 && r.Status < 400 {
+		response.Headers = map[string]openAPIHeader{
+			"Location": {Description: "Where to go next", Schema: &schema{Type: "string", Format: "uri"}},
+		}
+	}
+	if r.Response == nil {
+		return response
+	}
+
+	data := g.schemaOf(reflect.TypeOf(r.Response), false)
+	if !r.Raw {
+		name := exportedName(r.Operation) + "Response"
+		document := &schema{
+			Type: "object",
+			Properties: map[string]*schema{
+				"data": data,
+				"meta": {Type: "object", AdditionalProperties: &schema{}},
+			},
+			Required:             []string{"data"},
+			AdditionalProperties: false,
+		}
+		if r.Paginated {
+			document.Properties["links"] = &schema{
+				Type:                 "object",
+				Properties:           map[string]*schema{"next": {Type: "string", Format: "uri"}},
+				AdditionalProperties: false,
+			}
+			response.Headers = map[string]openAPIHeader{
+				"Link": {Description: `The next page's URL with rel="next", when there is one`, Schema: &schema{Type: "string"}},
+			}
+		}
+		g.schemas[name] = document
+		data = &schema{Ref: componentsPrefix + name}
+	}
+
+	response.Content = map[string]openAPIMediaType{mediaTypeJSON: {Schema: data}}
+	return response
+}
+
+// schemaGenerator describes Go types with schemas, the way encoding/json
+// encodes them. Named structs become components; everything else is inline.
+type schemaGenerator struct {
+	schemas map[string]*schema
+	names   map[schemaKey]string
+}
+
+// schemaKey is a type described for requests or responses. Response members
+// that are always encoded are required, but request members are only required
+// when they're bound with binding:"required", or binding:"exists" for pointers
+// whose zero value is valid, so requests can leave them out.
+type schemaKey struct {
+	t     reflect.Type
+	input bool
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schemaOf(t reflect.Type, input bool) *schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		return &schema{Ref: componentsPrefix + g.component(t, input)}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.structSchema(t, input)
+	case reflect.Slice, reflect.Array:
+		// nil slices are encoded as null
+		return &schema{Type: "array", Items: g.schemaOf(t.Elem(), input), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem(), input), Nullable: true}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	default:
+		return &schema{}
+	}
+}
+
+// component returns the name of the component describing the named struct, adding it if it's new
+func (g *schemaGenerator) component(t reflect.Type, input bool) string {
+	key := schemaKey{t: t, input: input}
+	if name, ok := g.names[key]; ok {
+		return name
+	}
+
+	name := exportedName(t.Name())
+	if _, taken := g.schemas[name]; taken && input {
+		name += "Input"
+	}
+	if _, taken := g.schemas[name]; taken {
+		name = exportedName(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+	}
+
+	// The name is claimed first, so types that refer to themselves terminate
+	g.names[key] = name
+	g.schemas[name] = &schema{}
+	*g.schemas[name] = *g.structSchema(t, input)
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type, input bool) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema), AdditionalProperties: false}
+	g.addFields(s, t, input)
+	sort.Strings(s.Required)
+	return s
+}
+
+func (g *schemaGenerator) addFields(s *schema, t reflect.Type, input bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, options = tag[:i], tag[i+1:]
+		}
+
+		embedded := field.Type
+		for embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			g.addFields(s, embedded, input)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := g.schemaOf(field.Type, input)
+		applyConstraints(property, field)
+		s.Properties[name] = property
+
+		omitempty := strings.Contains(options, "omitempty")
+		binding := field.Tag.Get("binding")
+		if hasRule(binding, "required") || hasRule(binding, "exists") || (!input && !omitempty) {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// applyConstraints adds the field's validation rules that a schema can express
+func applyConstraints(s *schema, field reflect.StructField) {
+	if s.Ref != "" {
+		return
+	}
+
+	for _, tag := range []string{field.Tag.Get("binding"), field.Tag.Get("validate")} {
+		for _, rule := range strings.Split(tag, ",") {
+			parts := strings.SplitN(rule, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			switch parts[0] {
+			case "oneof":
+				s.Enum = strings.Fields(parts[1])
+			case "min", "gte", "max", "lte":
+				bound, err := strconv.ParseFloat(parts[1], 64)
+				if err != nil {
+					continue
+				}
+				lower := parts[0] == "min" || parts[0] == "gte"
+				if s.Type == "string" {
+					length := int(bound)
+					if lower {
+						s.MinLength = &length
+					} else {
+						s.MaxLength = &length
+					}
+				} else if lower {
+					s.Minimum = &bound
+				} else {
+					s.Maximum = &bound
+				}
+			}
+		}
+	}
+}
+
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPIPath returns the route's path with gin's :name parameters as {name}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func pathParameters(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			names = append(names, segment[1:])
+		}
+	}
+	return names
+}
+
+func exportedName(name string) string {
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// describeType says what a value of the schema's type is, for error details
+func describeType(s *schema) string {
+	switch {
+	case s.Format == "date-time":
+		return "an RFC 3339 timestamp"
+	case s.Type == "object" || s.Type == "array" || s.Type == "integer":
+		return "an " + s.Type
+	default:
+		return fmt.Sprintf("a %s", s.Type)
+	}
+}
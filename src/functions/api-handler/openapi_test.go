@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/automatic/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOpenAPI fails when the handlers drift from the document: every route the
+// engine serves must be documented, and every response a route returns must
+// match the schema the document gives for it. The published copy in
+// docs/openapi.json is rewritten with
+//
+//	UPDATE_OPENAPI=true go test -run TestOpenAPI
+func TestOpenAPI(t *testing.T) {
+	doc := openAPI()
+
+	t.Run("documents every route the engine serves", func(t *testing.T) {
+		served := make(map[string]bool)
+		for _, r := range getEngine().Routes() {
+			path := openAPIPath(strings.TrimPrefix(r.Path, "/v1"))
+			served[strings.ToLower(r.Method)+" "+path] = true
+			assert.NotNil(t, doc.Paths[path][strings.ToLower(r.Method)], "%s %s isn't documented", r.Method, r.Path)
+		}
+		for path, operations := range doc.Paths {
+			for method := range operations {
+				assert.True(t, served[method+" "+path], "%s %s is documented but not served", method, path)
+			}
+		}
+	})
+
+	t.Run("publishes the document", func(t *testing.T) {
+		data, err := json.MarshalIndent(doc, "", "  ")
+		require.NoError(t, err)
+		data = append(data, '\n')
+
+		path := filepath.Join("..", "..", "..", "docs", "openapi.json")
+		if os.Getenv("UPDATE_OPENAPI") == "true" {
+			require.NoError(t, ioutil.WriteFile(path, data, 0644))
+		}
+
+		published, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, string(data), string(published), "docs/openapi.json is out of date, run the test with UPDATE_OPENAPI=true")
+	})
+
+	t.Run("every response matches the document", func(t *testing.T) {
+		// A user of its own keeps the account apart from the other tests'
+		config := simulator.DefaultConfig()
+		config.Users[0].ID = "U_openapi"
+
+		sim := simulator.New(config)
+		withSimulator(t, sim, func(t *testing.T) {
+			checkResponses(t, doc)
+		})
+	})
+}
+
+// checkResponses calls every route through the engine, checking each response against the document
+func checkResponses(t *testing.T, doc *openAPIDocument) {
+	server := httptest.NewServer(getEngine())
+	defer server.Close()
+
+	routes := make(map[string]route)
+	for _, r := range apiRoutes() {
+		routes[r.Method+" "+r.Path] = r
+	}
+	succeeded := make(map[string]bool)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	token := ""
+
+	// call requests the route, with the path's parameters filled in from params, and returns the response's document
+	call := func(method, pattern string, params map[string]string, body interface{}) map[string]interface{} {
+		query := ""
+		if i := strings.Index(pattern, "?"); i >= 0 {
+			pattern, query = pattern[:i], pattern[i:]
+		}
+		r, ok := routes[method+" "+pattern]
+		require.True(t, ok, "%s %s isn't a route", method, pattern)
+
+		path := pattern
+		for name, value := range params {
+			path = strings.Replace(path, ":"+name, url.PathEscape(value), 1)
+		}
+
+		var reader *bytes.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		request, err := http.NewRequest(method, server.URL+"/v1"+path+query, reader)
+		require.NoError(t, err)
+		if body != nil {
+			request.Header.Set("Content-Type", mediaTypeJSON)
+		}
+		if r.Authenticated {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		response, err := client.Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		data, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		op := doc.operation(r)
+		documented, ok := op.Responses[strconv.Itoa(response.StatusCode)]
+		if !ok {
+			require.True(t, response.StatusCode >= http.StatusBadRequest, "%s %s returned an undocumented %d: %s", method, pattern, response.StatusCode, data)
+			documented = op.Responses[errorResponseKey]
+		} else {
+			succeeded[method+" "+pattern] = true
+		}
+
+		if documented.Content == nil {
+			// Redirects are only described by their Location
+			return nil
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var decoded interface{}
+		require.NoError(t, decoder.Decode(&decoded), "%s %s", method, pattern)
+		for _, invalid := range doc.validate(documented.Content[mediaTypeJSON].Schema, decoded, "") {
+			t.Errorf("%s %s returned %s: %s", method, pattern, invalid.Pointer, invalid.Detail)
+		}
+
+		document, _ := decoded.(map[string]interface{})
+		return document
+	}
+	data := func(document map[string]interface{}) map[string]interface{} {
+		object, _ := document["data"].(map[string]interface{})
+		return object
+	}
+	first := func(document map[string]interface{}) map[string]interface{} {
+		list, _ := document["data"].([]interface{})
+		require.NotEmpty(t, list)
+		object, _ := list[0].(map[string]interface{})
+		return object
+	}
+
+	call("GET", "/", nil, nil)
+	call("GET", "/errors", nil, nil)
+	call("GET", "/openapi.json", nil, nil)
+
+	// Sign in with the simulated Automatic
+	redirect, err := client.Get(server.URL + "/v1/integration/automatic/authenticate")
+	require.NoError(t, err)
+	redirect.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, redirect.StatusCode)
+	call("GET", "/integration/automatic/authenticate", nil, nil)
+
+	signIn, err := client.Get(redirect.Header.Get("Location") + "&user=U_openapi")
+	require.NoError(t, err)
+	signIn.Body.Close()
+	callback, err := url.Parse(signIn.Header.Get("Location"))
+	require.NoError(t, err)
+
+	call("GET", "/integration/automatic/authenticate/callback", nil, nil)
+	authenticated := call("GET", "/integration/automatic/authenticate/callback?"+callback.RawQuery, nil, nil)
+	token, _ = data(authenticated)["Token"].(string)
+	require.NotEmpty(t, token)
+
+	accountID, _ := data(call("GET", "/private/", nil, nil))["ID"].(string)
+	require.NotEmpty(t, accountID)
+	call("POST", "/private/sync", nil, nil)
+
+	call("GET", "/private/notifications", nil, nil)
+	call("PUT", "/private/notifications", nil, map[string]interface{}{"DeliveryHour": 8, "TimeZone": "America/Denver"})
+	call("PUT", "/private/notifications", nil, map[string]interface{}{"DeliveryHour": 25})
+
+	vehicle := data(call("POST", "/private/vehicles", nil, map[string]interface{}{"VIN": "1HGCM82633A004352", "Model": "Accord"}))
+	vehicleID, _ := vehicle["ID"].(string)
+	require.NotEmpty(t, vehicleID)
+	call("GET", "/private/vehicles", nil, nil)
+	call("GET", "/private/vin/:vin", map[string]string{"vin": "1HGCM82633A004352"}, nil)
+
+	params := map[string]string{"id": vehicleID}
+	call("POST", "/private/vehicles/:id/odometer", params, map[string]interface{}{"Reading": 1500, "ReadAt": time.Now().Add(-2 * time.Hour)})
+	call("POST", "/private/vehicles/:id/odometer", params, map[string]interface{}{"Reading": 10, "ReadAt": time.Now().Add(-time.Hour)})
+	call("GET", "/private/vehicles/:id/odometer", params, nil)
+	template := first(call("GET", "/private/vehicles/:id/suggested-reminders", params, nil))
+	call("POST", "/private/vehicles/:id/apply-template", params, map[string]interface{}{"TemplateID": template["ID"]})
+
+	reminder := data(call("POST", "/private/reminders", nil, map[string]interface{}{"VehicleID": vehicleID, "Title": "Wiper blades", "IntervalDays": 365}))
+	reminderID, _ := reminder["ID"].(string)
+	require.NotEmpty(t, reminderID)
+	call("GET", "/private/reminders", nil, nil)
+	page := call("GET", "/private/reminders?limit=1&sort=due", nil, nil)
+	links, _ := page["links"].(map[string]interface{})
+	next, _ := links["next"].(string)
+	require.NotEmpty(t, next, "the template's reminders don't fit in one page")
+	call("GET", "/private/reminders?"+next[strings.Index(next, "?")+1:], nil, nil)
+	call("GET", "/private/reminders?vehicle_id="+url.QueryEscape(vehicleID)+"&order=desc", nil, nil)
+	call("POST", "/private/reminders/:id/snooze", map[string]string{"id": reminderID}, map[string]interface{}{"Days": 7})
+	call("POST", "/private/reminders/:id/dismiss", map[string]string{"id": reminderID}, nil)
+
+	stored, err := auto.DefaultStore().FindReminder(context.Background(), accountID, reminderID)
+	require.NoError(t, err)
+	actionToken, err := auto.NewReminderActionToken(stored, auto.ReminderActionDismiss, 0)
+	require.NoError(t, err)
+	call("GET", "/actions/:token", map[string]string{"token": actionToken}, nil)
+	call("POST", "/actions/:token", map[string]string{"token": actionToken}, nil)
+
+	for _, r := range apiRoutes() {
+		assert.True(t, succeeded[r.Method+" "+r.Path], "%s %s never succeeded, so its response wasn't checked", r.Method, r.Path)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	validate := func(r route, contentType, body string) (int, errorDocument) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(r.Method, "/v1"+r.Path, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", contentType)
+
+		validateRequest(r)(c)
+		if !c.IsAborted() {
+			return http.StatusOK, errorDocument{}
+		}
+
+		document := errorDocument{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &document))
+		return recorder.Code, document
+	}
+	createVehicle := route{Method: "POST", Path: "/private/vehicles", Request: createVehicleRequest{}}
+
+	t.Run("passes a body that matches the schema", func(t *testing.T) {
+		status, _ := validate(createVehicle, "application/json; charset=utf-8", `{"Make":"Honda","Model":"Accord","Year":2003}`)
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("points at every member that doesn't match the schema", func(t *testing.T) {
+		status, document := validate(createVehicle, mediaTypeJSON, `{"Make":1,"Year":2019.5,"Color":"red"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		sources := make(map[string]string)
+		for _, object := range document.Errors {
+			require.NotNil(t, object.Source)
+			sources[object.Source.Pointer] = object.Detail
+		}
+		assert.Equal(t, map[string]string{
+			"/Color": "Color isn't a known member",
+			"/Make":  "Make must be a string",
+			"/Year":  "Year must be an integer",
+		}, sources)
+	})
+
+	t.Run("requires bound members", func(t *testing.T) {
+		status, document := validate(route{Method: "POST", Path: "/private/vehicles/:id/odometer", Request: addOdometerReadingRequest{}}, mediaTypeJSON, `{"ReadAt":"yesterday"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		require.Len(t, document.Errors, 2)
+		assert.Equal(t, "/Reading", document.Errors[0].Source.Pointer)
+		assert.Equal(t, "ReadAt must be an RFC 3339 timestamp", document.Errors[1].Detail)
+	})
+
+	t.Run("rejects bodies that aren't JSON", func(t *testing.T) {
+		status, document := validate(createVehicle, "text/plain", "Honda Accord")
+		assert.Equal(t, http.StatusUnsupportedMediaType, status)
+		assert.Equal(t, errUnsupportedMediaType.Code, document.Errors[0].Code)
+	})
+
+	t.Run("checks query parameters against their schemas", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", "/v1/private/reminders?limit=1000&order=sideways&sort=due", nil)
+
+		validateRequest(route{Method: "GET", Path: "/private/reminders", Paginated: true})(c)
+		require.True(t, c.IsAborted())
+
+		document := errorDocument{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &document))
+		require.Len(t, document.Errors, 2)
+		assert.Equal(t, &errorSource{Parameter: "limit"}, document.Errors[0].Source)
+		assert.Equal(t, "limit must be at most 100", document.Errors[0].Detail)
+		assert.Equal(t, "order must be one of asc, desc", document.Errors[1].Detail)
+	})
+
+	t.Run("requires query parameters", func(t *testing.T) {
+		callback := route{Method: "GET", Path: "/integration/automatic/authenticate/callback", Query: []queryParameter{
+			{Name: "code", Required: true},
+			{Name: "state", Required: true},
+		}}
+		status, document := validate(callback, "", "")
+		assert.Equal(t, http.StatusBadRequest, status)
+		require.Len(t, document.Errors, 2)
+		assert.Equal(t, &errorSource{Parameter: "code"}, document.Errors[0].Source)
+		assert.Equal(t, &errorSource{Parameter: "state"}, document.Errors[1].Source)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+// Values of the order parameter
+const (
+	orderAscending  = "asc"
+	orderDescending = "desc"
+)
+
+// pageParameters are the query parameters every paginated route reads
+var pageParameters = []queryParameter{
+	{
+		Name:        "limit",
+		Description: fmt.Sprintf("The most items in the page. Defaults to %d.", auto.DefaultPageLimit),
+		Schema:      &schema{Type: "integer", Minimum: floatPointer(1), Maximum: floatPointer(auto.MaxPageLimit)},
+	},
+	{
+		Name:        "cursor",
+		Description: "Continues the listing after the page it was returned with. It's only valid with the same parameters.",
+	},
+	{
+		Name:        "order",
+		Description: "The order items are listed in. Defaults to asc.",
+		Schema:      &schema{Type: "string", Enum: []string{orderAscending, orderDescending}},
+	},
+}
+
+// pageRequest returns the page the request's parameters ask for
+func pageRequest(c *gin.Context) (auto.PageRequest, error) {
+	page := auto.PageRequest{Cursor: c.Query("cursor")}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > auto.MaxPageLimit {
+			return page, newParameterError(errBadRequest, "limit", fmt.Sprintf("limit must be an integer from 1 to %d", auto.MaxPageLimit))
+		}
+		page.Limit = n
+	}
+
+	switch c.Query("order") {
+	case "", orderAscending:
+	case orderDescending:
+		page.Descending = true
+	default:
+		return page, newParameterError(errBadRequest, "order", fmt.Sprintf("order must be one of %s, %s", orderAscending, orderDescending))
+	}
+
+	return page, nil
+}
+
+// pageError returns the error for a listing that failed. A cursor the store
+// rejects points at the cursor parameter.
+func pageError(err error) error {
+	if errors.Is(err, auto.ErrInvalidCursor) {
+		return newParameterError(errInvalidCursor, "cursor", "The cursor wasn't issued for this listing. Start the listing again without it.").causedBy(err)
+	}
+	return err
+}
+
+// respondWithPage writes a page of a listing. When there's another page its
+// URL is in the Link header and links.next, and its cursor is in meta.next_cursor.
+func respondWithPage(c *gin.Context, data interface{}, page auto.Page) {
+	document := dataDocument{Data: data, Meta: documentMeta(requestContext(c))}
+
+	if page.Next != "" {
+		next := nextPageURL(c, page.Next)
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+
+		document.Links = map[string]string{"next": next}
+		if document.Meta == nil {
+			document.Meta = make(map[string]interface{})
+		}
+		document.Meta["next_cursor"] = page.Next
+	}
+
+	render(c, http.StatusOK, mediaTypeJSON, document)
+}
+
+// nextPageURL returns the request's URL with the cursor for the next page
+func nextPageURL(c *gin.Context, cursor string) string {
+	query := c.Request.URL.Query()
+	query.Set("cursor", cursor)
+
+	return strings.TrimSuffix(auto.DefaultConfig().APIBaseURL, "/") + c.Request.URL.Path + "?" + query.Encode()
+}
+
+func floatPointer(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagination(t *testing.T) {
+	newContext := func(target string) (*gin.Context, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("GET", target, nil)
+		return c, recorder
+	}
+
+	t.Run("reads the page parameters", func(t *testing.T) {
+		c, _ := newContext("/v1/private/vehicles?limit=10&cursor=abc&order=desc")
+		page, err := pageRequest(c)
+		require.NoError(t, err)
+		assert.Equal(t, auto.PageRequest{Limit: 10, Cursor: "abc", Descending: true}, page)
+
+		c, _ = newContext("/v1/private/vehicles")
+		page, err = pageRequest(c)
+		require.NoError(t, err)
+		assert.Equal(t, auto.PageRequest{}, page)
+	})
+
+	t.Run("rejects invalid page parameters", func(t *testing.T) {
+		for target, parameter := range map[string]string{
+			"/v1/private/vehicles?limit=0":        "limit",
+			"/v1/private/vehicles?limit=101":      "limit",
+			"/v1/private/vehicles?limit=ten":      "limit",
+			"/v1/private/vehicles?order=sideways": "order",
+		} {
+			c, _ := newContext(target)
+			_, err := pageRequest(c)
+			e, ok := err.(*Error)
+			require.True(t, ok, target)
+			require.Len(t, e.Invalid, 1)
+			assert.Equal(t, parameter, e.Invalid[0].Parameter, target)
+		}
+	})
+
+	t.Run("links to the next page", func(t *testing.T) {
+		c, recorder := newContext("/v1/private/reminders?sort=due&limit=2")
+		respondWithPage(c, []string{"rem:1", "rem:2"}, auto.Page{Next: "next.page"})
+
+		next := auto.DefaultConfig().APIBaseURL + "/v1/private/reminders?cursor=next.page&limit=2&sort=due"
+		assert.Equal(t, `<`+next+`>; rel="next"`, recorder.Header().Get("Link"))
+
+		document := struct {
+			Data  []string
+			Meta  map[string]interface{}
+			Links map[string]string
+		}{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &document))
+		assert.Equal(t, []string{"rem:1", "rem:2"}, document.Data)
+		assert.Equal(t, next, document.Links["next"])
+		assert.Equal(t, "next.page", document.Meta["next_cursor"])
+	})
+
+	t.Run("the last page has no links", func(t *testing.T) {
+		c, recorder := newContext("/v1/private/reminders")
+		respondWithPage(c, []string{}, auto.Page{})
+
+		assert.Empty(t, recorder.Header().Get("Link"))
+		assert.NotContains(t, recorder.Body.String(), "links")
+	})
+
+	t.Run("invalid cursors point at the cursor parameter", func(t *testing.T) {
+		c, recorder := newContext("/v1/private/reminders?cursor=forged")
+		respondWithError(c, pageError(auto.ErrInvalidCursor))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+		document := errorDocument{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &document))
+		require.Len(t, document.Errors, 1)
+		assert.Equal(t, errInvalidCursor.Code, document.Errors[0].Code)
+		assert.Equal(t, &errorSource{Parameter: "cursor"}, document.Errors[0].Source)
+	})
+}
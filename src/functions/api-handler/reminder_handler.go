@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/serverless"
+	"github.com/segmentio/ksuid"
+)
+
+type reminderPayload struct {
+	*auto.Reminder
+	Status          auto.ReminderStatus
+	NextDueAt       *time.Time `json:",omitempty"`
+	NextDueOdometer float64    `json:",omitempty"`
+}
+
+func newReminderPayload(r *auto.Reminder, now time.Time, odometer float64) *reminderPayload {
+	payload := &reminderPayload{
+		Reminder:        r,
+		Status:          r.Evaluate(now, odometer),
+		NextDueOdometer: r.NextDueOdometer(),
+	}
+	if due := r.NextDueAt(); !due.IsZero() {
+		payload.NextDueAt = &due
+	}
+	return payload
+}
+
+// odometerCache holds the latest odometer reading for each vehicle while reminders are evaluated.
+//
+// Manual and trip-derived readings share a timeline, so reminders evaluate the
+// same way for manually managed and Automatic vehicles.
+type odometerCache map[string]float64
+
+func (o odometerCache) reading(ctx context.Context, accountID, vehicleID string) (float64, error) {
+	if vehicleID == "" {
+		return 0, nil
+	}
+	if value, ok := o[vehicleID]; ok {
+		return value, nil
+	}
+
+	latest, err := auto.DefaultStore().LatestOdometerReading(ctx, accountID, vehicleID)
+	if err == auto.ErrRecordNotFound {
+		o[vehicleID] = 0
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	o[vehicleID] = latest.Reading
+	return latest.Reading, nil
+}
+
+func listRemindersHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	page, err := pageRequest(c)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	listing := auto.ReminderListing{PageRequest: page, VehicleID: c.Query("vehicle_id"), Sort: c.Query("sort")}
+	if listing.VehicleID != "" && listing.Sort == auto.ReminderSortDue {
+		respondWithError(c, newParameterError(errBadRequest, "sort", "A vehicle's reminders can only be sorted by created"))
+		return
+	}
+
+	reminders, next, err := listReminders(ctx, c.GetString(contextUserIDKey), listing)
+	if err != nil {
+		respondWithError(c, pageError(err))
+		return
+	}
+
+	respondWithPage(c, reminders, next)
+}
+
+func listReminders(ctx context.Context, accountID string, listing auto.ReminderListing) ([]*reminderPayload, auto.Page, error) {
+	reminders, next, err := auto.DefaultStore().ListReminders(ctx, accountID, listing)
+	if err != nil {
+		return nil, auto.Page{}, err
+	}
+
+	now := time.Now()
+	odometers := odometerCache{}
+	payloads := make([]*reminderPayload, len(reminders))
+	for i, r := range reminders {
+		odometer, err := odometers.reading(ctx, accountID, r.VehicleID)
+		if err != nil {
+			return nil, auto.Page{}, err
+		}
+		payloads[i] = newReminderPayload(r, now, odometer)
+	}
+
+	return payloads, next, nil
+}
+
+type createReminderRequest struct {
+	VehicleID             string
+	Title                 string
+	IntervalDays          int
+	IntervalDistance      float64
+	LastCompletedAt       time.Time
+	LastCompletedOdometer float64
+}
+
+func createReminderHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	request := createReminderRequest{}
+	if err := bindJSON(c, &request); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	reminder, err := createReminder(ctx, c.GetString(contextUserIDKey), request)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, reminder)
+}
+
+func createReminder(ctx context.Context, accountID string, request createReminderRequest) (*reminderPayload, error) {
+	if request.IntervalDays == 0 && request.IntervalDistance == 0 {
+		return nil, newFieldError(errBadRequest, "/IntervalDays", "A reminder needs an interval in days or distance")
+	}
+
+	if request.VehicleID != "" {
+		if _, err := auto.DefaultStore().FindVehicle(ctx, accountID, request.VehicleID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Distance intervals start from the vehicle's current reading unless told otherwise.
+	if request.LastCompletedOdometer == 0 {
+		odometer, err := odometerCache{}.reading(ctx, accountID, request.VehicleID)
+		if err != nil {
+			return nil, err
+		}
+		request.LastCompletedOdometer = odometer
+	}
+
+	reminder := &auto.Reminder{
+		ID:                    fmt.Sprintf("rem:%s", ksuid.New().String()),
+		AccountID:             accountID,
+		VehicleID:             request.VehicleID,
+		Title:                 request.Title,
+		IntervalDays:          request.IntervalDays,
+		IntervalDistance:      request.IntervalDistance,
+		LastCompletedAt:       request.LastCompletedAt,
+		LastCompletedOdometer: request.LastCompletedOdometer,
+	}
+	if err := serverless.GetValidator().Struct(reminder); err != nil {
+		return nil, invalidRequestError(err)
+	}
+
+	if err := auto.DefaultStore().SaveReminder(ctx, reminder); err != nil {
+		return nil, err
+	}
+
+	return newReminderPayload(reminder, time.Now(), request.LastCompletedOdometer), nil
+}
+
+type snoozeReminderRequest struct {
+	Until    time.Time
+	Days     int
+	Distance float64
+	Odometer float64
+}
+
+func snoozeReminderHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	request := snoozeReminderRequest{}
+	if err := bindJSON(c, &request); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	reminder, err := snoozeReminder(ctx, c.GetString(contextUserIDKey), c.Param("id"), request)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, reminder)
+}
+
+func snoozeReminder(ctx context.Context, accountID, reminderID string, request snoozeReminderRequest) (*reminderPayload, error) {
+	now := time.Now()
+
+	until := request.Until
+	if request.Days > 0 {
+		until = now.AddDate(0, 0, request.Days)
+	}
+
+	if !until.After(now) {
+		if request.Distance <= 0 {
+			return nil, newError(errBadRequest, "A snooze needs a future time or a distance")
+		}
+		until = time.Time{}
+	}
+
+	reminder, err := auto.DefaultStore().FindReminder(ctx, accountID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	odometer := request.Odometer
+	if odometer <= 0 {
+		odometer, err = odometerCache{}.reading(ctx, accountID, reminder.VehicleID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var untilOdometer float64
+	if request.Distance > 0 {
+		if odometer <= 0 {
+			return nil, newFieldError(errBadRequest, "/Odometer", "Snoozing by distance requires an odometer reading")
+		}
+		untilOdometer = odometer + request.Distance
+	}
+
+	reminder.Snooze(until, untilOdometer)
+
+	if err := auto.DefaultStore().SaveReminder(ctx, reminder); err != nil {
+		return nil, err
+	}
+
+	return newReminderPayload(reminder, now, odometer), nil
+}
+
+func dismissReminderHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	reminder, err := dismissReminder(ctx, c.GetString(contextUserIDKey), c.Param("id"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, reminder)
+}
+
+func dismissReminder(ctx context.Context, accountID, reminderID string) (*reminderPayload, error) {
+	reminder, err := auto.DefaultStore().FindReminder(ctx, accountID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	reminder.Dismiss()
+
+	if err := auto.DefaultStore().SaveReminder(ctx, reminder); err != nil {
+		return nil, err
+	}
+
+	odometer, err := odometerCache{}.reading(ctx, accountID, reminder.VehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReminderPayload(reminder, time.Now(), odometer), nil
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"net/http"
 	"sync"
 	"time"
 
-	"github.com/maddiesch/serverless"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/outbound"
 )
 
 type httpSendFunction func(*http.Client, *http.Request) (*http.Response, error)
 
 type httpStack struct {
-	client *http.Client
-	sender httpSendFunction
+	client   *http.Client
+	sender   httpSendFunction
+	retry    outbound.RetryPolicy
+	breakers *outbound.Breakers
+	limiter  *outbound.Limiter
 }
 
 var (
@@ -24,26 +28,67 @@ var (
 func getHTTPStack() *httpStack {
 	httpStackInstanceSetup.Do(func() {
 		httpStackInstance = &httpStack{
+			// The timeout caps a single request. Requests are also cancelled with
+			// their context, which ends before the Lambda invocation does.
 			client: &http.Client{
 				Timeout: 10 * time.Second,
 			},
 			sender: func(c *http.Client, r *http.Request) (*http.Response, error) {
 				return c.Do(r)
 			},
+			retry: outbound.DefaultRetryPolicy,
+			// Five failures in a row is enough to stop waiting on a host that's down
+			breakers: outbound.NewBreakers(5, 30*time.Second),
+			// Budgets are shared by every Lambda through the table. Automatic's
+			// X-RateLimit headers hold requests back sooner when it says so.
+			limiter: &outbound.Limiter{
+				Store:      storeLimits{},
+				HostLimit:  outbound.Limit{Requests: 1000, Window: time.Minute},
+				TokenLimit: outbound.Limit{Requests: 60, Window: time.Minute},
+				MaxWait:    2 * time.Second,
+				OnThrottle: reportThrottle,
+				OnBlockError: func(ctx context.Context, key outbound.LimitKey, err error) {
+					auto.DefaultLogger().Warn(ctx, "failed to record outbound rate limit", auto.Fields{"limit_kind": key.Kind, "error": err})
+				},
+			},
 		}
 	})
 	return httpStackInstance
 }
 
-func sendRequest(r *http.Request) (*http.Response, error) {
-	serverless.GetLogger().Printf("SUB-REQUEST: [%s] %s", r.Method, r.URL)
-
-	response, err := getHTTPStack().sender(getHTTPStack().client, r)
-	if err != nil {
-		return response, err
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return response, fmt.Errorf("invalid HTTP response: %s", response.Status)
-	}
-	return response, err
+// Do sends the request, retrying idempotent requests that fail, failing fast
+// while the host's circuit is open and holding requests back to stay within
+// rate limits. It implements automatic.Doer. Build the
+// request with http.NewRequestWithContext so it's cancelled with the work it's for.
+func (s *httpStack) Do(r *http.Request) (*http.Response, error) {
+	return outbound.Send(r, s.retry, s.breakers, s.limiter.Limit(func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+		response, err := s.sender(s.client, r)
+
+		fields := auto.Fields{
+			"method":     r.Method,
+			"url":        r.URL.String(),
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if err != nil {
+			fields["error"] = err
+			auto.DefaultLogger().Warn(r.Context(), "outbound request failed", fields)
+			return response, err
+		}
+		fields["status"] = response.StatusCode
+		auto.DefaultLogger().Info(r.Context(), "outbound request", fields)
+
+		return response, nil
+	}))
+}
+
+// storeLimits keeps rate limit budgets in the default store
+type storeLimits struct{}
+
+func (storeLimits) TakeLimit(ctx context.Context, key outbound.LimitKey, limit outbound.Limit, now time.Time) (bool, time.Time, error) {
+	return auto.DefaultStore().TakeRateLimit(ctx, key, limit, now)
+}
+
+func (storeLimits) BlockLimit(ctx context.Context, key outbound.LimitKey, until time.Time) error {
+	return auto.DefaultStore().BlockRateLimit(ctx, key, until)
 }
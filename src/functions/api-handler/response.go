@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+// The media types the API responds with. Successful responses are always
+// application/json; errors are application/problem+json when the client prefers it.
+const (
+	mediaTypeJSON    = "application/json"
+	mediaTypeProblem = "application/problem+json"
+)
+
+// dataDocument is the body of a successful response
+type dataDocument struct {
+	Data  interface{}            `json:"data"`
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+	Links map[string]string      `json:"links,omitempty"`
+}
+
+// errorDocument is the body of an error response, unless the client prefers problems
+type errorDocument struct {
+	Errors []errorObject          `json:"errors"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// errorObject is a JSON:API error object
+type errorObject struct {
+	Status string                 `json:"status"`
+	Code   string                 `json:"code"`
+	Title  string                 `json:"title,omitempty"`
+	Detail string                 `json:"detail,omitempty"`
+	Source *errorSource           `json:"source,omitempty"`
+	Meta   map[string]interface{} `json:"meta,omitempty"`
+}
+
+// errorSource points at the part of the request an error object is about
+type errorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// problem is an RFC 7807 problem details object. Code, request_id and
+// invalid-params are extension members.
+type problem struct {
+	Type          string                 `json:"type"`
+	Title         string                 `json:"title"`
+	Status        int                    `json:"status"`
+	Detail        string                 `json:"detail,omitempty"`
+	Instance      string                 `json:"instance,omitempty"`
+	Code          string                 `json:"code"`
+	RequestID     string                 `json:"request_id,omitempty"`
+	InvalidParams []problemParam         `json:"invalid-params,omitempty"`
+	Meta          map[string]interface{} `json:"meta,omitempty"`
+}
+
+// problemParam is a part of the request that caused a problem
+type problemParam struct {
+	Name    string `json:"name"`
+	Pointer string `json:"pointer,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+func newProblem(c *gin.Context, e *Error) problem {
+	p := problem{
+		Type:      errorTypeURI(e.Code),
+		Title:     e.Title,
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  c.Request.URL.Path,
+		Code:      e.Code,
+		RequestID: auto.RequestID(requestContext(c)),
+		Meta:      e.Meta,
+	}
+	for _, field := range e.Invalid {
+		name := field.Parameter
+		if name == "" {
+			name = strings.TrimPrefix(field.Pointer, "/")
+		}
+		p.InvalidParams = append(p.InvalidParams, problemParam{Name: name, Pointer: field.Pointer, Reason: field.Detail})
+	}
+	return p
+}
+
+// errorTypeURI identifies the error code in the catalog served by GET /v1/errors
+func errorTypeURI(code string) string {
+	return fmt.Sprintf("%s/v1/errors#%s", strings.TrimSuffix(auto.DefaultConfig().APIBaseURL, "/"), code)
+}
+
+// documentMeta returns the meta every document carries
+func documentMeta(ctx context.Context) map[string]interface{} {
+	if requestID := auto.RequestID(ctx); requestID != "" {
+		return map[string]interface{}{"request_id": requestID}
+	}
+	return nil
+}
+
+// respond writes the data in a successful response's document
+func respond(c *gin.Context, status int, data interface{}) {
+	render(c, status, mediaTypeJSON, dataDocument{Data: data, Meta: documentMeta(requestContext(c))})
+}
+
+func render(c *gin.Context, status int, mediaType string, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		auto.DefaultLogger().Error(requestContext(c), "encoding the response failed", auto.Fields{"error": err})
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(errorDocument{
+			Errors: newError(errInternalServerError, "An unknown error occurred.").objects(),
+			Meta:   documentMeta(requestContext(c)),
+		})
+		mediaType = mediaTypeJSON
+	}
+
+	c.Data(status, mediaType+"; charset=utf-8", data)
+}
+
+// NegotiateContent rejects requests whose Accept header doesn't allow any of
+// the media types the API responds with.
+func NegotiateContent(c *gin.Context) {
+	if negotiate(c.GetHeader("Accept"), mediaTypeJSON, mediaTypeProblem) == "" {
+		// The error is rendered as application/json, since the client can't accept either
+		respondWithError(c, newError(errNotAcceptable, "Responses are application/json, or application/problem+json for errors"))
+	}
+}
+
+// errorMediaType returns the media type errors are rendered as for the request
+func errorMediaType(c *gin.Context) string {
+	if negotiate(c.GetHeader("Accept"), mediaTypeJSON, mediaTypeProblem) == mediaTypeProblem {
+		return mediaTypeProblem
+	}
+	return mediaTypeJSON
+}
+
+// negotiate returns the offered media type the Accept header prefers, or ""
+// if it accepts none of them. Ties go to the earliest offer, and a missing
+// header accepts everything.
+func negotiate(accept string, offered ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offered[0]
+	}
+
+	type candidate struct {
+		mediaType   string
+		quality     float64
+		specificity int
+		offer       int
+	}
+
+	best := make(map[string]candidate)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		for i, offer := range offered {
+			specificity := mediaRangeSpecificity(mediaRange, offer)
+			if specificity < 0 {
+				continue
+			}
+			// The most specific range that matches an offer sets its quality
+			if current, ok := best[offer]; !ok || specificity > current.specificity {
+				best[offer] = candidate{mediaType: offer, quality: quality, specificity: specificity, offer: i}
+			}
+		}
+	}
+
+	candidates := make([]candidate, 0, len(best))
+	for _, c := range best {
+		if c.quality > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].quality != candidates[j].quality {
+			return candidates[i].quality > candidates[j].quality
+		}
+		if candidates[i].specificity != candidates[j].specificity {
+			return candidates[i].specificity > candidates[j].specificity
+		}
+		return candidates[i].offer < candidates[j].offer
+	})
+	return candidates[0].mediaType
+}
+
+// mediaRangeSpecificity returns how specifically the range matches the media type: 2 exactly, 1 for type/*, 0 for */*, or -1 if it doesn't
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
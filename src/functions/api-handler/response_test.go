@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := []string{mediaTypeJSON, mediaTypeProblem}
+
+	for accept, expected := range map[string]string{
+		"":                         mediaTypeJSON,
+		"*/*":                      mediaTypeJSON,
+		"application/*":            mediaTypeJSON,
+		"application/json":         mediaTypeJSON,
+		"application/problem+json": mediaTypeProblem,
+		"application/json, application/problem+json":       mediaTypeJSON,
+		"application/problem+json, application/json;q=0.9": mediaTypeProblem,
+		"application/json;q=0, */*":                        mediaTypeProblem,
+		"text/html,application/xhtml+xml,*/*;q=0.8":        mediaTypeJSON,
+		"text/html":            "",
+		"application/json;q=0": "",
+	} {
+		assert.Equal(t, expected, negotiate(accept, offered...), accept)
+	}
+}
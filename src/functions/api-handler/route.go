@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/vin"
+)
+
+// route is an endpoint the API serves. Its request and response types
+// describe it in the OpenAPI document, and requests are validated against that
+// description before the handler runs.
+type route struct {
+	// Method and Path are the route, relative to /v1. Path parameters use gin's :name syntax.
+	Method string
+	Path   string
+	// Operation is the OpenAPI operationId, which generated clients name their methods after
+	Operation string
+	Summary   string
+	Handler   gin.HandlerFunc
+
+	// Authenticated routes require an API token
+	Authenticated bool
+	// Query are the query parameters the route reads
+	Query []queryParameter
+	// Request is the type the JSON body is bound to, or nil if the route doesn't read one
+	Request interface{}
+	// Status is the status of a successful response, and Response the type of its data
+	Status   int
+	Response interface{}
+	// Raw routes respond with something other than a data document, described by Response
+	Raw bool
+	// Paginated routes respond with a page of a listing, and read the page parameters
+	Paginated bool
+}
+
+// queryParameter is a query parameter a route reads
+type queryParameter struct {
+	Name        string
+	Description string
+	Required    bool
+	// Schema describes the value. Values are strings if it's nil.
+	Schema *schema
+}
+
+// parameters returns every query parameter the route reads
+func (r route) parameters() []queryParameter {
+	if !r.Paginated {
+		return r.Query
+	}
+	return append(append([]queryParameter{}, r.Query...), pageParameters...)
+}
+
+// apiRoutes returns every route the API serves, in the order they're documented
+func apiRoutes() []route {
+	return []route{
+		{Method: "GET", Path: "/", Operation: "getRoot", Summary: "Check the API is available", Handler: rootHandler, Status: http.StatusOK, Response: struct{}{}},
+		{Method: "GET", Path: "/errors", Operation: "listErrors", Summary: "List the error codes the API returns", Handler: errorCatalogHandler, Status: http.StatusOK, Response: errorCatalog},
+		{Method: "GET", Path: "/openapi.json", Operation: "getOpenAPI", Summary: "Get this OpenAPI document", Handler: openAPIHandler, Status: http.StatusOK, Response: map[string]interface{}{}, Raw: true},
+
+		{Method: "GET", Path: "/actions/:token", Operation: "describeReminderAction", Summary: "Describe what a link from a digest does to a reminder, without doing it", Handler: reminderActionHandler, Status: http.StatusOK, Response: reminderActionPayload{}},
+		{Method: "POST", Path: "/actions/:token", Operation: "performReminderAction", Summary: "Snooze or dismiss a reminder with a link from a digest. A link only takes effect once.", Handler: performReminderActionHandler, Status: http.StatusOK, Response: reminderActionPayload{}},
+
+		{Method: "GET", Path: "/integration/automatic/authenticate", Operation: "authenticateWithAutomatic", Summary: "Redirect to Automatic to sign in", Handler: integrationAutomaticAuthHandler, Status: http.StatusTemporaryRedirect, Raw: true},
+		{
+			Method: "GET", Path: "/integration/automatic/authenticate/callback", Operation: "completeAutomaticAuthentication", Summary: "Finish signing in with Automatic and get an API token",
+			Handler: integrationAutomaticAuthCallbackHandler, Status: http.StatusOK, Response: automaticAuthenticationPayload{},
+			Query: []queryParameter{
+				{Name: "code", Description: "The authorization code Automatic redirected with", Required: true},
+				{Name: "state", Description: "The state the sign in was started with", Required: true},
+			},
+		},
+
+		{Method: "GET", Path: "/private/", Operation: "getAccount", Summary: "Get the account", Handler: getAccountHandler, Authenticated: true, Status: http.StatusOK, Response: auto.Account{}},
+		{Method: "GET", Path: "/private/notifications", Operation: "getNotificationPreferences", Summary: "Get the account's notification preferences", Handler: getNotificationPreferencesHandler, Authenticated: true, Status: http.StatusOK, Response: notificationPreferencesPayload{}},
+		{Method: "PUT", Path: "/private/notifications", Operation: "updateNotificationPreferences", Summary: "Update the account's notification preferences. Members that aren't sent are unchanged.", Handler: updateNotificationPreferencesHandler, Authenticated: true, Request: notificationPreferencesPayload{}, Status: http.StatusOK, Response: notificationPreferencesPayload{}},
+		{
+			Method: "GET", Path: "/private/reminders", Operation: "listReminders", Summary: "List the account's reminders",
+			Handler: listRemindersHandler, Authenticated: true, Status: http.StatusOK, Response: []*reminderPayload{}, Paginated: true,
+			Query: []queryParameter{
+				{Name: "vehicle_id", Description: "Only list the vehicle's reminders. They can only be sorted by created."},
+				{Name: "sort", Description: "What reminders are listed by: when they were created, the default, or when they're next due. Reminders without a time interval are due last.", Schema: &schema{Type: "string", Enum: []string{auto.ReminderSortCreated, auto.ReminderSortDue}}},
+			},
+		},
+		{Method: "POST", Path: "/private/reminders", Operation: "createReminder", Summary: "Create a reminder", Handler: createReminderHandler, Authenticated: true, Request: createReminderRequest{}, Status: http.StatusCreated, Response: reminderPayload{}},
+		{Method: "POST", Path: "/private/reminders/:id/snooze", Operation: "snoozeReminder", Summary: "Snooze a reminder until a time or distance", Handler: snoozeReminderHandler, Authenticated: true, Request: snoozeReminderRequest{}, Status: http.StatusOK, Response: reminderPayload{}},
+		{Method: "POST", Path: "/private/reminders/:id/dismiss", Operation: "dismissReminder", Summary: "Dismiss a reminder's current occurrence", Handler: dismissReminderHandler, Authenticated: true, Status: http.StatusOK, Response: reminderPayload{}},
+		{Method: "POST", Path: "/private/sync", Operation: "syncAutomaticVehicles", Summary: "Sync the account's vehicles from Automatic", Handler: integrationAutomaticSyncHandler, Authenticated: true, Status: http.StatusOK, Response: []*auto.Vehicle{}},
+		{Method: "GET", Path: "/private/vehicles", Operation: "listVehicles", Summary: "List the account's vehicles, in ID order", Handler: listVehiclesHandler, Authenticated: true, Status: http.StatusOK, Response: []*auto.Vehicle{}, Paginated: true},
+		{Method: "POST", Path: "/private/vehicles", Operation: "createVehicle", Summary: "Add a vehicle that isn't managed by Automatic", Handler: createVehicleHandler, Authenticated: true, Request: createVehicleRequest{}, Status: http.StatusCreated, Response: auto.Vehicle{}},
+		{Method: "GET", Path: "/private/vehicles/:id/odometer", Operation: "listOdometerReadings", Summary: "List a vehicle's odometer readings, oldest first", Handler: odometerTimelineHandler, Authenticated: true, Status: http.StatusOK, Response: []*auto.OdometerReading{}},
+		{Method: "POST", Path: "/private/vehicles/:id/odometer", Operation: "addOdometerReading", Summary: "Add an odometer reading to a vehicle", Handler: addOdometerReadingHandler, Authenticated: true, Request: addOdometerReadingRequest{}, Status: http.StatusCreated, Response: auto.OdometerReading{}},
+		{Method: "GET", Path: "/private/vehicles/:id/suggested-reminders", Operation: "listSuggestedReminders", Summary: "List the maintenance templates that match a vehicle", Handler: suggestedRemindersHandler, Authenticated: true, Status: http.StatusOK, Response: []*auto.MaintenanceTemplate{}},
+		{Method: "POST", Path: "/private/vehicles/:id/apply-template", Operation: "applyMaintenanceTemplate", Summary: "Create the reminders of a maintenance template for a vehicle", Handler: applyTemplateHandler, Authenticated: true, Request: applyTemplateRequest{}, Status: http.StatusCreated, Response: []*reminderPayload{}},
+		{Method: "GET", Path: "/private/vin/:vin", Operation: "decodeVIN", Summary: "Decode a VIN", Handler: decodeVINHandler, Authenticated: true, Status: http.StatusOK, Response: vin.Decoded{}},
+	}
+}
+
+// registerRoutes adds the routes to the group. Each route's request is
+// validated against its operation in the OpenAPI document before its handler runs.
+func registerRoutes(group *gin.RouterGroup, routes []route) {
+	for _, r := range routes {
+		handlers := make([]gin.HandlerFunc, 0, 3)
+		if r.Authenticated {
+			handlers = append(handlers, Authenticate)
+		}
+		handlers = append(handlers, validateRequest(r), r.Handler)
+
+		group.Handle(r.Method, r.Path, handlers...)
+	}
+}
+
+func rootHandler(c *gin.Context) {
+	respond(c, http.StatusOK, struct{}{})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+)
+
+const (
+	// serverRequestTimeout caps a request's work, as API Gateway and the Lambda timeout do when deployed
+	serverRequestTimeout = 29 * time.Second
+	// serverShutdownTimeout is how long requests in flight get to finish after an interrupt
+	serverShutdownTimeout = 10 * time.Second
+)
+
+// serve serves the handler over HTTP on the address until the process is interrupted
+func serve(addr string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := auto.InterruptContext()
+	defer cancel()
+
+	auto.DefaultLogger().Info(ctx, "serving HTTP", auto.Fields{"url": "http://" + listener.Addr().String()})
+
+	return serveListener(ctx, listener, handler)
+}
+
+// serveListener serves the handler on the listener until the context ends, then
+// stops accepting connections and waits for requests in flight to finish
+func serveListener(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:           withRequestTimeout(handler, serverRequestTimeout),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(shutdown)
+}
+
+// withRequestTimeout gives each request's context a deadline, so work is cancelled the same way it is on Lambda
+func withRequestTimeout(handler http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	var deadline time.Time
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- serveListener(ctx, listener, handler)
+	}()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		assert.NoError(t, err)
+		responses <- response
+	}()
+
+	<-started
+	cancel()
+
+	response := <-responses
+	require.NotNil(t, response)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNoContent, response.StatusCode, "requests in flight finish")
+	assert.WithinDuration(t, time.Now().Add(serverRequestTimeout), deadline, time.Second)
+
+	assert.NoError(t, <-stopped)
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/slow")
+	assert.Error(t, err, "no new connections are accepted")
+}
+
+func TestRouter(t *testing.T) {
+	server := httptest.NewServer(getEngine())
+	defer server.Close()
+
+	get := func(path, token string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("serves public routes through the middleware", func(t *testing.T) {
+		response := get("/v1/", "")
+		defer response.Body.Close()
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.NotEmpty(t, response.Header.Get("X-Request-Id"))
+	})
+
+	t.Run("rejects requests that accept neither JSON nor problems", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/v1/", nil)
+		require.NoError(t, err)
+		request.Header.Set("Accept", "text/html")
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		assert.Equal(t, http.StatusNotAcceptable, response.StatusCode)
+		assert.Equal(t, "application/json; charset=utf-8", response.Header.Get("Content-Type"))
+	})
+
+	t.Run("requires a token for private routes", func(t *testing.T) {
+		response := get("/v1/private/", "")
+		defer response.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("serves private routes with a token", func(t *testing.T) {
+		account := &auto.Account{ID: "auid:" + ksuid.New().String(), FirstName: "Routed"}
+		require.NoError(t, auto.DefaultStore().SaveAutomaticAuthentication(context.Background(), account, auto.AutomaticAccessToken{
+			UserID:      "U_" + ksuid.New().String(),
+			AccessToken: "access",
+			ExpiresIn:   3600,
+		}, nil))
+
+		token, err := apiTokenForAccount(account)
+		require.NoError(t, err)
+
+		response := get("/v1/private/", token)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		body := struct{ Data auto.Account }{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&body))
+		assert.Equal(t, account.ID, body.Data.ID)
+		assert.Equal(t, account.ID, response.Header.Get("X-User-Id"))
+
+		response = get("/v1/private/reminders?vehicle_id="+url.QueryEscape("veh:1/x"), token)
+		defer response.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "IDs can't contain a slash")
+	})
+}
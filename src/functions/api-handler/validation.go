@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateRequest checks the request against the route's operation in the
+// OpenAPI document: required query parameters are present, query parameters
+// match their schemas, and the body is JSON that matches the request schema.
+// Everything that's wrong is returned at once, pointing at the parameters and members.
+func validateRequest(r route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var invalid []InvalidField
+		for _, q := range r.parameters() {
+			value := c.Query(q.Name)
+			if value == "" {
+				if q.Required {
+					invalid = append(invalid, InvalidField{Parameter: q.Name, Detail: fmt.Sprintf("%s is required", q.Name)})
+				}
+				continue
+			}
+			if q.Schema == nil {
+				continue
+			}
+
+			// Query values are strings, so numbers are decoded the way the body's are
+			var decoded interface{} = value
+			if q.Schema.Type == "integer" || q.Schema.Type == "number" {
+				decoded = json.Number(value)
+			}
+			for _, field := range openAPI().validate(q.Schema, decoded, appendPointer("", q.Name)) {
+				invalid = append(invalid, InvalidField{Parameter: q.Name, Detail: field.Detail})
+			}
+		}
+		if len(invalid) > 0 {
+			respondWithError(c, invalidFieldsError(invalid))
+			return
+		}
+
+		op := openAPI().operation(r)
+		if op == nil || op.RequestBody == nil {
+			return
+		}
+
+		if c.ContentType() != mediaTypeJSON {
+			respondWithError(c, newError(errUnsupportedMediaType, "The request body must be application/json"))
+			return
+		}
+
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			respondWithError(c, err)
+			return
+		}
+		// The handler binds the body, so it's put back for it to read
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+		if len(bytes.TrimSpace(data)) == 0 {
+			respondWithError(c, newError(errBadRequest, "The request body is empty"))
+			return
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var body interface{}
+		if err := decoder.Decode(&body); err != nil {
+			respondWithError(c, newError(errBadRequest, "The request body isn't valid JSON").causedBy(err))
+			return
+		}
+
+		if invalid := openAPI().validate(op.RequestBody.Content[mediaTypeJSON].Schema, body, ""); len(invalid) > 0 {
+			respondWithError(c, invalidFieldsError(invalid))
+		}
+	}
+}
+
+// validate returns the parts of a value decoded with UseNumber that don't
+// match the schema. The pointer is where the value is in the document.
+func (d *openAPIDocument) validate(s *schema, value interface{}, pointer string) []InvalidField {
+	s = d.resolve(s)
+	name := fieldName(pointer)
+	invalidType := []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s must be %s", name, describeType(s))}}
+
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s can't be null", name)}}
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return invalidType
+		}
+		return d.validateObject(s, object, pointer)
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return invalidType
+		}
+		var invalid []InvalidField
+		for i, item := range array {
+			invalid = append(invalid, d.validate(s.Items, item, fmt.Sprintf("%s/%d", pointer, i))...)
+		}
+		return invalid
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return invalidType
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return invalidType
+			}
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s must be one of %s", name, strings.Join(s.Enum, ", "))}}
+		}
+		if s.MinLength != nil && utf8.RuneCountInString(str) < *s.MinLength {
+			return []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s must be at least %d characters", name, *s.MinLength)}}
+		}
+		if s.MaxLength != nil && utf8.RuneCountInString(str) > *s.MaxLength {
+			return []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s must be at most %d characters", name, *s.MaxLength)}}
+		}
+		return nil
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return invalidType
+		}
+		n, err := number.Float64()
+		if err != nil || (s.Type == "integer" && n != math.Trunc(n)) {
+			return invalidType
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s must be at least %v", name, *s.Minimum)}}
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return []InvalidField{{Pointer: pointer, Detail: fmt.Sprintf("%s must be at most %v", name, *s.Maximum)}}
+		}
+		return nil
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalidType
+		}
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+func (d *openAPIDocument) validateObject(s *schema, object map[string]interface{}, pointer string) []InvalidField {
+	var invalid []InvalidField
+
+	for _, required := range s.Required {
+		if _, ok := object[required]; !ok {
+			invalid = append(invalid, InvalidField{Pointer: appendPointer(pointer, required), Detail: fmt.Sprintf("%s is required", required)})
+		}
+	}
+
+	members := make([]string, 0, len(object))
+	for member := range object {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	for _, member := range members {
+		memberPointer := appendPointer(pointer, member)
+		if property, ok := s.Properties[member]; ok {
+			invalid = append(invalid, d.validate(property, object[member], memberPointer)...)
+			continue
+		}
+
+		switch additional := s.AdditionalProperties.(type) {
+		case *schema:
+			invalid = append(invalid, d.validate(additional, object[member], memberPointer)...)
+		case bool:
+			if !additional {
+				invalid = append(invalid, InvalidField{Pointer: memberPointer, Detail: fmt.Sprintf("%s isn't a known member", member)})
+			}
+		}
+	}
+
+	return invalid
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/maddiesch/automatic-reminders/auto"
+	"github.com/maddiesch/automatic-reminders/auto/vin"
+	"github.com/segmentio/ksuid"
+)
+
+func listVehiclesHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	page, err := pageRequest(c)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	vehicles, next, err := auto.DefaultStore().ListVehicles(ctx, c.GetString(contextUserIDKey), page)
+	if err != nil {
+		respondWithError(c, pageError(err))
+		return
+	}
+
+	respondWithPage(c, vehicles, next)
+}
+
+type createVehicleRequest struct {
+	VIN         string
+	Make        string
+	Model       string
+	Submodel    string
+	Year        int
+	DisplayName string
+	Odometer    float64
+}
+
+func createVehicleHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	request := createVehicleRequest{}
+	if err := bindJSON(c, &request); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	vehicle, err := createVehicle(ctx, c.GetString(contextUserIDKey), request)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, vehicle)
+}
+
+// createVehicle adds a manually managed vehicle, one without an Automatic adapter.
+func createVehicle(ctx context.Context, accountID string, request createVehicleRequest) (*auto.Vehicle, error) {
+	vehicle := &auto.Vehicle{
+		ID:          fmt.Sprintf("veh:%s", ksuid.New().String()),
+		AccountID:   accountID,
+		VIN:         request.VIN,
+		Make:        request.Make,
+		Model:       request.Model,
+		Submodel:    request.Submodel,
+		Year:        request.Year,
+		DisplayName: request.DisplayName,
+	}
+
+	if err := vehicle.EnrichFromVIN(); err != nil {
+		return nil, newFieldError(errInvalidVIN, "/VIN", err.Error())
+	}
+	if vehicle.Make == "" || vehicle.Model == "" {
+		field := "/Make"
+		if vehicle.Make != "" {
+			field = "/Model"
+		}
+		return nil, newFieldError(errBadRequest, field, "A vehicle needs a make and model")
+	}
+
+	if err := auto.DefaultStore().SaveVehicle(ctx, vehicle); err != nil {
+		return nil, err
+	}
+
+	if request.Odometer > 0 {
+		err := auto.DefaultStore().AddOdometerReading(ctx, &auto.OdometerReading{
+			AccountID: accountID,
+			VehicleID: vehicle.ID,
+			Reading:   request.Odometer,
+			Source:    auto.OdometerSourceManual,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return vehicle, nil
+}
+
+type addOdometerReadingRequest struct {
+	// Reading is a pointer so a reading of 0 isn't mistaken for a missing one
+	Reading *float64 `binding:"exists,min=0"`
+	ReadAt  time.Time
+}
+
+func addOdometerReadingHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	request := addOdometerReadingRequest{}
+	if err := bindJSON(c, &request); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	reading, err := addOdometerReading(ctx, c.GetString(contextUserIDKey), c.Param("id"), request)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, reading)
+}
+
+func addOdometerReading(ctx context.Context, accountID, vehicleID string, request addOdometerReadingRequest) (*auto.OdometerReading, error) {
+	if request.ReadAt.After(time.Now()) {
+		return nil, newFieldError(errBadRequest, "/ReadAt", "Odometer readings can't be in the future")
+	}
+
+	if _, err := auto.DefaultStore().FindVehicle(ctx, accountID, vehicleID); err != nil {
+		return nil, err
+	}
+
+	reading := &auto.OdometerReading{
+		AccountID: accountID,
+		VehicleID: vehicleID,
+		Reading:   *request.Reading,
+		ReadAt:    request.ReadAt,
+		Source:    auto.OdometerSourceManual,
+	}
+
+	// A reading out of order is auto.ErrOdometerNotMonotonic, which is returned as invalid_odometer_reading.
+	if err := auto.DefaultStore().AddOdometerReading(ctx, reading); err != nil {
+		return nil, err
+	}
+
+	return reading, nil
+}
+
+func odometerTimelineHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	accountID := c.GetString(contextUserIDKey)
+
+	if _, err := auto.DefaultStore().FindVehicle(ctx, accountID, c.Param("id")); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	readings, err := auto.DefaultStore().OdometerTimeline(ctx, accountID, c.Param("id"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, readings)
+}
+
+func suggestedRemindersHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	vehicle, err := auto.DefaultStore().FindVehicle(ctx, c.GetString(contextUserIDKey), c.Param("id"))
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusOK, auto.SuggestedMaintenanceTemplates(vehicle))
+}
+
+type applyTemplateRequest struct {
+	TemplateID string `binding:"required"`
+}
+
+func applyTemplateHandler(c *gin.Context) {
+	ctx := requestContext(c)
+
+	request := applyTemplateRequest{}
+	if err := bindJSON(c, &request); err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	reminders, err := applyTemplate(ctx, c.GetString(contextUserIDKey), c.Param("id"), request.TemplateID)
+	if err != nil {
+		respondWithError(c, err)
+		return
+	}
+
+	respond(c, http.StatusCreated, reminders)
+}
+
+func applyTemplate(ctx context.Context, accountID, vehicleID, templateID string) ([]*reminderPayload, error) {
+	vehicle, err := auto.DefaultStore().FindVehicle(ctx, accountID, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := auto.FindMaintenanceTemplate(templateID)
+	if err != nil {
+		return nil, newFieldError(errBadRequest, "/TemplateID", "Unknown maintenance template")
+	}
+
+	existing, err := auto.DefaultStore().AccountReminders(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	reminders := template.NewReminders(vehicle, existing)
+	if len(reminders) == 0 {
+		return []*reminderPayload{}, nil
+	}
+
+	odometer, err := odometerCache{}.reading(ctx, accountID, vehicle.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range reminders {
+		r.ID = fmt.Sprintf("rem:%s", ksuid.New().String())
+		r.LastCompletedOdometer = odometer
+	}
+
+	if err := auto.DefaultStore().CreateReminders(ctx, reminders); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	payloads := make([]*reminderPayload, len(reminders))
+	for i, r := range reminders {
+		payloads[i] = newReminderPayload(r, now, odometer)
+	}
+
+	return payloads, nil
+}
+
+func decodeVINHandler(c *gin.Context) {
+	decoded, err := vin.Decode(c.Param("vin"))
+	if err != nil {
+		respondWithError(c, newParameterError(errInvalidVIN, "vin", err.Error()))
+		return
+	}
+
+	respond(c, http.StatusOK, decoded)
+}